@@ -0,0 +1,215 @@
+package accesslist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"main/storage"
+	"strings"
+	"time"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+)
+
+// CollectionName is the collection of the entries, keyed by canonical public key.
+const CollectionName = "accesslist"
+
+// Lists an entry can belong to.
+const (
+	// Allow pre-approves a receiver, skipping the anti-abuse checks of the service.
+	Allow = "allow"
+	// Deny refuses every drop to a receiver.
+	Deny = "deny"
+)
+
+// Entry puts a receiver on a list.
+type Entry struct {
+	Pubkey string
+	List   string
+	Reason string
+	// Expiry is the unix time the entry stops applying, 0 for never.
+	Expiry  int64
+	AddedAt int64
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return e.Expiry != 0 && now.Unix() >= e.Expiry
+}
+
+// CanonicalPubkey returns the key entries are stored under for a payment address or a base58
+// public key, the same as the Pubkey of the user records.
+func CanonicalPubkey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if wl, err := wallet.Base58CheckDeserialize(key); err == nil {
+		if len(wl.KeySet.PaymentAddress.Pk) != 32 {
+			return "", fmt.Errorf("accesslist: %v is not a payment address", key)
+		}
+		return base58.Base58Check{}.Encode(wl.KeySet.PaymentAddress.Pk, 0), nil
+	}
+	pk, _, err := base58.Base58Check{}.Decode(key)
+	if err != nil || len(pk) != 32 {
+		return "", fmt.Errorf("accesslist: %v is neither a payment address nor a public key", key)
+	}
+	return base58.Base58Check{}.Encode(pk, 0), nil
+}
+
+// Lists holds both lists in one collection: a key is on at most one of them.
+type Lists struct {
+	db storage.Collection
+}
+
+func New(store storage.Store) *Lists {
+	return &Lists{db: store.Collection(CollectionName)}
+}
+
+// Put adds or replaces the entry of e.Pubkey, which may also be given as a payment address.
+func (l *Lists) Put(e Entry) (Entry, error) {
+	if e.List != Allow && e.List != Deny {
+		return e, fmt.Errorf("accesslist: unknown list %q", e.List)
+	}
+	pubkey, err := CanonicalPubkey(e.Pubkey)
+	if err != nil {
+		return e, err
+	}
+	e.Pubkey = pubkey
+	if e.AddedAt == 0 {
+		e.AddedAt = time.Now().Unix()
+	}
+	return e, l.db.Put(e.Pubkey, e)
+}
+
+func (l *Lists) Remove(key string) error {
+	pubkey, err := CanonicalPubkey(key)
+	if err != nil {
+		return err
+	}
+	return l.db.Delete(pubkey)
+}
+
+// Check returns the entry applying to pubkey, with an empty List when there is none. Expired
+// entries are ignored.
+func (l *Lists) Check(pubkey string) (Entry, error) {
+	var e Entry
+	err := l.db.Get(pubkey, &e)
+	if err == storage.ErrNotFound || (err == nil && e.expired(time.Now())) {
+		return Entry{}, nil
+	}
+	return e, err
+}
+
+// Entries returns the entries of list, or of both lists when it is empty, expired ones included.
+func (l *Lists) Entries(list string) ([]Entry, error) {
+	result := []Entry{}
+	err := l.db.Scan("", "", func(id string, data []byte) error {
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("accesslist: decode entry %v: %v", id, err)
+		}
+		if list == "" || e.List == list {
+			result = append(result, e)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// ParseExpiry reads a date (YYYY-MM-DD, the entry expiring at its start) or an RFC3339 time. An
+// empty string means no expiry.
+func ParseExpiry(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Unix(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("accesslist: invalid expiry %q", s)
+	}
+	return t.Unix(), nil
+}
+
+// ParseImport reads entries for a bulk import, either a JSON array of entries or CSV lines of
+// "key,list,reason,expiry" where only key is required. list defaults to defaultList, and a first
+// line starting with "key" or "pubkey" is taken as a header.
+func ParseImport(r io.Reader, defaultList string) ([]Entry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		var entries []Entry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			if entries[i].List == "" {
+				entries[i].List = defaultList
+			}
+		}
+		return entries, nil
+	}
+	cr := csv.NewReader(strings.NewReader(string(data)))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for i, record := range records {
+		if i == 0 && (strings.EqualFold(record[0], "key") || strings.EqualFold(record[0], "pubkey")) {
+			continue
+		}
+		if len(record) == 1 && record[0] == "" {
+			continue
+		}
+		e := Entry{Pubkey: record[0], List: defaultList}
+		if len(record) > 1 && record[1] != "" {
+			e.List = record[1]
+		}
+		if len(record) > 2 {
+			e.Reason = record[2]
+		}
+		if len(record) > 3 {
+			if e.Expiry, err = ParseExpiry(record[3]); err != nil {
+				return nil, fmt.Errorf("line %v: %v", i+1, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("accesslist: nothing to import")
+	}
+	return entries, nil
+}
+
+// Import stores entries, all of them being checked first so that a bad line imports nothing. A key
+// listed twice is such a bad line, whichever lists the entries are on.
+func (l *Lists) Import(entries []Entry) error {
+	seen := make(map[string]int)
+	for i := range entries {
+		if entries[i].List != Allow && entries[i].List != Deny {
+			return fmt.Errorf("entry %v: accesslist: unknown list %q", i+1, entries[i].List)
+		}
+		pubkey, err := CanonicalPubkey(entries[i].Pubkey)
+		if err != nil {
+			return fmt.Errorf("entry %v: %v", i+1, err)
+		}
+		if j, ok := seen[pubkey]; ok {
+			return fmt.Errorf("entry %v: accesslist: %v already given by entry %v", i+1, pubkey, j+1)
+		}
+		seen[pubkey] = i
+		entries[i].Pubkey = pubkey
+	}
+	for _, e := range entries {
+		if _, err := l.Put(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
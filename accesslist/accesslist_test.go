@@ -0,0 +1,183 @@
+package accesslist
+
+import (
+	"bytes"
+	"main/storage"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// the receivers of the private key 1111111U1tofCB5sj3oKYgHbr6PXGtub7WTdKN2KcUdACTBN9GH5RYoAAYmeTgF6F6cfZ6HvYjSMiWWhfkLeGXD4Kw5auCFUqnaGrso7Eg
+const (
+	testPaymentAddress = "12svn1DAWMmVYyRDyak9Lf39TrFf3bZkcAbBDnDnmKnX1BQzCR5ithWEgEgU4UsUYhqsQZpjswZf1tCtgGjeyNLxbrjBoG5LnEAPKua4pPS77CDyPLu2skUtNUJ2Y7VEuDxvUX8rjTieBmkcfFAj"
+	testPubkey         = "12mKxyf3zV7jywjRuauJUbxrYwg78MBdRKxgpLYPHFHhcf93f1s"
+)
+
+var otherPubkey = base58.Base58Check{}.Encode(bytes.Repeat([]byte{7}, 32), 0)
+
+func newTestLists(t *testing.T) *Lists {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(storage.NewLevelDB(db))
+}
+
+func TestCanonicalPubkey(t *testing.T) {
+	for _, tc := range []struct {
+		key, want string
+	}{
+		{testPaymentAddress, testPubkey},
+		{testPubkey, testPubkey},
+		{" " + testPubkey + "\n", testPubkey},
+		{"invalid", ""},
+		{base58.Base58Check{}.Encode([]byte{1, 2, 3}, 0), ""},
+	} {
+		got, err := CanonicalPubkey(tc.key)
+		if got != tc.want || (err != nil) != (tc.want == "") {
+			t.Errorf("%q: got %q, err %v", tc.key, got, err)
+		}
+	}
+}
+
+func TestLists(t *testing.T) {
+	l := newTestLists(t)
+	check := func(key string) string {
+		e, err := l.Check(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.List
+	}
+
+	if _, err := l.Put(Entry{Pubkey: testPaymentAddress, List: Allow}); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(testPubkey); got != Allow {
+		t.Fatalf("allowed by payment address: %q", got)
+	}
+	// a key is on one list, the last one it was put on
+	if _, err := l.Put(Entry{Pubkey: testPubkey, List: Deny, Reason: "bot"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(testPubkey); got != Deny {
+		t.Fatalf("denied after being allowed: %q", got)
+	}
+	if allowed, _ := l.Entries(Allow); len(allowed) != 0 {
+		t.Fatalf("still on the allow list: %+v", allowed)
+	}
+
+	expired := time.Now().Add(-time.Hour).Unix()
+	if _, err := l.Put(Entry{Pubkey: otherPubkey, List: Deny, Expiry: expired}); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(otherPubkey); got != "" {
+		t.Fatalf("expired entry applies: %q", got)
+	}
+	if all, _ := l.Entries(""); len(all) != 2 {
+		t.Fatalf("entries %+v", all)
+	}
+	if _, err := l.Put(Entry{Pubkey: otherPubkey, List: "maybe"}); err == nil {
+		t.Fatal("unknown list accepted")
+	}
+
+	if err := l.Remove(testPaymentAddress); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(testPubkey); got != "" {
+		t.Fatalf("removed by payment address: %q", got)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	store, err := storage.OpenLevelDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(store).Put(Entry{Pubkey: testPubkey, List: Deny, Reason: "bot"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = storage.OpenLevelDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	e, err := New(store).Check(testPubkey)
+	if err != nil || e.List != Deny || e.Reason != "bot" || e.AddedAt == 0 {
+		t.Fatalf("entry after reopening: %+v, err %v", e, err)
+	}
+}
+
+func TestParseImport(t *testing.T) {
+	csv := "key,list,reason,expiry\n" +
+		testPaymentAddress + ",deny,bot,2030-01-01\n" +
+		"\n" +
+		otherPubkey + "\n"
+	entries, err := ParseImport(strings.NewReader(csv), Allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].List != Deny || entries[0].Reason != "bot" || entries[1].List != Allow {
+		t.Fatalf("entries %+v", entries)
+	}
+	if entries[0].Expiry != time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix() {
+		t.Fatalf("expiry %v", entries[0].Expiry)
+	}
+
+	json := `[{"Pubkey": "` + testPubkey + `", "Reason": "partner"}]`
+	if entries, err := ParseImport(strings.NewReader(json), Allow); err != nil || entries[0].List != Allow || entries[0].Reason != "partner" {
+		t.Fatalf("json: %+v, err %v", entries, err)
+	}
+
+	for _, tc := range []struct {
+		name, data, err string
+	}{
+		{"bad expiry", testPubkey + ",deny,,someday\n", "line 1"},
+		{"bad expiry after the header", "key\n" + testPubkey + "\n" + otherPubkey + ",deny,,someday\n", "line 3"},
+		{"header only", "pubkey,list\n", "nothing to import"},
+		{"bad json", "[{]", "invalid character"},
+	} {
+		if _, err := ParseImport(strings.NewReader(tc.data), Deny); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: err %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	l := newTestLists(t)
+	for _, tc := range []struct {
+		name    string
+		entries []Entry
+		err     string
+	}{
+		{"bad key", []Entry{{Pubkey: otherPubkey, List: Deny}, {Pubkey: "invalid", List: Deny}}, "entry 2"},
+		{"unknown list", []Entry{{Pubkey: otherPubkey, List: Deny}, {Pubkey: testPubkey, List: "maybe"}}, "entry 2"},
+		{"duplicate", []Entry{{Pubkey: testPaymentAddress, List: Allow}, {Pubkey: otherPubkey, List: Deny}, {Pubkey: testPubkey, List: Deny}}, "entry 3"},
+	} {
+		err := l.Import(tc.entries)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: err %v, want %q", tc.name, err, tc.err)
+		}
+		// a bad entry imports nothing
+		if all, _ := l.Entries(""); len(all) != 0 {
+			t.Fatalf("%v: imported %+v", tc.name, all)
+		}
+	}
+
+	if err := l.Import([]Entry{{Pubkey: testPaymentAddress, List: Allow}, {Pubkey: otherPubkey, List: Deny}}); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := l.Check(testPubkey); e.List != Allow {
+		t.Fatalf("imported entry %+v", e)
+	}
+}
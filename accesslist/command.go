@@ -0,0 +1,85 @@
+package accesslist
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const commandUsage = `usage: accesslist <command> [flags]
+  add -list allow|deny [-reason r] [-expiry YYYY-MM-DD] key...
+  remove key...
+  list [-list allow|deny]
+  import -file path [-list allow|deny]`
+
+// RunCommand is the `accesslist` subcommand of the services, managing the lists while they are
+// stopped.
+func RunCommand(l *Lists, args []string) error {
+	if len(args) == 0 {
+		return errors.New(commandUsage)
+	}
+	fs := flag.NewFlagSet("accesslist "+args[0], flag.ExitOnError)
+	list := fs.String("list", "", "allow or deny")
+	switch args[0] {
+	case "add":
+		reason := fs.String("reason", "", "why the key is listed")
+		expiry := fs.String("expiry", "", "when the entry stops applying, YYYY-MM-DD or RFC3339")
+		fs.Parse(args[1:])
+		expiresAt, err := ParseExpiry(*expiry)
+		if err != nil {
+			return err
+		}
+		for _, key := range fs.Args() {
+			e, err := l.Put(Entry{Pubkey: key, List: *list, Reason: *reason, Expiry: expiresAt})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%v added to the %v list\n", e.Pubkey, e.List)
+		}
+	case "remove":
+		fs.Parse(args[1:])
+		for _, key := range fs.Args() {
+			if err := l.Remove(key); err != nil {
+				return err
+			}
+		}
+	case "list":
+		fs.Parse(args[1:])
+		entries, err := l.Entries(*list)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, e := range entries {
+			expiry := "never"
+			if e.Expiry != 0 {
+				expiry = time.Unix(e.Expiry, 0).UTC().Format(time.RFC3339)
+				if e.expired(now) {
+					expiry += " (expired)"
+				}
+			}
+			fmt.Printf("%v\t%v\t%v\t%v\n", e.Pubkey, e.List, expiry, e.Reason)
+		}
+	case "import":
+		file := fs.String("file", "", "CSV or JSON file to import")
+		fs.Parse(args[1:])
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		entries, err := ParseImport(f, *list)
+		if err != nil {
+			return err
+		}
+		if err := l.Import(entries); err != nil {
+			return err
+		}
+		fmt.Printf("imported %v entries\n", len(entries))
+	default:
+		return errors.New(commandUsage)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package accesslist
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminKeyHeader carries the admin key of the service.
+const AdminKeyHeader = "X-Admin-Key"
+
+// RequireAdmin rejects the requests without the admin key. An empty adminKey disables the routes
+// it guards.
+func RequireAdmin(adminKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsAdmin(c, adminKey) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"Error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// IsAdmin tells whether a request carries adminKey, never when it is empty.
+func IsAdmin(c *gin.Context, adminKey string) bool {
+	key := c.GetHeader(AdminKeyHeader)
+	return adminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1
+}
+
+// RegisterRoutes adds the admin endpoints managing the lists to r:
+//
+//	GET    /accesslist?list=allow|deny  lists the entries
+//	GET    /accesslist/check?key=       returns the entry applying to a key
+//	POST   /accesslist                  adds an Entry, Expiry given as in ParseExpiry
+//	POST   /accesslist/import?list=     bulk imports the body, see ParseImport
+//	DELETE /accesslist?key=             removes the entry of a key
+func RegisterRoutes(r gin.IRouter, l *Lists) {
+	r.GET("/accesslist", func(c *gin.Context) {
+		entries, err := l.Entries(c.Query("list"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"Result": entries})
+	})
+	r.GET("/accesslist/check", func(c *gin.Context) {
+		pubkey, err := CanonicalPubkey(c.Query("key"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		e, err := l.Check(pubkey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"Result": e})
+	})
+	r.POST("/accesslist", func(c *gin.Context) {
+		var req struct {
+			Key    string
+			List   string
+			Reason string
+			Expiry string
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		expiry, err := ParseExpiry(req.Expiry)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		e, err := l.Put(Entry{Pubkey: req.Key, List: req.List, Reason: req.Reason, Expiry: expiry})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"Result": e})
+	})
+	r.POST("/accesslist/import", func(c *gin.Context) {
+		entries, err := ParseImport(c.Request.Body, c.Query("list"))
+		if err == nil {
+			err = l.Import(entries)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"Result": len(entries)})
+	})
+	r.DELETE("/accesslist", func(c *gin.Context) {
+		if err := l.Remove(c.Query("key")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"Result": 1})
+	})
+}
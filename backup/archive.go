@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// magic starts every archive, versioning the format.
+const magic = "AIRDROPDB-BACKUP-1\n"
+
+// An archive is gzip compressed: magic, then the records as uvarint-length-prefixed key and value,
+// then an empty key ending the records, the uvarint record count and the sha256 of everything
+// between magic and the checksum itself.
+
+// ErrChecksum is returned when an archive does not match its checksum.
+var ErrChecksum = errors.New("backup: archive checksum mismatch")
+
+// Info describes an archive.
+type Info struct {
+	Records  uint64
+	Checksum string
+}
+
+type archiveWriter struct {
+	gz      *gzip.Writer
+	sum     hash.Hash
+	w       io.Writer
+	records uint64
+	buf     [binary.MaxVarintLen64]byte
+}
+
+func newArchiveWriter(w io.Writer) (*archiveWriter, error) {
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	return &archiveWriter{gz: gz, sum: sum, w: io.MultiWriter(gz, sum)}, nil
+}
+
+func (a *archiveWriter) writeBytes(b []byte) error {
+	n := binary.PutUvarint(a.buf[:], uint64(len(b)))
+	if _, err := a.w.Write(a.buf[:n]); err != nil {
+		return err
+	}
+	_, err := a.w.Write(b)
+	return err
+}
+
+func (a *archiveWriter) add(key, value []byte) error {
+	if len(key) == 0 {
+		return errors.New("backup: empty key")
+	}
+	if err := a.writeBytes(key); err != nil {
+		return err
+	}
+	a.records++
+	return a.writeBytes(value)
+}
+
+func (a *archiveWriter) close() (Info, error) {
+	n := binary.PutUvarint(a.buf[:], 0)
+	n += binary.PutUvarint(a.buf[n:], a.records)
+	if _, err := a.w.Write(a.buf[:n]); err != nil {
+		return Info{}, err
+	}
+	checksum := a.sum.Sum(nil)
+	if _, err := a.gz.Write(checksum); err != nil {
+		return Info{}, err
+	}
+	if err := a.gz.Close(); err != nil {
+		return Info{}, err
+	}
+	return Info{Records: a.records, Checksum: hex.EncodeToString(checksum)}, nil
+}
+
+// WriteArchive writes the records produced by dump to w.
+func WriteArchive(w io.Writer, dump func(fn func(key, value []byte) error) error) (Info, error) {
+	a, err := newArchiveWriter(w)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := dump(a.add); err != nil {
+		return Info{}, err
+	}
+	return a.close()
+}
+
+// hashingReader feeds what is read through it to the checksum.
+type hashingReader struct {
+	r   *bufio.Reader
+	sum hash.Hash
+}
+
+func (h *hashingReader) ReadByte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == nil {
+		h.sum.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (h *hashingReader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(h)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(h.r, b); err != nil {
+		return nil, err
+	}
+	h.sum.Write(b)
+	return b, nil
+}
+
+// ReadArchive calls fn for every record of the archive read from r. The checksum can only be
+// checked at the end: callers must discard what fn received when an error is returned.
+func ReadArchive(r io.Reader, fn func(key, value []byte) error) (Info, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Info{}, fmt.Errorf("backup: not an archive: %v", err)
+	}
+	defer gz.Close()
+	br := bufio.NewReader(gz)
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, head); err != nil || string(head) != magic {
+		return Info{}, errors.New("backup: not an archive")
+	}
+	h := &hashingReader{r: br, sum: sha256.New()}
+	var records uint64
+	for {
+		key, err := h.readBytes()
+		if err != nil {
+			return Info{}, fmt.Errorf("backup: truncated archive: %v", err)
+		}
+		if len(key) == 0 {
+			break
+		}
+		value, err := h.readBytes()
+		if err != nil {
+			return Info{}, fmt.Errorf("backup: truncated archive: %v", err)
+		}
+		records++
+		if err := fn(key, value); err != nil {
+			return Info{}, err
+		}
+	}
+	count, err := binary.ReadUvarint(h)
+	if err != nil {
+		return Info{}, fmt.Errorf("backup: truncated archive: %v", err)
+	}
+	expected := h.sum.Sum(nil)
+	checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(br, checksum); err != nil {
+		return Info{}, fmt.Errorf("backup: truncated archive: %v", err)
+	}
+	if !bytes.Equal(checksum, expected) || count != records {
+		return Info{}, ErrChecksum
+	}
+	return Info{Records: records, Checksum: hex.EncodeToString(checksum)}, nil
+}
@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"main/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// Extension ends the name of the archives written by Snapshot.
+const Extension = ".bak.gz"
+
+// ErrUnsupported is returned for the backends without a local db to back up, which have their own
+// tooling (mongodump).
+var ErrUnsupported = errors.New("backup: the storage backend does not support snapshots")
+
+// Snapshot writes a consistent archive of store to dir, named after prefix and the current time.
+// The archive only appears under its final name once complete.
+func Snapshot(store storage.Store, dir, prefix string) (string, Info, error) {
+	dumper, ok := store.(storage.Dumper)
+	if !ok {
+		return "", Info{}, ErrUnsupported
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", Info{}, err
+	}
+	path := filepath.Join(dir, prefix+"-"+time.Now().UTC().Format("20060102T150405Z")+Extension)
+	f, err := ioutil.TempFile(dir, prefix+"-*.tmp")
+	if err != nil {
+		return "", Info{}, err
+	}
+	defer os.Remove(f.Name())
+	info, err := WriteArchive(f, dumper.Dump)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", Info{}, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", Info{}, err
+	}
+	return path, info, nil
+}
+
+// Prune removes the oldest archives of prefix in dir, keeping the last keep ones.
+func Prune(dir, prefix string, keep int) error {
+	names, err := filepath.Glob(filepath.Join(dir, prefix+"-*"+Extension))
+	if err != nil {
+		return err
+	}
+	// the timestamp in the name sorts them by age
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// Schedule takes a snapshot every interval, keeping the last keep archives. It never returns.
+func Schedule(store storage.Store, dir, prefix string, interval time.Duration, keep int) {
+	for {
+		time.Sleep(interval)
+		path, info, err := Snapshot(store, dir, prefix)
+		if err != nil {
+			log.Println("backup:", err)
+			continue
+		}
+		log.Printf("backup: wrote %v records to %v\n", info.Records, path)
+		if err := Prune(dir, prefix, keep); err != nil {
+			log.Println("backup:", err)
+		}
+	}
+}
+
+// Restore rebuilds the leveldb at dbPath from an archive. The archive is first loaded into a
+// separate db and checked, along with validate when set; the current db is then moved aside rather
+// than deleted. The service must be stopped: the restore is refused while the db is locked.
+func Restore(archive, dbPath string, validate func(storage.Store) error) (Info, string, error) {
+	if err := checkUnlocked(dbPath); err != nil {
+		return Info{}, "", err
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return Info{}, "", err
+	}
+	defer f.Close()
+
+	tmpPath := dbPath + ".restore"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return Info{}, "", err
+	}
+	db, err := leveldb.OpenFile(tmpPath, nil)
+	if err != nil {
+		return Info{}, "", err
+	}
+	info, err := ReadArchive(f, func(key, value []byte) error {
+		return db.Put(key, value, nil)
+	})
+	if err == nil && validate != nil {
+		err = validate(storage.NewLevelDB(db))
+	}
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return Info{}, "", err
+	}
+
+	oldPath := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		oldPath = dbPath + ".pre-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		if err := os.Rename(dbPath, oldPath); err != nil {
+			return Info{}, "", err
+		}
+	}
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return Info{}, "", err
+	}
+	return info, oldPath, nil
+}
+
+// checkUnlocked takes and releases the lock of the leveldb at dbPath, held by the service using it.
+func checkUnlocked(dbPath string) error {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil
+	}
+	s, err := lvstorage.OpenFile(dbPath, false)
+	if err != nil {
+		return fmt.Errorf("backup: %v is in use, stop the service first: %v", dbPath, err)
+	}
+	return s.Close()
+}
+
+// Diff is the difference between an archive and a live db.
+type Diff struct {
+	// Missing are the keys of the archive absent from the db.
+	Missing []string
+	// Added are the keys of the db absent from the archive.
+	Added []string
+	// Changed are the keys whose value differs.
+	Changed []string
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Added) == 0 && len(d.Changed) == 0
+}
+
+func (d Diff) String() string {
+	if d.Empty() {
+		return "the db matches the backup"
+	}
+	lines := []string{}
+	for _, part := range []struct {
+		name string
+		keys []string
+	}{{"missing from the db", d.Missing}, {"added since the backup", d.Added}, {"changed", d.Changed}} {
+		lines = append(lines, fmt.Sprintf("%v keys %v", len(part.keys), part.name))
+		for _, key := range part.keys {
+			lines = append(lines, "  "+key)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Verify checks an archive and compares it with a snapshot of store.
+func Verify(archive string, store storage.Store) (Info, Diff, error) {
+	dumper, ok := store.(storage.Dumper)
+	if !ok {
+		return Info{}, Diff{}, ErrUnsupported
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return Info{}, Diff{}, err
+	}
+	defer f.Close()
+	// only the value hashes are kept, the archive may not fit in memory twice
+	backedUp := make(map[string][sha256.Size]byte)
+	info, err := ReadArchive(f, func(key, value []byte) error {
+		backedUp[string(key)] = sha256.Sum256(value)
+		return nil
+	})
+	if err != nil {
+		return Info{}, Diff{}, err
+	}
+	var diff Diff
+	err = dumper.Dump(func(key, value []byte) error {
+		sum, ok := backedUp[string(key)]
+		if !ok {
+			diff.Added = append(diff.Added, string(key))
+			return nil
+		}
+		delete(backedUp, string(key))
+		if sum != sha256.Sum256(value) {
+			diff.Changed = append(diff.Changed, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return Info{}, Diff{}, err
+	}
+	for key := range backedUp {
+		diff.Missing = append(diff.Missing, key)
+	}
+	sort.Strings(diff.Missing)
+	return info, diff, nil
+}
@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"main/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// records is a dump function over kv, in key order as leveldb gives them.
+func records(kv ...string) func(fn func(key, value []byte) error) error {
+	return func(fn func(key, value []byte) error) error {
+		for i := 0; i < len(kv); i += 2 {
+			if err := fn([]byte(kv[i]), []byte(kv[i+1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func writeArchive(t *testing.T, kv ...string) []byte {
+	var buf bytes.Buffer
+	if _, err := WriteArchive(&buf, records(kv...)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// rewrite applies fn to the uncompressed content of an archive.
+func rewrite(t *testing.T, archive []byte, fn func([]byte) []byte) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(fn(data))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestArchive(t *testing.T) {
+	var buf bytes.Buffer
+	written, err := WriteArchive(&buf, records("users-a", `{"A":1}`, "users-b", "", "ledger-1", "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+	got := []string{}
+	info, err := ReadArchive(bytes.NewReader(archive), func(key, value []byte) error {
+		got = append(got, string(key)+"="+string(value))
+		return nil
+	})
+	if err != nil || info != written || info.Records != 3 {
+		t.Fatalf("read %+v, wrote %+v, err %v", info, written, err)
+	}
+	if fmt.Sprint(got) != `[users-a={"A":1} users-b= ledger-1=x]` {
+		t.Fatalf("records %v", got)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		archive []byte
+		err     string
+	}{
+		{"flipped byte", rewrite(t, archive, func(data []byte) []byte {
+			data[len(magic)+2] ^= 1
+			return data
+		}), ErrChecksum.Error()},
+		{"record count", rewrite(t, archive, func(data []byte) []byte {
+			data[len(data)-33]++
+			return data
+		}), ErrChecksum.Error()},
+		{"cut checksum", rewrite(t, archive, func(data []byte) []byte { return data[:len(data)-10] }), "truncated"},
+		{"cut records", rewrite(t, archive, func(data []byte) []byte { return data[:len(magic)+5] }), "truncated"},
+		{"cut gzip", archive[:len(archive)/2], "truncated"},
+		{"not gzip", []byte("users-a"), "not an archive"},
+		{"other format", rewrite(t, archive, func(data []byte) []byte { return append([]byte("X"), data[1:]...) }), "not an archive"},
+	} {
+		_, err := ReadArchive(bytes.NewReader(tc.archive), func(key, value []byte) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: err %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func openDB(t *testing.T, path string, kv ...string) *leveldb.DB {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(kv); i += 2 {
+		if err := db.Put([]byte(kv[i]), []byte(kv[i+1]), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// dbContent lists the records of the leveldb at path.
+func dbContent(t *testing.T, path string) string {
+	db := openDB(t, path)
+	defer db.Close()
+	got := []string{}
+	err := storage.NewLevelDB(db).(storage.Dumper).Dump(func(key, value []byte) error {
+		got = append(got, string(key)+"="+string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprint(got)
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "airdropdb")
+	openDB(t, dbPath, "users-a", "old").Close()
+	archive := filepath.Join(dir, "backup"+Extension)
+	if err := ioutil.WriteFile(archive, writeArchive(t, "users-a", "new", "users-b", "new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := filepath.Join(dir, "corrupted"+Extension)
+	if err := ioutil.WriteFile(corrupted, rewrite(t, writeArchive(t, "users-a", "new"), func(data []byte) []byte {
+		data[len(magic)+2] ^= 1
+		return data
+	}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := errors.New("invalid user record")
+
+	// failures leave the db as it was
+	for _, tc := range []struct {
+		name     string
+		archive  string
+		validate func(storage.Store) error
+	}{
+		{"corrupted archive", corrupted, nil},
+		{"missing archive", filepath.Join(dir, "missing"+Extension), nil},
+		{"validation", archive, func(storage.Store) error { return invalid }},
+	} {
+		if _, oldPath, err := Restore(tc.archive, dbPath, tc.validate); err == nil || oldPath != "" {
+			t.Errorf("%v: restored, err %v", tc.name, err)
+		}
+		if got := dbContent(t, dbPath); got != "[users-a=old]" {
+			t.Errorf("%v: db %v", tc.name, got)
+		}
+		if _, err := os.Stat(dbPath + ".restore"); !os.IsNotExist(err) {
+			t.Errorf("%v: temporary db left: %v", tc.name, err)
+		}
+	}
+
+	// the db of a running service is not replaced under it
+	live := openDB(t, dbPath)
+	_, _, err := Restore(archive, dbPath, nil)
+	live.Close()
+	if err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("restore of a db in use: %v", err)
+	}
+
+	validated := 0
+	info, oldPath, err := Restore(archive, dbPath, func(s storage.Store) error {
+		return s.Collection("users").Scan("", "", func(id string, data []byte) error {
+			validated++
+			return nil
+		})
+	})
+	if err != nil || info.Records != 2 || validated != 2 {
+		t.Fatalf("restore: %+v, %v validated, err %v", info, validated, err)
+	}
+	if got := dbContent(t, dbPath); got != "[users-a=new users-b=new]" {
+		t.Fatalf("restored db %v", got)
+	}
+	if got := dbContent(t, oldPath); got != "[users-a=old]" {
+		t.Fatalf("previous db at %v: %v", oldPath, got)
+	}
+
+	// nothing to move aside without a db
+	if _, oldPath, err := Restore(archive, filepath.Join(dir, "fresh"), nil); err != nil || oldPath != "" {
+		t.Fatalf("restore without a db: %v %v", oldPath, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	db := openDB(t, filepath.Join(dir, "db"), "users-a", "1", "users-b", "2", "users-c", "3")
+	defer db.Close()
+	store := storage.NewLevelDB(db)
+	path, written, err := Snapshot(store, dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, diff, err := Verify(path, store)
+	if err != nil || info != written || !diff.Empty() {
+		t.Fatalf("unchanged db: %+v %v, err %v", info, diff, err)
+	}
+
+	db.Delete([]byte("users-a"), nil)
+	db.Put([]byte("users-b"), []byte("changed"), nil)
+	db.Put([]byte("users-d"), []byte("4"), nil)
+	_, diff, err = Verify(path, store)
+	if err != nil || fmt.Sprint(diff.Missing, diff.Changed, diff.Added) != "[users-a] [users-b] [users-d]" {
+		t.Fatalf("diff %+v, err %v", diff, err)
+	}
+	if !strings.Contains(diff.String(), "1 keys missing from the db\n  users-a") {
+		t.Fatalf("diff report %q", diff.String())
+	}
+}
@@ -0,0 +1,286 @@
+package cooldown
+
+import (
+	"fmt"
+	"main/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CollectionName holds the drops granted, by "<campaign>/id/<identity>" and "<campaign>/ip/<ip>".
+const CollectionName = "cooldown"
+
+// Periods a Policy counts the drops over, besides "<n>d" for a sliding window of n days.
+const (
+	PeriodEver  = "ever"
+	PeriodMonth = "month"
+)
+
+// Policy caps the drops of a campaign.
+type Policy struct {
+	// Campaign is the campaign the policy applies to. The one of the empty campaign applies to the
+	// campaigns without a policy of their own.
+	Campaign string
+	// Limit is how many drops an identity gets per period, 1 by default.
+	Limit int
+	// Period is "ever" (the default), "month" for the calendar months in UTC, or "<n>d" for the
+	// last n days.
+	Period string
+	// IPLimit is how many drops the requests from a single IP get per period, unlimited when 0.
+	IPLimit int
+}
+
+// Config sets the policies of the campaigns. Without any, an identity gets a single drop ever.
+type Config struct {
+	// Pending is how long in minutes a drop that has not settled yet holds the next request of the
+	// identity back, 30 by default.
+	Pending  int
+	Policies []Policy
+}
+
+// Grant is a drop counted against the policy.
+type Grant struct {
+	At int64
+}
+
+type history struct {
+	Grants []Grant
+}
+
+// Decision tells whether a request may get a drop.
+type Decision struct {
+	Allowed bool
+	Reason  string
+	// NextEligible is the unix time a refused request would be allowed, 0 when never.
+	NextEligible int64
+}
+
+// Limiter applies the policies to the drops, kept in the store. Checking and granting a drop is
+// atomic within the process only: replicas sharing a Mongo store may grant a few drops too many.
+type Limiter struct {
+	pending  time.Duration
+	policies map[string]Policy
+	grants   storage.Collection
+	lock     sync.Mutex
+}
+
+func NewLimiter(store storage.Store, cfg Config) (*Limiter, error) {
+	if cfg.Pending <= 0 {
+		cfg.Pending = 30
+	}
+	l := &Limiter{
+		pending:  time.Duration(cfg.Pending) * time.Minute,
+		policies: make(map[string]Policy),
+		grants:   store.Collection(CollectionName),
+	}
+	for _, p := range cfg.Policies {
+		if p.Limit <= 0 {
+			p.Limit = 1
+		}
+		if p.Period == "" {
+			p.Period = PeriodEver
+		}
+		if _, _, err := window(p.Period, time.Now()); err != nil {
+			return nil, fmt.Errorf("cooldown: campaign %q: %v", p.Campaign, err)
+		}
+		if _, ok := l.policies[p.Campaign]; ok {
+			return nil, fmt.Errorf("cooldown: campaign %q has several policies", p.Campaign)
+		}
+		l.policies[p.Campaign] = p
+	}
+	return l, nil
+}
+
+// Pending is how long a drop that has not settled yet holds the next request back.
+func (l *Limiter) Pending() time.Duration {
+	return l.pending
+}
+
+func (l *Limiter) policy(campaign string) Policy {
+	if p, ok := l.policies[campaign]; ok {
+		return p
+	}
+	if p, ok := l.policies[""]; ok {
+		return p
+	}
+	return Policy{Limit: 1, Period: PeriodEver}
+}
+
+// window returns the start of the period at now, and its end for the calendar periods, 0 when it
+// slides or never ends.
+func window(period string, now time.Time) (int64, int64, error) {
+	switch period {
+	case PeriodEver:
+		return 0, 0, nil
+	case PeriodMonth:
+		y, m, _ := now.UTC().Date()
+		start := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+		return start.Unix(), start.AddDate(0, 1, 0).Unix(), nil
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(period, "d"))
+	if !strings.HasSuffix(period, "d") || err != nil || days <= 0 {
+		return 0, 0, fmt.Errorf("invalid period %q", period)
+	}
+	return now.Add(-time.Duration(days) * 24 * time.Hour).Unix(), 0, nil
+}
+
+func docID(campaign, kind, key string) string {
+	return campaign + "/" + kind + "/" + key
+}
+
+func (l *Limiter) history(id string) (history, error) {
+	var h history
+	err := l.grants.Get(id, &h)
+	if err == storage.ErrNotFound {
+		err = nil
+	}
+	return h, err
+}
+
+// check counts the grants of h within the period and tells when the next one is allowed, 0 meaning
+// now. It returns -1 when it never is.
+func check(h history, period string, limit int, now time.Time) int64 {
+	start, end, _ := window(period, now)
+	inWindow := []int64{}
+	for _, g := range h.Grants {
+		if g.At >= start {
+			inWindow = append(inWindow, g.At)
+		}
+	}
+	if len(inWindow) < limit {
+		return 0
+	}
+	switch {
+	case period == PeriodEver:
+		return -1
+	case end != 0:
+		return end
+	}
+	// sliding: once enough of the oldest grants leave the window
+	sort.Slice(inWindow, func(i, j int) bool { return inWindow[i] < inWindow[j] })
+	return inWindow[len(inWindow)-limit] + now.Unix() - start
+}
+
+// Check tells whether identity, requesting from ip, may get a drop of campaign. An empty ip is not
+// capped, for the requests of trusted integrators.
+func (l *Limiter) Check(campaign, identity, ip string, now time.Time) (Decision, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.check(campaign, identity, ip, now)
+}
+
+func (l *Limiter) check(campaign, identity, ip string, now time.Time) (Decision, error) {
+	p := l.policy(campaign)
+	h, err := l.history(docID(campaign, "id", identity))
+	if err != nil {
+		return Decision{}, err
+	}
+	d := Decision{Allowed: true}
+	never := false
+	refuse := func(next int64, reason string) {
+		d.Allowed = false
+		d.Reason = reason
+		if next == -1 {
+			never = true
+		} else if next > d.NextEligible {
+			d.NextEligible = next
+		}
+	}
+	if next := check(h, p.Period, p.Limit, now); next != 0 {
+		refuse(next, fmt.Sprintf("already dropped %v times, period %v", p.Limit, p.Period))
+	}
+	if ip != "" && p.IPLimit != 0 {
+		h, err = l.history(docID(campaign, "ip", ip))
+		if err != nil {
+			return Decision{}, err
+		}
+		if next := check(h, p.Period, p.IPLimit, now); next != 0 {
+			refuse(next, fmt.Sprintf("already dropped %v times to %v, period %v", p.IPLimit, ip, p.Period))
+		}
+	}
+	if never {
+		d.NextEligible = 0
+	}
+	return d, nil
+}
+
+// Acquire checks the request like Check and counts a drop at now if it is allowed. The drop is
+// given back with Release should it fail.
+func (l *Limiter) Acquire(campaign, identity, ip string, now time.Time) (Decision, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	d, err := l.check(campaign, identity, ip, now)
+	if err != nil || !d.Allowed {
+		return d, err
+	}
+	period := l.policy(campaign).Period
+	if err := l.add(docID(campaign, "id", identity), period, now); err != nil {
+		return d, err
+	}
+	if ip != "" && l.policy(campaign).IPLimit != 0 {
+		if err := l.add(docID(campaign, "ip", ip), period, now); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// add appends a grant at now, dropping those too old to count over period.
+func (l *Limiter) add(id, period string, now time.Time) error {
+	h, err := l.history(id)
+	if err != nil {
+		return err
+	}
+	start, _, _ := window(period, now)
+	kept := []Grant{}
+	for _, g := range h.Grants {
+		if g.At >= start {
+			kept = append(kept, g)
+		}
+	}
+	h.Grants = append(kept, Grant{At: now.Unix()})
+	return l.grants.Put(id, h)
+}
+
+// Release gives back the drop acquired at the unix time at, which failed.
+func (l *Limiter) Release(campaign, identity, ip string, at int64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ids := []string{docID(campaign, "id", identity)}
+	if ip != "" {
+		ids = append(ids, docID(campaign, "ip", ip))
+	}
+	for _, id := range ids {
+		h, err := l.history(id)
+		if err != nil {
+			return err
+		}
+		for i, g := range h.Grants {
+			if g.At == at {
+				h.Grants = append(h.Grants[:i], h.Grants[i+1:]...)
+				if err := l.grants.Put(id, h); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Seed counts a drop given at the unix time at, before the limiter existed, unless identity already
+// has one.
+func (l *Limiter) Seed(campaign, identity string, at int64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	id := docID(campaign, "id", identity)
+	h, err := l.history(id)
+	if err != nil || len(h.Grants) > 0 {
+		return err
+	}
+	h.Grants = []Grant{{At: at}}
+	return l.grants.Put(id, h)
+}
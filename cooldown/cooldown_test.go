@@ -0,0 +1,147 @@
+package cooldown
+
+import (
+	"main/storage"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newTestLimiter(t *testing.T, policies ...Policy) *Limiter {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	l, err := NewLimiter(storage.NewLevelDB(db), Config{Policies: policies})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestWindow(t *testing.T) {
+	now := time.Date(2021, 11, 20, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		period     string
+		start, end int64
+	}{
+		{PeriodEver, 0, 0},
+		{PeriodMonth, time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC).Unix(), time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		{"7d", now.AddDate(0, 0, -7).Unix(), 0},
+	}
+	for _, tc := range tests {
+		start, end, err := window(tc.period, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start != tc.start || end != tc.end {
+			t.Errorf("%v: window [%v, %v), want [%v, %v)", tc.period, start, end, tc.start, tc.end)
+		}
+	}
+	for _, period := range []string{"", "week", "0d", "-1d", "d"} {
+		if _, _, err := window(period, now); err == nil {
+			t.Errorf("%q: no error", period)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2021, 11, 20, 0, 0, 0, 0, time.UTC)
+	day := int64(24 * 3600)
+	at := func(daysAgo int64) Grant { return Grant{At: now.Unix() - daysAgo*day} }
+	tests := []struct {
+		name   string
+		grants []Grant
+		period string
+		limit  int
+		next   int64
+	}{
+		{"ever, none", nil, PeriodEver, 1, 0},
+		{"ever, used", []Grant{at(400)}, PeriodEver, 1, -1},
+		{"ever, one left", []Grant{at(400)}, PeriodEver, 2, 0},
+		{"month, last month", []Grant{at(20)}, PeriodMonth, 1, 0},
+		{"month, used", []Grant{at(2)}, PeriodMonth, 1, time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		{"sliding, out of the window", []Grant{at(8)}, "7d", 1, 0},
+		{"sliding, used", []Grant{at(3)}, "7d", 1, now.Unix() + 4*day},
+		// the second oldest of the window has to leave it for a third drop of 2
+		{"sliding, limit 2", []Grant{at(1), at(5), at(3), at(10)}, "7d", 2, now.Unix() + 4*day},
+	}
+	for _, tc := range tests {
+		if next := check(history{Grants: tc.grants}, tc.period, tc.limit, now); next != tc.next {
+			t.Errorf("%v: next %v, want %v", tc.name, next, tc.next)
+		}
+	}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	l := newTestLimiter(t,
+		Policy{Campaign: "", Limit: 1, Period: "7d", IPLimit: 2},
+		Policy{Campaign: "first-trade", Period: PeriodEver},
+	)
+	now := time.Date(2021, 11, 20, 0, 0, 0, 0, time.UTC)
+
+	d, err := l.Acquire("", "a", "203.0.113.7", now)
+	if err != nil || !d.Allowed {
+		t.Fatalf("first drop: %+v, %v", d, err)
+	}
+	d, err = l.Check("", "a", "203.0.113.7", now.Add(time.Hour))
+	if err != nil || d.Allowed || d.NextEligible != now.AddDate(0, 0, 7).Unix() {
+		t.Fatalf("second drop: %+v, %v", d, err)
+	}
+	// a campaign without a policy falls back to the one of the empty campaign, counted apart
+	if d, err := l.Check("other", "a", "203.0.113.7", now); err != nil || !d.Allowed {
+		t.Fatalf("other campaign: %+v, %v", d, err)
+	}
+	if d, err := l.Check("first-trade", "a", "", now); err != nil || !d.Allowed {
+		t.Fatalf("first trade: %+v, %v", d, err)
+	}
+
+	// the IP gets 2 drops over the period, the trusted requests without an IP are not capped
+	if d, err := l.Acquire("", "b", "203.0.113.7", now); err != nil || !d.Allowed {
+		t.Fatalf("second identity: %+v, %v", d, err)
+	}
+	if d, err := l.Acquire("", "c", "203.0.113.7", now); err != nil || d.Allowed {
+		t.Fatalf("third identity from the IP: %+v, %v", d, err)
+	}
+	if d, err := l.Acquire("", "c", "", now); err != nil || !d.Allowed {
+		t.Fatalf("third identity, trusted: %+v, %v", d, err)
+	}
+
+	// a failed drop is given back to both the identity and the IP
+	if err := l.Release("", "b", "203.0.113.7", now.Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := l.Acquire("", "b", "203.0.113.7", now.Add(time.Minute)); err != nil || !d.Allowed {
+		t.Fatalf("released drop: %+v, %v", d, err)
+	}
+
+	// never again, whenever asked
+	if d, err := l.Acquire("first-trade", "a", "", now); err != nil || !d.Allowed {
+		t.Fatalf("first trade: %+v, %v", d, err)
+	}
+	if d, err := l.Check("first-trade", "a", "", now.AddDate(5, 0, 0)); err != nil || d.Allowed || d.NextEligible != 0 {
+		t.Fatalf("second trade: %+v, %v", d, err)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	l := newTestLimiter(t)
+	now := time.Now()
+	if err := l.Seed("", "a", now.Add(-time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := l.Check("", "a", "", now); err != nil || d.Allowed {
+		t.Fatalf("seeded: %+v, %v", d, err)
+	}
+	// a seed never adds to the grants of an identity
+	if err := l.Seed("", "a", now.Unix()); err != nil {
+		t.Fatal(err)
+	}
+	h, err := l.history(docID("", "id", "a"))
+	if err != nil || len(h.Grants) != 1 {
+		t.Fatalf("grants %+v, %v", h.Grants, err)
+	}
+}
@@ -0,0 +1,70 @@
+package faucet
+
+import (
+	"encoding/json"
+	"main/accesslist"
+	"main/storage"
+	"main/sybil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newTestAccessLists(t *testing.T) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	accessLists = accesslist.New(storage.NewLevelDB(db))
+}
+
+func TestCheckAccessList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newTestAccessLists(t)
+	check := func(pubkey string) (bool, bool, int) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/requestdrop", nil)
+		allowed, ok := checkAccessList(c, pubkey)
+		return allowed, ok, w.Code
+	}
+
+	if allowed, ok, _ := check(testPubkey); allowed || !ok {
+		t.Fatalf("unlisted key: allowed %v, ok %v", allowed, ok)
+	}
+	if _, err := accessLists.Put(accesslist.Entry{Pubkey: testPaymentAddress, List: accesslist.Allow}); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, ok, _ := check(testPubkey); !allowed || !ok {
+		t.Fatalf("allowed key: allowed %v, ok %v", allowed, ok)
+	}
+	if _, err := accessLists.Put(accesslist.Entry{Pubkey: testPubkey, List: accesslist.Deny}); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, ok, code := check(testPubkey); allowed || ok || code != http.StatusForbidden {
+		t.Fatalf("denied key: allowed %v, ok %v, status %v", allowed, ok, code)
+	}
+}
+
+// TestApproveDenied checks that a drop held for review is not carried out once its key is denied.
+func TestApproveDenied(t *testing.T) {
+	newTestAccessLists(t)
+	if _, err := accessLists.Put(accesslist.Entry{Pubkey: testPubkey, List: accesslist.Deny, Reason: "bot"}); err != nil {
+		t.Fatal(err)
+	}
+	adc.UserAccounts = make(map[string]*UserAccount)
+	request, _ := json.Marshal(airdropRequest{PaymentAddress: testPaymentAddress, Pubkey: testPubkey, ShardID: testShardID})
+	err := approveHeldAirdrop(sybil.Review{Request: request})
+	if err == nil || !strings.Contains(err.Error(), "deny list") {
+		t.Fatalf("approved a denied key: %v", err)
+	}
+	if _, ok := adc.UserAccounts[testPubkey]; ok {
+		t.Fatal("denied key registered")
+	}
+}
@@ -0,0 +1,697 @@
+package faucet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"main/ledger"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+)
+
+const (
+	bulkTxTimeout      = 30 * time.Minute
+	bulkPollInterval   = 10 * time.Second
+	bulkRebroadcastGap = 6 // polls a tx may stay unknown to the fullnode before it is sent again
+	bulkMaxExpiries    = 3 // times a batch may expire before the run gives up on it
+)
+
+// errBulkTxExpired is returned by waitBulkTx for a tx not confirmed in time or lost by the fullnode.
+var errBulkTxExpired = errors.New("tx expired")
+
+// Statuses of a bulk drop batch in the checkpoint file.
+const (
+	bulkBatchBuilt     = "built"
+	bulkBatchSent      = "sent"
+	bulkBatchConfirmed = "confirmed"
+)
+
+// bulkOutput is one line of the input file. Amount is in nano units of Token.
+type bulkOutput struct {
+	Address string
+	Token   string
+	Amount  uint64
+}
+
+// bulkBatch is one tx of the plan: up to MaxTxOutput outputs of the same token from one account.
+type bulkBatch struct {
+	ID      int
+	Account *AirdropAccount
+	Token   string
+	Outputs []bulkOutput
+}
+
+type bulkBatchState struct {
+	TxHash    string
+	RawTx     []byte
+	IsTokenTx bool
+	// Fee is 0 in the checkpoints written before fees were estimated, which used the default one.
+	Fee    uint64
+	Status string
+	// Inputs and TokenInputs are the key images of the coins the tx spends. They are unknown in the
+	// checkpoints written before the batches were rebuilt.
+	Inputs      []string
+	TokenInputs []string
+	// Replaces lists the expired txs of the batch, which spend the same coins: any of them may still
+	// land instead.
+	Replaces []string
+}
+
+// bulkCheckpoint is saved after every step of a run. A batch gets its tx stored before the tx is
+// sent, so that a resumed run sends the very same tx again instead of paying its outputs twice.
+type bulkCheckpoint struct {
+	// PlanHash identifies the input file and accounts the batches were planned from.
+	PlanHash string
+	Batches  map[int]*bulkBatchState
+
+	lock sync.Mutex
+	path string
+}
+
+// runBulkdropCommand is the `bulkdrop` subcommand, sending arbitrary amounts to the addresses of
+// a CSV or JSON file.
+func runBulkdropCommand(args []string) error {
+	fs := flag.NewFlagSet("bulkdrop", flag.ExitOnError)
+	file := fs.String("file", "", "CSV (address,token,amount) or JSON file of the outputs to send")
+	dryRun := fs.Bool("dry-run", false, "only print the plan and its cost")
+	checkpointPath := fs.String("checkpoint", "", "checkpoint file, defaults to <file>.checkpoint")
+	campaign := fs.String("campaign", "bulkdrop", "campaign of the drops in the ledger")
+	fs.Parse(args)
+	if *file == "" {
+		return fmt.Errorf("bulkdrop: -file is required")
+	}
+	if *checkpointPath == "" {
+		*checkpointPath = *file + ".checkpoint"
+	}
+
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	outputs, err := parseBulkOutputs(data, strings.ToLower(filepath.Ext(*file)) == ".json")
+	if err != nil {
+		return err
+	}
+	batches, err := planBulkBatches(outputs)
+	if err != nil {
+		return err
+	}
+	printBulkSummary(batches)
+	if *dryRun {
+		return nil
+	}
+
+	checkpoint, err := loadBulkCheckpoint(*checkpointPath, bulkPlanHash(data))
+	if err != nil {
+		return err
+	}
+	failed := runBulkBatches(batches, checkpoint, *campaign)
+	if failed > 0 {
+		return fmt.Errorf("bulkdrop: %v batches failed, run again to resume", failed)
+	}
+	log.Println("bulkdrop: all batches confirmed")
+	return nil
+}
+
+func parseBulkOutputs(data []byte, isJSON bool) ([]bulkOutput, error) {
+	var outputs []bulkOutput
+	if isJSON {
+		if err := json.Unmarshal(data, &outputs); err != nil {
+			return nil, err
+		}
+	} else {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		line := 0
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			line++
+			if len(record) != 3 {
+				return nil, fmt.Errorf("line %v: expected address,token,amount", line)
+			}
+			amount, err := strconv.ParseUint(strings.TrimSpace(record[2]), 10, 64)
+			if err != nil {
+				if line == 1 {
+					continue // header
+				}
+				return nil, fmt.Errorf("line %v: invalid amount %v", line, record[2])
+			}
+			outputs = append(outputs, bulkOutput{
+				Address: strings.TrimSpace(record[0]),
+				Token:   strings.TrimSpace(record[1]),
+				Amount:  amount,
+			})
+		}
+	}
+
+	invalid := []string{}
+	for i := range outputs {
+		o := &outputs[i]
+		if o.Token == "" || strings.EqualFold(o.Token, "prv") {
+			o.Token = common.PRVIDStr
+		}
+		if _, err := hex.DecodeString(o.Token); err != nil || len(o.Token) != 64 {
+			invalid = append(invalid, fmt.Sprintf("#%v: invalid token %v", i+1, o.Token))
+		}
+		if o.Amount == 0 {
+			invalid = append(invalid, fmt.Sprintf("#%v: zero amount", i+1))
+		}
+		wl, err := wallet.Base58CheckDeserialize(o.Address)
+		if err != nil || len(wl.KeySet.PaymentAddress.Pk) == 0 {
+			invalid = append(invalid, fmt.Sprintf("#%v: invalid address %v", i+1, o.Address))
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("%v invalid outputs:\n%v", len(invalid), strings.Join(invalid, "\n"))
+	}
+	return outputs, nil
+}
+
+// planBulkBatches sends each output from the first account of the receiver's shard, and groups
+// them into txs in a deterministic order so that a resumed run plans the same batches. The plan
+// fails when a receiver's shard has no account.
+func planBulkBatches(outputs []bulkOutput) ([]*bulkBatch, error) {
+	accountIdxByShard := make(map[int]int)
+	for i := len(adc.AirdropAccounts) - 1; i >= 0; i-- {
+		accountIdxByShard[adc.AirdropAccounts[i].ShardID] = i
+	}
+	grouped := make([]map[string][]bulkOutput, len(adc.AirdropAccounts))
+	missing := make(map[int]int)
+	for _, o := range outputs {
+		wl, _ := wallet.Base58CheckDeserialize(o.Address)
+		pk := wl.KeySet.PaymentAddress.Pk
+		shardID := int(common.GetShardIDFromLastByte(pk[len(pk)-1]))
+		accIdx, ok := accountIdxByShard[shardID]
+		if !ok {
+			missing[shardID]++
+			continue
+		}
+		if grouped[accIdx] == nil {
+			grouped[accIdx] = make(map[string][]bulkOutput)
+		}
+		grouped[accIdx][o.Token] = append(grouped[accIdx][o.Token], o)
+	}
+	if len(missing) > 0 {
+		shards := []string{}
+		for shardID, count := range missing {
+			shards = append(shards, fmt.Sprintf("shard %v (%v outputs)", shardID, count))
+		}
+		sort.Strings(shards)
+		return nil, fmt.Errorf("no airdrop account for %v", strings.Join(shards, ", "))
+	}
+
+	batches := []*bulkBatch{}
+	for accIdx, byToken := range grouped {
+		tokens := []string{}
+		for token := range byToken {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+		for _, token := range tokens {
+			list := byToken[token]
+			for start := 0; start < len(list); start += MaxTxOutput {
+				end := start + MaxTxOutput
+				if end > len(list) {
+					end = len(list)
+				}
+				batches = append(batches, &bulkBatch{
+					ID:      len(batches),
+					Account: adc.AirdropAccounts[accIdx],
+					Token:   token,
+					Outputs: list[start:end],
+				})
+			}
+		}
+	}
+	return batches, nil
+}
+
+func printBulkSummary(batches []*bulkBatch) {
+	type accountCost struct {
+		txs     int
+		amounts map[string]uint64
+	}
+	costs := make(map[*AirdropAccount]*accountCost)
+	totalOutputs := 0
+	totalFee := uint64(0)
+	for _, b := range batches {
+		cost, ok := costs[b.Account]
+		if !ok {
+			cost = &accountCost{amounts: make(map[string]uint64)}
+			costs[b.Account] = cost
+		}
+		cost.txs++
+		txFee := bulkTxFee(b)
+		cost.amounts[common.PRVIDStr] += txFee
+		totalFee += txFee
+		for _, o := range b.Outputs {
+			cost.amounts[b.Token] += o.Amount
+		}
+		totalOutputs += len(b.Outputs)
+	}
+	fmt.Printf("%v outputs in %v txs, %v in fees at most\n", totalOutputs, len(batches), totalFee)
+	for _, acc := range adc.AirdropAccounts {
+		cost, ok := costs[acc]
+		if !ok {
+			continue
+		}
+		fmt.Printf("account %v (shard %v): %v txs\n", acc.PaymentAddress, acc.ShardID, cost.txs)
+		for token, amount := range cost.amounts {
+			balance, err := incClient.GetBalance(acc.Privatekey, token)
+			status := "ok"
+			if err != nil {
+				status = "balance unknown: " + err.Error()
+			} else if balance < amount {
+				status = fmt.Sprintf("INSUFFICIENT, balance %v", balance)
+			}
+			fmt.Printf("\t%v: %v fees included, %v\n", token, amount, status)
+		}
+	}
+}
+
+func bulkPlanHash(data []byte) string {
+	h := sha256.New()
+	h.Write(data)
+	for _, acc := range adc.AirdropAccounts {
+		h.Write([]byte(acc.PaymentAddress))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func loadBulkCheckpoint(path, planHash string) (*bulkCheckpoint, error) {
+	checkpoint := &bulkCheckpoint{PlanHash: planHash, Batches: make(map[int]*bulkBatchState), path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, checkpoint.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, err
+	}
+	if checkpoint.PlanHash != planHash {
+		return nil, fmt.Errorf("checkpoint %v belongs to another file or account set", path)
+	}
+	log.Printf("bulkdrop: resuming from %v\n", path)
+	return checkpoint, nil
+}
+
+// save writes the checkpoint through a temp file so that a crash never leaves it half written.
+func (cp *bulkCheckpoint) save() error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := cp.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, cp.path)
+}
+
+func (cp *bulkCheckpoint) set(id int, state bulkBatchState) error {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	cp.Batches[id] = &state
+	return cp.save()
+}
+
+func (cp *bulkCheckpoint) get(id int) (bulkBatchState, bool) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	state, ok := cp.Batches[id]
+	if !ok {
+		return bulkBatchState{}, false
+	}
+	return *state, true
+}
+
+// runBulkBatches runs the accounts in parallel. The batches of an account go one at a time, each
+// waiting for the previous one to be confirmed since it spends its change. It returns the number
+// of batches left undone.
+func runBulkBatches(batches []*bulkBatch, checkpoint *bulkCheckpoint, campaign string) int {
+	byAccount := make(map[*AirdropAccount][]*bulkBatch)
+	for _, b := range batches {
+		byAccount[b.Account] = append(byAccount[b.Account], b)
+	}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	failed := 0
+	for _, list := range byAccount {
+		wg.Add(1)
+		go func(list []*bulkBatch) {
+			defer wg.Done()
+			for i, b := range list {
+				if err := runBulkBatch(b, checkpoint, campaign); err != nil {
+					log.Printf("bulkdrop: batch %v from %v: %v\n", b.ID, b.Account.PaymentAddress, err)
+					lock.Lock()
+					failed += len(list) - i
+					lock.Unlock()
+					return
+				}
+			}
+		}(list)
+	}
+	wg.Wait()
+	return failed
+}
+
+func runBulkBatch(b *bulkBatch, checkpoint *bulkCheckpoint, campaign string) error {
+	state, ok := checkpoint.get(b.ID)
+	if ok && state.Status == bulkBatchConfirmed {
+		return nil
+	}
+	if !ok {
+		var err error
+		state, err = buildBulkTx(b)
+		if err != nil {
+			return err
+		}
+		if err := checkpoint.set(b.ID, state); err != nil {
+			return err
+		}
+	}
+	for expiries := 0; ; expiries++ {
+		if state.Status == bulkBatchBuilt {
+			if err := sendBulkTx(state); err != nil {
+				// an interrupted run may have sent it already
+				if _, checkErr := incClient.CheckTxInBlock(state.TxHash); checkErr != nil {
+					return err
+				}
+			}
+			recordBulkBatch(b, state, campaign)
+			state.Status = bulkBatchSent
+			if err := checkpoint.set(b.ID, state); err != nil {
+				return err
+			}
+			log.Printf("bulkdrop: batch %v sent: %v\n", b.ID, state.TxHash)
+		}
+		landed, err := waitBulkTx(state)
+		if err == errBulkTxExpired && expiries < bulkMaxExpiries {
+			if state, err = rebuildBulkTx(b, state); err != nil {
+				return err
+			}
+			if err := checkpoint.set(b.ID, state); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("tx %v: %v", state.TxHash, err)
+		}
+		settleBulkBatch(state, landed)
+		state.Status = bulkBatchConfirmed
+		log.Printf("bulkdrop: batch %v confirmed: %v\n", b.ID, landed)
+		return checkpoint.set(b.ID, state)
+	}
+}
+
+// settleBulkBatch records the tx of a batch that landed as confirmed and the others as replaced.
+func settleBulkBatch(state bulkBatchState, landed string) {
+	for _, txHash := range append([]string{state.TxHash}, state.Replaces...) {
+		event, replacedBy := ledger.EventReplaced, landed
+		if txHash == landed {
+			event, replacedBy = ledger.EventConfirmed, ""
+		}
+		if err := txLedger.RecordSettled(txHash, event, replacedBy); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// bulkTxFee estimates the fee of a batch, counting as many inputs as outputs since the coins are
+// only picked when the tx is built.
+func bulkTxFee(b *bulkBatch) uint64 {
+	numOutputs := len(b.Outputs) + 1
+	if b.Token != common.PRVIDStr {
+		numOutputs++
+	}
+	return feeEstimator.Fee(b.Account.PaymentAddress, len(b.Outputs), numOutputs, b.Token != common.PRVIDStr)
+}
+
+// buildBulkTx picks the coins of a batch, leaving room for the higher fee of a rebuild, which has
+// to spend the very same coins.
+func buildBulkTx(b *bulkBatch) (bulkBatchState, error) {
+	ada := b.Account
+	if err := loadAirdropAccountUTXOs(ada); err != nil {
+		return bulkBatchState{}, err
+	}
+	isTokenTx := b.Token != common.PRVIDStr
+	if isTokenTx {
+		if err := getAirdropAccountTokenUTXOs(ada, b.Token); err != nil {
+			return bulkBatchState{}, err
+		}
+	}
+	total := uint64(0)
+	for _, o := range b.Outputs {
+		total += o.Amount
+	}
+	txFee := bulkTxFee(b)
+	prvNeeded := feeEstimator.Replacement(txFee)
+
+	ada.lock.Lock()
+	defer ada.lock.Unlock()
+	var tokenCoins []Coin
+	if isTokenTx {
+		var err error
+		if tokenCoins, err = ada.pickFreeCoins(b.Token, total); err != nil {
+			return bulkBatchState{}, err
+		}
+	} else {
+		prvNeeded += total
+	}
+	prvCoins, err := ada.pickFreeCoins(common.PRVIDStr, prvNeeded)
+	if err == nil {
+		var state bulkBatchState
+		if state, err = buildBulkTxWithCoins(b, tokenCoins, prvCoins, txFee); err == nil {
+			return state, nil
+		}
+	}
+	for _, v := range append(tokenCoins, prvCoins...) {
+		delete(ada.UTXOInUse, v.Coin.GetPublicKey().String())
+	}
+	return bulkBatchState{}, err
+}
+
+func buildBulkTxWithCoins(b *bulkBatch, tokenCoins, prvCoins []Coin, fee uint64) (bulkBatchState, error) {
+	addrList := []string{}
+	amountList := []uint64{}
+	for _, o := range b.Outputs {
+		addrList = append(addrList, o.Address)
+		amountList = append(amountList, o.Amount)
+	}
+	prvCoinList, prvIdxList, prvKeyImages := splitCoins(prvCoins)
+	state := bulkBatchState{Fee: fee, Status: bulkBatchBuilt, Inputs: prvKeyImages}
+	var err error
+	if b.Token == common.PRVIDStr {
+		txParam := incclient.NewTxParam(b.Account.Privatekey, addrList, amountList, fee, nil, nil, nil)
+		state.RawTx, state.TxHash, err = incClient.CreateRawTransactionWithInputCoins(txParam, prvCoinList, prvIdxList)
+	} else {
+		tokenCoinList, tokenIdxList, tokenKeyImages := splitCoins(tokenCoins)
+		txTokenParam := incclient.NewTxTokenParam(b.Token, 1, addrList, amountList, false, 0, nil)
+		txParam := incclient.NewTxParam(b.Account.Privatekey, []string{}, []uint64{}, fee, txTokenParam, nil, nil)
+		state.RawTx, state.TxHash, err = incClient.CreateRawTokenTransactionWithInputCoins(txParam, tokenCoinList, tokenIdxList, prvCoinList, prvIdxList)
+		state.IsTokenTx = true
+		state.TokenInputs = tokenKeyImages
+	}
+	return state, err
+}
+
+func splitCoins(coins []Coin) ([]coin.PlainCoin, []uint64, []string) {
+	coinList := []coin.PlainCoin{}
+	idxList := []uint64{}
+	keyImages := []string{}
+	for _, v := range coins {
+		coinList = append(coinList, v.Coin)
+		idxList = append(idxList, v.Index)
+		keyImages = append(keyImages, keyImageString(v.Coin))
+	}
+	return coinList, idxList, keyImages
+}
+
+// rebuildBulkTx replaces the expired tx of a batch. While its coins are unspent, the new tx spends
+// the same ones with a higher fee so that only one of the txs can pay. Once another tx spent them,
+// none of the batch's txs can land any more and the batch is built anew.
+func rebuildBulkTx(b *bulkBatch, state bulkBatchState) (bulkBatchState, error) {
+	if len(state.Inputs) == 0 {
+		return state, fmt.Errorf("tx %v expired and its inputs are unknown: check it before removing batch %v from the checkpoint", state.TxHash, b.ID)
+	}
+	inMempool, err := mempool.CheckCoinsInMempool(append(append([]string{}, state.Inputs...), state.TokenInputs...))
+	if err != nil {
+		return state, err
+	}
+	for _, spent := range inMempool {
+		if spent {
+			log.Printf("bulkdrop: batch %v: tx %v expired with its inputs in the mempool, waiting again\n", b.ID, state.TxHash)
+			return state, nil
+		}
+	}
+
+	ada := b.Account
+	if err := loadAirdropAccountUTXOs(ada); err != nil {
+		return state, err
+	}
+	if state.IsTokenTx {
+		if err := getAirdropAccountTokenUTXOs(ada, b.Token); err != nil {
+			return state, err
+		}
+	}
+	ada.lock.Lock()
+	prvCoins, unspent := ada.coinsByKeyImages(common.PRVIDStr, state.Inputs)
+	tokenCoins, tokenUnspent := ada.coinsByKeyImages(b.Token, state.TokenInputs)
+	ada.lock.Unlock()
+
+	if !unspent || !tokenUnspent {
+		landed, err := bulkTxSpending(b, state)
+		if err != nil {
+			return state, err
+		}
+		if landed != "" {
+			// waitBulkTx sees it confirmed next
+			return state, nil
+		}
+		rebuilt, err := buildBulkTx(b)
+		if err != nil {
+			return state, err
+		}
+		for _, txHash := range append([]string{state.TxHash}, state.Replaces...) {
+			if err := txLedger.RecordSettled(txHash, ledger.EventFailed, ""); err != nil {
+				log.Println(err)
+			}
+		}
+		log.Printf("bulkdrop: batch %v: the inputs of tx %v were spent by another tx, built anew as %v\n", b.ID, state.TxHash, rebuilt.TxHash)
+		return rebuilt, nil
+	}
+
+	fee := feeEstimator.Replacement(state.Fee)
+	if fee <= state.Fee {
+		return state, fmt.Errorf("tx %v expired with its fee already at the ceiling", state.TxHash)
+	}
+	rebuilt, err := buildBulkTxWithCoins(b, tokenCoins, prvCoins, fee)
+	if err != nil {
+		return state, err
+	}
+	rebuilt.Replaces = append(append([]string{}, state.Replaces...), state.TxHash)
+	log.Printf("bulkdrop: batch %v: tx %v expired, rebuilt as %v with fee %v\n", b.ID, state.TxHash, rebuilt.TxHash, fee)
+	return rebuilt, nil
+}
+
+// bulkTxSpending returns which tx of the batch spent its PRV inputs, if any did.
+func bulkTxSpending(b *bulkBatch, state bulkBatchState) (string, error) {
+	spentBy, err := incClient.GetTxHashBySerialNumbers(state.Inputs, common.PRVIDStr, byte(b.Account.ShardID))
+	if err != nil {
+		return "", err
+	}
+	for _, txHash := range append([]string{state.TxHash}, state.Replaces...) {
+		for _, spender := range spentBy {
+			if spender == txHash {
+				return txHash, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func sendBulkTx(state bulkBatchState) error {
+	if state.IsTokenTx {
+		return incClient.SendRawTokenTx(state.RawTx)
+	}
+	return incClient.SendRawTx(state.RawTx)
+}
+
+// recordBulkBatch records the broadcast of the tx of a batch. A resumed run records the same tx
+// again, so the outputs already in the ledger under its hash are skipped.
+func recordBulkBatch(b *bulkBatch, state bulkBatchState, campaign string) {
+	recorded, err := txLedger.BroadcastEntries(state.TxHash)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	// the outputs recorded by receiver and amount, a receiver possibly being paid several times
+	seen := make(map[string]int)
+	for _, e := range recorded {
+		seen[fmt.Sprintf("%v %v", e.Receiver, e.Amount)]++
+	}
+	txFee := state.Fee
+	if txFee == 0 {
+		txFee = incclient.DefaultPRVFee
+	}
+	entries := []ledger.Entry{}
+	for i, o := range b.Outputs {
+		if key := fmt.Sprintf("%v %v", o.Address, o.Amount); seen[key] > 0 {
+			seen[key]--
+			continue
+		}
+		e := ledger.Entry{
+			Kind:     ledger.KindDrop,
+			Campaign: campaign,
+			TxHash:   state.TxHash,
+			Account:  b.Account.PaymentAddress,
+			Receiver: o.Address,
+			TokenID:  b.Token,
+			Amount:   o.Amount,
+		}
+		if i == 0 {
+			e.Fee = txFee
+		}
+		entries = append(entries, e)
+	}
+	if err := txLedger.RecordBroadcast(entries...); err != nil {
+		log.Println(err)
+	}
+}
+
+// waitBulkTx polls until a tx of the batch is in a block and returns its hash. The current tx is
+// sent again as is when the fullnode lost it: the same bytes can never pay twice.
+func waitBulkTx(state bulkBatchState) (string, error) {
+	deadline := time.Now().Add(bulkTxTimeout)
+	misses := 0
+	for time.Now().Before(deadline) {
+		isInBlock, err := incClient.CheckTxInBlock(state.TxHash)
+		if err == nil && isInBlock {
+			return state.TxHash, nil
+		}
+		for _, txHash := range state.Replaces {
+			if isReplacedInBlock, replacedErr := incClient.CheckTxInBlock(txHash); replacedErr == nil && isReplacedInBlock {
+				return txHash, nil
+			}
+		}
+		if err != nil {
+			misses++
+			if misses%bulkRebroadcastGap == 0 {
+				if err := sendBulkTx(state); err != nil {
+					log.Printf("bulkdrop: tx %v lost and cannot be sent again: %v\n", state.TxHash, err)
+					return "", errBulkTxExpired
+				}
+				log.Printf("bulkdrop: tx %v sent again\n", state.TxHash)
+			}
+		}
+		time.Sleep(bulkPollInterval)
+	}
+	return "", errBulkTxExpired
+}
@@ -0,0 +1,285 @@
+package faucet
+
+import (
+	"errors"
+	"fmt"
+	"main/ledger"
+	"main/storage"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// bulkNode lands the txs it is sent at once. The key images in inMempool are spent by pending txs,
+// those in spentBy by the txs on chain.
+type bulkNode struct {
+	*buildNode
+	failSends bool
+	landed    map[string]int
+	inMempool map[string]bool
+	spentBy   map[string]string
+}
+
+func newBulkNode(node *buildNode) *bulkNode {
+	n := &bulkNode{buildNode: node, landed: make(map[string]int), inMempool: make(map[string]bool), spentBy: make(map[string]string)}
+	incClient = n
+	mempool = n
+	return n
+}
+
+func (n *bulkNode) SendRawTx(encodedTx []byte) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.failSends {
+		return errors.New("connection refused")
+	}
+	n.landed[string(encodedTx)]++
+	return nil
+}
+
+func (n *bulkNode) SendRawTokenTx(encodedTx []byte) error {
+	return n.SendRawTx(encodedTx)
+}
+
+func (n *bulkNode) CheckTxInBlock(txHash string) (bool, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.landed[txHash] == 0 {
+		return false, errors.New("tx not found")
+	}
+	return true, nil
+}
+
+func (n *bulkNode) CheckCoinsInMempool(snList []string) ([]bool, error) {
+	result := []bool{}
+	for _, sn := range snList {
+		result = append(result, n.inMempool[sn])
+	}
+	return result, nil
+}
+
+func (n *bulkNode) GetTxHashBySerialNumbers(snList []string, tokenID string, shardID byte) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, sn := range snList {
+		result[sn] = n.spentBy[sn]
+	}
+	return result, nil
+}
+
+func testAddress(t *testing.T, shardID int) string {
+	wl, err := wallet.GenRandomWalletForShardID(byte(shardID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wl.Base58CheckSerialize(wallet.PaymentAddressType)
+}
+
+func TestRecordBulkBatch(t *testing.T) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	oldLedger := txLedger
+	defer func() { txLedger = oldLedger }()
+	if txLedger, err = ledger.New(storage.NewLevelDB(db)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bulkBatch{
+		Account: &AirdropAccount{PaymentAddress: "account"},
+		Token:   "prv",
+		Outputs: []bulkOutput{{"a", "prv", 1}, {"b", "prv", 2}, {"a", "prv", 1}},
+	}
+	state := bulkBatchState{TxHash: "tx", Fee: 100}
+	// a run cut short after recording the first output
+	if err := txLedger.RecordBroadcast(ledger.Entry{TxHash: "tx", Receiver: "a", Amount: 1, Fee: 100}); err != nil {
+		t.Fatal(err)
+	}
+	recordBulkBatch(b, state, "campaign")
+	recordBulkBatch(b, state, "campaign")
+
+	entries, err := txLedger.BroadcastEntries("tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%v entries recorded, want 3: %+v", len(entries), entries)
+	}
+	fee := uint64(0)
+	for _, e := range entries {
+		fee += e.Fee
+	}
+	if fee != 100 {
+		t.Fatalf("fee recorded %v times over", fee/100)
+	}
+}
+
+func TestPlanBulkBatches(t *testing.T) {
+	setupDrop(t, nil, nil)
+	adc.AirdropAccounts[1].ShardID = testShardID + 1
+	outputs := []bulkOutput{}
+	for i := 0; i < MaxTxOutput+1; i++ {
+		outputs = append(outputs, bulkOutput{testAddress(t, testShardID), common.PRVIDStr, 1})
+	}
+	outputs = append(outputs, bulkOutput{testAddress(t, testShardID), testTokenID, 1}, bulkOutput{testAddress(t, testShardID+1), common.PRVIDStr, 1})
+
+	batches, err := planBulkBatches(outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, b := range batches {
+		got = append(got, fmt.Sprintf("%v %v %v", b.Account.PaymentAddress, b.Token[60:], len(b.Outputs)))
+	}
+	if strings.Join(got, ", ") != "account0 0004 30, account0 0004 1, account0 0abc 1, account1 0004 1" {
+		t.Fatalf("batches %v", got)
+	}
+
+	// the shards without account are all named, and nothing is planned
+	outputs = append(outputs, bulkOutput{testAddress(t, 5), common.PRVIDStr, 1}, bulkOutput{testAddress(t, 6), common.PRVIDStr, 1}, bulkOutput{testAddress(t, 6), testTokenID, 1})
+	batches, err = planBulkBatches(outputs)
+	if err == nil || err.Error() != "no airdrop account for shard 5 (1 outputs), shard 6 (2 outputs)" || batches != nil {
+		t.Fatalf("planned %v batches, err %v", len(batches), err)
+	}
+}
+
+// TestRebuildBulkTx checks the replacement of an expired batch tx.
+func TestRebuildBulkTx(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// spend marks the first input of the expired tx as the test case has it
+		spend func(n *bulkNode, state bulkBatchState)
+		// the rebuilt tx spends the same inputs, other ones, or the expired tx is waited for again
+		want string
+	}{
+		{"inputs unspent", func(n *bulkNode, state bulkBatchState) {}, "same inputs"},
+		{"inputs in the mempool", func(n *bulkNode, state bulkBatchState) { n.inMempool[state.Inputs[0]] = true }, "wait"},
+		{"inputs spent by the batch", func(n *bulkNode, state bulkBatchState) {
+			n.spentBy[state.Inputs[0]] = state.TxHash
+			n.unspent["key0/"+common.PRVIDStr] = n.unspent["key0/"+common.PRVIDStr][1:]
+		}, "wait"},
+		{"inputs spent by another tx", func(n *bulkNode, state bulkBatchState) {
+			n.spentBy[state.Inputs[0]] = "other"
+			n.unspent["key0/"+common.PRVIDStr] = n.unspent["key0/"+common.PRVIDStr][1:]
+		}, "other inputs"},
+	} {
+		base, _ := setupDrop(t, testCoins(5000, 5000), nil)
+		node := newBulkNode(base)
+		b := &bulkBatch{Account: adc.AirdropAccounts[0], Token: common.PRVIDStr, Outputs: []bulkOutput{{testPaymentAddress, common.PRVIDStr, 1000}}}
+		state, err := buildBulkTx(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recordBulkBatch(b, state, "campaign")
+		state.Status = bulkBatchSent
+		tc.spend(node, state)
+
+		rebuilt, err := rebuildBulkTx(b, state)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		switch tc.want {
+		case "wait":
+			if rebuilt.TxHash != state.TxHash {
+				t.Errorf("%v: rebuilt as %v", tc.name, rebuilt.TxHash)
+			}
+		case "same inputs":
+			if rebuilt.TxHash == state.TxHash || rebuilt.Inputs[0] != state.Inputs[0] || rebuilt.Fee != 2*state.Fee ||
+				len(rebuilt.Replaces) != 1 || rebuilt.Replaces[0] != state.TxHash || rebuilt.Status != bulkBatchBuilt {
+				t.Errorf("%v: rebuilt %+v", tc.name, rebuilt)
+			}
+		case "other inputs":
+			if rebuilt.TxHash == state.TxHash || rebuilt.Inputs[0] == state.Inputs[0] || len(rebuilt.Replaces) != 0 || rebuilt.Status != bulkBatchBuilt {
+				t.Errorf("%v: rebuilt %+v", tc.name, rebuilt)
+			}
+			entries, _ := txLedger.Query(time.Time{}, time.Time{}, "")
+			if last := entries[len(entries)-1]; last.TxHash != state.TxHash || last.Event != ledger.EventFailed {
+				t.Errorf("%v: expired tx not failed in the ledger: %+v", tc.name, entries)
+			}
+		}
+	}
+
+	// the checkpoints written before the inputs were kept cannot tell which coins to spend
+	setupDrop(t, nil, nil)
+	b := &bulkBatch{Account: adc.AirdropAccounts[0], Token: common.PRVIDStr}
+	if _, err := rebuildBulkTx(b, bulkBatchState{TxHash: "old"}); err == nil || !strings.Contains(err.Error(), "inputs are unknown") {
+		t.Fatalf("rebuilt without inputs: %v", err)
+	}
+}
+
+// TestBulkdropResume runs a bulk drop cut short twice, and checks that the resumed run completes
+// it without building, sending or recording any batch twice.
+func TestBulkdropResume(t *testing.T) {
+	base, _ := setupDrop(t, testCoins(5000, 5000), testCoins(30, 50))
+	node := newBulkNode(base)
+	receivers := []string{testAddress(t, testShardID), testAddress(t, testShardID), testAddress(t, testShardID)}
+	outputs := []bulkOutput{}
+	for _, receiver := range receivers {
+		outputs = append(outputs, bulkOutput{receiver, common.PRVIDStr, 1000}, bulkOutput{receiver, testTokenID, 10})
+	}
+	batches, err := planBulkBatches(outputs)
+	if err != nil || len(batches) != 2 {
+		t.Fatalf("%v batches, err %v", len(batches), err)
+	}
+	path := filepath.Join(t.TempDir(), "outputs.checkpoint")
+	checkpoint, err := loadBulkCheckpoint(path, "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the PRV batch lands, the fullnode goes away before the token one is sent
+	if err := runBulkBatch(batches[0], checkpoint, "campaign"); err != nil {
+		t.Fatal(err)
+	}
+	node.failSends = true
+	if failed := runBulkBatches(batches[1:], checkpoint, "campaign"); failed != 1 {
+		t.Fatalf("%v batches failed", failed)
+	}
+	// and the run is killed as it records the PRV batch confirmed
+	state, _ := checkpoint.get(0)
+	state.Status = bulkBatchSent
+	if err := checkpoint.set(0, state); err != nil {
+		t.Fatal(err)
+	}
+
+	node.failSends = false
+	checkpoint, err = loadBulkCheckpoint(path, "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := runBulkBatches(batches, checkpoint, "campaign"); failed != 0 {
+		t.Fatalf("%v batches failed on resume", failed)
+	}
+	if node.built != 2 {
+		t.Fatalf("%v txs built for 2 batches", node.built)
+	}
+	entries, err := txLedger.Query(time.Time{}, time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2*len(outputs) {
+		t.Fatalf("%v ledger entries for %v outputs", len(entries), len(outputs))
+	}
+	events := make(map[string]int)
+	for _, e := range entries {
+		events[e.TxHash+" "+e.Event]++
+	}
+	for _, b := range batches {
+		state, _ := checkpoint.get(b.ID)
+		if state.Status != bulkBatchConfirmed || node.landed[state.TxHash] != 1 {
+			t.Fatalf("batch %v: %+v, sent %v times", b.ID, state, node.landed[state.TxHash])
+		}
+		for _, event := range []string{ledger.EventBroadcast, ledger.EventConfirmed} {
+			if n := events[state.TxHash+" "+event]; n != len(b.Outputs) {
+				t.Errorf("batch %v: %v %v entries for %v outputs", b.ID, n, event, len(b.Outputs))
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package faucet
+
+import (
+	"log"
+	"main/storage"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// claimCollection holds the triggered drops to the public keys the faucet knows no payment
+// address of, by public key.
+const claimCollection = "claims"
+
+var claimdb storage.Collection
+
+// holdClaim keeps the drop to a public key the faucet cannot pay: the privacy v2 coins cannot be
+// made for a public key alone. It is paid once its owner asks the faucet with their payment
+// address. The first drop held for a key is kept, as the cooldown would refuse the others.
+func holdClaim(c *gin.Context, drop airdropRequest) {
+	err := claimdb.Insert(drop.Pubkey, drop)
+	if err != nil && err != storage.ErrExists {
+		log.Println("claims:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": "cannot hold the drop, try again later"})
+		return
+	}
+	log.Printf("drop to %v held until claimed\n", drop.Pubkey)
+	c.JSON(http.StatusOK, gin.H{
+		"Result": 4,
+	})
+}
+
+// takeClaim returns the drop held for pubkey, if any its cooldown still allows, as a drop to
+// paymentAddress. A held drop the cooldown refuses by now is dropped.
+func takeClaim(pubkey, paymentAddress, ip string) (airdropRequest, bool) {
+	var drop airdropRequest
+	err := claimdb.Get(pubkey, &drop)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			log.Println("claims:", err)
+		}
+		return drop, false
+	}
+	d, err := cooldowns.Check(dropCampaign(drop.Campaign), pubkey, ip, time.Now())
+	if err != nil {
+		log.Println("claims:", err)
+		return drop, false
+	}
+	if !d.Allowed {
+		log.Printf("dropping the claim of %v: %v\n", pubkey, d.Reason)
+		releaseClaim(pubkey)
+		return drop, false
+	}
+	drop.PaymentAddress = paymentAddress
+	return drop, true
+}
+
+func releaseClaim(pubkey string) {
+	if err := claimdb.Delete(pubkey); err != nil && err != storage.ErrNotFound {
+		log.Println("claims:", err)
+	}
+}
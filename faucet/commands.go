@@ -0,0 +1,89 @@
+package faucet
+
+import (
+	"fmt"
+	"main/accesslist"
+	"main/service"
+	"os"
+	"text/tabwriter"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+)
+
+// RunCommand runs a maintenance command of the faucet with its config at cfgPath. restore,
+// migrate-legacy, migrate, backup, verify and accesslist only open the db; reconcile, bulkdrop,
+// accounts and verify-ledger load the accounts and users too.
+func RunCommand(cfgPath, name string, args []string) error {
+	switch name {
+	case "restore", "migrate-legacy":
+		// both rewrite the db, they must run before opening it
+		if err := readConfig(cfgPath); err != nil {
+			return err
+		}
+		if name == "migrate-legacy" {
+			return dbCommands().MigrateLegacy(args)
+		}
+		return dbCommands().Restore(args)
+	case "migrate", "backup", "verify", "accesslist":
+		if err := load(cfgPath); err != nil {
+			return err
+		}
+		switch name {
+		case "migrate":
+			return userSchema.RunMigrateCommand(userdb, args)
+		case "backup":
+			return dbCommands().Backup(args)
+		case "verify":
+			return dbCommands().Verify(args)
+		case "accesslist":
+			return accesslist.RunCommand(accessLists, args)
+		}
+	case "reconcile", "bulkdrop", "accounts", "verify-ledger":
+		if err := load(cfgPath); err != nil {
+			return err
+		}
+		if err := start(); err != nil {
+			return err
+		}
+		switch name {
+		case "reconcile":
+			return runReconcileCommand(args)
+		case "bulkdrop":
+			return runBulkdropCommand(args)
+		case "accounts":
+			return runAccountsCommand(args)
+		case "verify-ledger":
+			return service.VerifyLedger(txLedger)
+		}
+	}
+	return fmt.Errorf("faucet: %w %v", service.ErrUnknownCommand, name)
+}
+
+// runAccountsCommand is the `accounts` subcommand: the airdrop accounts with their PRV and the
+// tokens of the drop policies.
+func runAccountsCommand(args []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tADDRESS\tTOKEN\tUTXOS\tBALANCE")
+	for _, acc := range adc.AirdropAccounts {
+		if err := loadAirdropAccountUTXOs(acc); err != nil {
+			return err
+		}
+		tokens := []string{common.PRVIDStr}
+		for _, policy := range config.DropPolicies {
+			if _, ok := acc.TokenUTXOList[policy.TokenID]; ok {
+				continue
+			}
+			if err := getAirdropAccountTokenUTXOs(acc, policy.TokenID); err != nil {
+				return err
+			}
+			tokens = append(tokens, policy.TokenID)
+		}
+		for _, tokenID := range tokens {
+			acc.lock.Lock()
+			numCoins := len(acc.coins(tokenID))
+			acc.lock.Unlock()
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", acc.ShardID, acc.PaymentAddress, tokenID, numCoins, acc.freeBalance(tokenID))
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,181 @@
+package faucet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"main/cooldown"
+	"main/fee"
+	"main/health"
+	"main/idempotency"
+	"main/nodepool"
+	"main/service"
+	"main/sybil"
+	"main/webhook"
+	"os"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+)
+
+type Config struct {
+	Port          int
+	Coinservice   string
+	Fullnode      string
+	AirdropKeys   []AirdropKey
+	CaptchaSecret string
+	// Campaign tags the drops in the disbursement ledger.
+	Campaign string
+	// DropPolicies are the pTokens given along with the PRV of a drop.
+	DropPolicies []DropPolicy
+	// ShieldTiers are the numbers of airdrop coins of the shield drops by the size tier of the
+	// shield, the last one capping the larger tiers. A shield drop is a single coin without them.
+	ShieldTiers []int
+	// Fee bounds the fees estimated from the fullnode.
+	Fee fee.Config
+	// Fullnodes are more fullnodes to spread the calls over, Fullnode being the one preferred for
+	// sending the txs.
+	Fullnodes []string
+	// FullnodePool tunes the probing of the fullnodes and the broadcast of the txs.
+	FullnodePool nodepool.Config
+	// ShadowMode builds the airdrop txs without sending them, recording them for /shadow/txs
+	// instead.
+	ShadowMode bool
+	// APIKeys are the integrators allowed to register a callback, see /requestdrop.
+	APIKeys []webhook.Key
+	// WebhookSecret signs the callbacks of requests made without an API key.
+	WebhookSecret string
+	// WebhookAllowPrivate lets the callbacks reach internal addresses, for a receiver registered on
+	// the private network.
+	WebhookAllowPrivate bool
+	// Sybil configures the scoring of the public drop requests.
+	Sybil sybil.Config
+	// Cooldown sets how often a user may get a drop of the campaign.
+	Cooldown cooldown.Config
+	// Idempotency sets how long the responses to the requests with an Idempotency-Key are replayed.
+	Idempotency idempotency.Config
+	// TrustedProxies are the addresses or CIDRs of the proxies whose X-Forwarded-For and X-Real-IP
+	// headers tell the client IP the cooldown and the sybil scoring go by. The remote address of
+	// the requests is used without them.
+	TrustedProxies []string
+	// AdminKey guards the /admin endpoints, /ledger/export, /shadow/txs and /debug/vars, which are
+	// disabled without it.
+	AdminKey string
+	// DBBackend is "leveldb" (default) or "mongo", the latter allowing several replicas.
+	DBBackend string
+	MongoURI  string
+	MongoDB   string
+	// UserCollection holds the user records, "users" by default.
+	UserCollection string
+	// Namespace prefixes the collections of the pending txs, webhooks and shadow records, so that
+	// the faucet can share its storage with the other services of the process.
+	Namespace string
+	// Health tunes the checks behind /readyz.
+	Health health.Config
+	// ReconcileInterval is the period in minutes of the chain reconciliation job.
+	ReconcileInterval int
+	// BackupDir enables the scheduled backups of the leveldb backend, every BackupInterval hours
+	// (24 by default), keeping the last BackupKeep archives (7 by default).
+	BackupDir      string
+	BackupInterval int
+	BackupKeep     int
+}
+type AirdropKey struct {
+	PrivateKey string
+}
+
+// shieldCoins is the number of airdrop coins of a shield drop of the given size tier.
+func shieldCoins(tier int) int {
+	if len(config.ShieldTiers) == 0 {
+		return 1
+	}
+	if tier >= len(config.ShieldTiers) {
+		tier = len(config.ShieldTiers) - 1
+	}
+	return config.ShieldTiers[tier]
+}
+
+// Request kinds a DropPolicy applies to.
+const (
+	requestFaucet = "faucet"
+	requestShield = "shield"
+)
+
+// DropPolicy gives Amount of TokenID to the receivers of the requests of kind Request, or of every
+// request when it is empty.
+type DropPolicy struct {
+	Request string
+	TokenID string
+	// Amount is in the smallest unit of the token, as the chain counts it: 1.5 of a token with 6
+	// decimals is 1500000.
+	Amount uint64
+}
+
+func (p DropPolicy) validate() error {
+	if p.Request != "" && p.Request != requestFaucet && p.Request != requestShield {
+		return fmt.Errorf("drop policy: unknown request kind %q", p.Request)
+	}
+	if _, err := hex.DecodeString(p.TokenID); err != nil || len(p.TokenID) != 64 || p.TokenID == common.PRVIDStr {
+		return fmt.Errorf("drop policy: invalid token %q", p.TokenID)
+	}
+	if p.Amount == 0 {
+		return fmt.Errorf("drop policy: no amount for token %v", p.TokenID)
+	}
+	return nil
+}
+
+var config Config
+
+func readConfig(path string) error {
+	if err := service.LoadConfig(path, &config); err != nil {
+		return err
+	}
+	if config.MongoDB == "" {
+		config.MongoDB = "airdrop"
+	}
+	if config.UserCollection == "" {
+		config.UserCollection = "users"
+	}
+	if config.Campaign == "" {
+		config.Campaign = "faucet"
+	}
+	for _, policy := range config.DropPolicies {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+	}
+	for _, coins := range config.ShieldTiers {
+		if coins <= 0 {
+			return fmt.Errorf("shield tiers: invalid number of coins %v", coins)
+		}
+	}
+	if config.WebhookSecret == "" {
+		config.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	}
+	if config.AdminKey == "" {
+		config.AdminKey = os.Getenv("ADMIN_KEY")
+	}
+	if config.CaptchaSecret == "" {
+		capSecret := os.Getenv("CAPTCHA_SECRET")
+		config.CaptchaSecret = capSecret
+	}
+
+	adc.airlock.Lock()
+	defer adc.airlock.Unlock()
+	for idx, key := range config.AirdropKeys {
+		wl, err := wallet.Base58CheckDeserialize(key.PrivateKey)
+		if err != nil {
+			return err
+		}
+
+		acc := &AirdropAccount{
+			PaymentAddress: wl.Base58CheckSerialize(wallet.PaymentAddressType),
+			Privatekey:     key.PrivateKey,
+			ShardID:        int(common.GetShardIDFromLastByte(wl.KeySet.PaymentAddress.Pk[31])),
+			TokenUTXOList:  make(map[string][]Coin),
+			UTXOInUse:      make(map[string]struct{}),
+		}
+		fmt.Printf("idx %v -> shardid %v address %v \n", idx, acc.ShardID, acc.PaymentAddress)
+		adc.AirdropAccounts = append(adc.AirdropAccounts, acc)
+	}
+	return nil
+}
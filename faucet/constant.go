@@ -1,4 +1,4 @@
-package main
+package faucet
 
 const (
 	AirdropCoinValue       uint64 = 100000000
@@ -6,3 +6,6 @@ const (
 	PRVCoinPerTokenCoins          = 30
 	MaxTxOutput                   = 30
 )
+
+// airdropTxKind marks the tracked txs that pay a user.
+const airdropTxKind = "airdrop"
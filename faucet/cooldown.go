@@ -0,0 +1,125 @@
+package faucet
+
+import (
+	"errors"
+	"log"
+	"main/accesslist"
+	"main/cooldown"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var cooldowns *cooldown.Limiter
+
+// requestIP is the IP the cooldown caps, none for the integrators and the triggers whose requests
+// all come from the same few machines.
+func requestIP(c *gin.Context) string {
+	if c.GetHeader("X-API-Key") != "" || accesslist.IsAdmin(c, config.AdminKey) {
+		return ""
+	}
+	return c.ClientIP()
+}
+
+// dropCampaign is the campaign a drop counts against, the faucet's one unless a trigger named
+// another.
+func dropCampaign(campaign string) string {
+	if campaign == "" {
+		return config.Campaign
+	}
+	return campaign
+}
+
+// checkCooldown answers the request itself when the previous drop of pubkey is still pending
+// (Result 1) or when the cooldown policy of campaign refuses another one (Result 2), returning
+// false. Both give the unix time the user may ask again, 0 when never. Pre-approved receivers skip
+// the pending window only.
+func checkCooldown(c *gin.Context, campaign, pubkey string, allowed bool) bool {
+	adc.userlock.RLock()
+	user, ok := adc.UserAccounts[pubkey]
+	var last int64
+	var success bool
+	if ok {
+		last, success = user.LastAirdropRequest, user.AirdropSuccess
+	}
+	adc.userlock.RUnlock()
+	if ok && !success && !allowed {
+		if until := time.Unix(last, 0).Add(cooldowns.Pending()); time.Now().Before(until) {
+			c.JSON(http.StatusOK, gin.H{
+				"Result":       1,
+				"NextEligible": until.Unix(),
+			})
+			return false
+		}
+	}
+	d, err := cooldowns.Check(dropCampaign(campaign), pubkey, requestIP(c), time.Now())
+	return answerCooldown(c, pubkey, d, err)
+}
+
+// errDropInFlight refuses a drop to a user whose previous one is under way.
+var errDropInFlight = errors.New("the user already requested another drop")
+
+// registerUser counts an accepted drop against the cooldown policy and adds its user, both under
+// adc.userlock so that concurrent duplicates of a request make a single drop. No user is returned
+// when the policy refuses the drop.
+func registerUser(drop airdropRequest) (*UserAccount, cooldown.Decision, error) {
+	adc.userlock.Lock()
+	defer adc.userlock.Unlock()
+	if prev, ok := adc.UserAccounts[drop.Pubkey]; ok && (prev.dropping || len(prev.OngoingTxs) != 0) {
+		return nil, cooldown.Decision{}, errDropInFlight
+	}
+	now := time.Now()
+	d, err := cooldowns.Acquire(dropCampaign(drop.Campaign), drop.Pubkey, drop.IP, now)
+	if err != nil || !d.Allowed {
+		return nil, d, err
+	}
+	drop.GrantedAt = now.Unix()
+	user := newAirdropUser(drop)
+	user.dropping = true
+	adc.UserAccounts[drop.Pubkey] = user
+	return user, d, nil
+}
+
+// registerDrop is registerUser for a request, answering it itself when no user is returned: with
+// Result 1 when a duplicate got there first, as checkCooldown otherwise.
+func registerDrop(c *gin.Context, drop airdropRequest) *UserAccount {
+	user, d, err := registerUser(drop)
+	if err == errDropInFlight {
+		c.JSON(http.StatusOK, gin.H{
+			"Result": 1,
+		})
+		return nil
+	}
+	if !answerCooldown(c, drop.Pubkey, d, err) {
+		return nil
+	}
+	return user
+}
+
+func answerCooldown(c *gin.Context, pubkey string, d cooldown.Decision, err error) bool {
+	if err != nil {
+		log.Println("cooldown:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": "cannot check the request, try again later"})
+		return false
+	}
+	if !d.Allowed {
+		log.Printf("drop to %v refused: %v\n", pubkey, d.Reason)
+		c.JSON(http.StatusOK, gin.H{
+			"Result":       2,
+			"NextEligible": d.NextEligible,
+		})
+		return false
+	}
+	return true
+}
+
+// releaseCooldown gives back the drop of a user whose airdrop failed, so that they can ask again.
+func releaseCooldown(user *UserAccount) {
+	if user.GrantedAt == 0 {
+		return
+	}
+	if err := cooldowns.Release(dropCampaign(user.Campaign), user.Pubkey, user.IP, user.GrantedAt); err != nil {
+		log.Println("cooldown:", err)
+	}
+}
@@ -0,0 +1,39 @@
+package faucet
+
+import (
+	"main/accesslist"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.AdminKey = "admin"
+	defer func() { config.AdminKey = "" }()
+
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"remote address", nil, "203.0.113.7"},
+		// no proxy is trusted
+		{"forwarded", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "203.0.113.7"},
+		{"integrator", map[string]string{"X-API-Key": "key"}, ""},
+		{"trigger", map[string]string{accesslist.AdminKeyHeader: "admin"}, ""},
+		{"wrong admin key", map[string]string{accesslist.AdminKeyHeader: "nope"}, "203.0.113.7"},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/faucet", nil)
+		c.Request.RemoteAddr = "203.0.113.7:4242"
+		for k, v := range tc.headers {
+			c.Request.Header.Set(k, v)
+		}
+		if got := requestIP(c); got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
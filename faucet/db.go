@@ -0,0 +1,72 @@
+package faucet
+
+import (
+	"log"
+	"main/accesslist"
+	"main/service"
+	"main/storage"
+)
+
+// dbPath is the leveldb directory of the leveldb backend.
+const dbPath = "airdropdb"
+
+var localdb storage.Store
+var userdb storage.Collection
+
+// privatedb is the view of localdb holding what the faucet keeps to itself, see Config.Namespace.
+var privatedb storage.Store
+
+func initDB() error {
+	store, private, err := service.OpenStore(storage.Config{
+		Backend:     config.DBBackend,
+		LevelDBPath: dbPath,
+		MongoURI:    config.MongoURI,
+		MongoDB:     config.MongoDB,
+	}, config.Namespace)
+	if err != nil {
+		return err
+	}
+	localdb = store
+	privatedb = private
+	userdb = store.Collection(config.UserCollection)
+	claimdb = private.Collection(claimCollection)
+	accessLists = accesslist.New(store)
+	return userdb.EnsureIndex("Record.Pubkey")
+}
+
+func UpdateUserAirdropInfo(user *UserAccount) error {
+	env, err := userSchema.Wrap(user)
+	if err != nil {
+		return err
+	}
+	return userdb.Put(user.PaymentAddress, env)
+}
+
+// LoadUserAirdropInfo loads every user record, upgrading and storing back the older ones. Records
+// that cannot be read are logged and skipped rather than keeping the service from starting.
+func LoadUserAirdropInfo() ([]*UserAccount, error) {
+	var result []*UserAccount
+	var upgraded []*UserAccount
+	err := userdb.Scan("", "", func(id string, data []byte) error {
+		userAcc := new(UserAccount)
+		isUpgraded, err := userSchema.Decode(data, userAcc)
+		if err != nil {
+			log.Printf("skipping user record %v: %v\n", id, err)
+			return nil
+		}
+		if isUpgraded {
+			upgraded = append(upgraded, userAcc)
+		}
+		result = append(result, userAcc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, userAcc := range upgraded {
+		if err := UpdateUserAirdropInfo(userAcc); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,45 @@
+package faucet
+
+import (
+	"main/health"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+)
+
+var healthChecker *health.Checker
+
+// initHealthChecks checks what the faucet needs to drop: the fullnode and coin service, accounts
+// holding enough PRV on every shard since a user is only served from their own, and the db.
+func initHealthChecks() {
+	healthChecker = health.NewChecker(config.Health)
+	shards := []int{}
+	for shard := 0; shard < common.MaxShardNumber; shard++ {
+		shards = append(shards, shard)
+	}
+	healthChecker.Add("fullnode", fullnodes.Check)
+	healthChecker.Add("coinservice", health.HTTP(config.Coinservice+"/health"))
+	healthChecker.Add("accounts", health.Accounts(healthChecker.MinAccountsPerShard(), shards, fundedAccounts))
+	healthChecker.Add("db", health.Writable(privatedb))
+}
+
+// fundedAccounts counts by shard the accounts able to pay a shield drop of the top tier, the
+// largest one. The coins of the others are reloaded in case they were funded since.
+func fundedAccounts() map[int]int {
+	adc.airlock.RLock()
+	accounts := append([]*AirdropAccount{}, adc.AirdropAccounts...)
+	adc.airlock.RUnlock()
+	coins := shieldCoins(len(config.ShieldTiers))
+	counts := make(map[int]int)
+	for _, acc := range accounts {
+		needed := uint64(coins)*AirdropCoinShieldValue + feeEstimator.Replacement(feeEstimator.Fee(acc.PaymentAddress, 1, coins+1, false))
+		if acc.freeBalance(common.PRVIDStr) < needed {
+			if err := loadAirdropAccountUTXOs(acc); err != nil {
+				continue
+			}
+		}
+		if acc.freeBalance(common.PRVIDStr) >= needed {
+			counts[acc.ShardID]++
+		}
+	}
+	return counts
+}
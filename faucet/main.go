@@ -0,0 +1,975 @@
+package faucet
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"main/accesslist"
+	"main/cooldown"
+	"main/fee"
+	"main/health"
+	"main/idempotency"
+	"main/ledger"
+	"main/nodepool"
+	"main/service"
+	"main/shadow"
+	"main/slacknoti"
+	"main/sybil"
+	"main/txtracker"
+	"main/webhook"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/incognitochain/coin-service/shared"
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+	"github.com/pkg/errors"
+)
+
+var incClient nodepool.Client
+var fullnodes *nodepool.Pool
+
+// mempool tells the key images spent by pending txs: fullnodes, but for the tests.
+var mempool interface {
+	CheckCoinsInMempool(snList []string) ([]bool, error)
+}
+
+var tracker *txtracker.Tracker
+var txLedger *ledger.Ledger
+var webhooks *webhook.Dispatcher
+var feeEstimator *fee.Estimator
+var idempotent *idempotency.Store
+
+type UserAccount struct {
+	PaymentAddress     string
+	Pubkey             string
+	ShardID            int
+	TotalTokens        map[string]uint64
+	OngoingTxs         []string
+	Txs                map[string]*AirdropTxDetail
+	LastAirdropRequest int64
+	AirdropSuccess     bool
+	// CallbackURL is notified once the airdrop confirms or fails, signed for APIKey.
+	CallbackURL string
+	APIKey      string
+	// IP requested the drop, granted by the cooldown policy at the unix time GrantedAt.
+	IP        string
+	GrantedAt int64
+	// Tier is the size tier of the shield of a shield drop, scaling it.
+	Tier int
+	// Campaign is the campaign of a triggered drop, the faucet's one when empty.
+	Campaign string
+	// dropping is set from the registration of the user until their txs are sent.
+	dropping bool
+}
+
+type AirdropAccount struct {
+	lock           sync.Mutex
+	Privatekey     string
+	PaymentAddress string
+	TotalUTXO      int
+	ShardID        int
+	UTXOList       []Coin
+	// TokenUTXOList holds the coins of the pTokens given by the drop policies.
+	TokenUTXOList map[string][]Coin
+	// UTXOInUse holds the public keys of the coins spent by pending txs, PRV and tokens alike.
+	UTXOInUse map[string]struct{}
+}
+
+type Coin struct {
+	Coin  coin.PlainCoin
+	Index uint64
+}
+
+type AirdropTxDetail struct {
+	TxHash string
+	Value  uint64
+	Amount uint64
+	// Status of the tx:
+	//	1: sent
+	//	2: confirmed
+	//	3: failed
+	//	4: replaced by another tx spending the same inputs
+	Status    int
+	ForShield bool
+	// Account is the payment address of the airdrop account that sent the tx.
+	Account string
+	// Inputs lists the key images of the PRV coins spent by the tx.
+	Inputs []string
+	Fee    uint64
+	// TokenID is set for the txs giving a pToken, TokenAmount of it out of the TokenInputs coins.
+	// Value is then only the fee.
+	TokenID     string
+	TokenAmount uint64
+	TokenInputs []string
+}
+
+type AirdropController struct {
+	userlock        sync.RWMutex
+	airlock         sync.RWMutex
+	UserAccounts    map[string]*UserAccount
+	AirdropAccounts []*AirdropAccount
+	lastUsedADA     int
+}
+
+var adc AirdropController
+
+// Open reads the config of the faucet at cfgPath and opens its storage, which Serve needs.
+func Open(cfgPath string) error {
+	return load(cfgPath)
+}
+
+// Serve runs the faucet, serving its API until the process exits or the server fails.
+func Serve() error {
+	if err := start(); err != nil {
+		return err
+	}
+	go tracker.Start()
+	go webhooks.Start()
+	go idempotent.Start()
+	initHealthChecks()
+	go healthChecker.Start()
+	if config.BackupDir != "" {
+		go startBackupJob()
+	}
+	if config.ShadowMode {
+		log.Println("running in shadow mode: txs are built but not sent")
+	} else {
+		go startReconcileJob()
+	}
+	r := gin.Default()
+	r.TrustedProxies = config.TrustedProxies
+
+	r.POST("/requestdrop", idempotent.Middleware(), APIReqDrop)
+	r.POST("/faucet", idempotent.Middleware(), APIFaucet)
+	// the ledger, the shadow txs and the metrics are the operators' only
+	adminOnly := accesslist.RequireAdmin(config.AdminKey)
+	r.GET("/debug/vars", adminOnly, gin.WrapH(expvar.Handler()))
+	r.GET("/ledger/export", adminOnly, APIExportLedger)
+	r.GET("/shadow/txs", adminOnly, APIShadowTxs)
+	health.RegisterRoutes(r, healthChecker)
+	admin := r.Group("/admin", adminOnly)
+	accesslist.RegisterRoutes(admin, accessLists)
+	sybil.RegisterRoutes(admin, sybilScorer, approveHeldAirdrop)
+
+	return r.Run("0.0.0.0:" + strconv.Itoa(config.Port))
+}
+
+// load reads the config at cfgPath and opens the db.
+func load(cfgPath string) error {
+	adc.UserAccounts = make(map[string]*UserAccount)
+	if err := readConfig(cfgPath); err != nil {
+		return err
+	}
+	return initDB()
+}
+
+// start connects to the network and loads the users, the pending txs being tracked again.
+func start() error {
+	go slacknoti.StartSlackHook()
+	var err error
+	fullnodes, err = nodepool.New(append([]string{config.Fullnode}, config.Fullnodes...), config.FullnodePool)
+	if err != nil {
+		return err
+	}
+	go fullnodes.Start()
+	incClient = fullnodes
+	mempool = fullnodes
+	feeEstimator = fee.New(fullnodes.URL, config.Fee)
+	tracker, err = txtracker.NewTracker(fullnodes, privatedb, txtracker.DefaultConfig())
+	if err != nil {
+		return err
+	}
+	tracker.Subscribe(onAirdropTxEvent)
+	tracker.Subscribe(txtracker.MetricsSubscriber)
+	tracker.SetReplacer(airdropTxKind, replaceAirdropTx)
+	txLedger, err = ledger.New(localdb)
+	if err != nil {
+		return err
+	}
+	if err := txLedger.Verify(); err != nil {
+		log.Println(err)
+	}
+	tracker.Subscribe(txLedger.Subscriber)
+	shadowStore = shadow.NewStore(privatedb)
+	sybilScorer = sybil.NewScorer(localdb, config.Sybil)
+	cooldowns, err = cooldown.NewLimiter(privatedb, config.Cooldown)
+	if err != nil {
+		return err
+	}
+	webhookConfig := webhook.DefaultConfig()
+	webhookConfig.AllowPrivate = config.WebhookAllowPrivate
+	webhooks = webhook.NewDispatcher(privatedb, config.APIKeys, config.WebhookSecret, webhookConfig)
+	idempotent = idempotency.NewStore(privatedb, config.Idempotency)
+	log.Println("initiating airdrop-tool")
+	otaKeyList := []string{}
+	for _, acc := range adc.AirdropAccounts {
+		wl, err := wallet.Base58CheckDeserialize(acc.Privatekey)
+		if err != nil {
+			return err
+		}
+		otaKeyList = append(otaKeyList, wl.Base58CheckSerialize(wallet.OTAKeyType))
+	}
+	for _, key := range otaKeyList {
+		err := incClient.SubmitKey(key)
+		if err != nil {
+			log.Printf("SubmitKey %v encoutered an error: %v\n", key, err)
+		}
+	}
+	adc.lastUsedADA = 0
+	airdroppedUser, err := LoadUserAirdropInfo()
+	if err != nil {
+		return err
+	}
+	for _, v := range airdroppedUser {
+		adc.UserAccounts[v.Pubkey] = v
+		// drops made before the cooldown policies count against them
+		if v.AirdropSuccess && v.GrantedAt == 0 {
+			if err := cooldowns.Seed(dropCampaign(v.Campaign), v.Pubkey, v.LastAirdropRequest); err != nil {
+				log.Println(err)
+			}
+		}
+		// users stored before the tracker existed still have their txs watched by nobody
+		for _, txHash := range v.OngoingTxs {
+			if tracker.IsTracked(txHash) {
+				continue
+			}
+			err := tracker.Track(txtracker.PendingTx{
+				TxHash:   txHash,
+				Kind:     airdropTxKind,
+				Owner:    v.PaymentAddress,
+				Deadline: time.Now().Add(35 * time.Minute).Unix(),
+			})
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	return nil
+}
+
+// RequestAirdrop is the body of the drop requests, see service.DropRequest.
+type RequestAirdrop = service.DropRequest
+
+func APIFaucet(c *gin.Context) {
+	var req RequestAirdrop
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	// the OTA receivers are fresh keys the cooldown and the access lists cannot link, and a public
+	// key alone cannot be paid: the faucet pays payment addresses only
+	if req.PaymentAddress == "" && (req.OTAReceiver != "" || req.Pubkey != "") {
+		c.JSON(http.StatusOK, gin.H{
+			"Result": 0,
+			"Error":  "request with a payment address",
+		})
+		return
+	}
+	rcv, ok := readReceiver(c, req)
+	if !ok {
+		return
+	}
+	paymentkey, key, shardID := rcv.Address, rcv.Key, rcv.ShardID
+	allowed, ok := checkAccessList(c, key)
+	if !ok {
+		return
+	}
+	// pre-approved receivers skip the captcha, the cooldown and the sybil scoring
+	var captcha *sybil.Captcha
+	if !allowed {
+		ok, info, err := VerifyCaptcha(req.Captcha, config.CaptchaSecret)
+		if !ok {
+			if err != nil {
+				log.Println("VerifyCaptcha", err)
+				c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"Error": errors.New("invalid captcha").Error()})
+			return
+		}
+		captcha = info
+	}
+	// a triggered drop held for the public key is paid in place of the faucet's one
+	claim, claimed := takeClaim(key, paymentkey, requestIP(c))
+	if !checkCooldown(c, claim.Campaign, key, allowed) {
+		return
+	}
+
+	apiKey := c.GetHeader("X-API-Key")
+	callbackURL, err := webhooks.Callback(apiKey, req.CallbackURL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	drop := airdropRequest{
+		PaymentAddress: paymentkey,
+		Pubkey:         key,
+		ShardID:        shardID,
+		CallbackURL:    callbackURL,
+		APIKey:         apiKey,
+		IP:             requestIP(c),
+	}
+	if claimed {
+		drop.ForShield, drop.Tier, drop.Campaign = claim.ForShield, claim.Tier, claim.Campaign
+	}
+	if !allowed && !checkSybil(c, drop, captcha) {
+		return
+	}
+	newUserAccount := registerDrop(c, drop)
+	if newUserAccount == nil {
+		return
+	}
+	if claimed {
+		releaseClaim(key)
+	}
+
+	go runAirdrop(newUserAccount, drop.ForShield)
+	c.JSON(http.StatusOK, gin.H{
+		"Result": 1,
+	})
+}
+
+func APIReqDrop(c *gin.Context) {
+	var req RequestAirdrop
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	rcv, ok := readReceiver(c, req)
+	if !ok {
+		return
+	}
+	paymentkey, key, shardID := rcv.Address, rcv.Key, rcv.ShardID
+	forShield := "true"
+	// the tier scales the drop and the campaign picks its cooldown, they are the trigger's call only
+	if req.Tier < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": "invalid tier"})
+		return
+	}
+	if req.Tier != 0 && !accesslist.IsAdmin(c, config.AdminKey) {
+		c.JSON(http.StatusForbidden, gin.H{"Error": "a tier needs the admin key"})
+		return
+	}
+	if req.Campaign != "" && !accesslist.IsAdmin(c, config.AdminKey) {
+		c.JSON(http.StatusForbidden, gin.H{"Error": "a campaign needs the admin key"})
+		return
+	}
+	// a public key the faucet never paid is held until its owner claims the drop
+	if rcv.Address == "" && !accesslist.IsAdmin(c, config.AdminKey) {
+		c.JSON(http.StatusOK, gin.H{
+			"Result": 0,
+			"Error":  "unknown public key, request with a payment address or an OTA receiver",
+		})
+		return
+	}
+	allowed, ok := checkAccessList(c, key)
+	if !ok {
+		return
+	}
+	if !checkCooldown(c, req.Campaign, key, allowed) {
+		return
+	}
+
+	apiKey := c.GetHeader("X-API-Key")
+	callbackURL, err := webhooks.Callback(apiKey, req.CallbackURL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+
+	drop := airdropRequest{
+		PaymentAddress: paymentkey,
+		Pubkey:         key,
+		ShardID:        shardID,
+		CallbackURL:    callbackURL,
+		APIKey:         apiKey,
+		IP:             requestIP(c),
+		ForShield:      forShield == "true",
+		Tier:           req.Tier,
+		Campaign:       req.Campaign,
+	}
+	if !allowed && !checkSybil(c, drop, nil) {
+		return
+	}
+	if rcv.Address == "" {
+		holdClaim(c, drop)
+		return
+	}
+	newUserAccount := registerDrop(c, drop)
+	if newUserAccount == nil {
+		return
+	}
+	go runAirdrop(newUserAccount, drop.ForShield)
+	c.JSON(http.StatusOK, gin.H{
+		"Result": 1,
+	})
+}
+
+// APIExportLedger exports the disbursement ledger, filtered by date range (from/to as
+// YYYY-MM-DD) and campaign, as csv or json.
+func APIExportLedger(c *gin.Context) {
+	from, to, err := ledger.ParseRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	entries, err := txLedger.Query(from, to, c.Query("campaign"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+	} else {
+		c.Header("Content-Type", "application/json")
+	}
+	err = ledger.Export(c.Writer, format, entries)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+	}
+}
+
+func GetTokenAmounts(paymentAddress string) (map[string]uint64, error) {
+	resp, err := http.Get(config.Coinservice + "/getkeyinfo?key=" + paymentAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := service.ReadRespBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	var apiResp struct {
+		Result shared.KeyInfoData
+		Error  string
+	}
+
+	err = json.Unmarshal(body, &apiResp)
+	if err != nil {
+		return nil, err
+	}
+	if apiResp.Error != "" {
+		return nil, errors.New(apiResp.Error)
+	}
+	keyinfo := apiResp.Result
+	result := make(map[string]uint64)
+	for token, info := range keyinfo.CoinIndex {
+		if token == common.PRVCoinID.String() {
+			continue
+		}
+		result[token] = info.Total
+	}
+	return result, nil
+}
+
+// runAirdrop makes the drop of user in the background, giving it back to the cooldown when it
+// fails before any tx was sent.
+func runAirdrop(user *UserAccount, forShield bool) {
+	if err := AirdropUser(user, forShield); err != nil {
+		log.Printf("airdrop to user %v failed: %v\n", user.PaymentAddress, err)
+		releaseCooldown(user)
+	}
+}
+
+func AirdropUser(user *UserAccount, forShield bool) error {
+	defer func() {
+		adc.userlock.Lock()
+		user.dropping = false
+		adc.userlock.Unlock()
+	}()
+	var err error
+	// the shield drops are a fixed amount, and may go to an OTA receiver the coin service knows
+	// nothing about; the other drops go to payment addresses only
+	if !forShield {
+		user.TotalTokens, err = GetTokenAmounts(user.PaymentAddress)
+		if err != nil {
+			return err
+		}
+		log.Println("total tokens", user.TotalTokens)
+	}
+	txsToWatch := []string{}
+	totalPRVAmountNeeded := uint64(0)
+	totalPRVCoinsNeeded := 0
+	// for _, tokenAmount := range user.TotalTokens {
+	// 	txNeedToSend := int(math.Ceil(float64(tokenAmount) / float64(PRVCoinPerTokenCoins)))
+	// 	totalPRVAmountNeeded += (incclient.DefaultPRVFee + (AirdropCoinValue * PRVCoinPerTokenCoins)) * uint64(txNeedToSend)
+	// 	totalPRVCoinsNeeded += txNeedToSend
+	// }
+	if forShield {
+		totalPRVCoinsNeeded = shieldCoins(user.Tier)
+		totalPRVAmountNeeded = uint64(totalPRVCoinsNeeded) * AirdropCoinShieldValue
+	} else {
+		totalCount := len(user.TotalTokens)
+		if totalCount == 0 {
+			totalPRVAmountNeeded = uint64(1) * AirdropCoinValue
+			totalPRVCoinsNeeded = 1
+		} else {
+			totalPRVAmountNeeded = uint64(len(user.TotalTokens)) * AirdropCoinValue
+			totalPRVCoinsNeeded = len(user.TotalTokens)
+		}
+	}
+
+	airdropAccount := chooseAirdropAccount(totalPRVAmountNeeded, user.ShardID, user.PaymentAddress)
+	// the fullnode may be slow to price the txs: not while holding the account
+	quote := feeEstimator.Quote(airdropAccount.PaymentAddress)
+	airdropAccount.lock.Lock()
+	totalTxNeeded := int(math.Ceil(float64(totalPRVCoinsNeeded) / float64(MaxTxOutput)))
+	txList := []string{}
+	log.Printf("sending txs for user %v: %v %v %v", user.PaymentAddress, totalPRVAmountNeeded, totalPRVCoinsNeeded, totalTxNeeded)
+	txsToSend := [][]byte{}
+	for i := 0; i < totalTxNeeded; i++ {
+		amount := uint64(MaxTxOutput)
+		if i+1 == totalTxNeeded {
+			amount = uint64(totalPRVCoinsNeeded - (i * MaxTxOutput))
+		}
+		txDetail, txBytes, txHash, err := CreateAirDropTx(airdropAccount, quote, user.PaymentAddress, amount, forShield)
+		if err != nil {
+			airdropAccount.lock.Unlock()
+			// none of the txs was sent: give their coins back
+			for _, txHash := range txsToWatch {
+				releaseInputs(airdropAccount, user.Txs[txHash].inputs())
+				delete(user.Txs, txHash)
+			}
+			return err
+		}
+		txsToWatch = append(txsToWatch, txHash)
+		txsToSend = append(txsToSend, txBytes)
+		user.Txs[txHash] = txDetail
+		txList = append(txList, txHash)
+	}
+	airdropAccount.lock.Unlock()
+	for _, policy := range dropPolicies(forShield) {
+		txDetail, txBytes, txHash, err := CreateTokenDropTx(policy, user.PaymentAddress, user.ShardID)
+		if err != nil {
+			log.Printf("cannot give %v of token %v to user %v: %v\n", policy.Amount, policy.TokenID, user.PaymentAddress, err)
+			continue
+		}
+		txsToWatch = append(txsToWatch, txHash)
+		txsToSend = append(txsToSend, txBytes)
+		user.Txs[txHash] = txDetail
+		txList = append(txList, txHash)
+	}
+	user.LastAirdropRequest = time.Now().Unix()
+	if config.ShadowMode {
+		shadowAirdrop(user, txsToWatch)
+		return nil
+	}
+	sc := 0
+	fl := 0
+	for idx, txBytes := range txsToSend {
+		if user.Txs[txsToWatch[idx]].TokenID != "" {
+			err = incClient.SendRawTokenTx(txBytes)
+		} else {
+			err = incClient.SendRawTx(txBytes)
+		}
+		if err != nil {
+			strings.Contains(err.Error(), "Reject")
+			log.Println("send tx error", err)
+			user.Txs[txsToWatch[idx]].Status = 3
+			fl++
+		} else {
+			user.Txs[txsToWatch[idx]].Status = 1
+			sc++
+		}
+		log.Printf("user %v sent success tx %v", user.PaymentAddress, txList[idx])
+	}
+
+	log.Printf("%v txs success, %v txs failed, wait for result...\n", sc, fl)
+	adc.userlock.Lock()
+	user.OngoingTxs = []string{}
+	for _, txHash := range txsToWatch {
+		if user.Txs[txHash].Status == 1 {
+			user.OngoingTxs = append(user.OngoingTxs, txHash)
+		}
+	}
+	failed := len(user.OngoingTxs) == 0
+	adc.userlock.Unlock()
+	err = UpdateUserAirdropInfo(user)
+	if err != nil {
+		log.Println(err)
+	}
+	if failed {
+		releaseCooldown(user)
+	}
+	for idx, txHash := range txsToWatch {
+		txDetail := user.Txs[txHash]
+		if txDetail.Status != 1 {
+			continue
+		}
+		tokenID, amount := txDetail.disbursed()
+		err := txLedger.RecordBroadcast(ledger.Entry{
+			Kind:     ledger.KindDrop,
+			Campaign: dropCampaign(user.Campaign),
+			TxHash:   txHash,
+			Account:  txDetail.Account,
+			Receiver: user.PaymentAddress,
+			TokenID:  tokenID,
+			Amount:   amount,
+			Fee:      txDetail.Fee,
+		})
+		if err != nil {
+			log.Println(err)
+		}
+		shardID := airdropAccount.ShardID
+		if acc := getAirdropAccount(txDetail.Account); acc != nil {
+			shardID = acc.ShardID
+		}
+		err = tracker.Track(txtracker.PendingTx{
+			TxHash:    txHash,
+			Kind:      airdropTxKind,
+			Owner:     user.PaymentAddress,
+			Deadline:  time.Now().Add(45 * time.Minute).Unix(),
+			RawTx:     txsToSend[idx],
+			IsTokenTx: txDetail.TokenID != "",
+			ShardID:   shardID,
+			Fee:       txDetail.Fee,
+			Inputs:    txDetail.inputs(),
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// onAirdropTxEvent updates the user record owning an airdrop tx once the tracker settles it.
+func onAirdropTxEvent(ev txtracker.Event) {
+	if ev.Tx.Kind != airdropTxKind {
+		return
+	}
+	user := getUserByPaymentAddress(ev.Tx.Owner)
+	if user == nil {
+		log.Printf("airdrop tx %v settled (%v) for unknown user %v\n", ev.Tx.TxHash, ev.Status, ev.Tx.Owner)
+		return
+	}
+	adc.userlock.Lock()
+	txDetail, ok := user.Txs[ev.Tx.TxHash]
+	if ok {
+		switch ev.Status {
+		case txtracker.StatusConfirmed:
+			txDetail.Status = 2
+		case txtracker.StatusReplaced:
+			txDetail.Status = 4
+		default:
+			txDetail.Status = 3
+		}
+	}
+	txToWatchLeft := []string{}
+	for _, txHash := range user.OngoingTxs {
+		if txHash != ev.Tx.TxHash {
+			txToWatchLeft = append(txToWatchLeft, txHash)
+		}
+	}
+	if ev.Status == txtracker.StatusReplaced {
+		if _, known := user.Txs[ev.ReplacedBy]; !known && ok {
+			newTxDetail := *txDetail
+			newTxDetail.TxHash = ev.ReplacedBy
+			user.Txs[ev.ReplacedBy] = &newTxDetail
+		}
+		if newTxDetail, known := user.Txs[ev.ReplacedBy]; known {
+			newTxDetail.Status = 1
+		}
+		txToWatchLeft = append(txToWatchLeft, ev.ReplacedBy)
+	}
+	user.OngoingTxs = txToWatchLeft
+	refreshAirdropSuccess(user)
+	done := len(user.OngoingTxs) == 0
+	if done && user.AirdropSuccess {
+		log.Println("Done airdrop for user", user.PaymentAddress)
+	}
+	payload := webhook.Payload{Event: webhook.EventFailed, PaymentAddress: user.PaymentAddress}
+	if user.AirdropSuccess {
+		payload.Event = webhook.EventConfirmed
+	}
+	for txHash := range user.Txs {
+		payload.TxHashes = append(payload.TxHashes, txHash)
+	}
+	adc.userlock.Unlock()
+	err := UpdateUserAirdropInfo(user)
+	if err != nil {
+		log.Println(err)
+	}
+	if done && !user.AirdropSuccess {
+		releaseCooldown(user)
+	}
+	if done {
+		err := webhooks.Notify(user.APIKey, user.CallbackURL, payload)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// refreshAirdropSuccess marks the airdrop successful once none of the user's txs is ongoing and
+// all of them landed. Callers hold adc.userlock.
+func refreshAirdropSuccess(user *UserAccount) {
+	if len(user.OngoingTxs) != 0 {
+		return
+	}
+	user.AirdropSuccess = true
+	for _, txDetail := range user.Txs {
+		if txDetail.Status != 2 && txDetail.Status != 4 {
+			user.AirdropSuccess = false
+		}
+	}
+}
+
+func getUserByPaymentAddress(paymentAddress string) *UserAccount {
+	adc.userlock.RLock()
+	defer adc.userlock.RUnlock()
+	for _, user := range adc.UserAccounts {
+		if user.PaymentAddress == paymentAddress {
+			return user
+		}
+	}
+	return nil
+}
+
+// CreateAirDropTx creates a tx sending UTXOamount airdrop coins to paymentAddress, priced at quote.
+// Callers hold ada.lock.
+func CreateAirDropTx(ada *AirdropAccount, quote fee.Quote, paymentAddress string, UTXOamount uint64, forShield bool) (*AirdropTxDetail, []byte, string, error) {
+	log.Println("Creating tx with param", ada.Privatekey, paymentAddress, UTXOamount)
+	coinValue := AirdropCoinValue
+	if forShield {
+		coinValue = AirdropCoinShieldValue
+	}
+	// the airdrop coins and the change
+	numOutputs := int(UTXOamount) + 1
+	txFee := quote.Fee(1, numOutputs, false)
+	coinsToUse := []string{}
+	chosenValue := uint64(0)
+	coinsDataToUse := []Coin{}
+
+	for _, v := range ada.UTXOList {
+		cpubkey := v.Coin.GetPublicKey()
+		if _, ok := ada.UTXOInUse[cpubkey.String()]; ok {
+			continue
+		}
+		chosenValue += v.Coin.GetValue()
+		coinsDataToUse = append(coinsDataToUse, v)
+		coinsToUse = append(coinsToUse, cpubkey.String())
+		// every coin makes the tx bigger; leave room for the higher fee of a replacement, which
+		// has to spend the very same coins
+		txFee = quote.Fee(len(coinsDataToUse), numOutputs, false)
+		if chosenValue >= (UTXOamount*coinValue)+feeEstimator.Replacement(txFee) {
+			break
+		}
+	}
+	for _, v := range coinsToUse {
+		ada.UTXOInUse[v] = struct{}{}
+	}
+
+	txDetail, txBytes, txHash, err := buildAirdropTx(ada, paymentAddress, UTXOamount, forShield, coinsDataToUse, txFee)
+	if err != nil {
+		for _, v := range coinsToUse {
+			delete(ada.UTXOInUse, v)
+		}
+	}
+	return txDetail, txBytes, txHash, err
+}
+
+// buildAirdropTx creates a tx sending UTXOamount airdrop coins to paymentAddress out of the given coins.
+func buildAirdropTx(ada *AirdropAccount, paymentAddress string, UTXOamount uint64, forShield bool, coinsToUse []Coin, fee uint64) (*AirdropTxDetail, []byte, string, error) {
+	coinValue := AirdropCoinValue
+	if forShield {
+		coinValue = AirdropCoinShieldValue
+	}
+	valueList := []uint64{}
+	paymentList := []string{}
+	for i := uint64(0); i < UTXOamount; i++ {
+		valueList = append(valueList, coinValue)
+		paymentList = append(paymentList, paymentAddress)
+	}
+
+	coinsDataToUse := []coin.PlainCoin{}
+	coinsToUseIdx := []uint64{}
+	keyImages := []string{}
+	for _, v := range coinsToUse {
+		coinsDataToUse = append(coinsDataToUse, v.Coin)
+		coinsToUseIdx = append(coinsToUseIdx, v.Index)
+		keyImages = append(keyImages, keyImageString(v.Coin))
+	}
+
+	txParam := incclient.NewTxParam(ada.Privatekey, paymentList, valueList, fee, nil, nil, nil)
+	encodedTx, txHash, err := incClient.CreateRawTransactionWithInputCoins(txParam, coinsDataToUse, coinsToUseIdx)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	txDetail := AirdropTxDetail{
+		TxHash:    txHash,
+		Value:     (UTXOamount * coinValue) + fee,
+		Amount:    UTXOamount,
+		ForShield: forShield,
+		Account:   ada.PaymentAddress,
+		Inputs:    keyImages,
+		Fee:       fee,
+	}
+	return &txDetail, encodedTx, txHash, nil
+}
+
+// replaceAirdropTx rebuilds a stuck airdrop tx out of the same coins with a higher fee.
+func replaceAirdropTx(stuck txtracker.PendingTx) (*txtracker.PendingTx, error) {
+	user := getUserByPaymentAddress(stuck.Owner)
+	if user == nil {
+		return nil, fmt.Errorf("user %v not found", stuck.Owner)
+	}
+	adc.userlock.RLock()
+	txDetail, ok := user.Txs[stuck.TxHash]
+	adc.userlock.RUnlock()
+	if !ok || len(txDetail.Inputs) == 0 {
+		return nil, fmt.Errorf("inputs of tx %v unknown", stuck.TxHash)
+	}
+	ada := getAirdropAccount(txDetail.Account)
+	if ada == nil {
+		return nil, fmt.Errorf("airdrop account %v not found", txDetail.Account)
+	}
+	fee := feeEstimator.Replacement(stuck.Fee)
+	if fee <= stuck.Fee {
+		return nil, fmt.Errorf("fee of tx %v already at the ceiling", stuck.TxHash)
+	}
+
+	if err := loadAirdropAccountUTXOs(ada); err != nil {
+		return nil, err
+	}
+	if txDetail.TokenID != "" {
+		if err := getAirdropAccountTokenUTXOs(ada, txDetail.TokenID); err != nil {
+			return nil, err
+		}
+	}
+	ada.lock.Lock()
+	defer ada.lock.Unlock()
+	coinsToUse, ok := ada.coinsByKeyImages(common.PRVIDStr, txDetail.Inputs)
+	if !ok {
+		return nil, fmt.Errorf("inputs of tx %v are no longer unspent", stuck.TxHash)
+	}
+	var newTxDetail *AirdropTxDetail
+	var encodedTx []byte
+	var txHash string
+	var err error
+	if txDetail.TokenID != "" {
+		tokenCoins, ok := ada.coinsByKeyImages(txDetail.TokenID, txDetail.TokenInputs)
+		if !ok {
+			return nil, fmt.Errorf("token inputs of tx %v are no longer unspent", stuck.TxHash)
+		}
+		newTxDetail, encodedTx, txHash, err = buildTokenDropTx(ada, user.PaymentAddress, txDetail.TokenID, txDetail.TokenAmount, tokenCoins, coinsToUse, fee)
+	} else {
+		newTxDetail, encodedTx, txHash, err = buildAirdropTx(ada, user.PaymentAddress, txDetail.Amount, txDetail.ForShield, coinsToUse, fee)
+	}
+	if err != nil {
+		return nil, err
+	}
+	adc.userlock.Lock()
+	newTxDetail.Status = 1
+	user.Txs[txHash] = newTxDetail
+	adc.userlock.Unlock()
+
+	return &txtracker.PendingTx{
+		TxHash:    txHash,
+		RawTx:     encodedTx,
+		IsTokenTx: newTxDetail.TokenID != "",
+		Fee:       newTxDetail.Fee,
+	}, nil
+}
+
+func chooseAirdropAccount(totalValueNeeded uint64, shardID int, userAddress string) *AirdropAccount {
+	var result *AirdropAccount
+	var i int
+retry:
+	if i+1 == len(adc.AirdropAccounts) {
+		time.Sleep(20 * time.Second)
+		log.Println("can't choose airdrop account", userAddress, shardID)
+		i = 0
+	}
+	i++
+	adc.airlock.Lock()
+	adc.lastUsedADA = (adc.lastUsedADA + 1) % len(adc.AirdropAccounts)
+	result = adc.AirdropAccounts[adc.lastUsedADA]
+	if result.ShardID != shardID {
+		adc.airlock.Unlock()
+		goto retry
+	}
+	if result.TotalUTXO == 0 || len(result.UTXOInUse) == len(result.UTXOList) {
+		if err := loadAirdropAccountUTXOs(result); err != nil {
+			log.Println("load coins of airdrop account", result.PaymentAddress, err)
+			adc.airlock.Unlock()
+			goto retry
+		}
+	}
+	totalADAValue := uint64(0)
+	for _, v := range result.UTXOList {
+		totalADAValue += v.Coin.GetValue()
+	}
+
+	if totalValueNeeded > totalADAValue {
+		msg := fmt.Sprintf("airdrop %v acc %v totalValueNeeded %v > totalADAValue %v \n", result.ShardID, result.PaymentAddress, totalValueNeeded, totalADAValue)
+		log.Println(msg)
+		go slacknoti.SendSlackNoti(msg)
+		adc.airlock.Unlock()
+		goto retry
+	} else {
+		if totalADAValue < 5*1e9 {
+			msg := fmt.Sprintf("airdrop %v acc %v totalADAValue %v < %v \n", result.ShardID, result.PaymentAddress, totalADAValue, 5*1e9)
+			log.Println(msg)
+			go slacknoti.SendSlackNoti(msg)
+		}
+	}
+	adc.airlock.Unlock()
+	return result
+}
+
+// loadAirdropAccountUTXOs reloads the PRV coins of an account, keeping the in-use marks of those
+// still unspent.
+func loadAirdropAccountUTXOs(adc *AirdropAccount) error {
+	uxto, indices, err := incClient.GetUnspentOutputCoins(adc.Privatekey, common.PRVCoinID.String(), 0)
+	if err != nil {
+		return err
+	}
+	if len(uxto) == 0 {
+		log.Println("no utxo for airdrop account", adc.ShardID)
+		return nil
+	}
+	var utxos []Coin
+	for idx, v := range uxto {
+		if v.GetVersion() == 2 {
+			utxos = append(utxos, Coin{
+				Coin:  v,
+				Index: indices[idx].Uint64(),
+			})
+		}
+	}
+	adc.lock.Lock()
+	adc.TotalUTXO = len(utxos)
+	adc.UTXOList = utxos
+	UTXOInUsePendingList := make(map[string]struct{})
+	for _, v := range utxos {
+		if _, ok := adc.UTXOInUse[v.Coin.GetPublicKey().String()]; ok {
+			UTXOInUsePendingList[v.Coin.GetPublicKey().String()] = struct{}{}
+		}
+	}
+	// the token coins are refreshed on their own, see getAirdropAccountTokenUTXOs
+	for _, tokenCoins := range adc.TokenUTXOList {
+		for _, v := range tokenCoins {
+			if _, ok := adc.UTXOInUse[v.Coin.GetPublicKey().String()]; ok {
+				UTXOInUsePendingList[v.Coin.GetPublicKey().String()] = struct{}{}
+			}
+		}
+	}
+	adc.UTXOInUse = UTXOInUsePendingList
+	adc.lock.Unlock()
+	return nil
+}
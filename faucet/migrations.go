@@ -0,0 +1,10 @@
+package faucet
+
+import "main/schema"
+
+// userSchema upgrades the stored UserAccount records. Append new migrations at the end, never
+// edit the released ones.
+var userSchema = schema.NewRegistry("user",
+	// 0 -> 1: the faucet stored its users without their Pubkey, which keys adc.UserAccounts
+	schema.BackfillPubkey,
+)
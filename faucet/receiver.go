@@ -0,0 +1,97 @@
+package faucet
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+)
+
+// errNoReceiver answers Result -1 to a request naming no receiver.
+var errNoReceiver = errors.New("no receiver given")
+
+// receiver is who a drop request pays. Key identifies them to the access lists, the cooldown and
+// the user records, and Address is what their txs pay to.
+type receiver struct {
+	Key     string
+	ShardID int
+	Address string
+}
+
+// resolveReceiver reads the receiver of a request, named by one of a payment address, a public key
+// or an OTA receiver. An OTA receiver is paid as is. The privacy v2 coins cannot be made for a
+// public key alone: that of a user the faucet paid before is paid at their payment address, the
+// others are left without an Address to pay.
+func resolveReceiver(req RequestAirdrop) (receiver, error) {
+	switch {
+	case req.PaymentAddress != "":
+		wl, err := wallet.Base58CheckDeserialize(req.PaymentAddress)
+		if err != nil {
+			return receiver{}, err
+		}
+		addr := wl.KeySet.PaymentAddress
+		if addr.GetOTAPublicKey() == nil || addr.GetPublicSpend() == nil || addr.GetPublicView() == nil {
+			return receiver{}, fmt.Errorf("invalid payment address")
+		}
+		return receiver{
+			Key:     base58.Base58Check{}.Encode(addr.Pk, 0),
+			ShardID: int(common.GetShardIDFromLastByte(addr.Pk[len(addr.Pk)-1])),
+			Address: req.PaymentAddress,
+		}, nil
+	case req.OTAReceiver != "":
+		var ota coin.OTAReceiver
+		if err := ota.FromString(req.OTAReceiver); err != nil {
+			return receiver{}, fmt.Errorf("invalid OTA receiver: %v", err)
+		}
+		if !ota.IsValid() {
+			return receiver{}, fmt.Errorf("invalid OTA receiver")
+		}
+		// every OTA receiver is a fresh one-time key: the cooldown cannot link those of a user
+		return receiver{
+			Key:     ota.String(),
+			ShardID: int(ota.GetShardID()),
+			Address: ota.String(),
+		}, nil
+	case req.Pubkey != "":
+		pubkey, _, err := base58.Base58Check{}.Decode(req.Pubkey)
+		if err != nil || len(pubkey) != 32 {
+			return receiver{}, fmt.Errorf("invalid public key")
+		}
+		key := base58.Base58Check{}.Encode(pubkey, 0)
+		rcv := receiver{
+			Key:     key,
+			ShardID: int(common.GetShardIDFromLastByte(pubkey[31])),
+		}
+		adc.userlock.RLock()
+		if user, ok := adc.UserAccounts[key]; ok {
+			rcv.Address = user.PaymentAddress
+		}
+		adc.userlock.RUnlock()
+		return rcv, nil
+	}
+	return receiver{}, errNoReceiver
+}
+
+// readReceiver resolves the receiver of req, answering the request itself when it cannot.
+func readReceiver(c *gin.Context, req RequestAirdrop) (receiver, bool) {
+	rcv, err := resolveReceiver(req)
+	if err == errNoReceiver {
+		c.JSON(http.StatusOK, gin.H{
+			"Result": -1,
+		})
+		return rcv, false
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"Result": 0,
+			"Error":  err.Error(),
+		})
+		return rcv, false
+	}
+	return rcv, true
+}
@@ -0,0 +1,160 @@
+package faucet
+
+import (
+	"bytes"
+	"encoding/json"
+	"main/cooldown"
+	"main/service"
+	"main/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// the receivers of the private key 1111111U1tofCB5sj3oKYgHbr6PXGtub7WTdKN2KcUdACTBN9GH5RYoAAYmeTgF6F6cfZ6HvYjSMiWWhfkLeGXD4Kw5auCFUqnaGrso7Eg
+const (
+	testPaymentAddress = "12svn1DAWMmVYyRDyak9Lf39TrFf3bZkcAbBDnDnmKnX1BQzCR5ithWEgEgU4UsUYhqsQZpjswZf1tCtgGjeyNLxbrjBoG5LnEAPKua4pPS77CDyPLu2skUtNUJ2Y7VEuDxvUX8rjTieBmkcfFAj"
+	testPubkey         = "12mKxyf3zV7jywjRuauJUbxrYwg78MBdRKxgpLYPHFHhcf93f1s"
+	testOTAReceiver    = "15yvUbGtLfviiHYY4abV6DZ6N4EYF6TR6rwvBJ5nnJUdM9KX6JhEdistwiyvYcDSRJtQxguCUn3ppaXabombmHVUFC8o2V9yXRcCEYueC2CcpdmADuNyUAzKsDxrrqC5v5gZRk8PgLkrURx4"
+	testShardID        = 3
+)
+
+func TestResolveReceiver(t *testing.T) {
+	adc.UserAccounts = make(map[string]*UserAccount)
+
+	rcv, err := resolveReceiver(RequestAirdrop{PaymentAddress: testPaymentAddress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rcv.Key != testPubkey || rcv.ShardID != testShardID || rcv.Address != testPaymentAddress {
+		t.Fatalf("payment address: got %+v", rcv)
+	}
+
+	rcv, err = resolveReceiver(RequestAirdrop{OTAReceiver: testOTAReceiver})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rcv.Key != testOTAReceiver || rcv.ShardID != testShardID || rcv.Address != testOTAReceiver {
+		t.Fatalf("OTA receiver: got %+v", rcv)
+	}
+
+	// a public key alone names no address to pay
+	rcv, err = resolveReceiver(RequestAirdrop{Pubkey: testPubkey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rcv.Key != testPubkey || rcv.ShardID != testShardID || rcv.Address != "" {
+		t.Fatalf("unknown public key: got %+v", rcv)
+	}
+	adc.UserAccounts[testPubkey] = &UserAccount{Pubkey: testPubkey, PaymentAddress: testPaymentAddress}
+	rcv, err = resolveReceiver(RequestAirdrop{Pubkey: testPubkey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rcv.Key != testPubkey || rcv.ShardID != testShardID || rcv.Address != testPaymentAddress {
+		t.Fatalf("public key: got %+v", rcv)
+	}
+
+	if _, err := resolveReceiver(RequestAirdrop{Pubkey: "12abc"}); err == nil {
+		t.Fatal("invalid public key resolved")
+	}
+	if _, err := resolveReceiver(RequestAirdrop{OTAReceiver: testPubkey}); err == nil {
+		t.Fatal("public key resolved as an OTA receiver")
+	}
+	if _, err := resolveReceiver(RequestAirdrop{}); err != errNoReceiver {
+		t.Fatalf("no receiver: got %v, want errNoReceiver", err)
+	}
+}
+
+// TestDropRequestBody checks that the bodies the shield trigger posts name the receiver, the public
+// keys of new users included.
+func TestDropRequestBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	adc.UserAccounts = make(map[string]*UserAccount)
+
+	for _, tc := range []struct {
+		receiver string
+		want     receiver
+	}{
+		{testOTAReceiver, receiver{Key: testOTAReceiver, ShardID: testShardID, Address: testOTAReceiver}},
+		{testPaymentAddress, receiver{Key: testPubkey, ShardID: testShardID, Address: testPaymentAddress}},
+		{testPubkey, receiver{Key: testPubkey, ShardID: testShardID}},
+	} {
+		body, err := json.Marshal(service.DropRequestFor(tc.receiver))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/requestdrop", bytes.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		var req RequestAirdrop
+		if err := c.ShouldBindJSON(&req); err != nil {
+			t.Fatal(err)
+		}
+		rcv, ok := readReceiver(c, req)
+		if !ok {
+			t.Fatalf("%s: answered %v", body, w.Body.String())
+		}
+		if rcv != tc.want {
+			t.Fatalf("%s: got %+v, want %+v", body, rcv, tc.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	if _, ok := readReceiver(c, RequestAirdrop{}); ok || w.Body.String() != `{"Result":-1}` {
+		t.Fatalf("no receiver: answered %v", w.Body.String())
+	}
+}
+
+func TestClaims(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := storage.NewLevelDB(db)
+	claimdb = store.Collection(claimCollection)
+	cooldowns, err = cooldown.NewLimiter(store, cooldown.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := takeClaim(testPubkey, testPaymentAddress, ""); ok {
+		t.Fatal("took a claim never held")
+	}
+	for _, tier := range []int{2, 3} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		holdClaim(c, airdropRequest{Pubkey: testPubkey, ShardID: testShardID, ForShield: true, Tier: tier, Campaign: "first-shield"})
+		if w.Body.String() != `{"Result":4}` {
+			t.Fatalf("hold: answered %v", w.Body.String())
+		}
+	}
+	drop, ok := takeClaim(testPubkey, testPaymentAddress, "")
+	if !ok {
+		t.Fatal("held claim not taken")
+	}
+	// the first drop held is kept
+	if drop.PaymentAddress != testPaymentAddress || !drop.ForShield || drop.Tier != 2 || drop.Campaign != "first-shield" {
+		t.Fatalf("got %+v", drop)
+	}
+
+	// a claim the cooldown refuses by now is dropped
+	if _, err := cooldowns.Acquire("first-shield", testPubkey, "", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := takeClaim(testPubkey, testPaymentAddress, ""); ok {
+		t.Fatal("took a claim the cooldown refuses")
+	}
+	if err := claimdb.Get(testPubkey, &drop); err != storage.ErrNotFound {
+		t.Fatalf("refused claim kept: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package faucet
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"main/ledger"
+	"main/reconcile"
+	"main/slacknoti"
+	"time"
+)
+
+const defaultReconcileInterval = 60 // minutes
+
+// reconcileAirdrops re-checks every stored airdrop tx on chain and against the ledger, and scans
+// the airdrop accounts for outgoing txs we have no record of. Status corrections are written back
+// unless dryRun is set, everything else is only reported.
+func reconcileAirdrops(dryRun bool) ([]reconcile.Discrepancy, error) {
+	records := []reconcile.TxRecord{}
+	known := make(map[string]bool)
+	adc.userlock.RLock()
+	for _, user := range adc.UserAccounts {
+		for txHash, txDetail := range user.Txs {
+			_, amount := txDetail.disbursed()
+			records = append(records, reconcile.TxRecord{Owner: user.PaymentAddress, TxHash: txHash, Status: txDetail.Status, Amount: amount})
+			known[txHash] = true
+		}
+	}
+	adc.userlock.RUnlock()
+
+	entries, err := txLedger.Query(time.Time{}, time.Time{}, "")
+	if err != nil {
+		return nil, fmt.Errorf("read the ledger: %v", err)
+	}
+	ledgerTxs := reconcile.LedgerTxs(entries)
+
+	ledgerDiscrepancies := reconcile.CheckLedger(records, ledgerTxs)
+	// the ledger settles a tx from the tracker seeing it in a block, over what the fullnode says now
+	corrected := make(map[string]bool)
+	for _, d := range ledgerDiscrepancies {
+		if d.NewStatus != 0 {
+			corrected[d.TxHash] = true
+		}
+	}
+	discrepancies := []reconcile.Discrepancy{}
+	for _, d := range reconcile.CheckTxs(incClient, records, tracker.IsTracked) {
+		if d.NewStatus == 0 || !corrected[d.TxHash] {
+			discrepancies = append(discrepancies, d)
+		}
+	}
+	discrepancies = append(discrepancies, ledgerDiscrepancies...)
+
+	adc.airlock.RLock()
+	accounts := append([]*AirdropAccount{}, adc.AirdropAccounts...)
+	adc.airlock.RUnlock()
+	for _, acc := range accounts {
+		unknownTxs, err := reconcile.FindUnknownTxs(incClient, acc.Privatekey, byte(acc.ShardID), known)
+		if err != nil {
+			return discrepancies, fmt.Errorf("scan spent coins of %v: %v", acc.PaymentAddress, err)
+		}
+		for _, d := range unknownTxs {
+			d.Owner = acc.PaymentAddress
+			discrepancies = append(discrepancies, d)
+		}
+	}
+
+	if !dryRun {
+		applyReconcileCorrections(discrepancies, ledgerTxs)
+	}
+	return discrepancies, nil
+}
+
+// applyReconcileCorrections writes back the corrected statuses, recording them in the ledger
+// unless it has them already.
+func applyReconcileCorrections(discrepancies []reconcile.Discrepancy, ledgerTxs map[string]reconcile.LedgerTx) {
+	changed := make(map[string]*UserAccount)
+	for _, d := range discrepancies {
+		if d.NewStatus == 0 {
+			continue
+		}
+		user := getUserByPaymentAddress(d.Owner)
+		if user == nil {
+			continue
+		}
+		adc.userlock.Lock()
+		if txDetail, ok := user.Txs[d.TxHash]; ok && txDetail.Status == d.OldStatus {
+			txDetail.Status = d.NewStatus
+			txToWatchLeft := []string{}
+			for _, txHash := range user.OngoingTxs {
+				if txHash != d.TxHash {
+					txToWatchLeft = append(txToWatchLeft, txHash)
+				}
+			}
+			user.OngoingTxs = txToWatchLeft
+			refreshAirdropSuccess(user)
+			changed[user.PaymentAddress] = user
+			event := ledger.EventFailed
+			if d.NewStatus == reconcile.StatusConfirmed {
+				event = ledger.EventConfirmed
+			}
+			if ledgerTxs[d.TxHash].Settled != event {
+				if err := txLedger.RecordSettled(d.TxHash, event, ""); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+		adc.userlock.Unlock()
+	}
+	for _, user := range changed {
+		err := UpdateUserAirdropInfo(user)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// startReconcileJob runs the reconciliation periodically and reports what it finds on slack.
+func startReconcileJob() {
+	interval := config.ReconcileInterval
+	if interval <= 0 {
+		interval = defaultReconcileInterval
+	}
+	for {
+		time.Sleep(time.Duration(interval) * time.Minute)
+		discrepancies, err := reconcileAirdrops(false)
+		if err != nil {
+			log.Println("reconcile:", err)
+		}
+		if len(discrepancies) > 0 {
+			msg := reconcile.Summary("faucet", discrepancies)
+			log.Println(msg)
+			go slacknoti.SendSlackNoti(msg)
+		}
+	}
+}
+
+// runReconcileCommand is the `ledger reconcile` subcommand: a one-off reconciliation while the service
+// is stopped, since both need the db.
+func runReconcileCommand(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report discrepancies, do not correct the stored records")
+	fs.Parse(args)
+
+	discrepancies, err := reconcileAirdrops(*dryRun)
+	fmt.Println(reconcile.Summary("faucet", discrepancies))
+	return err
+}
@@ -0,0 +1,146 @@
+package faucet
+
+import (
+	"errors"
+	"main/ledger"
+	"main/nodepool"
+	"main/reconcile"
+	"main/storage"
+	"main/txtracker"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// reconcileNode knows the txs of inBlock, which are all in a block.
+type reconcileNode struct {
+	nodepool.Client
+	inBlock map[string]bool
+}
+
+func (n reconcileNode) CheckTxInBlock(txHash string) (bool, error) {
+	if !n.inBlock[txHash] {
+		return false, errors.New("tx not found")
+	}
+	return true, nil
+}
+
+// setupReconcile stores a user with a tx of each mismatch class and the ledger entries of
+// these txs.
+func setupReconcile(t *testing.T) *UserAccount {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store := storage.NewLevelDB(db)
+	oldLedger, oldUserdb, oldTracker, oldClient, oldAdc := txLedger, userdb, tracker, incClient, adc.UserAccounts
+	t.Cleanup(func() {
+		txLedger, userdb, tracker, incClient, adc.UserAccounts = oldLedger, oldUserdb, oldTracker, oldClient, oldAdc
+	})
+	if txLedger, err = ledger.New(store); err != nil {
+		t.Fatal(err)
+	}
+	userdb = store.Collection("users")
+	if tracker, err = txtracker.NewTracker(nil, nil, txtracker.DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	incClient = reconcileNode{inBlock: map[string]bool{"landed": true, "unrecorded": true, "mismatch": true}}
+
+	user := &UserAccount{
+		PaymentAddress: testPaymentAddress,
+		OngoingTxs:     []string{"landed", "paid"},
+		Txs: map[string]*AirdropTxDetail{
+			// in a block, the tracker missed it
+			"landed": {TxHash: "landed", Value: 110, Fee: 100, Status: reconcile.StatusSent},
+			// confirmed in the ledger but the user is not marked paid, the fullnode lost it since
+			"paid": {TxHash: "paid", Value: 110, Fee: 100, Status: reconcile.StatusSent},
+			// paid with no ledger entry
+			"unrecorded": {TxHash: "unrecorded", Value: 110, Fee: 100, Status: reconcile.StatusConfirmed},
+			// the ledger has another amount
+			"mismatch": {TxHash: "mismatch", Value: 110, Fee: 100, Status: reconcile.StatusConfirmed},
+		},
+	}
+	adc.UserAccounts = map[string]*UserAccount{testPubkey: user}
+	for _, e := range []ledger.Entry{
+		{TxHash: "landed", Receiver: testPaymentAddress, Amount: 10},
+		{TxHash: "paid", Receiver: testPaymentAddress, Amount: 10},
+		{TxHash: "mismatch", Receiver: testPaymentAddress, Amount: 5},
+	} {
+		if err := txLedger.RecordBroadcast(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, txHash := range []string{"paid", "mismatch"} {
+		if err := txLedger.RecordSettled(txHash, ledger.EventConfirmed, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+func settledEvents(t *testing.T) map[string][]string {
+	entries, err := txLedger.Query(time.Time{}, time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string][]string)
+	for _, e := range entries {
+		if e.Event != ledger.EventBroadcast {
+			result[e.TxHash] = append(result[e.TxHash], e.Event)
+		}
+	}
+	return result
+}
+
+func TestReconcileAirdrops(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		user := setupReconcile(t)
+		discrepancies, err := reconcileAirdrops(dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]reconcile.Discrepancy)
+		for _, d := range discrepancies {
+			if _, ok := got[d.TxHash]; ok {
+				t.Fatalf("dry run %v: %v reported twice: %v", dryRun, d.TxHash, discrepancies)
+			}
+			got[d.TxHash] = d
+		}
+		if len(got) != 4 ||
+			got["landed"].NewStatus != reconcile.StatusConfirmed ||
+			got["paid"].NewStatus != reconcile.StatusConfirmed || got["paid"].Reason != "confirmed in the ledger" ||
+			got["unrecorded"].NewStatus != 0 ||
+			got["mismatch"].NewStatus != 0 || got["mismatch"].Reason != "amount 10, 5 in the ledger" {
+			t.Fatalf("dry run %v: %v", dryRun, discrepancies)
+		}
+
+		stored, err := LoadUserAirdropInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		settled := settledEvents(t)
+		if dryRun {
+			if user.Txs["landed"].Status != reconcile.StatusSent || user.Txs["paid"].Status != reconcile.StatusSent || len(user.OngoingTxs) != 2 {
+				t.Fatalf("dry run changed the user: %+v", user)
+			}
+			if len(stored) != 0 || len(settled["landed"]) != 0 {
+				t.Fatalf("dry run wrote the user (%v) or the ledger (%v)", stored, settled)
+			}
+			continue
+		}
+		if user.Txs["landed"].Status != reconcile.StatusConfirmed || user.Txs["paid"].Status != reconcile.StatusConfirmed ||
+			len(user.OngoingTxs) != 0 || !user.AirdropSuccess {
+			t.Fatalf("corrected user %+v", user)
+		}
+		if len(stored) != 1 || stored[0].Txs["paid"].Status != reconcile.StatusConfirmed {
+			t.Fatalf("stored users %+v", stored)
+		}
+		// the ledger already had the confirmation of paid
+		if len(settled["landed"]) != 1 || len(settled["paid"]) != 1 {
+			t.Fatalf("settled events %v", settled)
+		}
+	}
+}
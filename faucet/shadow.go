@@ -0,0 +1,65 @@
+package faucet
+
+import (
+	"log"
+	"main/ledger"
+	"main/shadow"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var shadowStore *shadow.Store
+
+// shadowAirdrop stands in for sending the txs built by AirdropUser in shadow mode. It records
+// them, gives their coins back to the accounts, and marks the user done in memory only, so that
+// nothing reaches the chain nor the user records.
+func shadowAirdrop(user *UserAccount, txHashes []string) {
+	for _, txHash := range txHashes {
+		txDetail := user.Txs[txHash]
+		tokenID, amount := txDetail.disbursed()
+		err := shadowStore.Record(shadow.Record{
+			Kind:     ledger.KindDrop,
+			Account:  txDetail.Account,
+			Receiver: user.PaymentAddress,
+			TxHash:   txHash,
+			TokenID:  tokenID,
+			Amount:   amount,
+			Fee:      txDetail.Fee,
+			Inputs:   append(append([]string{}, txDetail.Inputs...), txDetail.TokenInputs...),
+		})
+		if err != nil {
+			log.Println(err)
+		}
+		if ada := getAirdropAccount(txDetail.Account); ada != nil {
+			releaseInputs(ada, txDetail.inputs())
+		}
+	}
+
+	adc.userlock.Lock()
+	for _, txHash := range txHashes {
+		user.Txs[txHash].Status = 2
+	}
+	user.OngoingTxs = []string{}
+	user.AirdropSuccess = true
+	adc.userlock.Unlock()
+	log.Printf("shadow airdrop for user %v: %v txs recorded\n", user.PaymentAddress, len(txHashes))
+}
+
+// APIShadowTxs lists the txs built in shadow mode, filtered by date range (from/to as
+// YYYY-MM-DD) and receiver.
+func APIShadowTxs(c *gin.Context) {
+	from, to, err := ledger.ParseRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	records, err := shadowStore.Query(from, to, c.Query("receiver"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"Result": records,
+	})
+}
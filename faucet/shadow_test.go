@@ -0,0 +1,233 @@
+package faucet
+
+import (
+	"fmt"
+	"main/ledger"
+	"main/nodepool"
+	"main/shadow"
+	"main/storage"
+	"main/txtracker"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/crypto/operation"
+	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+const testTokenID = "0000000000000000000000000000000000000000000000000000000000000abc"
+
+type testCoin struct {
+	coin.PlainCoin
+	pubkey, keyImage *operation.Point
+	value            uint64
+}
+
+func (c testCoin) GetPublicKey() *operation.Point { return c.pubkey }
+func (c testCoin) GetKeyImage() *operation.Point  { return c.keyImage }
+func (c testCoin) GetValue() uint64               { return c.value }
+func (c testCoin) GetVersion() uint8              { return 2 }
+
+func testCoins(values ...uint64) []Coin {
+	result := []Coin{}
+	for i, v := range values {
+		result = append(result, Coin{Coin: testCoin{pubkey: operation.RandomPoint(), keyImage: operation.RandomPoint(), value: v}, Index: uint64(i)})
+	}
+	return result
+}
+
+// buildNode builds txs named after the coins they spend, and counts the txs built and sent. The
+// unspent coins it knows are keyed by private key and token.
+type buildNode struct {
+	nodepool.Client
+	lock    sync.Mutex
+	built   int
+	sent    int
+	unspent map[string][]Coin
+}
+
+func (n *buildNode) GetUnspentOutputCoins(privateKey, tokenID string, height uint64) ([]coin.PlainCoin, []*big.Int, error) {
+	coins := []coin.PlainCoin{}
+	indices := []*big.Int{}
+	for _, v := range n.unspent[privateKey+"/"+tokenID] {
+		coins = append(coins, v.Coin)
+		indices = append(indices, new(big.Int).SetUint64(v.Index))
+	}
+	return coins, indices, nil
+}
+
+// build names a tx after the coins it spends and the number of txs built before it.
+func (n *buildNode) build(coinLists ...[]coin.PlainCoin) ([]byte, string, error) {
+	keyImages := []string{}
+	for _, coins := range coinLists {
+		for _, c := range coins {
+			keyImages = append(keyImages, keyImageString(c))
+		}
+	}
+	n.lock.Lock()
+	n.built++
+	txHash := fmt.Sprintf("%v#%v", strings.Join(keyImages, "+"), n.built)
+	n.lock.Unlock()
+	return []byte(txHash), txHash, nil
+}
+
+func (n *buildNode) CreateRawTransactionWithInputCoins(param *incclient.TxParam, coins []coin.PlainCoin, indices []uint64) ([]byte, string, error) {
+	return n.build(coins)
+}
+
+func (n *buildNode) CreateRawTokenTransactionWithInputCoins(param *incclient.TxParam, tokenCoins []coin.PlainCoin, tokenIndices []uint64, prvCoins []coin.PlainCoin, prvIndices []uint64) ([]byte, string, error) {
+	return n.build(tokenCoins, prvCoins)
+}
+
+func (n *buildNode) SendRawTx(encodedTx []byte) error {
+	n.lock.Lock()
+	n.sent++
+	n.lock.Unlock()
+	return nil
+}
+
+func (n *buildNode) SendRawTokenTx(encodedTx []byte) error {
+	return n.SendRawTx(encodedTx)
+}
+
+// setupDrop gives the faucet two accounts on the shard of the test user holding coins, as the
+// fullnode knows them, and a store of its own.
+func setupDrop(t *testing.T, coins []Coin, tokenCoins []Coin) (*buildNode, storage.Store) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store := storage.NewLevelDB(db)
+	oldLedger, oldUserdb, oldTracker, oldClient, oldShadow, oldConfig := txLedger, userdb, tracker, incClient, shadowStore, config
+	oldUsers, oldAccounts, oldLastUsed, oldMempool := adc.UserAccounts, adc.AirdropAccounts, adc.lastUsedADA, mempool
+	t.Cleanup(func() {
+		txLedger, userdb, tracker, incClient, shadowStore, config = oldLedger, oldUserdb, oldTracker, oldClient, oldShadow, oldConfig
+		adc.UserAccounts, adc.AirdropAccounts, adc.lastUsedADA, mempool = oldUsers, oldAccounts, oldLastUsed, oldMempool
+	})
+	if txLedger, err = ledger.New(store); err != nil {
+		t.Fatal(err)
+	}
+	userdb = store.Collection("users")
+	shadowStore = shadow.NewStore(store)
+	if tracker, err = txtracker.NewTracker(nil, store, txtracker.DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	node := &buildNode{}
+	incClient = node
+
+	adc.UserAccounts = make(map[string]*UserAccount)
+	adc.AirdropAccounts = nil
+	adc.lastUsedADA = 0
+	node.unspent = make(map[string][]Coin)
+	for i := 0; i < 2; i++ {
+		node.unspent[fmt.Sprintf("key%v/%v", i, common.PRVIDStr)] = coins
+		node.unspent[fmt.Sprintf("key%v/%v", i, testTokenID)] = tokenCoins
+		adc.AirdropAccounts = append(adc.AirdropAccounts, &AirdropAccount{
+			Privatekey:     fmt.Sprintf("key%v", i),
+			PaymentAddress: fmt.Sprintf("account%v", i),
+			ShardID:        testShardID,
+			TotalUTXO:      len(coins),
+			UTXOList:       coins,
+			TokenUTXOList:  map[string][]Coin{testTokenID: tokenCoins},
+			UTXOInUse:      make(map[string]struct{}),
+		})
+	}
+	return node, store
+}
+
+// decision is what a drop tx gives, out of which coins.
+type decision struct {
+	Account, Receiver, TokenID string
+	Amount, Fee                uint64
+	Inputs                     string
+}
+
+func sortDecisions(decisions []decision) []decision {
+	sort.Slice(decisions, func(i, j int) bool { return decisions[i].Inputs < decisions[j].Inputs })
+	return decisions
+}
+
+// TestShadowAirdrop runs the same drop in shadow and live mode: the shadow one records the txs
+// the live one sends, and sends nothing.
+func TestShadowAirdrop(t *testing.T) {
+	coins := testCoins(AirdropCoinShieldValue, 10*AirdropCoinShieldValue, 10*AirdropCoinShieldValue)
+	tokenCoins := testCoins(30, 50)
+	policies := []DropPolicy{{Request: requestShield, TokenID: testTokenID, Amount: 40}}
+
+	drop := func(shadowMode bool) (*UserAccount, *buildNode, storage.Store) {
+		node, store := setupDrop(t, coins, tokenCoins)
+		config.ShadowMode = shadowMode
+		config.DropPolicies = policies
+		user := &UserAccount{PaymentAddress: testPaymentAddress, Pubkey: testPubkey, ShardID: testShardID, Txs: make(map[string]*AirdropTxDetail)}
+		adc.UserAccounts[user.Pubkey] = user
+		if err := AirdropUser(user, true); err != nil {
+			t.Fatal(err)
+		}
+		return user, node, store
+	}
+
+	user, node, store := drop(true)
+	if node.sent != 0 {
+		t.Fatalf("shadow mode sent %v txs", node.sent)
+	}
+	if len(user.Txs) != 2 || !user.AirdropSuccess || len(user.OngoingTxs) != 0 {
+		t.Fatalf("shadow user %+v", user)
+	}
+	for txHash := range user.Txs {
+		if tracker.IsTracked(txHash) {
+			t.Fatalf("shadow tx %v tracked", txHash)
+		}
+	}
+	if entries, _ := txLedger.Query(time.Time{}, time.Time{}, ""); len(entries) != 0 {
+		t.Fatalf("shadow txs in the ledger: %+v", entries)
+	}
+	if users, _ := LoadUserAirdropInfo(); len(users) != 0 {
+		t.Fatalf("shadow user stored: %+v", users)
+	}
+	if pending, _ := store.Collection(txtracker.CollectionName).Last(&txtracker.PendingTx{}); pending != "" {
+		t.Fatalf("shadow tx %v persisted by the tracker", pending)
+	}
+	for _, acc := range adc.AirdropAccounts {
+		if len(acc.UTXOInUse) != 0 {
+			t.Fatalf("coins of %v still in use after a shadow drop", acc.PaymentAddress)
+		}
+	}
+	records, err := shadowStore.Query(time.Time{}, time.Time{}, testPaymentAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shadowDecisions := []decision{}
+	for _, r := range records {
+		shadowDecisions = append(shadowDecisions, decision{r.Account, r.Receiver, r.TokenID, r.Amount, r.Fee, strings.Join(r.Inputs, "+")})
+	}
+
+	user, node, _ = drop(false)
+	if node.sent != len(user.Txs) || len(user.OngoingTxs) != len(user.Txs) {
+		t.Fatalf("live mode sent %v txs of %+v", node.sent, user)
+	}
+	liveDecisions := []decision{}
+	for txHash, txDetail := range user.Txs {
+		if !tracker.IsTracked(txHash) {
+			t.Fatalf("live tx %v not tracked", txHash)
+		}
+		entries, err := txLedger.BroadcastEntries(txHash)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("ledger entries of %v: %+v, err %v", txHash, entries, err)
+		}
+		e := entries[0]
+		inputs := append(append([]string{}, txDetail.Inputs...), txDetail.TokenInputs...)
+		liveDecisions = append(liveDecisions, decision{e.Account, e.Receiver, e.TokenID, e.Amount, e.Fee, strings.Join(inputs, "+")})
+	}
+
+	if fmt.Sprint(sortDecisions(shadowDecisions)) != fmt.Sprint(sortDecisions(liveDecisions)) {
+		t.Fatalf("shadow decisions\n%v\nlive decisions\n%v", shadowDecisions, liveDecisions)
+	}
+}
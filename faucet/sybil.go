@@ -0,0 +1,104 @@
+package faucet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"main/accesslist"
+	"main/sybil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var sybilScorer *sybil.Scorer
+
+// airdropRequest is an accepted drop request, kept as is while it is held for review.
+type airdropRequest struct {
+	PaymentAddress string
+	Pubkey         string
+	ShardID        int
+	CallbackURL    string
+	APIKey         string
+	IP             string
+	// GrantedAt is the unix time the cooldown policy granted the drop.
+	GrantedAt int64
+	ForShield bool
+	// Tier is the size tier of the shield of a shield drop.
+	Tier int
+	// Campaign is the campaign of a triggered drop, the faucet's one when empty.
+	Campaign string
+}
+
+func newAirdropUser(drop airdropRequest) *UserAccount {
+	user := new(UserAccount)
+	user.PaymentAddress = drop.PaymentAddress
+	user.Pubkey = drop.Pubkey
+	user.ShardID = drop.ShardID
+	user.Txs = make(map[string]*AirdropTxDetail)
+	user.CallbackURL = drop.CallbackURL
+	user.APIKey = drop.APIKey
+	user.IP = drop.IP
+	user.GrantedAt = drop.GrantedAt
+	user.Tier = drop.Tier
+	user.Campaign = drop.Campaign
+	return user
+}
+
+// checkSybil scores a request and answers it itself when it is refused or held for review (Result
+// 3), returning false. Integrators are trusted with their API key and the triggers with the admin
+// key: all their requests come from the same few machines.
+func checkSybil(c *gin.Context, drop airdropRequest, captcha *sybil.Captcha) bool {
+	if !sybilScorer.Enabled() || drop.APIKey != "" || accesslist.IsAdmin(c, config.AdminKey) {
+		return true
+	}
+	fp := sybil.NewFingerprint(c.Request, c.ClientIP(), drop.Pubkey, drop.ShardID)
+	fp.Captcha = captcha
+	rec, err := sybilScorer.Evaluate(fp, drop)
+	if err != nil {
+		log.Println("sybil:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": "cannot check the request, try again later"})
+		return false
+	}
+	switch rec.Decision {
+	case sybil.DecisionRefuse:
+		log.Printf("drop to %v refused, score %v: %v\n", drop.Pubkey, rec.Score, strings.Join(rec.Reasons, "; "))
+		c.JSON(http.StatusForbidden, gin.H{
+			"Result": -1,
+			"Error":  "this request looks automated",
+		})
+		return false
+	case sybil.DecisionReview:
+		log.Printf("drop to %v held for review, score %v: %v\n", drop.Pubkey, rec.Score, strings.Join(rec.Reasons, "; "))
+		c.JSON(http.StatusOK, gin.H{
+			"Result": 3,
+		})
+		return false
+	}
+	return true
+}
+
+// approveHeldAirdrop carries out a drop approved on review.
+func approveHeldAirdrop(review sybil.Review) error {
+	var drop airdropRequest
+	if err := json.Unmarshal(review.Request, &drop); err != nil {
+		return err
+	}
+	// the key may have been denied while the drop was held
+	if e, err := accessLists.Check(drop.Pubkey); err != nil {
+		return err
+	} else if e.List == accesslist.Deny {
+		return fmt.Errorf("%v is on the deny list: %v", drop.Pubkey, e.Reason)
+	}
+	user, d, err := registerUser(drop)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New(d.Reason)
+	}
+	go runAirdrop(user, drop.ForShield)
+	return nil
+}
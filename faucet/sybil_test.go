@@ -0,0 +1,54 @@
+package faucet
+
+import (
+	"main/accesslist"
+	"main/storage"
+	"main/sybil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// TestCheckSybilTrigger checks that the trigger, whose drops all come from one machine with the
+// Go user agent, is not scored.
+func TestCheckSybilTrigger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sybilScorer = sybil.NewScorer(storage.NewLevelDB(db), sybil.Config{Enabled: true})
+	config.AdminKey = "admin"
+	defer func() { config.AdminKey = "" }()
+
+	check := func(pubkey, adminKey string) (bool, string) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/requestdrop", nil)
+		c.Request.RemoteAddr = "203.0.113.7:4242"
+		c.Request.Header.Set("User-Agent", "Go-http-client/1.1")
+		if adminKey != "" {
+			c.Request.Header.Set(accesslist.AdminKeyHeader, adminKey)
+		}
+		return checkSybil(c, airdropRequest{Pubkey: pubkey}, nil), w.Body.String()
+	}
+	if ok, body := check("a", ""); !ok {
+		t.Fatalf("first request: answered %v", body)
+	}
+	if ok, body := check("b", ""); ok || body != `{"Result":3}` {
+		t.Fatalf("second request from the IP: ok %v, answered %v", ok, body)
+	}
+	for _, pubkey := range []string{"c", "d", "e"} {
+		if ok, body := check(pubkey, "admin"); !ok {
+			t.Fatalf("trigger request: answered %v", body)
+		}
+	}
+	if ok, _ := check("f", "wrong"); ok {
+		t.Fatal("request with a wrong admin key not scored")
+	}
+}
@@ -0,0 +1,260 @@
+package faucet
+
+import (
+	"fmt"
+	"log"
+	"main/fee"
+	"main/slacknoti"
+	"sort"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+)
+
+// dropPolicies returns the policies applying to a request.
+func dropPolicies(forShield bool) []DropPolicy {
+	kind := requestFaucet
+	if forShield {
+		kind = requestShield
+	}
+	result := []DropPolicy{}
+	for _, policy := range config.DropPolicies {
+		if policy.Request == "" || policy.Request == kind {
+			result = append(result, policy)
+		}
+	}
+	return result
+}
+
+// disbursed returns the token a tx gives and how much of it, fee excluded.
+func (d *AirdropTxDetail) disbursed() (string, uint64) {
+	if d.TokenID != "" {
+		return d.TokenID, d.TokenAmount
+	}
+	return common.PRVIDStr, d.Value - d.Fee
+}
+
+// inputs returns the key images of the coins spent by a tx, by token.
+func (d *AirdropTxDetail) inputs() map[string][]string {
+	result := map[string][]string{common.PRVIDStr: d.Inputs}
+	if d.TokenID != "" {
+		result[d.TokenID] = d.TokenInputs
+	}
+	return result
+}
+
+// releaseInputs gives back to ada the coins of a tx that was never sent.
+func releaseInputs(ada *AirdropAccount, inputs map[string][]string) {
+	ada.lock.Lock()
+	defer ada.lock.Unlock()
+	for tokenID, keyImages := range inputs {
+		coins, _ := ada.coinsByKeyImages(tokenID, keyImages)
+		for _, v := range coins {
+			delete(ada.UTXOInUse, v.Coin.GetPublicKey().String())
+		}
+	}
+}
+
+func getAirdropAccount(paymentAddress string) *AirdropAccount {
+	adc.airlock.RLock()
+	defer adc.airlock.RUnlock()
+	for _, acc := range adc.AirdropAccounts {
+		if acc.PaymentAddress == paymentAddress {
+			return acc
+		}
+	}
+	return nil
+}
+
+// coins returns the known coins of tokenID. Callers hold ada.lock.
+func (ada *AirdropAccount) coins(tokenID string) []Coin {
+	if tokenID == common.PRVIDStr {
+		return ada.UTXOList
+	}
+	return ada.TokenUTXOList[tokenID]
+}
+
+// freeBalance sums the coins of tokenID no pending tx spends.
+func (ada *AirdropAccount) freeBalance(tokenID string) uint64 {
+	ada.lock.Lock()
+	defer ada.lock.Unlock()
+	total := uint64(0)
+	for _, v := range ada.coins(tokenID) {
+		if _, ok := ada.UTXOInUse[v.Coin.GetPublicKey().String()]; !ok {
+			total += v.Coin.GetValue()
+		}
+	}
+	return total
+}
+
+// pickFreeCoins takes coins no pending tx spends until they reach needed, marking them in use.
+// Callers hold ada.lock.
+func (ada *AirdropAccount) pickFreeCoins(tokenID string, needed uint64) ([]Coin, error) {
+	result := []Coin{}
+	total := uint64(0)
+	for _, v := range ada.coins(tokenID) {
+		if total >= needed {
+			break
+		}
+		if _, ok := ada.UTXOInUse[v.Coin.GetPublicKey().String()]; ok {
+			continue
+		}
+		total += v.Coin.GetValue()
+		result = append(result, v)
+	}
+	if total < needed {
+		return nil, fmt.Errorf("account %v has %v of token %v available, %v needed", ada.PaymentAddress, total, tokenID, needed)
+	}
+	for _, v := range result {
+		ada.UTXOInUse[v.Coin.GetPublicKey().String()] = struct{}{}
+	}
+	return result, nil
+}
+
+// coinsByKeyImages finds the coins of tokenID with the given key images, which must all still be
+// unspent. Callers hold ada.lock.
+func (ada *AirdropAccount) coinsByKeyImages(tokenID string, keyImages []string) ([]Coin, bool) {
+	result := []Coin{}
+	for _, keyImage := range keyImages {
+		for _, v := range ada.coins(tokenID) {
+			if keyImageString(v.Coin) == keyImage {
+				result = append(result, v)
+				break
+			}
+		}
+	}
+	return result, len(result) == len(keyImages)
+}
+
+func getAirdropAccountTokenUTXOs(ada *AirdropAccount, tokenID string) error {
+	uxto, indices, err := incClient.GetUnspentOutputCoins(ada.Privatekey, tokenID, 0)
+	if err != nil {
+		return err
+	}
+	var utxos []Coin
+	for idx, v := range uxto {
+		if v.GetVersion() == 2 {
+			utxos = append(utxos, Coin{
+				Coin:  v,
+				Index: indices[idx].Uint64(),
+			})
+		}
+	}
+	ada.lock.Lock()
+	ada.TokenUTXOList[tokenID] = utxos
+	ada.lock.Unlock()
+	return nil
+}
+
+// chooseTokenAccount picks an account holding amount of tokenID and the PRV for the fee,
+// preferring those of the receiver's shard.
+func chooseTokenAccount(tokenID string, amount uint64, shardID int) (*AirdropAccount, error) {
+	adc.airlock.RLock()
+	accounts := append([]*AirdropAccount{}, adc.AirdropAccounts...)
+	adc.airlock.RUnlock()
+	sort.SliceStable(accounts, func(i, j int) bool {
+		return accounts[i].ShardID == shardID && accounts[j].ShardID != shardID
+	})
+	for _, acc := range accounts {
+		if acc.freeBalance(tokenID) < amount {
+			if err := getAirdropAccountTokenUTXOs(acc, tokenID); err != nil {
+				log.Printf("get %v coins of %v: %v\n", tokenID, acc.PaymentAddress, err)
+				continue
+			}
+		}
+		// a token tx usually spends a coin of each and gives the receiver, the token change and the
+		// PRV change an output
+		feeNeeded := feeEstimator.Replacement(feeEstimator.Fee(acc.PaymentAddress, 2, 3, true))
+		if acc.freeBalance(common.PRVIDStr) < feeNeeded {
+			if err := loadAirdropAccountUTXOs(acc); err != nil {
+				log.Printf("get coins of %v: %v\n", acc.PaymentAddress, err)
+				continue
+			}
+		}
+		if acc.freeBalance(tokenID) >= amount && acc.freeBalance(common.PRVIDStr) >= feeNeeded {
+			return acc, nil
+		}
+	}
+	msg := fmt.Sprintf("no airdrop account has %v of token %v available\n", amount, tokenID)
+	log.Println(msg)
+	go slacknoti.SendSlackNoti(msg)
+	return nil, fmt.Errorf("no airdrop account has %v of token %v available", amount, tokenID)
+}
+
+// CreateTokenDropTx creates a tx giving policy.Amount of policy.TokenID to paymentAddress.
+func CreateTokenDropTx(policy DropPolicy, paymentAddress string, shardID int) (*AirdropTxDetail, []byte, string, error) {
+	ada, err := chooseTokenAccount(policy.TokenID, policy.Amount, shardID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	quote := feeEstimator.Quote(ada.PaymentAddress)
+	ada.lock.Lock()
+	defer ada.lock.Unlock()
+	tokenCoins, prvCoins, txFee, err := ada.pickTokenDropCoins(policy.TokenID, policy.Amount, quote)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return buildTokenDropTx(ada, paymentAddress, policy.TokenID, policy.Amount, tokenCoins, prvCoins, txFee)
+}
+
+// pickTokenDropCoins takes the coins giving amount of tokenID and the PRV coins paying the fee of
+// the tx, priced at quote, and returns them along with the fee. It holds no coin when either is
+// short. Callers hold ada.lock.
+func (ada *AirdropAccount) pickTokenDropCoins(tokenID string, amount uint64, quote fee.Quote) ([]Coin, []Coin, uint64, error) {
+	tokenCoins, err := ada.pickFreeCoins(tokenID, amount)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	// leave room for the higher fee of a replacement, which has to spend the very same coins. The
+	// fee coins are assumed to be two at most.
+	txFee := quote.Fee(len(tokenCoins)+2, 3, true)
+	prvCoins, err := ada.pickFreeCoins(common.PRVIDStr, feeEstimator.Replacement(txFee))
+	if err != nil {
+		for _, v := range tokenCoins {
+			delete(ada.UTXOInUse, v.Coin.GetPublicKey().String())
+		}
+		return nil, nil, 0, err
+	}
+	return tokenCoins, prvCoins, txFee, nil
+}
+
+// buildTokenDropTx creates a tx sending amount of tokenID to paymentAddress out of the given
+// coins, the fee being paid in PRV.
+func buildTokenDropTx(ada *AirdropAccount, paymentAddress, tokenID string, amount uint64, tokenCoins, prvCoins []Coin, fee uint64) (*AirdropTxDetail, []byte, string, error) {
+	tokenCoinList := []coin.PlainCoin{}
+	tokenIdxList := []uint64{}
+	tokenKeyImages := []string{}
+	for _, v := range tokenCoins {
+		tokenCoinList = append(tokenCoinList, v.Coin)
+		tokenIdxList = append(tokenIdxList, v.Index)
+		tokenKeyImages = append(tokenKeyImages, keyImageString(v.Coin))
+	}
+	prvCoinList := []coin.PlainCoin{}
+	prvIdxList := []uint64{}
+	prvKeyImages := []string{}
+	for _, v := range prvCoins {
+		prvCoinList = append(prvCoinList, v.Coin)
+		prvIdxList = append(prvIdxList, v.Index)
+		prvKeyImages = append(prvKeyImages, keyImageString(v.Coin))
+	}
+
+	txTokenParam := incclient.NewTxTokenParam(tokenID, 1, []string{paymentAddress}, []uint64{amount}, false, 0, nil)
+	txParam := incclient.NewTxParam(ada.Privatekey, []string{}, []uint64{}, fee, txTokenParam, nil, nil)
+	encodedTx, txHash, err := incClient.CreateRawTokenTransactionWithInputCoins(txParam, tokenCoinList, tokenIdxList, prvCoinList, prvIdxList)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	txDetail := AirdropTxDetail{
+		TxHash:      txHash,
+		Value:       fee,
+		Account:     ada.PaymentAddress,
+		Inputs:      prvKeyImages,
+		Fee:         fee,
+		TokenID:     tokenID,
+		TokenAmount: amount,
+		TokenInputs: tokenKeyImages,
+	}
+	return &txDetail, encodedTx, txHash, nil
+}
@@ -0,0 +1,179 @@
+package faucet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+)
+
+const otherTokenID = "0000000000000000000000000000000000000000000000000000000000000def"
+
+func TestDropPolicies(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	readPolicies := func(policies string) error {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := ioutil.WriteFile(path, []byte(`{"DropPolicies": `+policies+`}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		config = Config{}
+		return readConfig(path)
+	}
+
+	// the amounts are integers in the smallest unit of the tokens, read without rounding
+	err := readPolicies(`[{"Request": "shield", "TokenID": "` + testTokenID + `", "Amount": 1500000},
+		{"TokenID": "` + otherTokenID + `", "Amount": 18446744073709551615}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DropPolicies[0].Amount != 1500000 || config.DropPolicies[1].Amount != 18446744073709551615 {
+		t.Fatalf("policies %+v", config.DropPolicies)
+	}
+	for _, tc := range []struct {
+		forShield bool
+		want      []string
+	}{
+		{true, []string{testTokenID, otherTokenID}},
+		{false, []string{otherTokenID}},
+	} {
+		got := []string{}
+		for _, policy := range dropPolicies(tc.forShield) {
+			got = append(got, policy.TokenID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("shield %v: tokens %v", tc.forShield, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		name, policies, err string
+	}{
+		{"decimal amount", `[{"TokenID": "` + testTokenID + `", "Amount": 1.5}]`, "cannot unmarshal number 1.5"},
+		{"negative amount", `[{"TokenID": "` + testTokenID + `", "Amount": -1}]`, "cannot unmarshal number -1"},
+		{"no amount", `[{"TokenID": "` + testTokenID + `"}]`, "no amount"},
+		{"PRV", `[{"TokenID": "` + common.PRVIDStr + `", "Amount": 1}]`, "invalid token"},
+		{"short token", `[{"TokenID": "abc", "Amount": 1}]`, "invalid token"},
+		{"unknown request", `[{"Request": "bulk", "TokenID": "` + testTokenID + `", "Amount": 1}]`, "unknown request kind"},
+	} {
+		if err := readPolicies(tc.policies); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: err %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+// dropFee is the PRV a token drop needs, with room for a replacement, at the default fee.
+const dropFee = 2 * incclient.DefaultPRVFee
+
+func TestChooseTokenAccount(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// the coins of the accounts, the second one being on the shard of the receiver
+		prv, token [2][]uint64
+		// the PRV coins the fullnode has for the second account
+		reloaded []uint64
+		want     string
+	}{
+		{"receiver shard first", [2][]uint64{{dropFee}, {dropFee}}, [2][]uint64{{40}, {40}}, nil, "account1"},
+		{"token short on the receiver shard", [2][]uint64{{dropFee}, {dropFee}}, [2][]uint64{{40}, {30}}, nil, "account0"},
+		{"token in several coins", [2][]uint64{{dropFee}, {dropFee}}, [2][]uint64{{40}, {30, 10}}, nil, "account1"},
+		{"fee short on the receiver shard", [2][]uint64{{dropFee}, {dropFee - 1}}, [2][]uint64{{40}, {40}}, nil, "account0"},
+		{"fee found on reload", [2][]uint64{{dropFee}, {dropFee - 1}}, [2][]uint64{{40}, {40}}, []uint64{dropFee}, "account1"},
+		{"token short, PRV available", [2][]uint64{{dropFee}, {dropFee}}, [2][]uint64{{39}, {20, 19}}, nil, ""},
+		{"PRV short, token available", [2][]uint64{{dropFee - 1}, {}}, [2][]uint64{{40}, {40}}, nil, ""},
+	} {
+		node, _ := setupDrop(t, nil, nil)
+		for i, acc := range adc.AirdropAccounts {
+			acc.ShardID = i + testShardID - 1
+			acc.UTXOList = testCoins(tc.prv[i]...)
+			acc.TokenUTXOList = map[string][]Coin{testTokenID: testCoins(tc.token[i]...)}
+			node.unspent[acc.Privatekey+"/"+common.PRVIDStr] = acc.UTXOList
+			node.unspent[acc.Privatekey+"/"+testTokenID] = acc.TokenUTXOList[testTokenID]
+		}
+		if tc.reloaded != nil {
+			node.unspent["key1/"+common.PRVIDStr] = testCoins(tc.reloaded...)
+		}
+
+		acc, err := chooseTokenAccount(testTokenID, 40, testShardID)
+		if tc.want == "" {
+			if err == nil || !strings.Contains(err.Error(), "no airdrop account has 40") {
+				t.Errorf("%v: chose %v, err %v", tc.name, acc, err)
+			}
+			continue
+		}
+		if err != nil || acc.PaymentAddress != tc.want {
+			t.Errorf("%v: chose %v, err %v", tc.name, acc, err)
+		}
+	}
+}
+
+func TestCreateTokenDropTx(t *testing.T) {
+	setupDrop(t, testCoins(dropFee/2, dropFee/2, dropFee), testCoins(30, 50, 5))
+	acc := adc.AirdropAccounts[0]
+	adc.AirdropAccounts = adc.AirdropAccounts[:1]
+	policy := DropPolicy{TokenID: testTokenID, Amount: 40}
+
+	txDetail, _, txHash, err := CreateTokenDropTx(policy, testPaymentAddress, testShardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenID, amount := txDetail.disbursed(); tokenID != testTokenID || amount != 40 || txDetail.Fee != incclient.DefaultPRVFee || txDetail.Value != txDetail.Fee {
+		t.Fatalf("tx %v gives %v of %v: %+v", txHash, amount, tokenID, txDetail)
+	}
+	// the token coins up to the amount, the PRV coins up to the fee of a replacement
+	if len(txDetail.TokenInputs) != 2 || len(txDetail.Inputs) != 2 || len(acc.UTXOInUse) != 4 {
+		t.Fatalf("tx spends %v token and %v PRV coins, %v in use", len(txDetail.TokenInputs), len(txDetail.Inputs), len(acc.UTXOInUse))
+	}
+	for _, keyImage := range append(txDetail.TokenInputs, txDetail.Inputs...) {
+		if !strings.Contains(txHash, keyImage) {
+			t.Fatalf("tx %v built without input %v", txHash, keyImage)
+		}
+	}
+
+	// 5 of the token left: no second drop, and nothing more in use
+	if _, _, _, err := CreateTokenDropTx(policy, testPaymentAddress, testShardID); err == nil {
+		t.Fatal("dropped more than the account holds")
+	}
+	if len(acc.UTXOInUse) != 4 {
+		t.Fatalf("%v coins in use after a failed drop", len(acc.UTXOInUse))
+	}
+
+	// the coins of a dropped tx are free again once released
+	releaseInputs(acc, txDetail.inputs())
+	if len(acc.UTXOInUse) != 0 || acc.freeBalance(testTokenID) != 85 || acc.freeBalance(common.PRVIDStr) != 2*dropFee {
+		t.Fatalf("after release: %v in use, %v of the token, %v PRV", len(acc.UTXOInUse), acc.freeBalance(testTokenID), acc.freeBalance(common.PRVIDStr))
+	}
+}
+
+// TestPickTokenDropCoins checks that a drop short of the token or of the fee holds no coin of the
+// other, as when it races another drop for the coins of the account it was given.
+func TestPickTokenDropCoins(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		prv, token []uint64
+		err        string
+	}{
+		{"enough of both", []uint64{dropFee}, []uint64{40}, ""},
+		{"token short, PRV available", []uint64{dropFee}, []uint64{39}, "39 of token " + testTokenID},
+		{"PRV short, token available", []uint64{dropFee - 1}, []uint64{40}, fmt.Sprintf("%v of token %v available, %v needed", dropFee-1, common.PRVIDStr, dropFee)},
+	} {
+		setupDrop(t, testCoins(tc.prv...), testCoins(tc.token...))
+		acc := adc.AirdropAccounts[0]
+		acc.lock.Lock()
+		tokenCoins, prvCoins, txFee, err := acc.pickTokenDropCoins(testTokenID, 40, feeEstimator.Quote(acc.PaymentAddress))
+		acc.lock.Unlock()
+		if tc.err == "" {
+			if err != nil || len(tokenCoins) != 1 || len(prvCoins) != 1 || txFee != incclient.DefaultPRVFee || len(acc.UTXOInUse) != 2 {
+				t.Errorf("%v: %v token and %v PRV coins at %v, err %v", tc.name, len(tokenCoins), len(prvCoins), txFee, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) || len(acc.UTXOInUse) != 0 {
+			t.Errorf("%v: err %v, %v coins in use", tc.name, err, len(acc.UTXOInUse))
+		}
+	}
+}
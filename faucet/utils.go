@@ -0,0 +1,52 @@
+package faucet
+
+import (
+	"encoding/json"
+	"main/sybil"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
+)
+
+var restyClient = resty.New()
+
+// VerifyCaptcha checks a captcha response with hcaptcha, returning what it tells about the
+// challenge for the sybil scoring.
+func VerifyCaptcha(clientCaptcha string, secret string) (bool, *sybil.Captcha, error) {
+	data := make(map[string]string)
+	data["response"] = clientCaptcha
+	data["secret"] = secret
+
+	re, err := restyClient.R().
+		EnableTrace().
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").SetFormData(data).
+		Post("https://hcaptcha.com/siteverify")
+	if err != nil {
+		return false, nil, err
+	}
+
+	var responseBodyData struct {
+		Success     bool   `json:"success"`
+		ChallengeTs string `json:"challenge_ts"`
+		Hostname    string `json:"hostname"`
+	}
+
+	err = json.Unmarshal(re.Body(), &responseBodyData)
+	if err != nil {
+		return false, nil, err
+	}
+
+	info := &sybil.Captcha{Hostname: responseBodyData.Hostname}
+	if t, err := time.Parse(time.RFC3339, responseBodyData.ChallengeTs); err == nil {
+		info.ChallengeTime = t.Unix()
+	}
+	return responseBodyData.Success, info, nil
+}
+
+// keyImageString returns the base58 encoded key image of a coin, as used to check whether it is spent.
+func keyImageString(c coin.PlainCoin) string {
+	return base58.Base58Check{}.Encode(c.GetKeyImage().ToBytesS(), common.ZeroByte)
+}
@@ -0,0 +1,216 @@
+package fee
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+)
+
+// Sizes of a v2 tx in bytes, on the generous side: an input carries its ring of 8 coins, an output
+// its coin and share of the range proof.
+const (
+	baseTxSize  = 1024
+	inputSize   = 700
+	outputSize  = 300
+	tokenTxSize = 1024 // the token part of a tx has its own header and proof
+)
+
+// estimatorBlocks is the number of blocks the fullnode bases its fee rate on.
+const estimatorBlocks = 8
+
+// EstimateSize returns the size in kB, rounded up, of a tx with the given inputs and outputs.
+func EstimateSize(numInputs, numOutputs int, isTokenTx bool) uint64 {
+	size := baseTxSize + numInputs*inputSize + numOutputs*outputSize
+	if isTokenTx {
+		size += tokenTxSize
+	}
+	return uint64((size + 1023) / 1024)
+}
+
+// Config bounds the fee of a tx.
+type Config struct {
+	// Floor is the lowest fee of a tx, incclient.DefaultPRVFee by default.
+	Floor uint64
+	// Ceiling is the highest fee of a tx, 100 times the floor by default.
+	Ceiling uint64
+	// Refresh is how long in seconds a fee rate from the fullnode is used, 60 by default.
+	Refresh int
+	// RetryAfter is how long in seconds the fullnode is left alone after it failed to give a fee
+	// rate, the last one it gave being used meanwhile, 10 by default.
+	RetryAfter int
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Floor == 0 {
+		cfg.Floor = incclient.DefaultPRVFee
+	}
+	if cfg.Ceiling == 0 {
+		cfg.Ceiling = 100 * cfg.Floor
+	}
+	if cfg.Ceiling < cfg.Floor {
+		cfg.Ceiling = cfg.Floor
+	}
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = 60
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = 10
+	}
+	return cfg
+}
+
+type rate struct {
+	perKb     uint64
+	fetchedAt time.Time
+	// failedAt is when the last fetch failed, after fetchedAt.
+	failedAt time.Time
+}
+
+// Estimator prices txs with the fee rate the fullnode sees on the shard of their sender. A nil
+// Estimator prices every tx at incclient.DefaultPRVFee.
+type Estimator struct {
+	rpcURL func() string
+	cfg    Config
+	client *http.Client
+	lock   sync.Mutex
+	rates  map[string]rate
+}
+
+// New creates an Estimator asking the fullnode whose URL rpcURL returns, so that it follows the
+// failovers of a nodepool.Pool.
+func New(rpcURL func() string, cfg Config) *Estimator {
+	return &Estimator{
+		rpcURL: rpcURL,
+		cfg:    cfg.withDefaults(),
+		client: &http.Client{Timeout: 10 * time.Second},
+		rates:  make(map[string]rate),
+	}
+}
+
+// Fee returns the fee of a tx sent by sender, a payment address. When the fullnode cannot tell,
+// the last rate it gave is used, or the floor.
+func (e *Estimator) Fee(sender string, numInputs, numOutputs int, isTokenTx bool) uint64 {
+	return e.Quote(sender).Fee(numInputs, numOutputs, isTokenTx)
+}
+
+// Quote is the fee rate of a sender, fetched once to price the txs it builds while holding a lock.
+type Quote struct {
+	e     *Estimator
+	perKb uint64
+}
+
+// Quote returns the fee rate of sender, as Fee gets it.
+func (e *Estimator) Quote(sender string) Quote {
+	if e == nil {
+		return Quote{}
+	}
+	perKb, err := e.feePerKb(sender)
+	if err != nil {
+		log.Printf("fee: estimate for %v: %v\n", sender, err)
+	}
+	return Quote{e: e, perKb: perKb}
+}
+
+// Fee returns the fee of a tx with the given inputs and outputs at the rate of q.
+func (q Quote) Fee(numInputs, numOutputs int, isTokenTx bool) uint64 {
+	if q.e == nil {
+		return incclient.DefaultPRVFee
+	}
+	return q.e.Clamp(q.perKb * EstimateSize(numInputs, numOutputs, isTokenTx))
+}
+
+// Replacement returns the fee of a tx replacing a stuck one that paid fee: twice as much, within
+// the ceiling. It is no higher than fee once fee reaches the ceiling.
+func (e *Estimator) Replacement(fee uint64) uint64 {
+	if fee == 0 {
+		fee = incclient.DefaultPRVFee
+	}
+	return e.Clamp(2 * fee)
+}
+
+// Clamp brings fee within the floor and ceiling.
+func (e *Estimator) Clamp(fee uint64) uint64 {
+	if e == nil {
+		return fee
+	}
+	if fee < e.cfg.Floor {
+		return e.cfg.Floor
+	}
+	if fee > e.cfg.Ceiling {
+		return e.cfg.Ceiling
+	}
+	return fee
+}
+
+func (e *Estimator) feePerKb(sender string) (uint64, error) {
+	e.lock.Lock()
+	cached, ok := e.rates[sender]
+	e.lock.Unlock()
+	if ok && time.Since(cached.fetchedAt) < time.Duration(e.cfg.Refresh)*time.Second {
+		return cached.perKb, nil
+	}
+	// a fullnode that just failed would likely make every tx wait for the timeout again
+	if ok && time.Since(cached.failedAt) < time.Duration(e.cfg.RetryAfter)*time.Second {
+		return cached.perKb, nil
+	}
+	perKb, err := e.fetch(sender)
+	if err != nil {
+		cached.failedAt = time.Now()
+		e.lock.Lock()
+		e.rates[sender] = cached
+		e.lock.Unlock()
+		return cached.perKb, err
+	}
+	e.lock.Lock()
+	e.rates[sender] = rate{perKb: perKb, fetchedAt: time.Now()}
+	e.lock.Unlock()
+	return perKb, nil
+}
+
+// fetch asks the fullnode for its fee rate, -1 letting it fall back on its own default.
+func (e *Estimator) fetch(sender string) (uint64, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0",
+		"id":      1,
+		"method":  "estimatefeewithestimator",
+		"params":  []interface{}{-1, sender, estimatorBlocks, ""},
+	})
+	if err != nil {
+		return 0, err
+	}
+	resp, err := e.client.Post(e.rpcURL(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	var rpcResp struct {
+		Result *struct {
+			EstimateFeeCoinPerKb uint64
+		}
+		Error *struct {
+			Message string
+		}
+	}
+	if err := json.Unmarshal(data, &rpcResp); err != nil {
+		return 0, fmt.Errorf("decode estimatefeewithestimator response: %v", err)
+	}
+	if rpcResp.Error != nil {
+		return 0, errors.New(rpcResp.Error.Message)
+	}
+	if rpcResp.Result == nil {
+		return 0, errors.New("estimatefeewithestimator returned no result")
+	}
+	return rpcResp.Result.EstimateFeeCoinPerKb, nil
+}
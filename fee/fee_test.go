@@ -0,0 +1,57 @@
+package fee
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFee(t *testing.T) {
+	var calls int32
+	failing := int32(1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.Write([]byte(`{"Error":{"Message":"busy"}}`))
+			return
+		}
+		w.Write([]byte(`{"Result":{"EstimateFeeCoinPerKb":100}}`))
+	}))
+	defer srv.Close()
+	e := New(func() string { return srv.URL }, Config{Floor: 10, Ceiling: 1000})
+
+	// the floor while the fullnode fails, which is not asked again right away
+	for i := 0; i < 3; i++ {
+		if fee := e.Fee("sender", 1, 2, false); fee != 10 {
+			t.Fatalf("failing fullnode: fee %v, want the floor", fee)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("failing fullnode asked %v times", calls)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	e.rates["sender"] = rate{}
+	q := e.Quote("sender")
+	if fee := q.Fee(1, 2, false); fee != 100*EstimateSize(1, 2, false) {
+		t.Fatalf("fee %v", fee)
+	}
+	if fee := q.Fee(20, 2, false); fee != 1000 {
+		t.Fatalf("fee %v, want the ceiling", fee)
+	}
+	// the last rate is kept through a failure
+	atomic.StoreInt32(&failing, 1)
+	r := e.rates["sender"]
+	r.fetchedAt = r.fetchedAt.Add(-time.Hour)
+	e.rates["sender"] = r
+	if fee := e.Fee("sender", 1, 2, false); fee != 100*EstimateSize(1, 2, false) {
+		t.Fatalf("failing fullnode after a rate: fee %v", fee)
+	}
+
+	var nilEstimator *Estimator
+	if fee := nilEstimator.Quote("sender").Fee(5, 5, true); fee != nilEstimator.Fee("sender", 1, 1, false) || fee == 0 {
+		t.Fatalf("nil estimator: fee %v", fee)
+	}
+}
@@ -0,0 +1,224 @@
+package health
+
+import (
+	"fmt"
+	"main/storage"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config tunes the readiness checks of a service.
+type Config struct {
+	// Interval is the period in seconds of the checks, 30 by default. /readyz answers with the
+	// last results so that probing it costs nothing.
+	Interval int
+	// MinAccountsPerShard is how many airdrop accounts able to drop each shard needs, 1 by default.
+	MinAccountsPerShard int
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30
+	}
+	if cfg.MinAccountsPerShard <= 0 {
+		cfg.MinAccountsPerShard = 1
+	}
+	return cfg
+}
+
+// Check reports on a dependency of the service. detail is shown whether it passes or not.
+type Check func() (detail string, err error)
+
+// Status is the last result of a check.
+type Status struct {
+	Name   string
+	OK     bool
+	Detail string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+	// CheckedAt is the unix time of the check, 0 until the first one completes.
+	CheckedAt int64
+	Took      string `json:",omitempty"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the checks of a service in the background.
+type Checker struct {
+	cfg      Config
+	started  time.Time
+	lock     sync.RWMutex
+	checks   []namedCheck
+	statuses map[string]Status
+}
+
+func NewChecker(cfg Config) *Checker {
+	return &Checker{
+		cfg:      cfg.withDefaults(),
+		started:  time.Now(),
+		statuses: make(map[string]Status),
+	}
+}
+
+// MinAccountsPerShard is the configured number of accounts each shard needs, for Accounts.
+func (c *Checker) MinAccountsPerShard() int {
+	return c.cfg.MinAccountsPerShard
+}
+
+// Add registers a check. Checks are added before Start.
+func (c *Checker) Add(name string, check Check) {
+	c.lock.Lock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+	c.statuses[name] = Status{Name: name, Error: "not checked yet"}
+	c.lock.Unlock()
+}
+
+// Start runs the checks now and then every Interval.
+func (c *Checker) Start() {
+	t := time.NewTicker(time.Duration(c.cfg.Interval) * time.Second)
+	defer t.Stop()
+	for {
+		c.runAll()
+		<-t.C
+	}
+}
+
+func (c *Checker) runAll() {
+	c.lock.RLock()
+	checks := append([]namedCheck{}, c.checks...)
+	c.lock.RUnlock()
+	var wg sync.WaitGroup
+	for _, nc := range checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := nc.check()
+			st := Status{
+				Name:      nc.name,
+				OK:        err == nil,
+				Detail:    detail,
+				CheckedAt: time.Now().Unix(),
+				Took:      time.Since(start).Round(time.Millisecond).String(),
+			}
+			if err != nil {
+				st.Error = err.Error()
+			}
+			c.lock.Lock()
+			c.statuses[nc.name] = st
+			c.lock.Unlock()
+		}(nc)
+	}
+	wg.Wait()
+}
+
+// Ready tells whether every check passed last time, with their results in the order they were
+// added.
+func (c *Checker) Ready() (bool, []Status) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	ready := true
+	result := []Status{}
+	for _, nc := range c.checks {
+		st := c.statuses[nc.name]
+		ready = ready && st.OK
+		result = append(result, st)
+	}
+	return ready, result
+}
+
+// Fullnode checks that node answers, with the heights it reports.
+func Fullnode(node interface {
+	GetBestBlock() (map[int]uint64, error)
+}) Check {
+	return func() (string, error) {
+		heights, err := node.GetBestBlock()
+		if err != nil {
+			return "", err
+		}
+		shards := []int{}
+		for shard := range heights {
+			shards = append(shards, shard)
+		}
+		sort.Ints(shards)
+		parts := []string{}
+		for _, shard := range shards {
+			name := fmt.Sprintf("shard %v", shard)
+			if shard == -1 {
+				name = "beacon"
+			}
+			parts = append(parts, fmt.Sprintf("%v at %v", name, heights[shard]))
+		}
+		return strings.Join(parts, ", "), nil
+	}
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// HTTP checks that url answers without a server error.
+func HTTP(url string) Check {
+	return func() (string, error) {
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return "", fmt.Errorf("%v answered %v", url, resp.Status)
+		}
+		return resp.Status, nil
+	}
+}
+
+// probeCollection holds the document the DB check writes.
+const probeCollection = "health"
+
+type probe struct {
+	Time int64
+}
+
+// Writable checks that store takes writes, reading back the document it writes.
+func Writable(store storage.Store) Check {
+	probes := store.Collection(probeCollection)
+	return func() (string, error) {
+		now := time.Now().UnixNano()
+		if err := probes.Put("probe", probe{Time: now}); err != nil {
+			return "", err
+		}
+		var got probe
+		if err := probes.Get("probe", &got); err != nil {
+			return "", err
+		}
+		if got.Time != now {
+			// another replica wrote meanwhile: its write went through, so did ours
+			return "written concurrently", nil
+		}
+		return "", nil
+	}
+}
+
+// Accounts checks that each of shards has at least min accounts able to drop, as counted by
+// count.
+func Accounts(min int, shards []int, count func() map[int]int) Check {
+	return func() (string, error) {
+		counts := count()
+		parts := []string{}
+		short := []string{}
+		for _, shard := range shards {
+			parts = append(parts, fmt.Sprintf("shard %v: %v", shard, counts[shard]))
+			if counts[shard] < min {
+				short = append(short, fmt.Sprint(shard))
+			}
+		}
+		detail := strings.Join(parts, ", ")
+		if len(short) > 0 {
+			return detail, fmt.Errorf("fewer than %v accounts able to drop on shard %v", min, strings.Join(short, ", "))
+		}
+		return detail, nil
+	}
+}
@@ -0,0 +1,146 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"main/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// failingStore is a store whose writes all fail.
+type failingStore struct {
+	storage.Store
+}
+
+func (s failingStore) Collection(name string) storage.Collection {
+	return failingCollection{s.Store.Collection(name)}
+}
+
+type failingCollection struct {
+	storage.Collection
+}
+
+func (c failingCollection) Put(id string, v interface{}) error {
+	return errors.New("disk full")
+}
+
+func TestWritable(t *testing.T) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := storage.NewLevelDB(db)
+	if _, err := Writable(store)(); err != nil {
+		t.Fatal(err)
+	}
+	// a struct, which every backend stores
+	var got probe
+	if err := store.Collection(probeCollection).Get("probe", &got); err != nil || got.Time == 0 {
+		t.Fatalf("probe %+v, err %v", got, err)
+	}
+	if _, err := Writable(failingStore{store})(); err == nil {
+		t.Fatal("a store refusing writes reported writable")
+	}
+}
+
+type fakeNode struct {
+	heights map[int]uint64
+	err     error
+}
+
+func (n fakeNode) GetBestBlock() (map[int]uint64, error) {
+	return n.heights, n.err
+}
+
+func TestFullnode(t *testing.T) {
+	detail, err := Fullnode(fakeNode{heights: map[int]uint64{1: 20, -1: 10, 0: 30}})()
+	if err != nil || detail != "beacon at 10, shard 0 at 30, shard 1 at 20" {
+		t.Fatalf("detail %q, err %v", detail, err)
+	}
+	if _, err := Fullnode(fakeNode{err: errors.New("connection refused")})(); err == nil {
+		t.Fatal("unreachable node reported up")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	for _, tc := range []struct {
+		status int
+		ok     bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNotFound, true},
+		{http.StatusBadGateway, false},
+	} {
+		status = tc.status
+		if _, err := HTTP(srv.URL)(); (err == nil) != tc.ok {
+			t.Errorf("status %v: err %v", tc.status, err)
+		}
+	}
+	srv.Close()
+	if _, err := HTTP(srv.URL)(); err == nil {
+		t.Fatal("closed server reported up")
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	counts := map[int]int{0: 2, 1: 1}
+	check := Accounts(2, []int{0, 1, 2}, func() map[int]int { return counts })
+	detail, err := check()
+	if err == nil || err.Error() != "fewer than 2 accounts able to drop on shard 1, 2" {
+		t.Fatalf("err %v", err)
+	}
+	if detail != "shard 0: 2, shard 1: 1, shard 2: 0" {
+		t.Fatalf("detail %q", detail)
+	}
+	counts = map[int]int{0: 2, 1: 3, 2: 2}
+	if _, err := check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := NewChecker(Config{})
+	failing := errors.New("down")
+	c.Add("ok", func() (string, error) { return "fine", nil })
+	c.Add("flaky", func() (string, error) { return "", failing })
+	r := gin.New()
+	RegisterRoutes(r, c)
+	readyz := func() (int, []Status) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body struct {
+			Checks []Status
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, body.Checks
+	}
+
+	// not ready until the checks ran
+	if code, _ := readyz(); code != http.StatusServiceUnavailable {
+		t.Fatalf("before the checks: %v", code)
+	}
+	c.runAll()
+	code, checks := readyz()
+	if code != http.StatusServiceUnavailable || len(checks) != 2 || !checks[0].OK || checks[1].Error != "down" {
+		t.Fatalf("%v %+v", code, checks)
+	}
+	failing = nil
+	c.runAll()
+	if code, _ := readyz(); code != http.StatusOK {
+		t.Fatalf("all checks passing: %v", code)
+	}
+}
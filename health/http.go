@@ -0,0 +1,32 @@
+package health
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes adds the probes of c to r:
+//
+//	GET /healthz   200 as long as the process serves requests
+//	GET /readyz    200 when every check passed last time, 503 otherwise, with the results
+func RegisterRoutes(r gin.IRouter, c *Checker) {
+	r.GET("/healthz", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"Status": "ok",
+			"Uptime": time.Since(c.started).Round(time.Second).String(),
+		})
+	})
+	r.GET("/readyz", func(ctx *gin.Context) {
+		ready, statuses := c.Ready()
+		code := http.StatusOK
+		if !ready {
+			code = http.StatusServiceUnavailable
+		}
+		ctx.JSON(code, gin.H{
+			"Ready":  ready,
+			"Checks": statuses,
+		})
+	})
+}
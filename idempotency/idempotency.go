@@ -0,0 +1,230 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"main/storage"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Header carries the key a client picks for a request and sends again with its retries.
+	Header = "Idempotency-Key"
+	// CollectionName holds the responses, by a hash of the key, its API key and the route.
+	CollectionName = "idempotency"
+	// ReplayedHeader is set on the responses replayed from a previous request.
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// abandonAfter is how long a request may run before another one with its key takes over, should
+// the process have died meanwhile.
+const abandonAfter = time.Minute
+
+// Config sets how long the responses are kept.
+type Config struct {
+	// TTL is how long in hours a response is replayed, 24 by default.
+	TTL int
+}
+
+// Record is the response to the first request with a key. Status is 0 while it runs.
+type Record struct {
+	RequestHash string
+	Status      int
+	ContentType string
+	Body        string
+	CreatedAt   int64
+}
+
+// Store keeps the responses to replay. Keys are claimed atomically, across replicas on the Mongo
+// backend: a record is only ever replaced if it did not change since it was read.
+type Store struct {
+	ttl     time.Duration
+	records storage.Collection
+	lock    sync.Mutex
+}
+
+func NewStore(store storage.Store, cfg Config) *Store {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24
+	}
+	return &Store{
+		ttl:     time.Duration(cfg.TTL) * time.Hour,
+		records: store.Collection(CollectionName),
+	}
+}
+
+func recordID(apiKey, route, key string) string {
+	sum := sha256.Sum256([]byte(apiKey + "\n" + route + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RawQuery+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// maxClaimRetries bounds the retries of a claim racing with other requests for the same key.
+const maxClaimRetries = 3
+
+// claim records that the request of id runs, unless another one did. It returns the record of the
+// other one then, or else the record claimed, which the request replaces when done.
+func (s *Store) claim(id, hash string) (*Record, *Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for retry := 0; ; retry++ {
+		now := time.Now()
+		rec := Record{RequestHash: hash, CreatedAt: now.Unix()}
+		err := s.records.Insert(id, rec)
+		if err != storage.ErrExists {
+			return nil, &rec, err
+		}
+		var prev Record
+		err = s.records.Get(id, &prev)
+		if err == storage.ErrNotFound && retry < maxClaimRetries {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		created := time.Unix(prev.CreatedAt, 0)
+		expired := now.Sub(created) > s.ttl
+		abandoned := prev.Status == 0 && now.Sub(created) > abandonAfter
+		if !expired && !abandoned {
+			return &prev, nil, nil
+		}
+		// take over only if no other request did since prev was read
+		err = s.records.Swap(id, prev, rec)
+		if (err == storage.ErrConflict || err == storage.ErrNotFound) && retry < maxClaimRetries {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &rec, nil
+	}
+}
+
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(str string) (int, error) {
+	r.body.WriteString(str)
+	return r.ResponseWriter.WriteString(str)
+}
+
+// Middleware replays the response to the first request with the Idempotency-Key of a request,
+// instead of handling it again. A request sent while the first one runs gets a 409, and one reusing
+// the key with other parameters a 422. Server errors are not kept, so that the retries go through.
+// Requests without a key are handled as usual.
+func (s *Store) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" {
+			c.Next()
+			return
+		}
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		id := recordID(c.GetHeader("X-API-Key"), c.FullPath(), key)
+		hash := requestHash(c.Request, body)
+		prev, claimed, err := s.claim(id, hash)
+		if err != nil {
+			log.Println("idempotency:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"Error": "cannot check the request, try again later"})
+			return
+		}
+		switch {
+		case prev == nil:
+		case prev.RequestHash != hash:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"Error": fmt.Sprintf("%v already used for another request", Header)})
+			return
+		case prev.Status == 0:
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"Error": "a request with this key is in progress"})
+			return
+		default:
+			c.Header(ReplayedHeader, "true")
+			c.Data(prev.Status, prev.ContentType, []byte(prev.Body))
+			c.Abort()
+			return
+		}
+
+		rec := &recorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Next()
+		status := rec.Status()
+		result := Record{
+			RequestHash: hash,
+			Status:      status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.String(),
+			CreatedAt:   time.Now().Unix(),
+		}
+		if status >= 500 {
+			// expired at once, for the next request with the key to take over
+			result = Record{RequestHash: hash}
+		}
+		// a request that ran past abandonAfter may have been taken over, whose record stays
+		err = s.records.Swap(id, claimed, result)
+		if err == storage.ErrConflict {
+			err = fmt.Errorf("request with key %v taken over while it ran", key)
+		}
+		if err != nil {
+			log.Println("idempotency:", err)
+		}
+	}
+}
+
+// Start deletes the expired responses every hour.
+func (s *Store) Start() {
+	for {
+		if err := s.sweep(); err != nil {
+			log.Println("idempotency:", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func (s *Store) sweep() error {
+	expired := []string{}
+	err := s.records.Scan("", "", func(id string, data []byte) error {
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("decode record %v: %v", id, err)
+		}
+		if time.Since(time.Unix(rec.CreatedAt, 0)) > s.ttl {
+			expired = append(expired, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, id := range expired {
+		if err := s.records.Delete(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
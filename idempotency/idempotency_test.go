@@ -0,0 +1,113 @@
+package idempotency
+
+import (
+	"main/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newTestStore(t *testing.T) storage.Store {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return storage.NewLevelDB(db)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := NewStore(newTestStore(t), Config{})
+	handled := 0
+	status := http.StatusOK
+	r := gin.New()
+	r.POST("/drop", s.Middleware(), func(c *gin.Context) {
+		handled++
+		c.JSON(status, gin.H{"Handled": handled})
+	})
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/drop", strings.NewReader(body))
+		req.Header.Set(Header, key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("a", "x")
+	again := send("a", "x")
+	if handled != 1 || again.Body.String() != first.Body.String() || again.Header().Get(ReplayedHeader) != "true" {
+		t.Fatalf("handled %v times, replayed %q", handled, again.Body.String())
+	}
+	if w := send("a", "y"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused with another body: %v", w.Code)
+	}
+
+	// a server error is not kept, the retry goes through
+	status = http.StatusInternalServerError
+	send("b", "x")
+	status = http.StatusOK
+	if w := send("b", "x"); handled != 3 || w.Code != http.StatusOK {
+		t.Fatalf("retry after a server error: handled %v, status %v", handled, w.Code)
+	}
+}
+
+func TestTakeOver(t *testing.T) {
+	store := newTestStore(t)
+	// the requests are spread over replicas, which only share the db
+	replicas := []*Store{NewStore(store, Config{}), NewStore(store, Config{})}
+	abandoned := Record{RequestHash: "h", CreatedAt: time.Now().Add(-2 * abandonAfter).Unix()}
+	if err := replicas[0].records.Put("id", abandoned); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	claims := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(s *Store) {
+			defer wg.Done()
+			prev, claimed, err := s.claim("id", "h")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if claimed != nil {
+				lock.Lock()
+				claims++
+				lock.Unlock()
+			} else if prev.Status != 0 || prev.CreatedAt == abandoned.CreatedAt {
+				t.Errorf("claim returned %+v", prev)
+			}
+		}(replicas[i%2])
+	}
+	wg.Wait()
+	if claims != 1 {
+		t.Fatalf("abandoned key taken over %v times", claims)
+	}
+
+	// the request taken over does not overwrite the response of the one that took over
+	var current Record
+	if err := replicas[0].records.Get("id", &current); err != nil {
+		t.Fatal(err)
+	}
+	done := Record{RequestHash: "h", Status: http.StatusOK, Body: "second", CreatedAt: current.CreatedAt}
+	if err := replicas[1].records.Swap("id", current, done); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicas[0].records.Swap("id", abandoned, Record{RequestHash: "h", Status: http.StatusOK, Body: "first"}); err != storage.ErrConflict {
+		t.Fatalf("late response stored: %v", err)
+	}
+	prev, _, err := replicas[0].claim("id", "h")
+	if err != nil || prev == nil || prev.Body != "second" {
+		t.Fatalf("replayed %+v, %v", prev, err)
+	}
+}
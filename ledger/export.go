@@ -0,0 +1,75 @@
+package ledger
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// ParseRange parses an export date range given as YYYY-MM-DD, both days included. Empty bounds are
+// left open.
+func ParseRange(fromStr, toStr string) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if fromStr != "" {
+		from, err = time.Parse(dateLayout, fromStr)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from date %v", fromStr)
+		}
+	}
+	if toStr != "" {
+		to, err = time.Parse(dateLayout, toStr)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to date %v", toStr)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+// Export writes entries as "csv" or "json".
+func Export(w io.Writer, format string, entries []Entry) error {
+	switch format {
+	case "csv":
+		return writeCSV(w, entries)
+	case "json", "":
+		return json.NewEncoder(w).Encode(entries)
+	}
+	return fmt.Errorf("unknown export format %v", format)
+}
+
+func writeCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"seq", "time", "event", "kind", "campaign", "txhash", "account", "receiver", "tokenid", "amount", "fee", "replacedby", "prevhash", "hash"})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err := cw.Write([]string{
+			strconv.FormatUint(e.Seq, 10),
+			time.Unix(e.Time, 0).UTC().Format(time.RFC3339),
+			e.Event,
+			e.Kind,
+			e.Campaign,
+			e.TxHash,
+			e.Account,
+			e.Receiver,
+			e.TokenID,
+			strconv.FormatUint(e.Amount, 10),
+			strconv.FormatUint(e.Fee, 10),
+			e.ReplacedBy,
+			e.PrevHash,
+			e.Hash,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
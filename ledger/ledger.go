@@ -0,0 +1,344 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"main/storage"
+	"main/txtracker"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of disbursement.
+const (
+	KindDrop      = "drop"
+	KindSplit     = "split"
+	KindMint      = "mint"
+	KindRebalance = "rebalance"
+)
+
+// Events recorded for a tx.
+const (
+	EventBroadcast = "broadcast"
+	EventConfirmed = "confirmed"
+	EventFailed    = "failed"
+	EventReplaced  = "replaced"
+)
+
+const (
+	// CollectionName is the collection of the entries, keyed by their zero-padded sequence number
+	// so that they iterate in order.
+	CollectionName = "ledger"
+	// TxCollectionName indexes the broadcast entries of each tx, one per receiver, under
+	// "<txHash>-<seq>".
+	TxCollectionName = "ledgertx"
+	// TimeCollectionName indexes the entries by time under "<time>-<seq>", zero-padded, for the
+	// queries by date range.
+	TimeCollectionName = "ledgertime"
+	// SettledCollectionName marks the final events recorded for a tx under "<txHash>-<event>".
+	SettledCollectionName = "ledgersettled"
+)
+
+// maxAppendRetries bounds the retries of an append racing with another replica for a sequence
+// number.
+const maxAppendRetries = 5
+
+// Entry is a write-once ledger record. Each entry carries the hash of the previous one, so that
+// any later change to the ledger breaks the chain.
+type Entry struct {
+	Seq      uint64
+	Time     int64
+	Event    string
+	Kind     string
+	Campaign string
+	TxHash   string
+	// Account is the payment address of the account sending the tx.
+	Account  string
+	Receiver string
+	TokenID  string
+	// Amount is the total sent to Receiver, fee excluded.
+	Amount uint64
+	// Fee is paid once per tx: when a tx has several entries, only the first one carries it.
+	Fee uint64
+	// ReplacedBy is the tx that took over for EventReplaced.
+	ReplacedBy string
+	PrevHash   string
+	Hash       string
+}
+
+func (e Entry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Ledger appends entries to the store shared with the rest of the service.
+type Ledger struct {
+	lock      sync.Mutex
+	entries   storage.Collection
+	txIndex   storage.Collection
+	timeIndex storage.Collection
+	settled   storage.Collection
+	lastSeq   uint64
+	lastHash  string
+}
+
+func New(store storage.Store) (*Ledger, error) {
+	l := &Ledger{
+		entries:   store.Collection(CollectionName),
+		txIndex:   store.Collection(TxCollectionName),
+		timeIndex: store.Collection(TimeCollectionName),
+		settled:   store.Collection(SettledCollectionName),
+	}
+	if err := l.loadLast(); err != nil {
+		return nil, err
+	}
+	if err := l.indexTimes(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func timeID(t int64, seq uint64) string {
+	return fmt.Sprintf("%020d-%v", t, entryID(seq))
+}
+
+// indexTimes indexes by time the entries appended after the last one indexed, those of a ledger
+// older than the index or of an append cut short.
+func (l *Ledger) indexTimes() error {
+	var lastIndexed uint64
+	_, err := l.timeIndex.Last(&lastIndexed)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	if lastIndexed >= l.lastSeq {
+		return nil
+	}
+	return l.entries.Scan(entryID(lastIndexed+1), "", func(id string, data []byte) error {
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("ledger: decode entry %v: %v", id, err)
+		}
+		return l.timeIndex.Put(timeID(e.Time, e.Seq), e.Seq)
+	})
+}
+
+func (l *Ledger) loadLast() error {
+	var last Entry
+	_, err := l.entries.Last(&last)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ledger: decode last entry: %v", err)
+	}
+	l.lastSeq = last.Seq
+	l.lastHash = last.Hash
+	return nil
+}
+
+func entryID(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+// Append chains e after the last entry and stores it.
+func (l *Ledger) Append(e Entry) (Entry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	for retry := 0; ; retry++ {
+		e.Seq = l.lastSeq + 1
+		e.PrevHash = l.lastHash
+		e.Hash = e.computeHash()
+		err := l.entries.Insert(entryID(e.Seq), e)
+		if err == nil {
+			break
+		}
+		if err != storage.ErrExists || retry == maxAppendRetries {
+			return e, err
+		}
+		// another replica took the sequence number: chain after its entry instead
+		if err := l.loadLast(); err != nil {
+			return e, err
+		}
+	}
+	l.lastSeq = e.Seq
+	l.lastHash = e.Hash
+	if err := l.timeIndex.Put(timeID(e.Time, e.Seq), e.Seq); err != nil {
+		return e, err
+	}
+	if e.Event == EventBroadcast {
+		if err := l.txIndex.Put(e.TxHash+"-"+entryID(e.Seq), e.Seq); err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// RecordBroadcast records a tx handed to the network, with one entry per receiver.
+func (l *Ledger) RecordBroadcast(entries ...Entry) error {
+	for _, e := range entries {
+		e.Event = EventBroadcast
+		if _, err := l.Append(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordSettled records the final state of a tx, copying what it disbursed from its broadcast
+// entries. Txs broadcast before the ledger existed are skipped, and so is an event already
+// recorded for the tx, as by a run resumed after a crash.
+func (l *Ledger) RecordSettled(txHash, event, replacedBy string) error {
+	var recorded string
+	err := l.settled.Get(txHash+"-"+event, &recorded)
+	if err == nil {
+		return nil
+	}
+	if err != storage.ErrNotFound {
+		return err
+	}
+	broadcast, err := l.BroadcastEntries(txHash)
+	if err != nil {
+		return err
+	}
+	for _, e := range broadcast {
+		e.Time = 0
+		e.Event = event
+		e.ReplacedBy = replacedBy
+		if _, err := l.Append(e); err != nil {
+			return err
+		}
+	}
+	if len(broadcast) == 0 {
+		return nil
+	}
+	return l.settled.Put(txHash+"-"+event, event)
+}
+
+// BroadcastEntries returns the entries recorded when txHash was broadcast, one per receiver.
+func (l *Ledger) BroadcastEntries(txHash string) ([]Entry, error) {
+	seqList := []uint64{}
+	// '.' follows '-': the range covers exactly the ids of txHash
+	err := l.txIndex.Scan(txHash+"-", txHash+".", func(id string, data []byte) error {
+		var seq uint64
+		if err := json.Unmarshal(data, &seq); err != nil {
+			return err
+		}
+		seqList = append(seqList, seq)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := []Entry{}
+	for _, seq := range seqList {
+		var e Entry
+		if err := l.entries.Get(entryID(seq), &e); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// Subscriber records the txs settled by a txtracker.Tracker.
+func (l *Ledger) Subscriber(ev txtracker.Event) {
+	var err error
+	switch ev.Status {
+	case txtracker.StatusConfirmed:
+		err = l.RecordSettled(ev.Tx.TxHash, EventConfirmed, "")
+	case txtracker.StatusReplaced:
+		err = l.RecordSettled(ev.Tx.TxHash, EventReplaced, ev.ReplacedBy)
+		if err == nil && ev.Replacement != nil {
+			err = l.recordReplacement(ev.Tx.TxHash, ev.Replacement)
+		}
+	default:
+		err = l.RecordSettled(ev.Tx.TxHash, EventFailed, "")
+	}
+	if err != nil {
+		log.Println("ledger:", err)
+	}
+}
+
+// recordReplacement records the broadcast of a tx that disburses the same as the one it replaces.
+func (l *Ledger) recordReplacement(txHash string, replacement *txtracker.PendingTx) error {
+	old, err := l.BroadcastEntries(txHash)
+	if err != nil {
+		return err
+	}
+	for i := range old {
+		old[i].Time = 0
+		old[i].TxHash = replacement.TxHash
+		if i == 0 {
+			old[i].Fee = replacement.Fee
+		}
+	}
+	return l.RecordBroadcast(old...)
+}
+
+// Verify walks the whole ledger and checks the hash chain.
+func (l *Ledger) Verify() error {
+	prevHash := ""
+	seq := uint64(0)
+	return l.entries.Scan("", "", func(id string, data []byte) error {
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("ledger: decode entry %v: %v", id, err)
+		}
+		seq++
+		if e.Seq != seq {
+			return fmt.Errorf("ledger: entry %v found where %v was expected", e.Seq, seq)
+		}
+		if e.PrevHash != prevHash || e.Hash != e.computeHash() {
+			return fmt.Errorf("ledger: chain broken at entry %v", e.Seq)
+		}
+		prevHash = e.Hash
+		return nil
+	})
+}
+
+// Query returns the entries recorded within [from, to) for campaign. A zero bound or an empty
+// campaign matches everything.
+func (l *Ledger) Query(from, to time.Time, campaign string) ([]Entry, error) {
+	var start, end string
+	if !from.IsZero() {
+		start = timeID(from.Unix(), 0)
+	}
+	if !to.IsZero() {
+		end = timeID(to.Unix(), 0)
+	}
+	seqList := []uint64{}
+	err := l.timeIndex.Scan(start, end, func(id string, data []byte) error {
+		var seq uint64
+		if err := json.Unmarshal(data, &seq); err != nil {
+			return err
+		}
+		seqList = append(seqList, seq)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the entries of the replicas may be a little out of time order
+	sort.Slice(seqList, func(i, j int) bool { return seqList[i] < seqList[j] })
+	result := []Entry{}
+	for _, seq := range seqList {
+		var e Entry
+		if err := l.entries.Get(entryID(seq), &e); err != nil {
+			return nil, err
+		}
+		if campaign != "" && e.Campaign != campaign {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"main/storage"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testStores runs test against a leveldb, and against the Mongo database of
+// STORAGE_TEST_MONGO_URI when set.
+func testStores(t *testing.T, test func(t *testing.T, store storage.Store)) {
+	t.Run("leveldb", func(t *testing.T) {
+		db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		test(t, storage.NewLevelDB(db))
+	})
+	t.Run("mongo", func(t *testing.T) {
+		uri := os.Getenv("STORAGE_TEST_MONGO_URI")
+		if uri == "" {
+			t.Skip("STORAGE_TEST_MONGO_URI not set")
+		}
+		dbName := fmt.Sprintf("ledgertest%v", time.Now().UnixNano())
+		store, err := storage.OpenMongo(uri, dbName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		defer dropMongo(t, uri, dbName)
+		test(t, store)
+	})
+}
+
+func dropMongo(t *testing.T, uri, dbName string) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Log(err)
+		return
+	}
+	defer client.Disconnect(ctx)
+	client.Database(dbName).Drop(ctx)
+}
+
+func TestQuery(t *testing.T) {
+	testStores(t, testQuery)
+}
+
+func testQuery(t *testing.T, store storage.Store) {
+	l, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2021, 11, 20, 0, 0, 0, 0, time.UTC)
+	for i, campaign := range []string{"a", "b", "a", "a"} {
+		e := Entry{Time: day.AddDate(0, 0, i).Unix(), Kind: KindDrop, Campaign: campaign, TxHash: "tx"}
+		if err := l.RecordBroadcast(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a ledger from before the time index gets indexed when opened
+	if err := store.Collection(TimeCollectionName).Delete(timeID(day.AddDate(0, 0, 3).Unix(), 4)); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = New(store); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from, to time.Time
+		campaign string
+		want     []uint64
+	}{
+		{time.Time{}, time.Time{}, "", []uint64{1, 2, 3, 4}},
+		{day.AddDate(0, 0, 1), time.Time{}, "", []uint64{2, 3, 4}},
+		{time.Time{}, day.AddDate(0, 0, 2), "", []uint64{1, 2}},
+		{day, day.AddDate(0, 0, 3), "a", []uint64{1, 3}},
+		{day.AddDate(0, 0, 5), time.Time{}, "", nil},
+	}
+	for _, tc := range tests {
+		entries, err := l.Query(tc.from, tc.to, tc.campaign)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []uint64{}
+		for _, e := range entries {
+			got = append(got, e.Seq)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("[%v, %v) %q: got %v, want %v", tc.from, tc.to, tc.campaign, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("[%v, %v) %q: got %v, want %v", tc.from, tc.to, tc.campaign, got, tc.want)
+			}
+		}
+	}
+	if err := l.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReplicas checks the indexes of the entries as another replica sharing the store reads them.
+func TestReplicas(t *testing.T) {
+	testStores(t, testReplicas)
+}
+
+func testReplicas(t *testing.T, store storage.Store) {
+	first, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Entry{Kind: KindDrop, TxHash: "tx", Receiver: "a", Amount: 1, Fee: 100}
+	if err := first.RecordBroadcast(e, Entry{Kind: KindDrop, TxHash: "tx", Receiver: "b", Amount: 2}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.RecordSettled("tx", EventConfirmed, ""); err != nil {
+		t.Fatal(err)
+	}
+	broadcast, err := first.BroadcastEntries("tx")
+	if err != nil || len(broadcast) != 2 || broadcast[0].Receiver != "a" {
+		t.Fatalf("broadcast entries %+v, err %v", broadcast, err)
+	}
+	entries, err := first.Query(time.Time{}, time.Time{}, "")
+	if err != nil || len(entries) != 4 || entries[3].Event != EventConfirmed || entries[3].Receiver != "b" {
+		t.Fatalf("entries %+v, err %v", entries, err)
+	}
+	// an event is recorded once, whichever replica records it again
+	if err := first.RecordSettled("tx", EventConfirmed, ""); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := first.Query(time.Time{}, time.Time{}, ""); len(entries) != 4 {
+		t.Fatalf("confirmed twice: %+v", entries)
+	}
+	if err := first.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
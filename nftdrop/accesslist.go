@@ -0,0 +1,30 @@
+package nftdrop
+
+import (
+	"main/accesslist"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var accessLists *accesslist.Lists
+
+// checkAccessList answers the request itself when pubkey is denied or cannot be checked, returning
+// ok false. allowed tells whether pubkey is pre-approved.
+func checkAccessList(c *gin.Context, pubkey string) (allowed bool, ok bool) {
+	e, err := accessLists.Check(pubkey)
+	if err != nil {
+		logger.Println("accesslist:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": "cannot check the request, try again later"})
+		return false, false
+	}
+	if e.List == accesslist.Deny {
+		logger.Printf("drop to %v denied: %v\n", pubkey, e.Reason)
+		c.JSON(http.StatusForbidden, gin.H{
+			"Result": -1,
+			"Error":  "this address is not eligible",
+		})
+		return false, false
+	}
+	return e.List == accesslist.Allow, true
+}
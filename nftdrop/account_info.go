@@ -1,4 +1,4 @@
-package main
+package nftdrop
 
 import (
 	"fmt"
@@ -492,6 +492,31 @@ func (account *AccountInfo) MarkUsed(tokenID string, coinList []Coin) {
 
 }
 
+// findUTXOs returns the UTXOs of the account with the given key images, by tokenID, or nil if
+// it does not hold them all.
+func (account *AccountInfo) findUTXOs(keyImages map[string][]string) map[string][]Coin {
+	account.mtx.RLock()
+	defer account.mtx.RUnlock()
+	res := make(map[string][]Coin)
+	for tokenID, snList := range keyImages {
+		tokenInfo, ok := account.TokenList[tokenID]
+		if !ok {
+			return nil
+		}
+		for _, snStr := range snList {
+			utxo, ok := tokenInfo.UTXOList[snStr]
+			if !ok {
+				return nil
+			}
+			res[tokenID] = append(res[tokenID], utxo)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
 //// SyncAllUTXOs calls the remote node and update the list of UTXOs.
 //func (account *AccountInfo) SyncAllUTXOs() (map[string][]coin.PlainCoin, map[string][]*big.Int, error) {
 //	outCoinKey, err := incclient.NewOutCoinKeyFromPrivateKey(account.PrivateOTAKey)
@@ -1,4 +1,4 @@
-package main
+package nftdrop
 
 import (
 	"fmt"
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/incognitochain/go-incognito-sdk-v2/common"
-	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
 	"github.com/incognitochain/incognito-chain/wallet"
 )
 
@@ -156,7 +155,7 @@ func (am *AccountManager) managePRVUTXOs() {
 				go func(acc *AccountInfo) {
 					acc.updateSplittingStatus(true)
 					logger.Printf("Splitting PRV for account %v, numFeeUTXOs %v\n", acc.toString(), len(utxoList))
-					err = splitPRV(acc, 2*incclient.DefaultPRVFee, numSplitPRVs)
+					err = splitPRV(acc, feeEstimator.Replacement(feeEstimator.Fee(acc.PaymentAddress, 1, 2, true)), numSplitPRVs)
 					if err != nil {
 						logger.Printf("splitPRV for account %v error: %v\n", acc.toString(), err)
 					} else {
@@ -0,0 +1,42 @@
+package nftdrop
+
+import (
+	"main/backup"
+	"main/service"
+	"time"
+)
+
+const (
+	defaultBackupInterval = 24 // hours
+	defaultBackupKeep     = 7
+	backupPrefix          = "airdropdb"
+)
+
+// startBackupJob takes the scheduled snapshots of the db while the service runs.
+func startBackupJob() {
+	interval := config.BackupInterval
+	if interval <= 0 {
+		interval = defaultBackupInterval
+	}
+	keep := config.BackupKeep
+	if keep <= 0 {
+		keep = defaultBackupKeep
+	}
+	backup.Schedule(localdb, config.BackupDir, backupPrefix, time.Duration(interval)*time.Hour, keep)
+}
+
+// dbCommands is what the db subcommands work on, the store being nil until initDB.
+func dbCommands() service.DB {
+	return service.DB{
+		Store:          localdb,
+		Backend:        config.DBBackend,
+		Path:           dbPath,
+		BackupDir:      config.BackupDir,
+		BackupPrefix:   backupPrefix,
+		UserCollection: config.UserCollection,
+		DecodeUser: func(data []byte) error {
+			_, err := userSchema.Decode(data, new(UserAccount))
+			return err
+		},
+	}
+}
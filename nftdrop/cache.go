@@ -1,4 +1,4 @@
-package main
+package nftdrop
 
 import (
 	"encoding/json"
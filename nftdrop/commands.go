@@ -0,0 +1,78 @@
+package nftdrop
+
+import (
+	"fmt"
+	"main/accesslist"
+	"main/service"
+	"os"
+	"text/tabwriter"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+)
+
+// RunCommand runs a maintenance command of the NFT drop with its config at cfgPath. restore,
+// migrate-legacy, migrate, backup, verify and accesslist only open the db; accounts and
+// verify-ledger set the airdrop accounts up too.
+func RunCommand(cfgPath, name string, args []string) error {
+	switch name {
+	case "restore", "migrate-legacy":
+		// both rewrite the db, they must run before opening it
+		if err := readConfig(cfgPath); err != nil {
+			return err
+		}
+		if name == "migrate-legacy" {
+			return dbCommands().MigrateLegacy(args)
+		}
+		return dbCommands().Restore(args)
+	case "migrate", "backup", "verify", "accesslist":
+		if err := load(cfgPath); err != nil {
+			return err
+		}
+		switch name {
+		case "migrate":
+			return userSchema.RunMigrateCommand(userdb, args)
+		case "backup":
+			return dbCommands().Backup(args)
+		case "verify":
+			return dbCommands().Verify(args)
+		case "accesslist":
+			return accesslist.RunCommand(accessLists, args)
+		}
+	case "accounts", "verify-ledger":
+		if err := load(cfgPath); err != nil {
+			return err
+		}
+		if err := start(); err != nil {
+			return err
+		}
+		switch name {
+		case "accounts":
+			return runAccountsCommand(args)
+		case "verify-ledger":
+			return service.VerifyLedger(txLedger)
+		}
+	}
+	return fmt.Errorf("nftdrop: %w %v", service.ErrUnknownCommand, name)
+}
+
+// runAccountsCommand is the `accounts` subcommand: the airdrop accounts with their PRV and NFTs,
+// synced once.
+func runAccountsCommand(args []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tADDRESS\tPRV UTXOS\tPRV\tNFTS")
+	for privateKey, acc := range adc.AirdropAccounts.Accounts {
+		wl, err := wallet.Base58CheckDeserialize(privateKey)
+		if err != nil {
+			return err
+		}
+		if err := incClient.SubmitKey(wl.Base58CheckSerialize(wallet.OTAKeyType)); err != nil {
+			logger.Printf("SubmitKey %v encoutered an error: %v\n", acc.toString(), err)
+		}
+		acc.Update()
+		utxoList, _ := acc.GetListUnspentOutput(common.PRVIDStr)
+		nftList, _ := acc.GetMyNFTs()
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", acc.ShardID, acc.PaymentAddress, len(utxoList), acc.GetBalance(common.PRVIDStr), len(nftList))
+	}
+	return w.Flush()
+}
@@ -1,14 +1,17 @@
-package main
+package nftdrop
 
 import (
-	"context"
 	"fmt"
-	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+	"main/fee"
+	"main/idempotency"
+	"main/nodepool"
 	"sync"
-	"time"
 )
 
-var incClient *incclient.IncClient
+var incClient nodepool.Client
+var fullnodes *nodepool.Pool
+var feeEstimator *fee.Estimator
+var idempotent *idempotency.Store
 
 type UserAccount struct {
 	PaymentAddress     string
@@ -17,8 +20,14 @@ type UserAccount struct {
 	TotalTokens        map[string]uint64
 	OngoingTxs         []string
 	Txs                map[string]*AirdropTxDetail
-	LastAirdropRequest time.Time
+	LastAirdropRequest int64
 	AirdropSuccess     bool
+	// CallbackURL is notified once the airdrop confirms or fails, signed for APIKey.
+	CallbackURL string
+	APIKey      string
+	// IP requested the drop, granted by the cooldown policy at the unix time GrantedAt.
+	IP        string
+	GrantedAt int64
 }
 
 func (ua UserAccount) toString() string {
@@ -28,7 +37,12 @@ func (ua UserAccount) toString() string {
 type AirdropTxDetail struct {
 	TxHash  string
 	NFTused string
-	Status  int
+	// Status of the tx:
+	//	1: sent
+	//	2: confirmed
+	//	3: failed
+	//	4: replaced by another tx spending the same inputs
+	Status int
 }
 
 type AirdropController struct {
@@ -50,77 +64,3 @@ var (
 	thresholdTriggerSplit = 20
 	minPRVRequired        = uint64(100)
 )
-
-func waitingCheckTxInBlock(acc *AccountInfo, txHash, tokenIDStr string, utxoList []Coin) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
-	success := false
-	for {
-		select {
-		case <-ctx.Done():
-			// We assume timed-out = failed
-			logger.Printf("Checking status of tx %v timed-out\n", txHash)
-			acc.ClearTempUsed(tokenIDStr, utxoList)
-			return
-		default:
-			isInBlock, err := incClient.CheckTxInBlock(txHash)
-			if err != nil || !isInBlock {
-				time.Sleep(10 * time.Second)
-			} else {
-				//logger.Printf("Tx %v is in block\n", txHash)
-				success = true
-				acc.MarkUsed(tokenIDStr, utxoList)
-				time.Sleep(10 * time.Second)
-			}
-		}
-		if success {
-			break
-		}
-	}
-}
-
-func watchUserAirdropStatus(user *UserAccount, ctx context.Context) {
-	defer func() {
-		err := UpdateUserAirdropInfo(user)
-		if err != nil {
-			logger.Println(err)
-		}
-		if !user.AirdropSuccess {
-			AirdropNFT(user)
-		}
-	}()
-	for {
-		select {
-		case <-ctx.Done():
-			user.OngoingTxs = []string{}
-			user.AirdropSuccess = false
-			return
-		default:
-			txToWatchLeft := []string{}
-			for _, txhash := range user.OngoingTxs {
-				isInBlock, err := incClient.CheckTxInBlock(txhash)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-				if !isInBlock {
-					txToWatchLeft = append(txToWatchLeft, txhash)
-				} else {
-					user.Txs[txhash].Status = 2
-				}
-				fmt.Println("txDetail.IsInBlock", isInBlock)
-			}
-			user.OngoingTxs = txToWatchLeft
-			err := UpdateUserAirdropInfo(user)
-			if err != nil {
-				logger.Println(err)
-			}
-			if len(user.OngoingTxs) == 0 {
-				user.AirdropSuccess = true
-				logger.Println("Done airdrop for user", user.PaymentAddress)
-				return
-			}
-			time.Sleep(15 * time.Second)
-		}
-	}
-}
@@ -1,15 +1,21 @@
-package main
+package nftdrop
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"main/cooldown"
+	"main/fee"
+	"main/health"
+	"main/idempotency"
+	"main/nodepool"
+	"main/service"
+	"main/sybil"
+	"main/webhook"
 	"os"
 	"time"
 
-	"github.com/incognitochain/go-incognito-sdk-v2/common"
 	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+	"github.com/patrickmn/go-cache"
 )
 
 type Config struct {
@@ -24,6 +30,55 @@ type Config struct {
 	Coinservice           string
 	Fullnode              string
 	AirdropKeys           []AirdropKey
+	// Campaign tags the drops in the disbursement ledger.
+	Campaign string
+	// ShadowMode builds the NFT transfers without sending them, recording them for /shadow/txs
+	// instead. Minting and splitting are left to the production instance.
+	ShadowMode bool
+	// DBBackend is "leveldb" (default) or "mongo", the latter allowing several replicas.
+	DBBackend string
+	MongoURI  string
+	MongoDB   string
+	// UserCollection holds the user records, "nftusers" by default so that both services can share
+	// a Mongo database.
+	UserCollection string
+	// Namespace prefixes the collections of the pending txs, webhooks and shadow records, so that
+	// the service can share its storage with the faucet.
+	Namespace string
+	// APIKeys are the integrators allowed to register a callback, see /requestdrop-nft.
+	APIKeys []webhook.Key
+	// WebhookSecret signs the callbacks of requests made without an API key.
+	WebhookSecret string
+	// WebhookAllowPrivate lets the callbacks reach internal addresses, for a receiver registered on
+	// the private network.
+	WebhookAllowPrivate bool
+	// Sybil configures the scoring of the drop requests.
+	Sybil sybil.Config
+	// Cooldown sets how often a user may get an NFT of the campaign.
+	Cooldown cooldown.Config
+	// Idempotency sets how long the responses to the requests with an Idempotency-Key are replayed.
+	Idempotency idempotency.Config
+	// TrustedProxies are the addresses or CIDRs of the proxies whose X-Forwarded-For and X-Real-IP
+	// headers tell the client IP the cooldown and the sybil scoring go by. The remote address of
+	// the requests is used without them.
+	TrustedProxies []string
+	// AdminKey guards the /admin endpoints, /ledger/export, /shadow/txs and /debug/vars, which are
+	// disabled without it.
+	AdminKey string
+	// Fee bounds the fees estimated from the fullnode.
+	Fee fee.Config
+	// Fullnodes are more fullnodes to spread the calls over, Fullnode being the one preferred for
+	// sending the txs.
+	Fullnodes []string
+	// FullnodePool tunes the probing of the fullnodes and the broadcast of the txs.
+	FullnodePool nodepool.Config
+	// Health tunes the checks behind /readyz.
+	Health health.Config
+	// BackupDir enables the scheduled backups of the leveldb backend, every BackupInterval hours
+	// (24 by default), keeping the last BackupKeep archives (7 by default).
+	BackupDir      string
+	BackupInterval int
+	BackupKeep     int
 }
 type AirdropKey struct {
 	PrivateKey string
@@ -31,17 +86,25 @@ type AirdropKey struct {
 
 var config Config
 
-func readConfig() {
+func readConfig(path string) error {
 	logger.Printf("Loading config...\n")
-	data, err := ioutil.ReadFile("./cfg.json")
-	if err != nil {
-		log.Fatalln(err)
+	if err := service.LoadConfig(path, &config); err != nil {
+		return err
 	}
-	if data != nil {
-		err = json.Unmarshal(data, &config)
-		if err != nil {
-			panic(err)
-		}
+	if config.MongoDB == "" {
+		config.MongoDB = "airdrop"
+	}
+	if config.UserCollection == "" {
+		config.UserCollection = "nftusers"
+	}
+	if config.WebhookSecret == "" {
+		config.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	}
+	if config.AdminKey == "" {
+		config.AdminKey = os.Getenv("ADMIN_KEY")
+	}
+	if config.Campaign == "" {
+		config.Campaign = "nftdrop"
 	}
 	if config.NumMintBatchNFTs != 0 {
 		numMintBatchNFTs = config.NumMintBatchNFTs
@@ -66,16 +129,33 @@ func readConfig() {
 	if config.SDKLog != "" {
 		writer, err := os.OpenFile(config.SDKLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 		if err != nil {
-			fmt.Println("Error opening file:", err)
-			os.Exit(1)
+			return fmt.Errorf("open SDKLog: %v", err)
 		}
 		incclient.Logger.Log = log.New(writer, "", log.Ldate|log.Ltime)
 	}
+	return nil
+}
+
+// load reads the config at cfgPath and opens the db.
+func load(cfgPath string) error {
+	cachedb = cache.New(5*time.Minute, 5*time.Minute)
+	adc.UserAccounts = make(map[string]*UserAccount)
+	if err := readConfig(cfgPath); err != nil {
+		return err
+	}
+	return initDB()
+}
 
-	incClient, err = incclient.NewIncClient(config.Fullnode, "", 2)
+// start connects to the network and sets the airdrop accounts up, without syncing them yet.
+func start() error {
+	var err error
+	fullnodes, err = nodepool.New(append([]string{config.Fullnode}, config.Fullnodes...), config.FullnodePool)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	go fullnodes.Start()
+	incClient = fullnodes
+	feeEstimator = fee.New(fullnodes.URL, config.Fee)
 
 	privateKeys := make([]string, 0)
 	for _, key := range config.AirdropKeys {
@@ -83,10 +163,15 @@ func readConfig() {
 	}
 	adc.AirdropAccounts, err = NewAccountManager(privateKeys)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	logger.Printf("Loaded accounts: %v\n", len(adc.AirdropAccounts.Accounts))
+	return initTracker()
+}
 
+// syncAccounts starts syncing the airdrop accounts and waits for every shard to have one ready,
+// then leaves the minting and splitting to their jobs.
+func syncAccounts() {
 	go adc.AirdropAccounts.Sync()
 	shardStatus := make(map[byte]bool)
 	for {
@@ -96,7 +181,7 @@ func readConfig() {
 				shardStatus[acc.ShardID] = true
 			}
 		}
-		for shard := 1; shard < common.MaxShardNumber; shard++ {
+		for _, shard := range readyShards() {
 			if !shardStatus[byte(shard)] {
 				ready = false
 				logger.Printf("Shard %v not ready!!\n", shard)
@@ -108,7 +193,11 @@ func readConfig() {
 			break
 		}
 	}
-	go adc.AirdropAccounts.manageNFTs()
-	go adc.AirdropAccounts.managePRVUTXOs()
+	if config.ShadowMode {
+		logger.Println("running in shadow mode: NFT transfers are built but not sent")
+	} else {
+		go adc.AirdropAccounts.manageNFTs()
+		go adc.AirdropAccounts.managePRVUTXOs()
+	}
 	logger.Println("Loaded config successfully!!")
 }
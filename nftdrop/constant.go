@@ -1,4 +1,4 @@
-package main
+package nftdrop
 
 const (
 	MaxNFTPerAccount uint64 = 1
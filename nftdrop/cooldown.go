@@ -0,0 +1,81 @@
+package nftdrop
+
+import (
+	"main/cooldown"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var cooldowns *cooldown.Limiter
+
+// requestIP is the IP the cooldown caps, none for the integrators whose requests all come from the
+// same few machines.
+func requestIP(c *gin.Context) string {
+	if c.GetHeader("X-API-Key") != "" {
+		return ""
+	}
+	return c.ClientIP()
+}
+
+// checkCooldown answers the request itself when user, the previous request of pubkey if any, is
+// still pending (Result 1) or when the cooldown policy refuses another drop (Result 2), returning
+// false. Both give the unix time the user may ask again, 0 when never. Callers hold adc.userlock.
+func checkCooldown(c *gin.Context, pubkey string, user *UserAccount) bool {
+	if user != nil && !user.AirdropSuccess {
+		until := time.Unix(user.LastAirdropRequest, 0).Add(cooldowns.Pending())
+		if len(user.OngoingTxs) != 0 || time.Now().Before(until) {
+			c.JSON(http.StatusOK, gin.H{
+				"Result":       1,
+				"AirdropTx":    user.Txs,
+				"NextEligible": until.Unix(),
+			})
+			return false
+		}
+	}
+	d, err := cooldowns.Check(config.Campaign, pubkey, requestIP(c), time.Now())
+	return answerCooldown(c, pubkey, user, d, err)
+}
+
+// acquireCooldown counts the drop about to be made against the policy, answering the request
+// itself like checkCooldown when another request got there first.
+func acquireCooldown(c *gin.Context, drop *airdropRequest) bool {
+	now := time.Now()
+	d, err := cooldowns.Acquire(config.Campaign, drop.Pubkey, drop.IP, now)
+	if err == nil && d.Allowed {
+		drop.GrantedAt = now.Unix()
+	}
+	return answerCooldown(c, drop.Pubkey, nil, d, err)
+}
+
+func answerCooldown(c *gin.Context, pubkey string, user *UserAccount, d cooldown.Decision, err error) bool {
+	if err != nil {
+		logger.Println("cooldown:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": "cannot check the request, try again later"})
+		return false
+	}
+	if !d.Allowed {
+		logger.Printf("drop to %v refused: %v\n", pubkey, d.Reason)
+		resp := gin.H{
+			"Result":       2,
+			"NextEligible": d.NextEligible,
+		}
+		if user != nil {
+			resp["AirdropTx"] = user.Txs
+		}
+		c.JSON(http.StatusOK, resp)
+		return false
+	}
+	return true
+}
+
+// releaseCooldown gives back the drop of a user whose airdrop failed, so that they can ask again.
+func releaseCooldown(user *UserAccount) {
+	if user.GrantedAt == 0 {
+		return
+	}
+	if err := cooldowns.Release(config.Campaign, user.Pubkey, user.IP, user.GrantedAt); err != nil {
+		logger.Println("cooldown:", err)
+	}
+}
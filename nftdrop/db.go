@@ -1,62 +1,70 @@
-package main
+package nftdrop
 
 import (
-	"encoding/json"
-
-	"github.com/pkg/errors"
-	"github.com/syndtr/goleveldb/leveldb"
-	lvdbErrors "github.com/syndtr/goleveldb/leveldb/errors"
-	"github.com/syndtr/goleveldb/leveldb/filter"
-	"github.com/syndtr/goleveldb/leveldb/opt"
+	"main/accesslist"
+	"main/service"
+	"main/storage"
 )
 
-var localdb *leveldb.DB
+// dbPath is the leveldb directory of the leveldb backend.
+const dbPath = "airdropdb"
+
+var localdb storage.Store
+var userdb storage.Collection
+
+// privatedb is the view of localdb holding what the service keeps to itself, see Config.Namespace.
+var privatedb storage.Store
 
 func initDB() error {
-	handles := 256
-	cache := 8
-	dbPath := "airdropdb"
-	lvdb, err := leveldb.OpenFile(dbPath, &opt.Options{
-		OpenFilesCacheCapacity: handles,
-		BlockCacheCapacity:     cache / 2 * opt.MiB,
-		WriteBuffer:            cache / 4 * opt.MiB, // Two of these are used internally
-		Filter:                 filter.NewBloomFilter(10),
-	})
-	if _, corrupted := err.(*lvdbErrors.ErrCorrupted); corrupted {
-		lvdb, err = leveldb.RecoverFile(dbPath, nil)
-	}
+	store, private, err := service.OpenStore(storage.Config{
+		Backend:     config.DBBackend,
+		LevelDBPath: dbPath,
+		MongoURI:    config.MongoURI,
+		MongoDB:     config.MongoDB,
+	}, config.Namespace)
 	if err != nil {
-		return errors.Wrapf(err, "levelvdb.OpenFile %s", dbPath)
+		return err
 	}
-	localdb = lvdb
-	return nil
+	localdb = store
+	privatedb = private
+	userdb = store.Collection(config.UserCollection)
+	accessLists = accesslist.New(store)
+	return userdb.EnsureIndex("Record.Pubkey")
 }
 
 func UpdateUserAirdropInfo(user *UserAccount) error {
-	userBytes, err := json.Marshal(user)
+	env, err := userSchema.Wrap(user)
 	if err != nil {
 		return err
 	}
-	err = localdb.Put([]byte(user.PaymentAddress), userBytes, nil)
-	return err
+	return userdb.Put(user.PaymentAddress, env)
 }
 
+// LoadUserAirdropInfo loads every user record, upgrading and storing back the older ones. Records
+// that cannot be read are logged and skipped rather than keeping the service from starting.
 func LoadUserAirdropInfo() ([]*UserAccount, error) {
 	var result []*UserAccount
-	iter := localdb.NewIterator(nil, nil)
-	for iter.Next() {
-		// Remember that the contents of the returned slice should not be modified, and
-		// only valid until the next call to Next.
-		// key := iter.Key()
+	var upgraded []*UserAccount
+	err := userdb.Scan("", "", func(id string, data []byte) error {
 		userAcc := new(UserAccount)
-		value := iter.Value()
-		err := json.Unmarshal(value, userAcc)
+		isUpgraded, err := userSchema.Decode(data, userAcc)
 		if err != nil {
-			return nil, err
+			logger.Printf("skipping user record %v: %v\n", id, err)
+			return nil
+		}
+		if isUpgraded {
+			upgraded = append(upgraded, userAcc)
 		}
 		result = append(result, userAcc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, userAcc := range upgraded {
+		if err := UpdateUserAirdropInfo(userAcc); err != nil {
+			return nil, err
+		}
 	}
-	iter.Release()
-	err := iter.Error()
-	return result, err
+	return result, nil
 }
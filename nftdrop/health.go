@@ -0,0 +1,42 @@
+package nftdrop
+
+import (
+	"main/health"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+)
+
+var healthChecker *health.Checker
+
+// readyShards are the shards syncAccounts waits for. A user is served from another shard when
+// theirs has no account ready.
+func readyShards() []int {
+	shards := []int{}
+	for shard := 1; shard < common.MaxShardNumber; shard++ {
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+// initHealthChecks checks what the service needs to drop: the fullnode and coin service, synced
+// accounts holding NFTs and PRV, and the db.
+func initHealthChecks() {
+	healthChecker = health.NewChecker(config.Health)
+	healthChecker.Add("fullnode", fullnodes.Check)
+	healthChecker.Add("coinservice", health.HTTP(config.Coinservice+"/health"))
+	healthChecker.Add("accounts", health.Accounts(healthChecker.MinAccountsPerShard(), readyShards(), droppingAccounts))
+	healthChecker.Add("db", health.Writable(privatedb))
+}
+
+// droppingAccounts counts by shard the accounts GetRandomAirdropAccount may pick.
+func droppingAccounts() map[int]int {
+	counts := make(map[int]int)
+	for _, acc := range adc.AirdropAccounts.Accounts {
+		nftList, _ := acc.GetMyNFTs()
+		utxoList, _ := acc.GetListUnspentOutput(common.PRVIDStr)
+		if acc.isAvailable() && len(nftList) > 0 && len(utxoList) > 0 {
+			counts[int(acc.ShardID)]++
+		}
+	}
+	return counts
+}
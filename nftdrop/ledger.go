@@ -0,0 +1,45 @@
+package nftdrop
+
+import (
+	"main/ledger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var txLedger *ledger.Ledger
+
+// recordBroadcast adds a tx just sent to the disbursement ledger. It must run before the tx is
+// tracked, so that its settlement always finds it.
+func recordBroadcast(e ledger.Entry) {
+	e.Campaign = config.Campaign
+	err := txLedger.RecordBroadcast(e)
+	if err != nil {
+		logger.Println(err)
+	}
+}
+
+// APIExportLedger exports the disbursement ledger, filtered by date range (from/to as
+// YYYY-MM-DD) and campaign, as csv or json.
+func APIExportLedger(c *gin.Context) {
+	from, to, err := ledger.ParseRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	entries, err := txLedger.Query(from, to, c.Query("campaign"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+	} else {
+		c.Header("Content-Type", "application/json")
+	}
+	err = ledger.Export(c.Writer, format, entries)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+	}
+}
@@ -1,8 +1,13 @@
-package main
+package nftdrop
 
 import (
-	"context"
 	"encoding/json"
+	"expvar"
+	"main/accesslist"
+	"main/health"
+	"main/service"
+	"main/sybil"
+	"main/txtracker"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,44 +18,83 @@ import (
 	"github.com/incognitochain/go-incognito-sdk-v2/common"
 	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
 	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
-	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 )
 
-func main() {
-	cachedb = cache.New(5*time.Minute, 5*time.Minute)
-	adc.UserAccounts = make(map[string]*UserAccount)
-	readConfig()
-	if err := initDB(); err != nil {
-		panic(err)
+// Open reads the config of the NFT drop at cfgPath and opens its storage, which Serve needs.
+func Open(cfgPath string) error {
+	return load(cfgPath)
+}
+
+// Serve runs the NFT drop, serving its API until the process exits or the server fails.
+func Serve() error {
+	if err := start(); err != nil {
+		return err
 	}
-	var err error
 	logger.Println("initiating airdrop-tool")
 	adc.lastUsedADA = 0
 	airdroppedUser, err := LoadUserAirdropInfo()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	toResume := []*UserAccount{}
 	for _, v := range airdroppedUser {
 		adc.UserAccounts[v.Pubkey] = v
+		// drops made before the cooldown policies count against them
+		if v.AirdropSuccess && v.GrantedAt == 0 {
+			if err := cooldowns.Seed(config.Campaign, v.Pubkey, v.LastAirdropRequest); err != nil {
+				logger.Println(err)
+			}
+		}
 		if len(v.OngoingTxs) != 0 {
-			ctx, _ := context.WithTimeout(context.Background(), 20*time.Minute)
-			go watchUserAirdropStatus(v, ctx)
+			for _, txHash := range v.OngoingTxs {
+				err := tracker.Track(txtracker.PendingTx{
+					TxHash:   txHash,
+					Kind:     airdropTxKind,
+					Owner:    v.Pubkey,
+					Deadline: time.Now().Add(20 * time.Minute).Unix(),
+				})
+				if err != nil {
+					logger.Println(err)
+				}
+			}
 		} else {
-			if !v.AirdropSuccess {
-				go AirdropNFT(v)
+			// a user with failed txs is left alone, one of them may still be confirmed
+			if !v.AirdropSuccess && len(v.Txs) == 0 {
+				toResume = append(toResume, v)
 			}
 		}
 	}
+	go tracker.Start()
+	go webhooks.Start()
+	go idempotent.Start()
+	initHealthChecks()
+	go healthChecker.Start()
+	if config.BackupDir != "" {
+		go startBackupJob()
+	}
+	// the accounts take a while to sync: /readyz tells when the service can drop meanwhile
+	go func() {
+		syncAccounts()
+		for _, v := range toResume {
+			go AirdropNFT(v)
+		}
+	}()
 	r := gin.Default()
+	r.TrustedProxies = config.TrustedProxies
 
-	r.GET("/requestdrop-nft", APIReqDrop)
+	r.GET("/requestdrop-nft", idempotent.Middleware(), APIReqDrop)
+	// the ledger, the shadow txs and the metrics are the operators' only
+	adminOnly := accesslist.RequireAdmin(config.AdminKey)
+	r.GET("/debug/vars", adminOnly, gin.WrapH(expvar.Handler()))
+	r.GET("/ledger/export", adminOnly, APIExportLedger)
+	r.GET("/shadow/txs", adminOnly, APIShadowTxs)
+	health.RegisterRoutes(r, healthChecker)
+	admin := r.Group("/admin", adminOnly)
+	accesslist.RegisterRoutes(admin, accessLists)
+	sybil.RegisterRoutes(admin, sybilScorer, approveHeldAirdrop)
 
-	err = r.Run("0.0.0.0:" + strconv.Itoa(config.Port))
-	if err != nil {
-		panic(err)
-	}
-	select {}
+	return r.Run("0.0.0.0:" + strconv.Itoa(config.Port))
 }
 
 func APIReqDrop(c *gin.Context) {
@@ -71,32 +115,46 @@ func APIReqDrop(c *gin.Context) {
 	}
 	shardID = int(common.GetShardIDFromLastByte(wl.KeySet.PaymentAddress.Pk[31]))
 	pubkey := base58.Base58Check{}.Encode(wl.KeySet.PaymentAddress.Pk, 0)
+	allowed, ok := checkAccessList(c, pubkey)
+	if !ok {
+		return
+	}
+	apiKey := c.GetHeader("X-API-Key")
+	callbackURL, err := webhooks.Callback(apiKey, c.Query("callbackurl"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
 
 	adc.userlock.Lock()
-	if user, ok := adc.UserAccounts[pubkey]; ok {
+	if !checkCooldown(c, pubkey, adc.UserAccounts[pubkey]) {
 		adc.userlock.Unlock()
-		t := user.LastAirdropRequest
-		r := 0
-		if time.Since(t) <= 30*time.Minute {
-			r = 1
-		}
-		if user.AirdropSuccess {
-			r = 2
-		}
-		c.JSON(http.StatusOK, gin.H{
-			"Result":    r,
-			"AirdropTx": user.Txs,
-		})
 		return
 	}
-	start := time.Now()
-	existNFT, err := checkUserHaveNFT(paymentkey)
-	if err != nil {
-		logger.Printf("checkUserHaveNFT error: %v\n", err)
+	drop := airdropRequest{
+		PaymentAddress: paymentkey,
+		Pubkey:         pubkey,
+		ShardID:        shardID,
+		CallbackURL:    callbackURL,
+		APIKey:         apiKey,
+		IP:             requestIP(c),
+	}
+	// pre-approved receivers skip the sybil scoring and may get an NFT even if they already hold one
+	if !allowed && !checkSybil(c, drop, nil) {
 		adc.userlock.Unlock()
 		return
 	}
-	logger.Printf("checkUserHaveNFT timeElapsed: %v\n", time.Since(start).Seconds())
+	existNFT := false
+	if !allowed {
+		start := time.Now()
+		existNFT, err = checkUserHaveNFT(paymentkey)
+		if err != nil {
+			logger.Printf("checkUserHaveNFT error: %v\n", err)
+			adc.userlock.Unlock()
+			return
+		}
+		logger.Printf("checkUserHaveNFT timeElapsed: %v\n", time.Since(start).Seconds())
+	}
 	if existNFT {
 		logger.Printf("User %v already had an NFT\n", paymentkey)
 		adc.userlock.Unlock()
@@ -105,16 +163,18 @@ func APIReqDrop(c *gin.Context) {
 		})
 		return
 	}
-	newUserAccount := new(UserAccount)
-	newUserAccount.PaymentAddress = paymentkey
-	newUserAccount.Pubkey = pubkey
-	newUserAccount.ShardID = shardID
-	newUserAccount.Txs = make(map[string]*AirdropTxDetail)
+	if !acquireCooldown(c, &drop) {
+		adc.userlock.Unlock()
+		return
+	}
+	newUserAccount := newAirdropUser(drop)
 	adc.UserAccounts[pubkey] = newUserAccount
 	adc.userlock.Unlock()
-	err = UpdateUserAirdropInfo(newUserAccount)
-	if err != nil {
-		logger.Println(err)
+	if !config.ShadowMode {
+		err = UpdateUserAirdropInfo(newUserAccount)
+		if err != nil {
+			logger.Println(err)
+		}
 	}
 	go AirdropNFT(newUserAccount)
 	c.JSON(http.StatusOK, gin.H{
@@ -128,7 +188,7 @@ func checkUserHaveNFT(paymentAddress string) (bool, error) {
 		return false, err
 	}
 	defer resp.Body.Close()
-	body, err := readRespBody(resp)
+	body, err := service.ReadRespBody(resp)
 	if err != nil {
 		return false, err
 	}
@@ -157,7 +217,7 @@ func AirdropNFT(user *UserAccount) {
 	attempt := 0
 	for attempt < maxAttempts {
 		adc.userlock.Lock()
-		user.LastAirdropRequest = time.Now()
+		user.LastAirdropRequest = time.Now().Unix()
 		adc.userlock.Unlock()
 
 		airdropAccount, err := adc.AirdropAccounts.GetRandomAirdropAccount(byte(user.ShardID))
@@ -177,10 +237,20 @@ func AirdropNFT(user *UserAccount) {
 			continue
 		}
 
+		if config.ShadowMode {
+			// nothing was sent: the user is done in memory only
+			adc.userlock.Lock()
+			user.Txs[txHash] = &AirdropTxDetail{TxHash: txHash, NFTused: nftID, Status: 2}
+			user.AirdropSuccess = true
+			adc.userlock.Unlock()
+			logger.Printf("shadow airdrop for %v recorded: %v\n", user.toString(), txHash)
+			break
+		}
+
 		txsToWatch = append(txsToWatch, txHash)
 
 		adc.userlock.Lock()
-		user.LastAirdropRequest = time.Now()
+		user.LastAirdropRequest = time.Now().Unix()
 		user.Txs[txHash] = &AirdropTxDetail{
 			TxHash:  txHash,
 			NFTused: nftID,
@@ -189,8 +259,19 @@ func AirdropNFT(user *UserAccount) {
 		user.OngoingTxs = txsToWatch
 		adc.userlock.Unlock()
 
-		ctx, _ := context.WithTimeout(context.Background(), 30*time.Minute)
-		watchUserAirdropStatus(user, ctx)
+		err = UpdateUserAirdropInfo(user)
+		if err != nil {
+			logger.Println(err)
+		}
+		err = tracker.Track(txtracker.PendingTx{
+			TxHash:   txHash,
+			Kind:     airdropTxKind,
+			Owner:    user.Pubkey,
+			Deadline: time.Now().Add(30 * time.Minute).Unix(),
+		})
+		if err != nil {
+			logger.Println(err)
+		}
 		break
 	}
 
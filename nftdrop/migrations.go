@@ -0,0 +1,36 @@
+package nftdrop
+
+import (
+	"fmt"
+	"main/schema"
+	"time"
+)
+
+// userSchema upgrades the stored UserAccount records. Append new migrations at the end, never
+// edit the released ones.
+var userSchema = schema.NewRegistry("user",
+	// 0 -> 1: LastAirdropRequest becomes a unix time like in the faucet, and records missing
+	// their Pubkey get it back
+	func(record map[string]interface{}) error {
+		if err := normalizeLastAirdropRequest(record); err != nil {
+			return err
+		}
+		return schema.BackfillPubkey(record)
+	},
+)
+
+func normalizeLastAirdropRequest(record map[string]interface{}) error {
+	timeStr, ok := record["LastAirdropRequest"].(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, timeStr)
+	if err != nil {
+		return fmt.Errorf("invalid LastAirdropRequest %v: %v", timeStr, err)
+	}
+	record["LastAirdropRequest"] = int64(0)
+	if !t.IsZero() {
+		record["LastAirdropRequest"] = t.Unix()
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package nftdrop
+
+import (
+	"main/ledger"
+	"main/shadow"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var shadowStore *shadow.Store
+
+func recordShadowTx(r shadow.Record) {
+	err := shadowStore.Record(r)
+	if err != nil {
+		logger.Println(err)
+	}
+}
+
+// APIShadowTxs lists the txs built in shadow mode, filtered by date range (from/to as
+// YYYY-MM-DD) and receiver.
+func APIShadowTxs(c *gin.Context) {
+	from, to, err := ledger.ParseRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	records, err := shadowStore.Query(from, to, c.Query("receiver"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"Result": records,
+	})
+}
@@ -0,0 +1,115 @@
+package nftdrop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/accesslist"
+	"main/sybil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var sybilScorer *sybil.Scorer
+
+// airdropRequest is an accepted drop request, kept as is while it is held for review.
+type airdropRequest struct {
+	PaymentAddress string
+	Pubkey         string
+	ShardID        int
+	CallbackURL    string
+	APIKey         string
+	IP             string
+	// GrantedAt is the unix time the cooldown policy granted the drop.
+	GrantedAt int64
+}
+
+func newAirdropUser(drop airdropRequest) *UserAccount {
+	user := new(UserAccount)
+	user.PaymentAddress = drop.PaymentAddress
+	user.Pubkey = drop.Pubkey
+	user.ShardID = drop.ShardID
+	user.Txs = make(map[string]*AirdropTxDetail)
+	user.CallbackURL = drop.CallbackURL
+	user.APIKey = drop.APIKey
+	user.IP = drop.IP
+	user.GrantedAt = drop.GrantedAt
+	// pending from now on, for the duplicates of the request
+	user.LastAirdropRequest = drop.GrantedAt
+	return user
+}
+
+// checkSybil scores a request and answers it itself when it is refused or held for review (Result
+// 3), returning false. Integrators are trusted with their API key: all their requests come from
+// the same few machines.
+func checkSybil(c *gin.Context, drop airdropRequest, captcha *sybil.Captcha) bool {
+	if !sybilScorer.Enabled() || drop.APIKey != "" {
+		return true
+	}
+	fp := sybil.NewFingerprint(c.Request, c.ClientIP(), drop.Pubkey, drop.ShardID)
+	fp.Captcha = captcha
+	rec, err := sybilScorer.Evaluate(fp, drop)
+	if err != nil {
+		logger.Println("sybil:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"Error": "cannot check the request, try again later"})
+		return false
+	}
+	switch rec.Decision {
+	case sybil.DecisionRefuse:
+		logger.Printf("drop to %v refused, score %v: %v\n", drop.Pubkey, rec.Score, strings.Join(rec.Reasons, "; "))
+		c.JSON(http.StatusForbidden, gin.H{
+			"Result": -1,
+			"Error":  "this request looks automated",
+		})
+		return false
+	case sybil.DecisionReview:
+		logger.Printf("drop to %v held for review, score %v: %v\n", drop.Pubkey, rec.Score, strings.Join(rec.Reasons, "; "))
+		c.JSON(http.StatusOK, gin.H{
+			"Result": 3,
+		})
+		return false
+	}
+	return true
+}
+
+// approveHeldAirdrop carries out a drop approved on review.
+func approveHeldAirdrop(review sybil.Review) error {
+	var drop airdropRequest
+	if err := json.Unmarshal(review.Request, &drop); err != nil {
+		return err
+	}
+	// the key may have been denied while the drop was held
+	if e, err := accessLists.Check(drop.Pubkey); err != nil {
+		return err
+	} else if e.List == accesslist.Deny {
+		return fmt.Errorf("%v is on the deny list: %v", drop.Pubkey, e.Reason)
+	}
+	adc.userlock.Lock()
+	if user, ok := adc.UserAccounts[drop.Pubkey]; ok && !user.AirdropSuccess && (len(user.OngoingTxs) != 0 || len(user.Txs) == 0) {
+		adc.userlock.Unlock()
+		return errors.New("the user already requested another drop")
+	}
+	now := time.Now()
+	d, err := cooldowns.Acquire(config.Campaign, drop.Pubkey, drop.IP, now)
+	if err != nil || !d.Allowed {
+		adc.userlock.Unlock()
+		if err == nil {
+			err = errors.New(d.Reason)
+		}
+		return err
+	}
+	drop.GrantedAt = now.Unix()
+	user := newAirdropUser(drop)
+	adc.UserAccounts[drop.Pubkey] = user
+	adc.userlock.Unlock()
+	if !config.ShadowMode {
+		if err := UpdateUserAirdropInfo(user); err != nil {
+			logger.Println(err)
+		}
+	}
+	go AirdropNFT(user)
+	return nil
+}
@@ -0,0 +1,319 @@
+package nftdrop
+
+import (
+	"fmt"
+	"main/cooldown"
+	"main/idempotency"
+	"main/ledger"
+	"main/shadow"
+	"main/sybil"
+	"main/txtracker"
+	"main/webhook"
+	"sync"
+	"time"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+)
+
+const (
+	airdropTxKind = "airdrop"
+	utxoTxKind    = "utxo"
+)
+
+var tracker *txtracker.Tracker
+var webhooks *webhook.Dispatcher
+
+// utxoWatch remembers which UTXOs each tracked tx spends so that their state can be settled once
+// the tx is. It lives in memory only: after a restart, AccountInfo.Update re-syncs the UTXOs and
+// watchInputs watches a stuck tx again from the inputs the tracker persisted.
+type utxoWatch struct {
+	acc      *AccountInfo
+	tokenID  string
+	utxoList []Coin
+}
+
+// conflictChecks bounds the times the txs of a conflicted airdrop are looked for in a block,
+// conflictCheckInterval apart, before the user is airdropped again.
+const conflictChecks = 10
+
+var conflictCheckInterval = time.Minute
+
+var utxoWatchLock sync.Mutex
+var utxoWatchList = make(map[string][]utxoWatch)
+
+func initTracker() error {
+	var err error
+	tracker, err = txtracker.NewTracker(fullnodes, privatedb, txtracker.DefaultConfig())
+	if err != nil {
+		return err
+	}
+	tracker.Subscribe(onUTXOTxEvent)
+	tracker.Subscribe(onAirdropTxEvent)
+	tracker.Subscribe(txtracker.MetricsSubscriber)
+	tracker.SetReplacer(airdropTxKind, replaceNFTTransfer)
+	txLedger, err = ledger.New(localdb)
+	if err != nil {
+		return err
+	}
+	if err := txLedger.Verify(); err != nil {
+		logger.Println(err)
+	}
+	tracker.Subscribe(txLedger.Subscriber)
+	shadowStore = shadow.NewStore(privatedb)
+	sybilScorer = sybil.NewScorer(localdb, config.Sybil)
+	cooldowns, err = cooldown.NewLimiter(privatedb, config.Cooldown)
+	if err != nil {
+		return err
+	}
+	webhookConfig := webhook.DefaultConfig()
+	webhookConfig.AllowPrivate = config.WebhookAllowPrivate
+	webhooks = webhook.NewDispatcher(privatedb, config.APIKeys, config.WebhookSecret, webhookConfig)
+	idempotent = idempotency.NewStore(privatedb, config.Idempotency)
+	return nil
+}
+
+// trackUTXOs watches tx and marks the UTXOs it spends as spent once it is in a block, or releases
+// them if it never makes it.
+func trackUTXOs(acc *AccountInfo, tx txtracker.PendingTx, spent map[string][]Coin) {
+	tx.Inputs = make(map[string][]string)
+	utxoWatchLock.Lock()
+	for tokenID, utxoList := range spent {
+		utxoWatchList[tx.TxHash] = append(utxoWatchList[tx.TxHash], utxoWatch{acc: acc, tokenID: tokenID, utxoList: utxoList})
+		for _, utxo := range utxoList {
+			tx.Inputs[tokenID] = append(tx.Inputs[tokenID], keyImageString(utxo.Coin))
+		}
+	}
+	utxoWatchLock.Unlock()
+	if tx.Kind == "" {
+		tx.Kind = utxoTxKind
+	}
+	if tx.Deadline == 0 {
+		tx.Deadline = time.Now().Add(30 * time.Minute).Unix()
+	}
+	tx.ShardID = int(acc.ShardID)
+	err := tracker.Track(tx)
+	if err != nil {
+		logger.Println(err)
+	}
+}
+
+func onUTXOTxEvent(ev txtracker.Event) {
+	utxoWatchLock.Lock()
+	watchList := utxoWatchList[ev.Tx.TxHash]
+	delete(utxoWatchList, ev.Tx.TxHash)
+	if ev.Status == txtracker.StatusReplaced {
+		// the replacement spends the same UTXOs, plus the extra ones it may have needed for its fee
+		for _, w := range utxoWatchList[ev.ReplacedBy] {
+			w.acc.MarkTempUsed(w.tokenID, w.utxoList)
+		}
+		utxoWatchList[ev.ReplacedBy] = append(utxoWatchList[ev.ReplacedBy], watchList...)
+		utxoWatchLock.Unlock()
+		return
+	}
+	utxoWatchLock.Unlock()
+	for _, w := range watchList {
+		if ev.Status == txtracker.StatusConfirmed {
+			w.acc.MarkUsed(w.tokenID, w.utxoList)
+		} else {
+			// We assume timed-out = failed
+			logger.Printf("Checking status of tx %v %v\n", ev.Tx.TxHash, ev.Status)
+			w.acc.ClearTempUsed(w.tokenID, w.utxoList)
+		}
+	}
+}
+
+func onAirdropTxEvent(ev txtracker.Event) {
+	if ev.Tx.Kind != airdropTxKind {
+		return
+	}
+	adc.userlock.Lock()
+	user, ok := adc.UserAccounts[ev.Tx.Owner]
+	if !ok {
+		adc.userlock.Unlock()
+		logger.Printf("airdrop tx %v settled (%v) for unknown user %v\n", ev.Tx.TxHash, ev.Status, ev.Tx.Owner)
+		return
+	}
+	txDetail, ok := user.Txs[ev.Tx.TxHash]
+	if !ok {
+		txDetail = &AirdropTxDetail{TxHash: ev.Tx.TxHash}
+		user.Txs[ev.Tx.TxHash] = txDetail
+	}
+	switch ev.Status {
+	case txtracker.StatusConfirmed:
+		txDetail.Status = 2
+		user.OngoingTxs = []string{}
+		user.AirdropSuccess = true
+	case txtracker.StatusReplaced:
+		txDetail.Status = 4
+		newTxDetail, ok := user.Txs[ev.ReplacedBy]
+		if !ok {
+			newTxDetail = &AirdropTxDetail{TxHash: ev.ReplacedBy, NFTused: txDetail.NFTused}
+			user.Txs[ev.ReplacedBy] = newTxDetail
+		}
+		newTxDetail.Status = 1
+		user.OngoingTxs = []string{ev.ReplacedBy}
+	default:
+		txDetail.Status = 3
+		user.OngoingTxs = []string{}
+		user.AirdropSuccess = false
+	}
+	adc.userlock.Unlock()
+
+	err := UpdateUserAirdropInfo(user)
+	if err != nil {
+		logger.Println(err)
+	}
+	switch {
+	case user.AirdropSuccess:
+		logger.Println("Done airdrop for user", user.PaymentAddress)
+		notifyAirdrop(user, webhook.EventConfirmed)
+	case ev.Status == txtracker.StatusReplaced:
+	case ev.Conflicted:
+		// the NFT was spent by another tx, the failed one can never land: airdrop again, unless
+		// that tx was one of its own
+		go awaitConflicted(user, ev.Tx)
+	default:
+		logger.Printf("airdrop tx %v to %v %v, not retrying since it may still be confirmed\n", ev.Tx.TxHash, user.toString(), ev.Status)
+		releaseCooldown(user)
+		notifyAirdrop(user, webhook.EventFailed)
+	}
+}
+
+// notifyAirdrop posts the terminal state of the user's airdrop to its callback, if any.
+func notifyAirdrop(user *UserAccount, event string) {
+	adc.userlock.RLock()
+	payload := webhook.Payload{Event: event, PaymentAddress: user.PaymentAddress}
+	for txHash := range user.Txs {
+		payload.TxHashes = append(payload.TxHashes, txHash)
+	}
+	adc.userlock.RUnlock()
+	err := webhooks.Notify(user.APIKey, user.CallbackURL, payload)
+	if err != nil {
+		logger.Println(err)
+	}
+}
+
+// replaceNFTTransfer rebuilds a stuck NFT transfer out of the same UTXOs with a higher fee.
+func replaceNFTTransfer(stuck txtracker.PendingTx) (*txtracker.PendingTx, error) {
+	adc.userlock.RLock()
+	user, ok := adc.UserAccounts[stuck.Owner]
+	adc.userlock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("user %v not found", stuck.Owner)
+	}
+	acc, spent, err := watchInputs(stuck)
+	if err != nil {
+		return nil, err
+	}
+
+	var nftID string
+	var nftCoins, prvCoins []Coin
+	for tokenID, utxoList := range spent {
+		if tokenID == common.PRVIDStr {
+			prvCoins = utxoList
+		} else {
+			nftID = tokenID
+			nftCoins = utxoList
+		}
+	}
+	if nftID == "" {
+		return nil, fmt.Errorf("tx %v spends no NFT", stuck.TxHash)
+	}
+
+	fee := feeEstimator.Replacement(stuck.Fee)
+	if fee <= stuck.Fee {
+		return nil, fmt.Errorf("fee of tx %v already at the ceiling", stuck.TxHash)
+	}
+	prvAmount := uint64(0)
+	for _, c := range prvCoins {
+		prvAmount += c.Coin.GetValue()
+	}
+	var extraCoins []Coin
+	if prvAmount < fee {
+		extraCoins, err = acc.ChooseBestUTXOs(common.PRVIDStr, fee-prvAmount)
+		if err != nil {
+			return nil, err
+		}
+		prvCoins = append(prvCoins, extraCoins...)
+	}
+	encodedTx, txHash, err := buildNFTTransferTx(acc, user.PaymentAddress, nftID, nftCoins, prvCoins, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make(map[string][]string)
+	for _, c := range prvCoins {
+		inputs[common.PRVIDStr] = append(inputs[common.PRVIDStr], keyImageString(c.Coin))
+	}
+	for _, c := range nftCoins {
+		inputs[nftID] = append(inputs[nftID], keyImageString(c.Coin))
+	}
+	if len(extraCoins) > 0 {
+		utxoWatchLock.Lock()
+		utxoWatchList[txHash] = append(utxoWatchList[txHash], utxoWatch{acc: acc, tokenID: common.PRVIDStr, utxoList: extraCoins})
+		utxoWatchLock.Unlock()
+	}
+	return &txtracker.PendingTx{
+		TxHash:    txHash,
+		RawTx:     encodedTx,
+		IsTokenTx: true,
+		Fee:       fee,
+		Inputs:    inputs,
+	}, nil
+}
+
+// watchInputs finds the UTXOs spent by tx from the inputs the tracker persisted with it, along
+// with the airdrop account holding them. A tx tracked before a restart has lost its watch: it is
+// watched again, its UTXOs marked in use, so that they are settled along with it.
+func watchInputs(tx txtracker.PendingTx) (*AccountInfo, map[string][]Coin, error) {
+	var acc *AccountInfo
+	var spent map[string][]Coin
+	for _, a := range adc.AirdropAccounts.Accounts {
+		if int(a.ShardID) != tx.ShardID {
+			continue
+		}
+		if spent = a.findUTXOs(tx.Inputs); spent != nil {
+			acc = a
+			break
+		}
+	}
+	if acc == nil {
+		return nil, nil, fmt.Errorf("inputs of tx %v not found in the airdrop accounts", tx.TxHash)
+	}
+
+	utxoWatchLock.Lock()
+	defer utxoWatchLock.Unlock()
+	if _, ok := utxoWatchList[tx.TxHash]; !ok {
+		for tokenID, utxoList := range spent {
+			acc.MarkTempUsed(tokenID, utxoList)
+			utxoWatchList[tx.TxHash] = append(utxoWatchList[tx.TxHash], utxoWatch{acc: acc, tokenID: tokenID, utxoList: utxoList})
+		}
+	}
+	return acc, spent, nil
+}
+
+// awaitConflicted waits for the final status of an airdrop whose inputs were spent by a tx the
+// fullnode could not tell: the original tx or one of its replacements may be that tx, only late
+// to show in a block. The user is airdropped again only once none of them landed.
+func awaitConflicted(user *UserAccount, tx txtracker.PendingTx) {
+	chain := append([]string{tx.TxHash}, tx.Replaces...)
+	for i := 0; i < conflictChecks; i++ {
+		time.Sleep(conflictCheckInterval)
+		for _, txHash := range chain {
+			isInBlock, err := incClient.CheckTxInBlock(txHash)
+			if err != nil || !isInBlock {
+				continue
+			}
+			logger.Printf("airdrop tx %v to %v landed after its inputs were seen spent\n", txHash, user.toString())
+			landed := txtracker.Event{Tx: tx, Status: txtracker.StatusConfirmed}
+			landed.Tx.TxHash = txHash
+			landed.Tx.RawTx = nil
+			landed.Tx.Replaces = nil
+			txLedger.Subscriber(landed)
+			onAirdropTxEvent(landed)
+			return
+		}
+	}
+	logger.Printf("no airdrop tx to %v landed, airdropping again\n", user.toString())
+	AirdropNFT(user)
+}
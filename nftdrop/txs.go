@@ -1,8 +1,11 @@
-package main
+package nftdrop
 
 import (
 	"context"
 	"fmt"
+	"main/ledger"
+	"main/shadow"
+	"main/txtracker"
 	"math"
 	"strings"
 	"time"
@@ -14,8 +17,29 @@ import (
 	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
 )
 
+// chooseFeeCoins chooses PRV UTXOs covering amount and the fee of a tx spending them along with
+// otherInputs coins of another token, returning them with that fee.
+func chooseFeeCoins(acc *AccountInfo, amount uint64, otherInputs, numOutputs int, isTokenTx bool) ([]Coin, uint64, error) {
+	quote := feeEstimator.Quote(acc.PaymentAddress)
+	txFee := quote.Fee(otherInputs+1, numOutputs, isTokenTx)
+	for {
+		coinsToSpend, err := acc.ChooseBestUTXOs(common.PRVIDStr, amount+txFee)
+		if err != nil {
+			return nil, 0, err
+		}
+		// more coins make a bigger tx; the fee only grows so this stops at the ceiling at the latest
+		needed := quote.Fee(otherInputs+len(coinsToSpend), numOutputs, isTokenTx)
+		if needed <= txFee {
+			return coinsToSpend, txFee, nil
+		}
+		txFee = needed
+	}
+}
+
 func transferPRV(acc *AccountInfo, addrList []string, amountList []uint64, doneChan chan string, errChan chan error) error {
-	requiredAmount := incclient.DefaultPRVFee
+	// the change takes an output too
+	txFee := feeEstimator.Fee(acc.PaymentAddress, 1, len(addrList)+1, false)
+	requiredAmount := txFee
 	for _, amt := range amountList {
 		requiredAmount += amt
 	}
@@ -28,7 +52,7 @@ func transferPRV(acc *AccountInfo, addrList []string, amountList []uint64, doneC
 
 		return err
 	}
-	coinsToSpend, err := acc.ChooseBestUTXOs(common.PRVIDStr, requiredAmount)
+	coinsToSpend, txFee, err := chooseFeeCoins(acc, requiredAmount-txFee, 0, len(addrList)+1, false)
 	if err != nil {
 		if errChan != nil {
 			errChan <- err
@@ -43,7 +67,7 @@ func transferPRV(acc *AccountInfo, addrList []string, amountList []uint64, doneC
 		idxList = append(idxList, c.Index)
 	}
 
-	txParam := incclient.NewTxParam(acc.PrivateKey, addrList, amountList, 0, nil, nil, nil)
+	txParam := incclient.NewTxParam(acc.PrivateKey, addrList, amountList, txFee, nil, nil, nil)
 	encodedTx, txHash, err := incClient.CreateRawTransactionWithInputCoins(txParam, coinList, idxList)
 	if err != nil {
 		if errChan != nil {
@@ -62,7 +86,27 @@ func transferPRV(acc *AccountInfo, addrList []string, amountList []uint64, doneC
 	acc.MarkTempUsed(common.PRVIDStr, coinsToSpend)
 	logger.Printf("TransferPRV %v TxHash: %v\n", acc.toString(), txHash)
 
-	waitingCheckTxInBlock(acc, txHash, common.PRVIDStr, coinsToSpend)
+	kind, receiver := ledger.KindSplit, acc.PaymentAddress
+	amount := uint64(0)
+	for i, addr := range addrList {
+		if addr != acc.PaymentAddress {
+			kind, receiver = ledger.KindRebalance, addr
+		}
+		amount += amountList[i]
+	}
+	recordBroadcast(ledger.Entry{
+		Kind:     kind,
+		TxHash:   txHash,
+		Account:  acc.PaymentAddress,
+		Receiver: receiver,
+		TokenID:  common.PRVIDStr,
+		Amount:   amount,
+		Fee:      txFee,
+	})
+	settled := tracker.Await(txHash)
+	trackUTXOs(acc, txtracker.PendingTx{TxHash: txHash, RawTx: encodedTx, Fee: txFee},
+		map[string][]Coin{common.PRVIDStr: coinsToSpend})
+	<-settled
 	if doneChan != nil {
 		doneChan <- txHash
 	}
@@ -106,12 +150,14 @@ func splitPRV(acc *AccountInfo, amountForEach uint64, numUTXOs int) error {
 				tmpNumUTXOs = incclient.MaxOutputSize * incclient.MaxOutputSize
 			}
 			numTxs := int(math.Ceil(float64(tmpNumUTXOs) / float64(incclient.MaxOutputSize)))
+			// each intermediate output pays for a tx splitting it into MaxOutputSize outputs
+			splitFee := feeEstimator.Fee(acc.PaymentAddress, 1, incclient.MaxOutputSize+1, false)
 
 			addrList := make([]string, 0)
 			amountList := make([]uint64, 0)
 			for i := 0; i < numTxs; i++ {
 				addrList = append(addrList, acc.PaymentAddress)
-				amountList = append(amountList, amountForEach*incclient.MaxOutputSize+incclient.DefaultPRVFee)
+				amountList = append(amountList, amountForEach*incclient.MaxOutputSize+splitFee)
 			}
 			err = transferPRV(acc, addrList, amountList, nil, nil)
 			if err != nil {
@@ -199,7 +245,9 @@ func mintNFT(acc *AccountInfo, doneChan chan string, errChan chan error) {
 	if minPRVRequired == 0 {
 		minPRVRequired = incClient.GetMinPRVRequiredToMintNFT(0)
 	}
-	requiredAmount := minPRVRequired + incclient.DefaultPRVFee
+	// the burnt PRV and the change
+	txFee := feeEstimator.Fee(acc.PaymentAddress, 1, 2, false)
+	requiredAmount := minPRVRequired + txFee
 	balance := acc.GetBalance(common.PRVIDStr)
 	if balance < requiredAmount {
 		errChan <- fmt.Errorf("insufficient PRV amount: required %v, got %v", requiredAmount, balance)
@@ -222,9 +270,7 @@ func mintNFT(acc *AccountInfo, doneChan chan string, errChan chan error) {
 		minPRVRequired = incClient.GetMinPRVRequiredToMintNFT(0)
 	}
 	md := metadataPdexv3.NewUserMintNftRequestWithValue(otaReceiveStr, minPRVRequired)
-	txParam := incclient.NewTxParam(acc.PrivateKey, []string{common.BurningAddress2}, []uint64{minPRVRequired}, 0, nil, md, nil)
-
-	coinsToSpend, err := acc.ChooseBestUTXOs(common.PRVIDStr, requiredAmount)
+	coinsToSpend, txFee, err := chooseFeeCoins(acc, minPRVRequired, 0, 2, false)
 	if err != nil {
 		logger.Println(err)
 		errChan <- err
@@ -240,6 +286,7 @@ func mintNFT(acc *AccountInfo, doneChan chan string, errChan chan error) {
 		idxList = append(idxList, c.Index)
 	}
 
+	txParam := incclient.NewTxParam(acc.PrivateKey, []string{common.BurningAddress2}, []uint64{minPRVRequired}, txFee, nil, md, nil)
 	encodedTx, txHash, err := incClient.CreateRawTransactionWithInputCoins(txParam, coinList, idxList)
 	if err != nil {
 		errChan <- err
@@ -252,7 +299,17 @@ func mintNFT(acc *AccountInfo, doneChan chan string, errChan chan error) {
 	}
 	acc.MarkTempUsed(common.PRVIDStr, coinsToSpend)
 
-	go waitingCheckTxInBlock(acc, txHash, common.PRVIDStr, coinsToSpend)
+	recordBroadcast(ledger.Entry{
+		Kind:     ledger.KindMint,
+		TxHash:   txHash,
+		Account:  acc.PaymentAddress,
+		Receiver: common.BurningAddress2,
+		TokenID:  common.PRVIDStr,
+		Amount:   minPRVRequired,
+		Fee:      txFee,
+	})
+	trackUTXOs(acc, txtracker.PendingTx{TxHash: txHash, RawTx: encodedTx, Fee: txFee},
+		map[string][]Coin{common.PRVIDStr: coinsToSpend})
 	doneChan <- txHash
 }
 
@@ -265,7 +322,7 @@ func mintNFTMany(acc *AccountInfo, numNFTs int) {
 	if minPRVRequired == 0 {
 		minPRVRequired = incClient.GetMinPRVRequiredToMintNFT(0)
 	}
-	requiredAmountForEach := minPRVRequired + incclient.DefaultPRVFee
+	requiredAmountForEach := minPRVRequired + feeEstimator.Fee(acc.PaymentAddress, 1, 2, false)
 	requiredAmount := requiredAmountForEach * uint64(numNFTs)
 	balance := acc.GetBalance(common.PRVIDStr)
 	if balance < requiredAmount {
@@ -342,7 +399,8 @@ func mintNFTMany(acc *AccountInfo, numNFTs int) {
 
 func transferNFT(acc *AccountInfo, paymentAddress string) (string, string, error) {
 	var err error
-	prvCoinsToSpend, err := acc.ChooseBestUTXOs(common.PRVIDStr, incclient.DefaultPRVFee)
+	// the NFT goes whole to the receiver, the PRV change back to acc
+	prvCoinsToSpend, txFee, err := chooseFeeCoins(acc, 0, 1, 2, true)
 	if err != nil {
 		return "", "", err
 	}
@@ -357,6 +415,53 @@ func transferNFT(acc *AccountInfo, paymentAddress string) (string, string, error
 		return "", "", err
 	}
 
+	encodedTx, txHash, err := buildNFTTransferTx(acc, paymentAddress, nftID, nftCoinToSpend, prvCoinsToSpend, txFee)
+	if err != nil {
+		return "", "", err
+	}
+	if config.ShadowMode {
+		inputs := []string{}
+		for _, c := range append(append([]Coin{}, nftCoinToSpend...), prvCoinsToSpend...) {
+			inputs = append(inputs, keyImageString(c.Coin))
+		}
+		recordShadowTx(shadow.Record{
+			Kind:     ledger.KindDrop,
+			Account:  acc.PaymentAddress,
+			Receiver: paymentAddress,
+			TxHash:   txHash,
+			TokenID:  nftID,
+			Amount:   1,
+			Fee:      txFee,
+			Inputs:   inputs,
+		})
+		return txHash, nftID, nil
+	}
+	err = incClient.SendRawTokenTx(encodedTx)
+	if err != nil {
+		return "", "", err
+	}
+	acc.MarkTempUsed(common.PRVIDStr, prvCoinsToSpend)
+	acc.MarkTempUsed(nftID, nftCoinToSpend)
+	logger.Printf("TransferNFT %v to %v TxHash: %v\n", acc.toString(),
+		fmt.Sprintf("%v...%v", paymentAddress[:5], paymentAddress[len(paymentAddress)-5:]), txHash)
+
+	recordBroadcast(ledger.Entry{
+		Kind:     ledger.KindDrop,
+		TxHash:   txHash,
+		Account:  acc.PaymentAddress,
+		Receiver: paymentAddress,
+		TokenID:  nftID,
+		Amount:   1,
+		Fee:      txFee,
+	})
+	trackUTXOs(acc, txtracker.PendingTx{TxHash: txHash, RawTx: encodedTx, IsTokenTx: true, Fee: txFee},
+		map[string][]Coin{common.PRVIDStr: prvCoinsToSpend, nftID: nftCoinToSpend})
+	return txHash, nftID, nil
+}
+
+// buildNFTTransferTx creates a tx sending nftID to paymentAddress out of the given UTXOs. A zero fee
+// means the default one.
+func buildNFTTransferTx(acc *AccountInfo, paymentAddress, nftID string, nftCoinToSpend, prvCoinsToSpend []Coin, fee uint64) ([]byte, string, error) {
 	prvCoinList := make([]coin.PlainCoin, 0)
 	prvIdxList := make([]uint64, 0)
 	nftCoinList := make([]coin.PlainCoin, 0)
@@ -372,24 +477,9 @@ func transferNFT(acc *AccountInfo, paymentAddress string) (string, string, error
 
 	txTokenParam := incclient.NewTxTokenParam(nftID, 1,
 		[]string{paymentAddress}, []uint64{1}, false, 0, nil)
-	txParam := incclient.NewTxParam(acc.PrivateKey, []string{}, []uint64{}, 0, txTokenParam, nil, nil)
+	txParam := incclient.NewTxParam(acc.PrivateKey, []string{}, []uint64{}, fee, txTokenParam, nil, nil)
 
-	encodedTx, txHash, err := incClient.CreateRawTokenTransactionWithInputCoins(
+	return incClient.CreateRawTokenTransactionWithInputCoins(
 		txParam, nftCoinList,
 		nftIdxList, prvCoinList, prvIdxList)
-	if err != nil {
-		return "", "", err
-	}
-	err = incClient.SendRawTokenTx(encodedTx)
-	if err != nil {
-		return "", "", err
-	}
-	acc.MarkTempUsed(common.PRVIDStr, prvCoinsToSpend)
-	acc.MarkTempUsed(nftID, nftCoinToSpend)
-	logger.Printf("TransferNFT %v to %v TxHash: %v\n", acc.toString(),
-		fmt.Sprintf("%v...%v", paymentAddress[:5], paymentAddress[len(paymentAddress)-5:]), txHash)
-
-	go waitingCheckTxInBlock(acc, txHash, common.PRVIDStr, prvCoinsToSpend)
-	go waitingCheckTxInBlock(acc, txHash, nftID, nftCoinToSpend)
-	return txHash, nftID, nil
 }
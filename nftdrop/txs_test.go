@@ -1,11 +1,17 @@
-package main
+package nftdrop
 
 import (
 	"fmt"
 	"github.com/incognitochain/go-incognito-sdk-v2/common"
 	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
 	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
 	"log"
+	"main/ledger"
+	"main/nodepool"
+	"main/storage"
+	"main/txtracker"
 	"strings"
 	"sync"
 	"testing"
@@ -56,6 +62,25 @@ func init() {
 		panic(err)
 	}
 	logger.Printf("Loaded accounts: %v\n", len(adc.AirdropAccounts.Accounts))
+	fullnodes, err = nodepool.New([]string{incclient.TestNetFullNode}, nodepool.Config{})
+	if err != nil {
+		panic(err)
+	}
+	tracker, err = txtracker.NewTracker(fullnodes, nil, txtracker.DefaultConfig())
+	if err != nil {
+		panic(err)
+	}
+	tracker.Subscribe(onUTXOTxEvent)
+	memdb, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		panic(err)
+	}
+	txLedger, err = ledger.New(storage.NewLevelDB(memdb))
+	if err != nil {
+		panic(err)
+	}
+	tracker.Subscribe(txLedger.Subscriber)
+	go tracker.Start()
 
 	go adc.AirdropAccounts.Sync()
 	shardStatus := make(map[byte]bool)
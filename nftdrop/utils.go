@@ -1,29 +1,12 @@
-package main
+package nftdrop
 
 import (
-	"compress/gzip"
-	"io"
-	"io/ioutil"
-	"net/http"
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
 )
 
-func readRespBody(resp *http.Response) ([]byte, error) {
-	var reader io.ReadCloser
-	var err error
-	switch resp.Header.Get("Content-Encoding") {
-	case "gzip":
-		reader, err = gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		defer reader.Close()
-	default:
-		reader = resp.Body
-	}
-
-	body, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-	return body, nil
+// keyImageString returns the base58 encoded key image of a coin, as used to check whether it is spent.
+func keyImageString(c coin.PlainCoin) string {
+	return base58.Base58Check{}.Encode(c.GetKeyImage().ToBytesS(), common.ZeroByte)
 }
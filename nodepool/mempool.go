@@ -0,0 +1,79 @@
+package nodepool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// rpcClient calls the RPCs the SDK does not wrap.
+var rpcClient = &http.Client{Timeout: 10 * time.Second}
+
+// CheckCoinsInMempool returns which of the key images in snList are spent by txs in the mempool
+// of a healthy node. The mempools differ from node to node, so a key image counts as spent as
+// soon as one of them says so.
+func (p *Pool) CheckCoinsInMempool(snList []string) ([]bool, error) {
+	var spent []bool
+	err := fmt.Errorf("nodepool: no fullnode available")
+	for _, n := range p.candidates(false) {
+		if !n.healthy {
+			continue
+		}
+		var res []bool
+		if res, err = hasSerialNumbersInMempool(n.url, snList); err != nil {
+			continue
+		}
+		if len(res) != len(snList) {
+			err = fmt.Errorf("nodepool: %v answered %v of %v key images", n.url, len(res), len(snList))
+			continue
+		}
+		if spent == nil {
+			spent = make([]bool, len(snList))
+		}
+		for i := range res {
+			spent[i] = spent[i] || res[i]
+		}
+	}
+	if spent == nil {
+		return nil, err
+	}
+	return spent, nil
+}
+
+func hasSerialNumbersInMempool(url string, snList []string) ([]bool, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0",
+		"id":      1,
+		"method":  "hasserialnumbersinmempool",
+		"params":  []interface{}{snList},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rpcClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var rpcResp struct {
+		Result []bool
+		Error  *struct {
+			Message string
+		}
+	}
+	if err := json.Unmarshal(data, &rpcResp); err != nil {
+		return nil, fmt.Errorf("decode hasserialnumbersinmempool response: %v", err)
+	}
+	if rpcResp.Error != nil {
+		return nil, errors.New(rpcResp.Error.Message)
+	}
+	return rpcResp.Result, nil
+}
@@ -0,0 +1,500 @@
+package nodepool
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/incclient"
+	"github.com/incognitochain/go-incognito-sdk-v2/metadata"
+)
+
+// Client is the part of the fullnode client the services use, implemented by both a single
+// incclient.IncClient and a Pool.
+type Client interface {
+	SubmitKey(otaKey string) error
+	GetBestBlock() (map[int]uint64, error)
+	GetUnspentOutputCoins(privateKey, tokenID string, height uint64) ([]coin.PlainCoin, []*big.Int, error)
+	GetSpentOutputCoins(privateKey, tokenID string, height uint64) ([]coin.PlainCoin, []*big.Int, error)
+	GetAllUTXOsV2(privateKey string) (map[string][]coin.PlainCoin, map[string][]*big.Int, error)
+	GetBalance(privateKey, tokenID string) (uint64, error)
+	GetListNftIDs(beaconHeight uint64) (map[string]uint64, error)
+	GetMinPRVRequiredToMintNFT(beaconHeight uint64) uint64
+	CheckTxInBlock(txHash string) (bool, error)
+	CheckCoinsSpent(shardID byte, tokenID string, snList []string) ([]bool, error)
+	GetTxHashBySerialNumbers(snList []string, tokenID string, shardID byte) (map[string]string, error)
+	CreateRawTransaction(param *incclient.TxParam, version int8) ([]byte, string, error)
+	CreateRawTokenTransaction(param *incclient.TxParam, version int8) ([]byte, string, error)
+	CreateRawTransactionWithInputCoins(param *incclient.TxParam, coins []coin.PlainCoin, indices []uint64) ([]byte, string, error)
+	CreateRawTokenTransactionWithInputCoins(param *incclient.TxParam, tokenCoins []coin.PlainCoin, tokenIndices []uint64, prvCoins []coin.PlainCoin, prvIndices []uint64) ([]byte, string, error)
+	CreateAndSendRawTransaction(privateKey string, addrList []string, amountList []uint64, version int8, md metadata.Metadata) (string, error)
+	SendRawTx(encodedTx []byte) error
+	SendRawTokenTx(encodedTx []byte) error
+}
+
+var _ Client = (*incclient.IncClient)(nil)
+
+// beaconHeight is the key of the beacon in the heights of GetBestBlock.
+const beaconHeight = -1
+
+// Config tunes the probing of the nodes of a Pool.
+type Config struct {
+	// ProbeInterval is the period in seconds of the height probes, 15 by default.
+	ProbeInterval int
+	// MaxLag is how many beacon blocks a node may be behind the highest one, 3 by default.
+	MaxLag uint64
+	// StallTimeout is how long in seconds a node may go without a new beacon block, 300 by
+	// default. It only counts while another node does better.
+	StallTimeout int
+	// Broadcast also sends the txs to every other healthy node, for a faster propagation. The
+	// result is still the one of the node picked first.
+	Broadcast bool
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = 15
+	}
+	if cfg.MaxLag == 0 {
+		cfg.MaxLag = 3
+	}
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = 300
+	}
+	return cfg
+}
+
+type node struct {
+	url    string
+	client Client
+	// set by the probes
+	height    uint64
+	changedAt time.Time
+	probeErr  error
+	healthy   bool
+}
+
+// Pool spreads the reads over the healthy fullnodes of a list and sends the txs through the first
+// healthy one, moving on to the next ones when a node fails. The coins of the accounts are read,
+// and their txs created, on the nodes the txs are sent through.
+type Pool struct {
+	cfg   Config
+	dial  func(url string) (Client, error)
+	lock  sync.RWMutex
+	nodes []*node
+	next  int
+	// accountHeights is, by private key, the beacon height of the node that last served the coins
+	// of the account.
+	accountHeights map[string]uint64
+}
+
+// New creates a pool of the fullnodes at urls, the first ones being preferred for the txs. The
+// nodes are all deemed healthy until the first probe.
+func New(urls []string, cfg Config) (*Pool, error) {
+	return newPool(urls, cfg, dialIncClient)
+}
+
+func dialIncClient(url string) (Client, error) {
+	client, err := incclient.NewIncClient(url, "", 2)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func newPool(urls []string, cfg Config, dial func(url string) (Client, error)) (*Pool, error) {
+	p := &Pool{cfg: cfg.withDefaults(), dial: dial, accountHeights: make(map[string]uint64)}
+	seen := make(map[string]bool)
+	for _, url := range urls {
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+		p.nodes = append(p.nodes, &node{url: url, healthy: true, changedAt: time.Now()})
+	}
+	if len(p.nodes) == 0 {
+		return nil, fmt.Errorf("nodepool: no fullnode configured")
+	}
+	for _, n := range p.nodes {
+		if err := p.connect(n); err != nil {
+			log.Printf("nodepool: %v: %v\n", n.url, err)
+		}
+	}
+	return p, nil
+}
+
+// connect creates the client of n, until it succeeds.
+func (p *Pool) connect(n *node) error {
+	if n.client != nil {
+		return nil
+	}
+	client, err := p.dial(n.url)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	n.client = client
+	p.lock.Unlock()
+	return nil
+}
+
+// Start probes the nodes now and then every ProbeInterval.
+func (p *Pool) Start() {
+	t := time.NewTicker(time.Duration(p.cfg.ProbeInterval) * time.Second)
+	defer t.Stop()
+	for {
+		p.probe()
+		<-t.C
+	}
+}
+
+func (p *Pool) probe() {
+	var wg sync.WaitGroup
+	heights := make([]uint64, len(p.nodes))
+	errs := make([]error, len(p.nodes))
+	for i, n := range p.nodes {
+		wg.Add(1)
+		go func(i int, n *node) {
+			defer wg.Done()
+			if errs[i] = p.connect(n); errs[i] != nil {
+				return
+			}
+			var best map[int]uint64
+			best, errs[i] = n.client.GetBestBlock()
+			heights[i] = best[beaconHeight]
+		}(i, n)
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	highest := uint64(0)
+	for i, n := range p.nodes {
+		n.probeErr = errs[i]
+		if errs[i] != nil {
+			continue
+		}
+		if heights[i] != n.height {
+			n.height = heights[i]
+			n.changedAt = now
+		}
+		if n.height > highest {
+			highest = n.height
+		}
+	}
+	stallTimeout := time.Duration(p.cfg.StallTimeout) * time.Second
+	for _, n := range p.nodes {
+		switch {
+		case n.probeErr != nil:
+			n.healthy = false
+		case n.height+p.cfg.MaxLag < highest:
+			n.healthy = false
+		case now.Sub(n.changedAt) > stallTimeout && n.height < highest:
+			n.healthy = false
+		default:
+			n.healthy = true
+		}
+		if !n.healthy {
+			log.Printf("nodepool: %v unhealthy: %v\n", n.url, n.describe(highest))
+		}
+	}
+}
+
+func (n *node) describe(highest uint64) string {
+	if n.probeErr != nil {
+		return n.probeErr.Error()
+	}
+	return fmt.Sprintf("beacon at %v, highest %v, last block %v ago", n.height, highest, time.Since(n.changedAt).Round(time.Second))
+}
+
+// candidates returns the nodes to try in order: the healthy ones, starting from the next in turn
+// when spread, then the others as a last resort.
+func (p *Pool) candidates(spread bool) []*node {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	healthy := []*node{}
+	others := []*node{}
+	for _, n := range p.nodes {
+		if n.client == nil {
+			continue
+		}
+		if n.healthy {
+			healthy = append(healthy, n)
+		} else {
+			others = append(others, n)
+		}
+	}
+	if spread && len(healthy) > 1 {
+		start := p.next % len(healthy)
+		p.next++
+		healthy = append(healthy[start:], healthy[:start]...)
+	}
+	return append(healthy, others...)
+}
+
+// do calls fn with the candidate nodes in turn until one succeeds, returning the last error.
+func (p *Pool) do(spread bool, fn func(c Client) error) error {
+	err := fmt.Errorf("nodepool: no fullnode available")
+	for _, n := range p.candidates(spread) {
+		if err = fn(n.client); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (p *Pool) read(fn func(c Client) error) error {
+	return p.do(true, fn)
+}
+
+// readAccount reads the coins of the account of privateKey. They come from the nodes in the order
+// the txs are sent through, so that the account sees its pending txs as the node it sends to does,
+// and never from a node behind the one that served them last: a coin spent in between would come
+// back as unspent.
+func (p *Pool) readAccount(privateKey string, fn func(c Client) error) error {
+	err := fmt.Errorf("nodepool: no fullnode available")
+	for _, n := range p.candidates(false) {
+		p.lock.RLock()
+		height, lastHeight := n.height, p.accountHeights[privateKey]
+		p.lock.RUnlock()
+		if height < lastHeight {
+			err = fmt.Errorf("nodepool: %v at beacon %v is behind the coins of the account, read at %v", n.url, height, lastHeight)
+			continue
+		}
+		if err = fn(n.client); err == nil {
+			p.lock.Lock()
+			if height > p.accountHeights[privateKey] {
+				p.accountHeights[privateKey] = height
+			}
+			p.lock.Unlock()
+			return nil
+		}
+	}
+	return err
+}
+
+// send sends a tx through the first node that takes it, and with Broadcast to the other healthy
+// nodes too, whose answers are only logged.
+func (p *Pool) send(fn func(c Client) error) error {
+	var sentBy Client
+	err := p.do(false, func(c Client) error {
+		err := fn(c)
+		if err == nil {
+			sentBy = c
+		}
+		return err
+	})
+	if err != nil || !p.cfg.Broadcast {
+		return err
+	}
+	for _, n := range p.candidates(false) {
+		if n.client == sentBy || !n.healthy {
+			continue
+		}
+		go func(n *node) {
+			if err := fn(n.client); err != nil && !strings.Contains(err.Error(), "already") {
+				log.Printf("nodepool: broadcast to %v: %v\n", n.url, err)
+			}
+		}(n)
+	}
+	return nil
+}
+
+// URL returns the URL of the node the next read would go to, for the RPCs the SDK does not wrap.
+func (p *Pool) URL() string {
+	candidates := p.candidates(true)
+	if len(candidates) == 0 {
+		return p.nodes[0].url
+	}
+	return candidates[0].url
+}
+
+// Check reports the state of every node, failing when none is healthy. It is a health.Check.
+func (p *Pool) Check() (string, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	highest := uint64(0)
+	for _, n := range p.nodes {
+		if n.probeErr == nil && n.height > highest {
+			highest = n.height
+		}
+	}
+	parts := []string{}
+	healthy := 0
+	for _, n := range p.nodes {
+		if n.healthy && n.client != nil {
+			healthy++
+			parts = append(parts, fmt.Sprintf("%v: beacon at %v", n.url, n.height))
+		} else {
+			parts = append(parts, fmt.Sprintf("%v: unhealthy, %v", n.url, n.describe(highest)))
+		}
+	}
+	detail := strings.Join(parts, "; ")
+	if healthy == 0 {
+		return detail, fmt.Errorf("no healthy fullnode out of %v", len(p.nodes))
+	}
+	return detail, nil
+}
+
+// SubmitKey submits otaKey to every node, since any may serve the coins of the key later. It only
+// fails when none took it.
+func (p *Pool) SubmitKey(otaKey string) error {
+	var lastErr error
+	submitted := 0
+	for _, n := range p.candidates(false) {
+		if err := n.client.SubmitKey(otaKey); err != nil {
+			log.Printf("nodepool: SubmitKey to %v: %v\n", n.url, err)
+			lastErr = err
+			continue
+		}
+		submitted++
+	}
+	if submitted == 0 && lastErr != nil {
+		return lastErr
+	}
+	return nil
+}
+
+func (p *Pool) GetBestBlock() (res map[int]uint64, err error) {
+	err = p.read(func(c Client) (err error) {
+		res, err = c.GetBestBlock()
+		return err
+	})
+	return res, err
+}
+
+func (p *Pool) GetUnspentOutputCoins(privateKey, tokenID string, height uint64) (coins []coin.PlainCoin, indices []*big.Int, err error) {
+	err = p.readAccount(privateKey, func(c Client) (err error) {
+		coins, indices, err = c.GetUnspentOutputCoins(privateKey, tokenID, height)
+		return err
+	})
+	return coins, indices, err
+}
+
+func (p *Pool) GetSpentOutputCoins(privateKey, tokenID string, height uint64) (coins []coin.PlainCoin, indices []*big.Int, err error) {
+	err = p.readAccount(privateKey, func(c Client) (err error) {
+		coins, indices, err = c.GetSpentOutputCoins(privateKey, tokenID, height)
+		return err
+	})
+	return coins, indices, err
+}
+
+func (p *Pool) GetAllUTXOsV2(privateKey string) (coins map[string][]coin.PlainCoin, indices map[string][]*big.Int, err error) {
+	err = p.readAccount(privateKey, func(c Client) (err error) {
+		coins, indices, err = c.GetAllUTXOsV2(privateKey)
+		return err
+	})
+	return coins, indices, err
+}
+
+func (p *Pool) GetBalance(privateKey, tokenID string) (balance uint64, err error) {
+	err = p.readAccount(privateKey, func(c Client) (err error) {
+		balance, err = c.GetBalance(privateKey, tokenID)
+		return err
+	})
+	return balance, err
+}
+
+func (p *Pool) GetListNftIDs(beaconHeight uint64) (res map[string]uint64, err error) {
+	err = p.read(func(c Client) (err error) {
+		res, err = c.GetListNftIDs(beaconHeight)
+		return err
+	})
+	return res, err
+}
+
+// GetMinPRVRequiredToMintNFT hides the errors as the SDK does, so a zero answer counts as one.
+func (p *Pool) GetMinPRVRequiredToMintNFT(beaconHeight uint64) (res uint64) {
+	p.read(func(c Client) error {
+		if res = c.GetMinPRVRequiredToMintNFT(beaconHeight); res == 0 {
+			return fmt.Errorf("no minimum to mint an NFT")
+		}
+		return nil
+	})
+	return res
+}
+
+func (p *Pool) CheckTxInBlock(txHash string) (inBlock bool, err error) {
+	err = p.read(func(c Client) (err error) {
+		inBlock, err = c.CheckTxInBlock(txHash)
+		return err
+	})
+	return inBlock, err
+}
+
+func (p *Pool) CheckCoinsSpent(shardID byte, tokenID string, snList []string) (spent []bool, err error) {
+	err = p.read(func(c Client) (err error) {
+		spent, err = c.CheckCoinsSpent(shardID, tokenID, snList)
+		return err
+	})
+	return spent, err
+}
+
+func (p *Pool) GetTxHashBySerialNumbers(snList []string, tokenID string, shardID byte) (res map[string]string, err error) {
+	err = p.read(func(c Client) (err error) {
+		res, err = c.GetTxHashBySerialNumbers(snList, tokenID, shardID)
+		return err
+	})
+	return res, err
+}
+
+// CreateRawTransaction, like the other tx creations, picks the coins of the tx on the node it is
+// sent through.
+func (p *Pool) CreateRawTransaction(param *incclient.TxParam, version int8) (encodedTx []byte, txHash string, err error) {
+	err = p.do(false, func(c Client) (err error) {
+		encodedTx, txHash, err = c.CreateRawTransaction(param, version)
+		return err
+	})
+	return encodedTx, txHash, err
+}
+
+func (p *Pool) CreateRawTokenTransaction(param *incclient.TxParam, version int8) (encodedTx []byte, txHash string, err error) {
+	err = p.do(false, func(c Client) (err error) {
+		encodedTx, txHash, err = c.CreateRawTokenTransaction(param, version)
+		return err
+	})
+	return encodedTx, txHash, err
+}
+
+func (p *Pool) CreateRawTransactionWithInputCoins(param *incclient.TxParam, coins []coin.PlainCoin, indices []uint64) (encodedTx []byte, txHash string, err error) {
+	err = p.do(false, func(c Client) (err error) {
+		encodedTx, txHash, err = c.CreateRawTransactionWithInputCoins(param, coins, indices)
+		return err
+	})
+	return encodedTx, txHash, err
+}
+
+func (p *Pool) CreateRawTokenTransactionWithInputCoins(param *incclient.TxParam, tokenCoins []coin.PlainCoin, tokenIndices []uint64, prvCoins []coin.PlainCoin, prvIndices []uint64) (encodedTx []byte, txHash string, err error) {
+	err = p.do(false, func(c Client) (err error) {
+		encodedTx, txHash, err = c.CreateRawTokenTransactionWithInputCoins(param, tokenCoins, tokenIndices, prvCoins, prvIndices)
+		return err
+	})
+	return encodedTx, txHash, err
+}
+
+// CreateAndSendRawTransaction goes to the primary node without broadcast, since a retry elsewhere
+// would create another tx.
+func (p *Pool) CreateAndSendRawTransaction(privateKey string, addrList []string, amountList []uint64, version int8, md metadata.Metadata) (txHash string, err error) {
+	candidates := p.candidates(false)
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("nodepool: no fullnode available")
+	}
+	return candidates[0].client.CreateAndSendRawTransaction(privateKey, addrList, amountList, version, md)
+}
+
+func (p *Pool) SendRawTx(encodedTx []byte) error {
+	return p.send(func(c Client) error {
+		return c.SendRawTx(encodedTx)
+	})
+}
+
+func (p *Pool) SendRawTokenTx(encodedTx []byte) error {
+	return p.send(func(c Client) error {
+		return c.SendRawTokenTx(encodedTx)
+	})
+}
@@ -0,0 +1,202 @@
+package nodepool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+)
+
+// fakeClient is a fullnode at height, failing every call with err. It logs the calls it served in
+// served.
+type fakeClient struct {
+	Client
+	url    string
+	lock   sync.Mutex
+	height uint64
+	err    error
+	served *[]string
+}
+
+func (c *fakeClient) answer(call string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	*c.served = append(*c.served, fmt.Sprintf("%v %v", call, c.url))
+	return nil
+}
+
+func (c *fakeClient) GetBestBlock() (map[int]uint64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return map[int]uint64{beaconHeight: c.height}, nil
+}
+
+func (c *fakeClient) CheckTxInBlock(txHash string) (bool, error) {
+	return true, c.answer("check")
+}
+
+func (c *fakeClient) GetUnspentOutputCoins(privateKey, tokenID string, height uint64) ([]coin.PlainCoin, []*big.Int, error) {
+	return nil, nil, c.answer("coins")
+}
+
+func (c *fakeClient) SendRawTx(encodedTx []byte) error {
+	return c.answer("send")
+}
+
+func (c *fakeClient) set(height uint64, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.height = height
+	c.err = err
+}
+
+func newTestPool(t *testing.T, heights ...uint64) (*Pool, []*fakeClient, *[]string) {
+	served := &[]string{}
+	clients := make(map[string]*fakeClient)
+	urls := []string{}
+	for i, height := range heights {
+		url := fmt.Sprint(i)
+		clients[url] = &fakeClient{url: url, height: height, served: served}
+		urls = append(urls, url)
+	}
+	p, err := newPool(urls, Config{}, func(url string) (Client, error) {
+		return clients[url], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []*fakeClient{}
+	for _, url := range urls {
+		list = append(list, clients[url])
+	}
+	return p, list, served
+}
+
+func (p *Pool) healthy() string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	res := ""
+	for _, n := range p.nodes {
+		res += fmt.Sprint(n.healthy)[:1]
+	}
+	return res
+}
+
+func TestProbe(t *testing.T) {
+	p, clients, _ := newTestPool(t, 100, 98, 90, 100)
+	clients[3].set(100, errors.New("connection refused"))
+	p.probe()
+	// within MaxLag, too far behind, down
+	if got := p.healthy(); got != "ttff" {
+		t.Fatalf("healthy %v", got)
+	}
+	if _, err := p.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a node back in sync is healthy again
+	clients[2].set(100, nil)
+	p.probe()
+	if got := p.healthy(); got != "tttf" {
+		t.Fatalf("healthy %v", got)
+	}
+
+	for _, c := range clients {
+		c.set(0, errors.New("down"))
+	}
+	p.probe()
+	if _, err := p.Check(); err == nil {
+		t.Fatal("no healthy node reported healthy")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	p, clients, served := newTestPool(t, 100, 100, 90)
+	p.probe()
+
+	// the reads are spread over the healthy nodes only
+	for i := 0; i < 4; i++ {
+		if _, err := p.CheckTxInBlock("tx"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(*served) != "[check 0 check 1 check 0 check 1]" {
+		t.Fatalf("reads served by %v", *served)
+	}
+
+	// the txs go through the first node, then the next healthy one, then the unhealthy one
+	*served = nil
+	if err := p.SendRawTx(nil); err != nil {
+		t.Fatal(err)
+	}
+	clients[0].set(100, errors.New("down"))
+	if err := p.SendRawTx(nil); err != nil {
+		t.Fatal(err)
+	}
+	clients[1].set(100, errors.New("down"))
+	if err := p.SendRawTx(nil); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(*served) != "[send 0 send 1 send 2]" {
+		t.Fatalf("txs sent through %v", *served)
+	}
+
+	clients[2].set(90, errors.New("down"))
+	if err := p.SendRawTx(nil); err == nil {
+		t.Fatal("tx sent with every node down")
+	}
+}
+
+func TestAccountReads(t *testing.T) {
+	p, clients, served := newTestPool(t, 100, 100, 100)
+	p.probe()
+
+	// the coins of an account are read where its txs are sent, not spread
+	for i := 0; i < 2; i++ {
+		if _, _, err := p.GetUnspentOutputCoins("key", "prv", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(*served) != "[coins 0 coins 0]" {
+		t.Fatalf("coins served by %v", *served)
+	}
+
+	// the first node moves on, then fails: the second one is behind the coins read from it
+	clients[0].set(105, nil)
+	p.probe()
+	if _, _, err := p.GetUnspentOutputCoins("key", "prv", 0); err != nil {
+		t.Fatal(err)
+	}
+	clients[0].set(105, errors.New("down"))
+	clients[2].set(105, nil)
+	p.probe()
+	*served = nil
+	if _, _, err := p.GetUnspentOutputCoins("key", "prv", 0); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(*served) != "[coins 2]" {
+		t.Fatalf("coins served by %v", *served)
+	}
+
+	// with the lagging node left alone, the account does not read from it, another account does
+	clients[2].set(105, errors.New("down"))
+	if _, _, err := p.GetUnspentOutputCoins("key", "prv", 0); err == nil {
+		t.Fatal("coins read from a node behind them")
+	}
+	*served = nil
+	if _, _, err := p.GetUnspentOutputCoins("other", "prv", 0); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(*served) != "[coins 1]" {
+		t.Fatalf("coins of another account served by %v", *served)
+	}
+}
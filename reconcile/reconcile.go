@@ -0,0 +1,165 @@
+package reconcile
+
+import (
+	"fmt"
+	"main/ledger"
+	"math/big"
+	"strings"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/coin"
+	"github.com/incognitochain/go-incognito-sdk-v2/common"
+	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
+)
+
+// Statuses of an airdrop tx, as stored in AirdropTxDetail.Status.
+const (
+	StatusSent      = 1
+	StatusConfirmed = 2
+	StatusFailed    = 3
+	StatusReplaced  = 4
+)
+
+// Node is the part of the fullnode client used to reconcile records with the chain.
+type Node interface {
+	CheckTxInBlock(txHash string) (bool, error)
+	GetSpentOutputCoins(privateKey, tokenID string, height uint64) ([]coin.PlainCoin, []*big.Int, error)
+	GetTxHashBySerialNumbers(snList []string, tokenID string, shardID byte) (map[string]string, error)
+}
+
+// TxRecord is a stored tx to check against the chain and the ledger.
+type TxRecord struct {
+	Owner  string
+	TxHash string
+	Status int
+	// Amount is what the tx gives Owner, fee excluded, as the ledger records it.
+	Amount uint64
+}
+
+// LedgerTx sums up the ledger entries of a tx.
+type LedgerTx struct {
+	// Amount is the total of the broadcast entries.
+	Amount uint64
+	// Settled is the last final event recorded, empty while the tx is only broadcast.
+	Settled string
+}
+
+// Discrepancy is a difference between the stored records and the chain. A zero NewStatus means
+// it cannot be corrected automatically and is only reported.
+type Discrepancy struct {
+	Owner     string
+	TxHash    string
+	OldStatus int
+	NewStatus int
+	Reason    string
+}
+
+func (d Discrepancy) String() string {
+	if d.NewStatus == 0 {
+		return fmt.Sprintf("%v (owner %v, status %v): %v", d.TxHash, d.Owner, d.OldStatus, d.Reason)
+	}
+	return fmt.Sprintf("%v (owner %v): status %v -> %v, %v", d.TxHash, d.Owner, d.OldStatus, d.NewStatus, d.Reason)
+}
+
+// CheckTxs re-checks every record on chain. isPending tells which txs are still watched by the
+// tracker, these are left alone.
+func CheckTxs(node Node, records []TxRecord, isPending func(txHash string) bool) []Discrepancy {
+	result := []Discrepancy{}
+	for _, r := range records {
+		if isPending != nil && isPending(r.TxHash) {
+			continue
+		}
+		isInBlock, err := node.CheckTxInBlock(r.TxHash)
+		switch {
+		case err == nil && isInBlock && r.Status != StatusConfirmed:
+			result = append(result, Discrepancy{r.Owner, r.TxHash, r.Status, StatusConfirmed, "tx is in a block"})
+		case err == nil && !isInBlock:
+			// still in the mempool, nothing to decide yet
+		case err != nil && r.Status == StatusConfirmed:
+			result = append(result, Discrepancy{r.Owner, r.TxHash, r.Status, 0, "confirmed tx unknown to the fullnode: " + err.Error()})
+		case err != nil && r.Status == StatusSent:
+			result = append(result, Discrepancy{r.Owner, r.TxHash, r.Status, StatusFailed, "sent tx unknown to the fullnode"})
+		}
+	}
+	return result
+}
+
+// LedgerTxs groups ledger entries by tx.
+func LedgerTxs(entries []ledger.Entry) map[string]LedgerTx {
+	result := make(map[string]LedgerTx)
+	for _, e := range entries {
+		tx := result[e.TxHash]
+		if e.Event == ledger.EventBroadcast {
+			tx.Amount += e.Amount
+		} else {
+			tx.Settled = e.Event
+		}
+		result[e.TxHash] = tx
+	}
+	return result
+}
+
+// CheckLedger compares the records with the ledger: a tx confirmed in the ledger is confirmed in
+// its record, a confirmed record has ledger entries and both agree on the amount. Only the status
+// of a record is corrected, from the ledger's.
+func CheckLedger(records []TxRecord, txs map[string]LedgerTx) []Discrepancy {
+	result := []Discrepancy{}
+	for _, r := range records {
+		tx, ok := txs[r.TxHash]
+		if !ok {
+			// txs confirmed before the ledger existed show up here too
+			if r.Status == StatusConfirmed {
+				result = append(result, Discrepancy{r.Owner, r.TxHash, r.Status, 0, "confirmed tx with no ledger entry"})
+			}
+			continue
+		}
+		if tx.Settled == ledger.EventConfirmed && r.Status != StatusConfirmed {
+			result = append(result, Discrepancy{r.Owner, r.TxHash, r.Status, StatusConfirmed, "confirmed in the ledger"})
+		}
+		if tx.Amount != r.Amount {
+			result = append(result, Discrepancy{r.Owner, r.TxHash, r.Status, 0, fmt.Sprintf("amount %v, %v in the ledger", r.Amount, tx.Amount)})
+		}
+	}
+	return result
+}
+
+// FindUnknownTxs scans the PRV coins spent by an account and reports the txs spending them that
+// are not in known.
+func FindUnknownTxs(node Node, privateKey string, shardID byte, known map[string]bool) ([]Discrepancy, error) {
+	spentCoins, _, err := node.GetSpentOutputCoins(privateKey, common.PRVIDStr, 0)
+	if err != nil {
+		return nil, err
+	}
+	snList := []string{}
+	for _, c := range spentCoins {
+		if c.GetVersion() != 2 {
+			continue
+		}
+		snList = append(snList, base58.Base58Check{}.Encode(c.GetKeyImage().ToBytesS(), common.ZeroByte))
+	}
+	if len(snList) == 0 {
+		return nil, nil
+	}
+	txHashes, err := node.GetTxHashBySerialNumbers(snList, common.PRVIDStr, shardID)
+	if err != nil {
+		return nil, err
+	}
+	result := []Discrepancy{}
+	reported := make(map[string]bool)
+	for _, txHash := range txHashes {
+		if txHash == "" || known[txHash] || reported[txHash] {
+			continue
+		}
+		reported[txHash] = true
+		result = append(result, Discrepancy{TxHash: txHash, Reason: "outgoing tx with no record"})
+	}
+	return result, nil
+}
+
+// Summary formats discrepancies for logs and notifications.
+func Summary(name string, discrepancies []Discrepancy) string {
+	lines := []string{fmt.Sprintf("reconcile %v: %v discrepancies", name, len(discrepancies))}
+	for _, d := range discrepancies {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,110 @@
+package reconcile
+
+import (
+	"errors"
+	"main/ledger"
+	"testing"
+)
+
+// fakeNode knows the txs of inBlock, true once in a block, and none other.
+type fakeNode struct {
+	Node
+	inBlock map[string]bool
+}
+
+func (n fakeNode) CheckTxInBlock(txHash string) (bool, error) {
+	isInBlock, ok := n.inBlock[txHash]
+	if !ok {
+		return false, errors.New("tx not found")
+	}
+	return isInBlock, nil
+}
+
+func TestCheckTxs(t *testing.T) {
+	node := fakeNode{inBlock: map[string]bool{"landed": true, "mempool": false}}
+	for _, tc := range []struct {
+		name   string
+		record TxRecord
+		want   int // the NewStatus of the discrepancy, -1 for none
+	}{
+		{"sent, in a block", TxRecord{TxHash: "landed", Status: StatusSent}, StatusConfirmed},
+		{"failed, in a block", TxRecord{TxHash: "landed", Status: StatusFailed}, StatusConfirmed},
+		{"confirmed, in a block", TxRecord{TxHash: "landed", Status: StatusConfirmed}, -1},
+		{"sent, in the mempool", TxRecord{TxHash: "mempool", Status: StatusSent}, -1},
+		{"sent, unknown", TxRecord{TxHash: "lost", Status: StatusSent}, StatusFailed},
+		{"confirmed, unknown", TxRecord{TxHash: "lost", Status: StatusConfirmed}, 0},
+		{"failed, unknown", TxRecord{TxHash: "lost", Status: StatusFailed}, -1},
+		{"still tracked", TxRecord{TxHash: "tracked", Status: StatusSent}, -1},
+	} {
+		got := CheckTxs(node, []TxRecord{tc.record}, func(txHash string) bool { return txHash == "tracked" })
+		if tc.want == -1 {
+			if len(got) != 0 {
+				t.Errorf("%v: %v", tc.name, got)
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].NewStatus != tc.want || got[0].OldStatus != tc.record.Status {
+			t.Errorf("%v: %v, want status %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLedgerTxs(t *testing.T) {
+	txs := LedgerTxs([]ledger.Entry{
+		{TxHash: "a", Event: ledger.EventBroadcast, Amount: 5},
+		{TxHash: "a", Event: ledger.EventBroadcast, Amount: 7},
+		{TxHash: "b", Event: ledger.EventBroadcast, Amount: 3},
+		{TxHash: "a", Event: ledger.EventConfirmed, Amount: 5},
+		{TxHash: "a", Event: ledger.EventConfirmed, Amount: 7},
+		{TxHash: "b", Event: ledger.EventReplaced, Amount: 3},
+		{TxHash: "c", Event: ledger.EventBroadcast, Amount: 3},
+	})
+	want := map[string]LedgerTx{"a": {12, ledger.EventConfirmed}, "b": {3, ledger.EventReplaced}, "c": {3, ""}}
+	if len(txs) != len(want) {
+		t.Fatalf("txs %+v", txs)
+	}
+	for txHash, tx := range want {
+		if txs[txHash] != tx {
+			t.Errorf("%v: %+v, want %+v", txHash, txs[txHash], tx)
+		}
+	}
+}
+
+func TestCheckLedger(t *testing.T) {
+	txs := map[string]LedgerTx{
+		"confirmed": {Amount: 10, Settled: ledger.EventConfirmed},
+		"failed":    {Amount: 10, Settled: ledger.EventFailed},
+		"pending":   {Amount: 10},
+	}
+	type result struct {
+		newStatus int
+		reason    string
+	}
+	for _, tc := range []struct {
+		name   string
+		record TxRecord
+		want   []result
+	}{
+		{"agree", TxRecord{TxHash: "confirmed", Status: StatusConfirmed, Amount: 10}, nil},
+		{"agree, pending", TxRecord{TxHash: "pending", Status: StatusSent, Amount: 10}, nil},
+		{"agree, failed", TxRecord{TxHash: "failed", Status: StatusFailed, Amount: 10}, nil},
+		{"not marked paid", TxRecord{TxHash: "confirmed", Status: StatusSent, Amount: 10}, []result{{StatusConfirmed, "confirmed in the ledger"}}},
+		{"marked failed", TxRecord{TxHash: "confirmed", Status: StatusFailed, Amount: 10}, []result{{StatusConfirmed, "confirmed in the ledger"}}},
+		{"paid, no ledger entry", TxRecord{TxHash: "unknown", Status: StatusConfirmed, Amount: 10}, []result{{0, "confirmed tx with no ledger entry"}}},
+		{"sent, no ledger entry", TxRecord{TxHash: "unknown", Status: StatusSent, Amount: 10}, nil},
+		{"amount mismatch", TxRecord{TxHash: "confirmed", Status: StatusConfirmed, Amount: 9}, []result{{0, "amount 9, 10 in the ledger"}}},
+		{"both", TxRecord{TxHash: "confirmed", Status: StatusSent, Amount: 11}, []result{{StatusConfirmed, "confirmed in the ledger"}, {0, "amount 11, 10 in the ledger"}}},
+	} {
+		tc.record.Owner = "owner"
+		got := CheckLedger([]TxRecord{tc.record}, txs)
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: %v", tc.name, got)
+			continue
+		}
+		for i, d := range got {
+			if d.NewStatus != tc.want[i].newStatus || d.Reason != tc.want[i].reason || d.Owner != "owner" || d.OldStatus != tc.record.Status {
+				t.Errorf("%v: %v", tc.name, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package schema
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"main/storage"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+)
+
+// BackfillPubkey sets the Pubkey of a user record stored without it from its PaymentAddress.
+func BackfillPubkey(record map[string]interface{}) error {
+	if pubkey, _ := record["Pubkey"].(string); pubkey != "" {
+		return nil
+	}
+	paymentAddress, _ := record["PaymentAddress"].(string)
+	wl, err := wallet.Base58CheckDeserialize(paymentAddress)
+	if err != nil {
+		return fmt.Errorf("invalid payment address %v: %v", paymentAddress, err)
+	}
+	record["Pubkey"] = base58.Base58Check{}.Encode(wl.KeySet.PaymentAddress.Pk, 0)
+	return nil
+}
+
+// Report counts the records Migrate went through.
+type Report struct {
+	Total      int
+	Upgraded   int
+	Unreadable int
+}
+
+// Migrate upgrades every record of c to the current version at once instead of as the service
+// loads them, storing them back unless dryRun. The records that cannot be upgraded are logged and
+// left as they are.
+func (r *Registry) Migrate(c storage.Collection, dryRun bool) (Report, error) {
+	var report Report
+	upgraded := make(map[string]json.RawMessage)
+	err := c.Scan("", "", func(id string, data []byte) error {
+		report.Total++
+		var record json.RawMessage
+		isUpgraded, err := r.Decode(data, &record)
+		if err != nil {
+			report.Unreadable++
+			log.Printf("%v record %v: %v\n", r.name, id, err)
+			return nil
+		}
+		if isUpgraded {
+			report.Upgraded++
+			upgraded[id] = record
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return report, err
+	}
+	for id, record := range upgraded {
+		if err := c.Put(id, &Envelope{Version: r.Version(), Record: record}); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// RunMigrateCommand is the `db migrate` subcommand, Migrate on the records of c.
+func (r *Registry) RunMigrateCommand(c storage.Collection, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report the records to upgrade")
+	fs.Parse(args)
+
+	report, err := r.Migrate(c, *dryRun)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v records: %v total, %v upgraded to version %v, %v unreadable\n", r.name, report.Total, report.Upgraded, r.Version(), report.Unreadable)
+	return nil
+}
@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"encoding/json"
+	"main/storage"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// the payment address of the private key 1111111U1tofCB5sj3oKYgHbr6PXGtub7WTdKN2KcUdACTBN9GH5RYoAAYmeTgF6F6cfZ6HvYjSMiWWhfkLeGXD4Kw5auCFUqnaGrso7Eg
+const (
+	testPaymentAddress = "12svn1DAWMmVYyRDyak9Lf39TrFf3bZkcAbBDnDnmKnX1BQzCR5ithWEgEgU4UsUYhqsQZpjswZf1tCtgGjeyNLxbrjBoG5LnEAPKua4pPS77CDyPLu2skUtNUJ2Y7VEuDxvUX8rjTieBmkcfFAj"
+	testPubkey         = "12mKxyf3zV7jywjRuauJUbxrYwg78MBdRKxgpLYPHFHhcf93f1s"
+)
+
+type testUser struct {
+	PaymentAddress string
+	Pubkey         string
+	AirdropSuccess bool
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	users := storage.NewLevelDB(db).Collection("users")
+	r := NewRegistry("user", BackfillPubkey)
+
+	// version 0: the records as the faucet stored them before the envelopes
+	fixture := map[string]string{
+		"a": `{"PaymentAddress":"` + testPaymentAddress + `","AirdropSuccess":true}`,
+		"b": `{"PaymentAddress":"b","Pubkey":"pk"}`,
+		"c": `{"PaymentAddress":"invalid"}`,
+	}
+	for id, record := range fixture {
+		if err := users.Put(id, json.RawMessage(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := users.Put("d", &Envelope{Version: 1, Record: json.RawMessage(`{"PaymentAddress":"d","Pubkey":"pk"}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Report{Total: 4, Upgraded: 2, Unreadable: 1}
+	if report, err := r.Migrate(users, true); err != nil || report != want {
+		t.Fatalf("dry run: %+v, %v", report, err)
+	}
+	var env Envelope
+	if err := users.Get("a", &env); err != nil || env.Record != nil {
+		t.Fatalf("dry run stored %+v, %v", env, err)
+	}
+
+	if report, err := r.Migrate(users, false); err != nil || report != want {
+		t.Fatalf("migrate: %+v, %v", report, err)
+	}
+	for id, pubkey := range map[string]string{"a": testPubkey, "b": "pk"} {
+		var env Envelope
+		if err := users.Get(id, &env); err != nil || env.Version != 1 {
+			t.Fatalf("%v: stored %+v, %v", id, env, err)
+		}
+		var user testUser
+		if err := json.Unmarshal(env.Record, &user); err != nil {
+			t.Fatal(err)
+		}
+		if user.Pubkey != pubkey || (id == "a" && !user.AirdropSuccess) {
+			t.Fatalf("%v: migrated to %+v", id, user)
+		}
+	}
+	// the unreadable record is left for an operator to look at
+	var raw json.RawMessage
+	if err := users.Get("c", &raw); err != nil || string(raw) != fixture["c"] {
+		t.Fatalf("c: %s, %v", raw, err)
+	}
+
+	if report, err := r.Migrate(users, false); err != nil || report != (Report{Total: 4, Unreadable: 1}) {
+		t.Fatalf("second run: %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,81 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Envelope wraps a stored record with the version of its schema. Records stored before envelopes
+// existed are version 0.
+type Envelope struct {
+	Version int
+	Record  json.RawMessage
+}
+
+// Migration upgrades a decoded record by one version, in place. Numbers are json.Number.
+type Migration func(record map[string]interface{}) error
+
+// Registry upgrades the records of one kind to its current version: migrations[i] upgrades
+// version i to i+1.
+type Registry struct {
+	name       string
+	migrations []Migration
+}
+
+func NewRegistry(name string, migrations ...Migration) *Registry {
+	return &Registry{name: name, migrations: migrations}
+}
+
+// Version is the current schema version.
+func (r *Registry) Version() int {
+	return len(r.migrations)
+}
+
+// Wrap returns the envelope to store v under.
+func (r *Registry) Wrap(v interface{}) (*Envelope, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Version: r.Version(), Record: data}, nil
+}
+
+// Decode upgrades a stored record to the current version and decodes it into v. It tells whether
+// the record was upgraded, so that the caller can store it back.
+func (r *Registry) Decode(data []byte, v interface{}) (bool, error) {
+	var env struct {
+		Version *int
+		Record  json.RawMessage
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return false, err
+	}
+	version, record := 0, json.RawMessage(data)
+	if env.Version != nil && env.Record != nil {
+		version, record = *env.Version, env.Record
+	}
+	if version > r.Version() {
+		return false, fmt.Errorf("%v record version %v is newer than %v", r.name, version, r.Version())
+	}
+	upgraded := version < r.Version()
+	if upgraded {
+		fields := make(map[string]interface{})
+		dec := json.NewDecoder(bytes.NewReader(record))
+		dec.UseNumber()
+		if err := dec.Decode(&fields); err != nil {
+			return false, err
+		}
+		for ; version < r.Version(); version++ {
+			if err := r.migrations[version](fields); err != nil {
+				return false, fmt.Errorf("%v migration %v->%v: %v", r.name, version, version+1, err)
+			}
+		}
+		var err error
+		record, err = json.Marshal(fields)
+		if err != nil {
+			return false, err
+		}
+	}
+	return upgraded, json.Unmarshal(record, v)
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"main/backup"
+	"main/ledger"
+	"main/storage"
+)
+
+// ErrUnknownCommand is returned by the RunCommand of a service for a command it does not have.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// DB is what the db subcommands of a service work on.
+type DB struct {
+	// Store is the open db, nil for Restore, which replaces it.
+	Store storage.Store
+	// Backend is the storage backend of the db, as in storage.Config. Only a leveldb is restored.
+	Backend string
+	// Path is the leveldb directory of the db.
+	Path string
+	// BackupDir is where the archives go unless told otherwise, named after BackupPrefix.
+	BackupDir    string
+	BackupPrefix string
+	// UserCollection holds the user records, which DecodeUser checks after a restore.
+	UserCollection string
+	DecodeUser     func(data []byte) error
+}
+
+// Backup is the `db backup` subcommand, a one-off snapshot while the service is stopped.
+func (db DB) Backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := fs.String("dir", db.BackupDir, "directory of the archive")
+	fs.Parse(args)
+	if *dir == "" {
+		return errors.New("backup: -dir is required when BackupDir is not configured")
+	}
+
+	path, info, err := backup.Snapshot(db.Store, *dir, db.BackupPrefix)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %v records to %v (sha256 %v)\n", info.Records, path, info.Checksum)
+	return nil
+}
+
+// Restore is the `db restore` subcommand. The db is only replaced once the archive passed its
+// checksum and its records can be read back. A Mongo db is restored with its own tooling: the
+// archive would go to a leveldb the service does not read.
+func (db DB) Restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	file := fs.String("file", "", "archive to restore")
+	fs.Parse(args)
+	if db.Backend != "" && db.Backend != "leveldb" {
+		return fmt.Errorf("restore: %w: the %v backend is restored with mongorestore", backup.ErrUnsupported, db.Backend)
+	}
+	if *file == "" {
+		return errors.New("restore: -file is required")
+	}
+
+	info, oldPath, err := backup.Restore(*file, db.Path, db.validateRestored)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %v records from %v\n", info.Records, *file)
+	if oldPath != "" {
+		fmt.Printf("the previous db was moved to %v\n", oldPath)
+	}
+	return nil
+}
+
+// MigrateLegacy is the `db migrate-legacy` subcommand: it moves the user records older versions
+// kept under bare ids in the leveldb to UserCollection, once, as the services refuse to open a
+// leveldb still holding them. The service must be stopped.
+func (db DB) MigrateLegacy(args []string) error {
+	fs := flag.NewFlagSet("migrate-legacy", flag.ExitOnError)
+	fs.Parse(args)
+	if db.Backend != "" && db.Backend != "leveldb" {
+		return fmt.Errorf("migrate-legacy: the %v backend has no legacy records", db.Backend)
+	}
+	count, err := storage.MigrateLegacyKeys(db.Path, db.UserCollection)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("moved %v records to %v\n", count, db.UserCollection)
+	return nil
+}
+
+// validateRestored checks that every user record decodes and that the ledger chain is intact.
+func (db DB) validateRestored(store storage.Store) error {
+	users := 0
+	err := store.Collection(db.UserCollection).Scan("", "", func(id string, data []byte) error {
+		users++
+		if err := db.DecodeUser(data); err != nil {
+			return fmt.Errorf("user record %v: %v", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l, err := ledger.New(store)
+	if err != nil {
+		return err
+	}
+	if err := l.Verify(); err != nil {
+		return err
+	}
+	log.Printf("restore: %v user records checked\n", users)
+	return nil
+}
+
+// Verify is the `db verify` subcommand: it checks an archive and lists how the db differs from it.
+func (db DB) Verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	file := fs.String("file", "", "archive to compare with the db")
+	fs.Parse(args)
+	if *file == "" {
+		return errors.New("verify: -file is required")
+	}
+
+	info, diff, err := backup.Verify(*file, db.Store)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("archive %v: %v records, checksum ok\n", *file, info.Records)
+	fmt.Println(diff)
+	return nil
+}
+
+// VerifyLedger is the `ledger verify` subcommand: a check of the hash chain of the disbursement
+// ledger.
+func VerifyLedger(l *ledger.Ledger) error {
+	if err := l.Verify(); err != nil {
+		return err
+	}
+	fmt.Println("ledger OK")
+	return nil
+}
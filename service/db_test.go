@@ -0,0 +1,21 @@
+package service
+
+import (
+	"errors"
+	"main/backup"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreMongo(t *testing.T) {
+	dir := t.TempDir()
+	db := DB{Backend: "mongo", Path: filepath.Join(dir, "airdropdb")}
+	err := db.Restore([]string{"-file", filepath.Join(dir, "missing.bak.gz")})
+	if !errors.Is(err, backup.ErrUnsupported) {
+		t.Fatalf("restore into mongo: %v", err)
+	}
+	if _, err := os.Stat(db.Path); !os.IsNotExist(err) {
+		t.Fatalf("leveldb created at %v: %v", db.Path, err)
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"main/storage"
+	"net/http"
+	"sync"
+
+	"github.com/incognitochain/go-incognito-sdk-v2/common/base58"
+	"github.com/incognitochain/go-incognito-sdk-v2/wallet"
+)
+
+// DefaultConfigPath is where a service reads its config unless told otherwise.
+const DefaultConfigPath = "./cfg.json"
+
+// LoadConfig decodes the JSON config at path into v.
+func LoadConfig(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
+
+// DropRequest is the body of POST /requestdrop and /faucet, the contract between the faucet and
+// the shield trigger. Exactly one of PaymentAddress, Pubkey and OTAReceiver names the receiver.
+type DropRequest struct {
+	PaymentAddress string `json:"paymentaddress,omitempty"`
+	Pubkey         string `json:"pubkey,omitempty"`
+	OTAReceiver    string `json:"otareceiver,omitempty"`
+	Captcha        string `json:"captcha,omitempty"`
+	CallbackURL    string `json:"callbackurl,omitempty"`
+	// Tier is the size tier of the shield a drop rewards, scaling its amount. Only the requests
+	// with the admin key may set it.
+	Tier int `json:"tier,omitempty"`
+	// Campaign is the campaign of the event a drop rewards, whose cooldown policy applies to it and
+	// which tags it in the ledger. Only the requests with the admin key may set it.
+	Campaign string `json:"campaign,omitempty"`
+}
+
+// DropRequestFor names a receiver of an on-chain event in a drop request: those of the privacy v1
+// coins are public keys, the requests of the pDEX v1 name payment addresses and the others OTA
+// receivers.
+func DropRequestFor(receiver string) DropRequest {
+	if pubkey, _, err := (base58.Base58Check{}).Decode(receiver); err == nil && len(pubkey) == 32 {
+		return DropRequest{Pubkey: receiver}
+	}
+	if _, err := wallet.Base58CheckDeserialize(receiver); err == nil {
+		return DropRequest{PaymentAddress: receiver}
+	}
+	return DropRequest{OTAReceiver: receiver}
+}
+
+// DropResponse is the answer to a DropRequest: Result is 1 when a drop to the receiver is under
+// way, 2 when the receiver got theirs already, 3 when held for review, 4 when held until the owner
+// of a public key claims it with their payment address, and 0 or -1 when refused.
+type DropResponse struct {
+	Result       int
+	Error        string
+	NextEligible int64
+}
+
+// ReadRespBody reads the body of resp, gunzipping it when needed.
+func ReadRespBody(resp *http.Response) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		reader, err = gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+	default:
+		reader = resp.Body
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+type openStore struct {
+	store      storage.Store
+	namespaces map[string]bool
+}
+
+var (
+	storesLock sync.Mutex
+	stores     = make(map[string]*openStore)
+)
+
+func storeLocation(cfg storage.Config) string {
+	switch cfg.Backend {
+	case "", "leveldb":
+		return "leveldb:" + cfg.LevelDBPath
+	}
+	return cfg.Backend + ":" + cfg.MongoURI + "/" + cfg.MongoDB
+}
+
+// OpenStore opens the storage of cfg, or hands out the one another service of the process already
+// opened at the same location. It also returns the view of it named after namespace, for the
+// collections of the service nobody else should read: its pending txs, webhooks and shadow
+// records. Two services sharing a storage need different namespaces, and different user
+// collections.
+func OpenStore(cfg storage.Config, namespace string) (storage.Store, storage.Store, error) {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	location := storeLocation(cfg)
+	s, ok := stores[location]
+	if !ok {
+		store, err := storage.Open(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = &openStore{store: store, namespaces: make(map[string]bool)}
+		stores[location] = s
+	}
+	if s.namespaces[namespace] {
+		return nil, nil, fmt.Errorf("service: %v is already used by another service with namespace %q", location, namespace)
+	}
+	s.namespaces[namespace] = true
+	return s.store, storage.Namespace(s.store, namespace), nil
+}
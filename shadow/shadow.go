@@ -0,0 +1,74 @@
+package shadow
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"main/storage"
+	"time"
+)
+
+// CollectionName is the collection of the records, keyed by their time so that they iterate in
+// order.
+const CollectionName = "shadow"
+
+// Record is a tx built in shadow mode, along with what it would have sent.
+type Record struct {
+	Time     int64
+	Kind     string
+	Account  string
+	Receiver string
+	TxHash   string
+	TokenID  string
+	// Amount is the total sent to Receiver, fee excluded.
+	Amount uint64
+	Fee    uint64
+	// Inputs lists the key images of the coins the tx spends.
+	Inputs []string
+}
+
+// Store keeps the shadow records in the store shared with the rest of the service.
+type Store struct {
+	records storage.Collection
+}
+
+func NewStore(store storage.Store) *Store {
+	s := &Store{records: store.Collection(CollectionName)}
+	if err := s.records.EnsureIndex("Receiver"); err != nil {
+		log.Println("shadow:", err)
+	}
+	return s
+}
+
+func (s *Store) Record(r Record) error {
+	now := time.Now()
+	if r.Time == 0 {
+		r.Time = now.Unix()
+	}
+	return s.records.Put(fmt.Sprintf("%020d-%v", now.UnixNano(), r.TxHash), r)
+}
+
+// Query returns the records within [from, to) sent to receiver. A zero bound or an empty receiver
+// matches everything.
+func (s *Store) Query(from, to time.Time, receiver string) ([]Record, error) {
+	// ids start with the time in nanoseconds
+	start, end := "", ""
+	if !from.IsZero() {
+		start = fmt.Sprintf("%020d", from.UnixNano())
+	}
+	if !to.IsZero() {
+		end = fmt.Sprintf("%020d", to.UnixNano())
+	}
+	result := []Record{}
+	err := s.records.Scan(start, end, func(id string, data []byte) error {
+		var r Record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if receiver == "" || r.Receiver == receiver {
+			result = append(result, r)
+		}
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,65 @@
+package shadow
+
+import (
+	"main/storage"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestQuery(t *testing.T) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := NewStore(storage.NewLevelDB(db))
+
+	for _, r := range []Record{
+		{Receiver: "a", TxHash: "1", Amount: 10, Inputs: []string{"k1"}},
+		{Receiver: "b", TxHash: "2"},
+		{Receiver: "a", TxHash: "3", Time: 42},
+	} {
+		if err := s.Record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	for _, tc := range []struct {
+		name     string
+		from, to time.Time
+		receiver string
+		want     []string
+	}{
+		{"all", time.Time{}, time.Time{}, "", []string{"1", "2", "3"}},
+		{"receiver", time.Time{}, time.Time{}, "a", []string{"1", "3"}},
+		{"until now", time.Time{}, now, "b", []string{"2"}},
+		{"from now", now, time.Time{}, "", nil},
+	} {
+		records, err := s.Query(tc.from, tc.to, tc.receiver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, r := range records {
+			got = append(got, r.TxHash)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: %v", tc.name, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%v: %v", tc.name, got)
+				break
+			}
+		}
+	}
+
+	records, _ := s.Query(time.Time{}, time.Time{}, "a")
+	if records[0].Time == 0 || records[0].Amount != 10 || records[0].Inputs[0] != "k1" || records[1].Time != 42 {
+		t.Fatalf("records %+v", records)
+	}
+}
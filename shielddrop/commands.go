@@ -0,0 +1,145 @@
+package shielddrop
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"main/ledger"
+	"main/service"
+	"main/storage"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// RunCommand runs the maintenance command name of the shield trigger, with its config at cfgPath.
+func RunCommand(cfgPath, name string, args []string) error {
+	switch name {
+	case "backfill", "status", "requeue":
+		if err := readConfig(cfgPath); err != nil {
+			return err
+		}
+		if err := initDB(); err != nil {
+			return err
+		}
+		switch name {
+		case "backfill":
+			return runBackfillCommand(args)
+		case "status":
+			return runStatusCommand(args)
+		case "requeue":
+			return runRequeueCommand(args)
+		}
+	}
+	return fmt.Errorf("shielddrop: %w %v", service.ErrUnknownCommand, name)
+}
+
+// runBackfillCommand is the `shield backfill` subcommand: the drops of the events of a past time
+// range, say one the trigger was down for, queued for the watcher to send. The events processed
+// before are skipped, and the cursors of the watcher are left alone.
+func runBackfillCommand(args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	fromStr := fs.String("from", "", "first day of the range, YYYY-MM-DD")
+	toStr := fs.String("to", "", "last day of the range, YYYY-MM-DD, up to now by default")
+	name := fs.String("source", "", "the source to backfill, all of them by default")
+	fs.Parse(args)
+	if *fromStr == "" {
+		return errors.New("backfill: -from is required")
+	}
+	from, to, err := ledger.ParseRange(*fromStr, *toStr)
+	if err != nil {
+		return err
+	}
+	var toUnix int64
+	if !to.IsZero() {
+		toUnix = to.Unix()
+	}
+	if err := initSources(); err != nil {
+		return err
+	}
+	backfilled := sources
+	if *name != "" {
+		src := findSource(*name)
+		if src == nil {
+			return fmt.Errorf("backfill: no source %v", *name)
+		}
+		backfilled = []*source{src}
+	}
+
+	for _, src := range backfilled {
+		count := 0
+		retry("backfill "+src.Name, func() error {
+			count = 0
+			return src.process(from.Unix(), toUnix, func(e Event) error {
+				count++
+				return nil
+			})
+		})
+		fmt.Printf("%v: %v events processed since %v\n", src.Name, count, from.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// runStatusCommand is the `shield status` subcommand: how many drop requests are pending, failed
+// and delivered, with the pending and failed ones listed.
+func runStatusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "only print the counts")
+	fs.Parse(args)
+
+	pending, err := listTriggers(queue)
+	if err != nil {
+		return err
+	}
+	failed, err := listTriggers(deadLetters)
+	if err != nil {
+		return err
+	}
+	deliveredCount := 0
+	err = delivered.Scan("", "", func(id string, data []byte) error {
+		deliveredCount++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("pending %v, failed %v, delivered %v\n", len(pending), len(failed), deliveredCount)
+	if *quiet || len(pending)+len(failed) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\nSTATE\tID\tCAMPAIGN\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR")
+	for _, t := range pending {
+		next := time.Unix(t.NextAttempt, 0).UTC().Format(time.RFC3339)
+		fmt.Fprintf(w, "pending\t%v\t%v\t%v\t%v\t%v\n", t.id, t.Campaign, t.Attempts, next, t.LastError)
+	}
+	for _, t := range failed {
+		fmt.Fprintf(w, "failed\t%v\t%v\t%v\t-\t%v\n", t.id, t.Campaign, t.Attempts, t.LastError)
+	}
+	return w.Flush()
+}
+
+func listTriggers(c storage.Collection) ([]queuedTrigger, error) {
+	list := []queuedTrigger{}
+	err := c.Scan("", "", func(id string, data []byte) error {
+		var t trigger
+		if err := json.Unmarshal(data, &t); err != nil {
+			return fmt.Errorf("decode %v: %v", id, err)
+		}
+		list = append(list, queuedTrigger{id: id, trigger: t})
+		return nil
+	})
+	return list, err
+}
+
+// runRequeueCommand is the `shield requeue` subcommand: the failed drop requests given by the ids
+// `shield status` lists, or all of them, sent again.
+func runRequeueCommand(args []string) error {
+	fs := flag.NewFlagSet("requeue", flag.ExitOnError)
+	fs.Parse(args)
+	n, err := requeue(fs.Args())
+	fmt.Printf("%v drop requests queued again\n", n)
+	return err
+}
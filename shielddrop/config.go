@@ -1,27 +1,110 @@
-package main
+package shielddrop
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"log"
+	"fmt"
+	"main/service"
+
+	"github.com/incognitochain/coin-service/shared"
 )
 
 type Config struct {
 	Coinservice    string
 	Airdropservice string
+	// CoinserviceMongoURI and CoinserviceMongoDB, "coins" by default as for the coin service, locate
+	// its database, which the sources read but for the shield ones of the "api" mode.
+	CoinserviceMongoURI string
+	CoinserviceMongoDB  string
+	// AdminKey lets the trigger check the access lists of the airdrop service before requesting a
+	// drop. Without it the airdrop service is left to refuse denied receivers itself. The size
+	// tiers and the campaigns of the sources need it too, as do the drops to the public keys the
+	// airdrop service holds until their owners claim them.
+	AdminKey string
+	// Sources are the events triggering the drops. Without any, the shields picked by Filter do as
+	// the "shield" source.
+	Sources []SourceConfig
+	// Filter picks the shields worth a drop when no Sources are set.
+	Filter Filter
+	// PollInterval is the period in seconds of the polls of the sources, 10 by default.
+	PollInterval int
+	// Overlap is how far in seconds before the cursor each poll looks again, for the txs the coin
+	// service indexes late, 600 by default. The events already processed are skipped.
+	Overlap int
+	// Workers is how many drop requests are sent to the airdrop service at once, 4 by default.
+	Workers int
+	// MaxAttempts is how many times a drop request is sent before it goes to the dead letters, 10 by
+	// default.
+	MaxAttempts int
+	// RetryDelay is the delay in seconds before the first retry of a drop request, doubled with each
+	// attempt up to MaxRetryDelay: 30 and 3600 by default.
+	RetryDelay    int
+	MaxRetryDelay int
+	// DBBackend is "leveldb" (default) or "mongo", where the cursor, the processed shields and the
+	// queue of the drop requests are kept.
+	DBBackend string
+	MongoURI  string
+	MongoDB   string
+	// Namespace prefixes the collections of the trigger, "shield" by default.
+	Namespace string
 }
 
 var config Config
 
-func readConfig() {
-	data, err := ioutil.ReadFile("./cfg.json")
-	if err != nil {
-		log.Fatalln(err)
-	}
-	if data != nil {
-		err = json.Unmarshal(data, &config)
-		if err != nil {
-			panic(err)
+func readConfig(path string) error {
+	if err := service.LoadConfig(path, &config); err != nil {
+		return err
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 10
+	}
+	if config.Overlap <= 0 {
+		config.Overlap = 600
+	}
+	if len(config.Sources) == 0 {
+		config.Sources = []SourceConfig{{Name: shieldSourceName, Kind: "shield", Filter: config.Filter}}
+	}
+	names := make(map[string]bool)
+	for i := range config.Sources {
+		src := &config.Sources[i]
+		if src.Name == "" {
+			src.Name = src.Kind
+		}
+		if names[src.Name] {
+			return fmt.Errorf("several sources are called %v", src.Name)
+		}
+		names[src.Name] = true
+		if (len(src.Filter.Tiers) != 0 || src.Campaign != "") && config.AdminKey == "" {
+			return fmt.Errorf("source %v: the tiers and the campaigns need the AdminKey of the airdrop service", src.Name)
+		}
+		if src.PollInterval <= 0 {
+			src.PollInterval = config.PollInterval
+		}
+		if src.Overlap <= 0 {
+			src.Overlap = config.Overlap
 		}
 	}
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 10
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 30
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = 3600
+	}
+	if config.MaxRetryDelay < config.RetryDelay {
+		config.MaxRetryDelay = config.RetryDelay
+	}
+	if config.MongoDB == "" {
+		config.MongoDB = "airdrop"
+	}
+	if config.CoinserviceMongoDB == "" {
+		config.CoinserviceMongoDB = shared.DefaultMongoDB
+	}
+	if config.Namespace == "" {
+		config.Namespace = "shield"
+	}
+	return nil
 }
@@ -0,0 +1,111 @@
+package shielddrop
+
+import (
+	"main/service"
+	"main/storage"
+	"time"
+)
+
+// dbPath is the leveldb directory of the leveldb backend.
+const dbPath = "shielddb"
+
+const (
+	cursorCollection = "cursor"
+	// processedCollection is named from the time the shields were the only events.
+	processedCollection = "shields"
+	// shieldCursor is the id of the cursor of the shield source, from the time it was the only one.
+	shieldCursor = "watch"
+)
+
+// cursors holds the cursors of the sources by name.
+var cursors storage.Collection
+
+// processed holds the processed events by sourceKey.
+var processed storage.Collection
+
+func initDB() error {
+	_, private, err := service.OpenStore(storage.Config{
+		Backend:     config.DBBackend,
+		LevelDBPath: dbPath,
+		MongoURI:    config.MongoURI,
+		MongoDB:     config.MongoDB,
+	}, config.Namespace)
+	if err != nil {
+		return err
+	}
+	cursors = private.Collection(cursorCollection)
+	processed = private.Collection(processedCollection)
+	queue = private.Collection(queueCollection)
+	delivered = private.Collection(deliveredCollection)
+	deadLetters = private.Collection(deadLetterCollection)
+	return nil
+}
+
+// sourceKey is the id of id of the source name in the collections the sources share. Those of the
+// shield source are bare, as from the time it was the only one.
+func sourceKey(name, id string) string {
+	if name == shieldSourceName {
+		return id
+	}
+	return name + "-" + id
+}
+
+// Cursor is the last event of a source processed in (Locktime, TxHash) order, the ones before it
+// having been processed too.
+type Cursor struct {
+	Locktime int64
+	TxHash   string
+}
+
+// before tells whether the tx txHash of the given locktime comes after c.
+func (c Cursor) before(locktime int64, txHash string) bool {
+	if locktime != c.Locktime {
+		return locktime > c.Locktime
+	}
+	return txHash > c.TxHash
+}
+
+// processedEvent is an event whose receivers were requested their drop.
+type processedEvent struct {
+	Locktime    int64
+	ProcessedAt int64
+}
+
+// loadCursor returns the cursor of the source name, a zero one before its first run.
+func loadCursor(name string) (Cursor, error) {
+	var c Cursor
+	err := cursors.Get(cursorID(name), &c)
+	if err == storage.ErrNotFound {
+		err = nil
+	}
+	return c, err
+}
+
+func saveCursor(name string, c Cursor) error {
+	return cursors.Put(cursorID(name), c)
+}
+
+func cursorID(name string) string {
+	if name == shieldSourceName {
+		return shieldCursor
+	}
+	return name
+}
+
+func isProcessed(name, txHash string) (bool, error) {
+	err := processed.Get(sourceKey(name, txHash), &processedEvent{})
+	switch err {
+	case nil:
+		return true, nil
+	case storage.ErrNotFound:
+		return false, nil
+	}
+	return false, err
+}
+
+func markProcessed(name string, e Event) error {
+	return processed.Put(sourceKey(name, e.TxHash), processedEvent{
+		Locktime:    e.Locktime,
+		ProcessedAt: time.Now().Unix(),
+	})
+}
@@ -0,0 +1,141 @@
+package shielddrop
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/incognitochain/coin-service/shared"
+	"github.com/incognitochain/incognito-chain/common"
+	metadataCommon "github.com/incognitochain/incognito-chain/metadata/common"
+	"github.com/incognitochain/incognito-chain/transaction"
+)
+
+// eventTypes names the txs of the events by their metadata type.
+var eventTypes = map[int]string{
+	metadataCommon.IssuingResponseMeta:           "centralized",
+	metadataCommon.IssuingETHResponseMeta:        "eth",
+	metadataCommon.IssuingBSCResponseMeta:        "bsc",
+	metadataCommon.IssuingPRVERC20ResponseMeta:   "prv-erc20",
+	metadataCommon.IssuingPRVBEP20ResponseMeta:   "prv-bep20",
+	metadataCommon.PortalV4ShieldingResponseMeta: "portal",
+
+	metadataCommon.PDETradeRequestMeta:                   "trade",
+	metadataCommon.PDECrossPoolTradeRequestMeta:          "cross-pool-trade",
+	metadataCommon.BurningRequestMeta:                    "burning",
+	metadataCommon.BurningRequestMetaV2:                  "burning",
+	metadataCommon.BurningPBSCRequestMeta:                "burning-bsc",
+	metadataCommon.BurningPRVERC20RequestMeta:            "burning-prv-erc20",
+	metadataCommon.BurningPRVBEP20RequestMeta:            "burning-prv-bep20",
+	metadataCommon.PDEContributionMeta:                   "contribution",
+	metadataCommon.PDEPRVRequiredContributionRequestMeta: "prv-required-contribution",
+}
+
+// typeName is the name of the metadata type of tx in eventTypes.
+func typeName(tx shared.TxData) string {
+	metaType, _ := strconv.Atoi(tx.Metatype)
+	if name, ok := eventTypes[metaType]; ok {
+		return name
+	}
+	return "meta-" + tx.Metatype
+}
+
+// Filter picks the events worth a drop and sizes them. The zero Filter passes all the events
+// moving something, at tier 0.
+type Filter struct {
+	// Tokens are the token ids of the events worth a drop, any when empty.
+	Tokens []string
+	// Types are the kinds of events worth a drop, any when empty: among "centralized", "eth", "bsc",
+	// "prv-erc20", "prv-bep20" and "portal" for the shields, and the other names of eventTypes for
+	// the requests of the other sources.
+	Types []string
+	// MinAmounts is the smallest amount of an event worth a drop by token id, in the smallest unit
+	// of the token. The "" entry applies to the other tokens.
+	MinAmounts map[string]uint64
+	// Tiers are the amounts by token id from which an event is of tier 1, 2 and so on, ascending,
+	// the "" entry applying to the other tokens. The airdrop service scales the drop with the tier.
+	Tiers map[string][]uint64
+}
+
+// detail is what the tx of an event moves: the token a shield mints, a trade sells and so on.
+type detail struct {
+	TokenID string
+	Type    string
+	Amount  uint64
+}
+
+// parseShield reads the token, kind and amount minted by a shield response tx, the way the coin
+// service does. A shield minting nothing has no output to its receiver.
+func parseShield(tx shared.TxData) (detail, error) {
+	s := detail{Type: typeName(tx)}
+	txChoice, err := shared.DeserializeTransactionJSON([]byte(tx.TxDetail))
+	if err != nil {
+		return s, fmt.Errorf("decode shield %v: %v", tx.TxHash, err)
+	}
+	txDetail := txChoice.ToTx()
+	if txDetail == nil {
+		return s, fmt.Errorf("decode shield %v: unknown tx", tx.TxHash)
+	}
+	s.TokenID = txDetail.GetTokenID().String()
+	proof := txDetail.GetProof()
+	if txDetail.GetType() == common.TxCustomTokenPrivacyType || txDetail.GetType() == common.TxTokenConversionType {
+		tokenData := transaction.GetTxTokenDataFromTransaction(txDetail)
+		if tokenData == nil || tokenData.TxNormal == nil {
+			return s, errors.New("no token data")
+		}
+		s.TokenID = tokenData.PropertyID.String()
+		proof = tokenData.TxNormal.GetProof()
+	}
+	if proof == nil {
+		return s, nil
+	}
+	for _, out := range proof.GetOutputCoins() {
+		s.Amount += out.GetValue()
+	}
+	return s, nil
+}
+
+// accept tells whether s is worth a drop, and why not when it is not.
+func (f Filter) accept(s detail) (bool, string) {
+	if s.Amount == 0 {
+		return false, "nothing moved"
+	}
+	if len(f.Tokens) != 0 && !contains(f.Tokens, s.TokenID) {
+		return false, "token " + s.TokenID
+	}
+	if len(f.Types) != 0 && !contains(f.Types, s.Type) {
+		return false, "type " + s.Type
+	}
+	min, ok := f.MinAmounts[s.TokenID]
+	if !ok {
+		min = f.MinAmounts[""]
+	}
+	if s.Amount < min {
+		return false, fmt.Sprintf("amount %v below %v", s.Amount, min)
+	}
+	return true, ""
+}
+
+// tier returns the size tier of s, 0 when below the first one.
+func (f Filter) tier(s detail) int {
+	tiers, ok := f.Tiers[s.TokenID]
+	if !ok {
+		tiers = f.Tiers[""]
+	}
+	tier := 0
+	for i, from := range tiers {
+		if s.Amount >= from {
+			tier = i + 1
+		}
+	}
+	return tier
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,17 @@
-package main
+package shielddrop
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"main/idempotency"
+	"main/service"
 	"net/http"
+	"net/url"
+	"sort"
 	"time"
 
 	"github.com/incognitochain/coin-service/shared"
@@ -14,35 +19,128 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func main() {
-	readConfig()
-	fromtime := time.Now().Unix()
+// pageSize is the number of shields the coin service returns at most per call.
+const pageSize = 1000
+
+// httpClient makes the calls to the coin service and the airdrop service, which a hung one would
+// otherwise hold up for good, and the queue with it.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+const (
+	minBackoff = 10 * time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// Watch polls the sources with its config at cfgPath and queues a drop request for the receivers
+// of their events, until the process exits. It only returns when it cannot start.
+func Watch(cfgPath string) error {
+	if err := readConfig(cfgPath); err != nil {
+		return err
+	}
+	if err := initDB(); err != nil {
+		return err
+	}
+	if err := initSources(); err != nil {
+		return err
+	}
+	cursors := make([]Cursor, len(sources))
+	for i, src := range sources {
+		var err error
+		if cursors[i], err = loadCursor(src.Name); err != nil {
+			return err
+		}
+	}
+	go startQueue()
+	for i, src := range sources {
+		go src.watch(cursors[i])
+	}
+	select {}
+}
+
+// retry calls fn until it succeeds, waiting twice as long after each failure.
+func retry(what string, fn func() error) {
+	delay := minBackoff
 	for {
-		offset := int64(0)
-	retry:
+		err := fn()
+		if err == nil {
+			return
+		}
+		log.Printf("%v: %v, retrying in %v\n", what, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+}
+
+// listShields pages through the shields since fromtime. The pages shift when shields arrive
+// meanwhile, so they are deduplicated and sorted in (Locktime, TxHash) order.
+func listShields(fromtime int64) ([]shared.TxData, error) {
+	result := []shared.TxData{}
+	seen := make(map[string]bool)
+	for offset := int64(0); ; {
 		list, err := getShieldWithRespond(uint64(fromtime), offset)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		for _, v := range list {
-			if v.Locktime > fromtime && len(list) <= 1000 {
-				fromtime = v.Locktime
-			}
-			for _, p := range v.PubKeyReceivers {
-				err := requestAirdrop(p)
-				if err != nil {
-					log.Println(err)
-				}
+		for _, tx := range list {
+			if !seen[tx.TxHash] {
+				seen[tx.TxHash] = true
+				result = append(result, tx)
 			}
 		}
-		if len(list) > 1000 {
-			offset += int64(len(list))
-			goto retry
+		if len(list) < pageSize {
+			break
+		}
+		offset += int64(len(list))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return Cursor{Locktime: result[i].Locktime, TxHash: result[i].TxHash}.before(result[j].Locktime, result[j].TxHash)
+	})
+	return result, nil
+}
+
+// shieldSource lists the shields through the coin service API.
+type shieldSource struct {
+	filter Filter
+}
+
+func newShieldSource(cfg SourceConfig) (TriggerSource, error) {
+	switch cfg.Mode {
+	case "", "api":
+		return shieldSource{filter: cfg.Filter}, nil
+	case "mongo":
+		return newDBShieldSource(cfg)
+	}
+	return nil, fmt.Errorf("unknown mode %q", cfg.Mode)
+}
+
+func (s shieldSource) Events(from, to int64) ([]Event, error) {
+	list, err := listShields(from)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for _, tx := range list {
+		if tx.Locktime < from || (to != 0 && tx.Locktime >= to) {
+			continue
+		}
+		e := Event{TxHash: tx.TxHash, Locktime: tx.Locktime, Receivers: tx.PubKeyReceivers}
+		d, err := parseShield(tx)
+		if err != nil {
+			e.Skipped = err.Error()
+		} else if ok, reason := s.filter.accept(d); !ok {
+			e.Skipped = reason
+		} else {
+			e.Tier = s.filter.tier(d)
 		}
-		time.Sleep(10 * time.Second)
+		events = append(events, e)
 	}
+	return events, nil
 }
 
+// ConnectDB points mgm to the database dbName of the coin service at mongoAddr.
 func ConnectDB(dbName string, mongoAddr string) error {
 	err := mgm.SetDefaultConfig(nil, dbName, options.Client().ApplyURI(mongoAddr))
 	if err != nil {
@@ -58,12 +156,12 @@ func ConnectDB(dbName string, mongoAddr string) error {
 }
 
 func getShieldWithRespond(fromtime uint64, offset int64) ([]shared.TxData, error) {
-	resp, err := http.Get(config.Coinservice + "/shield/gettxshield?offset=" + fmt.Sprintf("%v", offset) + "&fromtime=" + fmt.Sprintf("%v", fromtime))
+	resp, err := httpClient.Get(config.Coinservice + "/shield/gettxshield?offset=" + fmt.Sprintf("%v", offset) + "&fromtime=" + fmt.Sprintf("%v", fromtime))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	body, err := readRespBody(resp)
+	body, err := service.ReadRespBody(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -82,24 +180,91 @@ func getShieldWithRespond(fromtime uint64, offset int64) ([]shared.TxData, error
 	return apiResp.Result, nil
 }
 
-func requestAirdrop(pubkey string) error {
-	resp, err := http.Get(config.Airdropservice + "/requestdrop?pubkey=" + pubkey + "&shield=true")
+// isDenied asks the airdrop service whether pubkey is on its deny list. It is not when the check
+// is not configured or fails, the drop endpoint checking the list again anyway.
+func isDenied(pubkey string) (bool, error) {
+	if config.AdminKey == "" {
+		return false, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, config.Airdropservice+"/admin/accesslist/check?key="+url.QueryEscape(pubkey), nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+	req.Header.Set("X-Admin-Key", config.AdminKey)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
 	}
 	defer resp.Body.Close()
-	body, err := readRespBody(resp)
+	body, err := service.ReadRespBody(resp)
 	if err != nil {
-		return err
+		return false, err
 	}
 	var apiResp struct {
-		Result string
-		Error  string
+		Result struct {
+			List string
+		}
+		Error string
 	}
 	err = json.Unmarshal(body, &apiResp)
 	if err != nil {
-		return err
+		return false, err
 	}
-	fmt.Println(apiResp)
-	return nil
+	if apiResp.Error != "" {
+		return false, errors.New(apiResp.Error)
+	}
+	return apiResp.Result.List == "deny", nil
+}
+
+// requestAirdrop asks the faucet for the drop of t, returning its answer. Refusals are answers too,
+// the receiver having likely got theirs already: the error is for the requests worth retrying,
+// which the faucet answers once thanks to their Idempotency-Key.
+func requestAirdrop(t trigger) (string, error) {
+	dropReq := service.DropRequestFor(t.Receiver)
+	dropReq.Tier = t.Tier
+	dropReq.Campaign = t.Campaign
+	body, err := json.Marshal(dropReq)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, config.Airdropservice+"/requestdrop", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotency.Header, t.source()+"-"+t.TxHash+"-"+t.Receiver)
+	// the faucet trusts the tier, the campaign and the public keys it cannot pay yet with the admin
+	// key only
+	if config.AdminKey != "" {
+		req.Header.Set("X-Admin-Key", config.AdminKey)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := service.ReadRespBody(resp)
+	if err != nil {
+		return "", err
+	}
+	var apiResp service.DropResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		return "", fmt.Errorf("requestdrop: %v: %v", resp.Status, err)
+	}
+	// a 409 means a retry got there while the first request was still running
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusConflict {
+		return "", fmt.Errorf("requestdrop: %v: %v", resp.Status, apiResp.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("rejected, %v: %v", resp.Status, apiResp.Error), nil
+	}
+	switch apiResp.Result {
+	case 1:
+		return "requested", nil
+	case 3:
+		return "held for review", nil
+	case 4:
+		return "held until claimed", nil
+	}
+	return fmt.Sprintf("refused, result %v: %v", apiResp.Result, apiResp.Error), nil
 }
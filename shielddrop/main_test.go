@@ -0,0 +1,454 @@
+package shielddrop
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/idempotency"
+	"main/service"
+	"main/storage"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/incognitochain/coin-service/shared"
+	"github.com/incognitochain/incognito-chain/common"
+	"github.com/incognitochain/incognito-chain/incognitokey"
+	metadataCommon "github.com/incognitochain/incognito-chain/metadata/common"
+	"github.com/incognitochain/incognito-chain/privacy"
+	"github.com/incognitochain/incognito-chain/privacy/coin"
+	"github.com/incognitochain/incognito-chain/privacy/privacy_v2"
+	"github.com/incognitochain/incognito-chain/transaction/tx_ver2"
+	"github.com/incognitochain/incognito-chain/wallet"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// the receivers of the private key testPrivateKey
+const (
+	testPrivateKey  = "1111111U1tofCB5sj3oKYgHbr6PXGtub7WTdKN2KcUdACTBN9GH5RYoAAYmeTgF6F6cfZ6HvYjSMiWWhfkLeGXD4Kw5auCFUqnaGrso7Eg"
+	testPubkey      = "12mKxyf3zV7jywjRuauJUbxrYwg78MBdRKxgpLYPHFHhcf93f1s"
+	testOTAReceiver = "15yvUbGtLfviiHYY4abV6DZ6N4EYF6TR6rwvBJ5nnJUdM9KX6JhEdistwiyvYcDSRJtQxguCUn3ppaXabombmHVUFC8o2V9yXRcCEYueC2CcpdmADuNyUAzKsDxrrqC5v5gZRk8PgLkrURx4"
+)
+
+// testPaymentAddress returns the payment address of testPrivateKey.
+func testPaymentAddress(t *testing.T) (string, privacy.PaymentAddress) {
+	common.MaxShardNumber = 8
+	kw, err := wallet.Base58CheckDeserialize(testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kw.KeySet.InitFromPrivateKey(&kw.KeySet.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	return kw.Base58CheckSerialize(wallet.PaymentAddressType), kw.KeySet.PaymentAddress
+}
+
+// fakeFaucet answers the drop requests with status and resp, passing their bodies to got.
+func fakeFaucet(t *testing.T, status int, resp service.DropResponse, got chan<- service.DropRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/requestdrop" {
+			t.Errorf("got %v %v, want POST /requestdrop", r.Method, r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("got content type %q", ct)
+		}
+		if r.Header.Get(idempotency.Header) == "" {
+			t.Errorf("no %v", idempotency.Header)
+		}
+		var req service.DropRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		got <- req
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestRequestAirdrop(t *testing.T) {
+	got := make(chan service.DropRequest, 1)
+	srv := fakeFaucet(t, http.StatusOK, service.DropResponse{Result: 1}, got)
+	defer srv.Close()
+	config.Airdropservice = srv.URL
+	paymentAddress, _ := testPaymentAddress(t)
+
+	for _, tc := range []struct {
+		receiver string
+		want     service.DropRequest
+	}{
+		{testPubkey, service.DropRequest{Pubkey: testPubkey}},
+		{testOTAReceiver, service.DropRequest{OTAReceiver: testOTAReceiver}},
+		{paymentAddress, service.DropRequest{PaymentAddress: paymentAddress}},
+	} {
+		if outcome, err := requestAirdrop(trigger{TxHash: "tx1", Receiver: tc.receiver}); err != nil || outcome != "requested" {
+			t.Fatalf("%v: %v %v", tc.receiver, outcome, err)
+		}
+		if req := <-got; req != tc.want {
+			t.Fatalf("%v: faucet got %+v, want %+v", tc.receiver, req, tc.want)
+		}
+	}
+}
+
+func TestRequestAirdropFailed(t *testing.T) {
+	got := make(chan service.DropRequest, 1)
+	srv := fakeFaucet(t, http.StatusInternalServerError, service.DropResponse{Error: "cannot check the request, try again later"}, got)
+	defer srv.Close()
+	config.Airdropservice = srv.URL
+
+	if _, err := requestAirdrop(trigger{TxHash: "tx1", Receiver: testOTAReceiver}); err == nil {
+		t.Fatal("server error not returned")
+	}
+	<-got
+
+	// refusals are the faucet's call, not failures
+	srv2 := fakeFaucet(t, http.StatusOK, service.DropResponse{Result: 2}, got)
+	defer srv2.Close()
+	config.Airdropservice = srv2.URL
+	if _, err := requestAirdrop(trigger{TxHash: "tx1", Receiver: testOTAReceiver}); err != nil {
+		t.Fatal(err)
+	}
+	<-got
+}
+
+// TestRequestAirdropTimeout checks that a hung airdrop service fails the request rather than
+// holding up the queue.
+func TestRequestAirdropTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer srv.Close()
+	defer close(hung)
+	config.Airdropservice = srv.URL
+	defer func(c *http.Client) { httpClient = c }(httpClient)
+	httpClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	if _, err := requestAirdrop(trigger{TxHash: "tx1", Receiver: testOTAReceiver}); err == nil {
+		t.Fatal("hung request not failed")
+	}
+}
+
+// TestSendTriggerDenied checks that the deny list of the airdrop service is asked about the public
+// key of a receiver, whatever form it is given in.
+func TestSendTriggerDenied(t *testing.T) {
+	paymentAddress, _ := testPaymentAddress(t)
+	checked := make(chan string, 1)
+	requested := make(chan service.DropRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/accesslist/check":
+			key := r.URL.Query().Get("key")
+			checked <- key
+			list := ""
+			if key == testPubkey {
+				list = "deny"
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Result": map[string]string{"List": list}})
+		case "/requestdrop":
+			var req service.DropRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			requested <- req
+			json.NewEncoder(w).Encode(service.DropResponse{Result: 1})
+		}
+	}))
+	defer srv.Close()
+	config.Airdropservice = srv.URL
+	config.AdminKey = "admin"
+	defer func() { config.AdminKey = "" }()
+
+	for _, receiver := range []string{testPubkey, paymentAddress} {
+		outcome, err := sendTrigger(trigger{TxHash: "tx1", Receiver: receiver})
+		if err != nil || outcome != "skipped, on the deny list" {
+			t.Fatalf("%v: %v %v", receiver, outcome, err)
+		}
+		if key := <-checked; key != testPubkey {
+			t.Fatalf("%v: checked %v", receiver, key)
+		}
+	}
+	if len(requested) != 0 {
+		t.Fatalf("denied receiver requested: %+v", <-requested)
+	}
+
+	// an OTA receiver tells no public key, the airdrop service checks it
+	if outcome, err := sendTrigger(trigger{TxHash: "tx1", Receiver: testOTAReceiver}); err != nil || outcome != "requested" {
+		t.Fatalf("OTA receiver: %v %v", outcome, err)
+	}
+	if req := <-requested; req.OTAReceiver != testOTAReceiver || len(checked) != 0 {
+		t.Fatalf("OTA receiver: requested %+v, %v checks", req, len(checked))
+	}
+}
+
+// fakeCoinservice serves txs as the shields since fromtime, newest first like the coin service, in
+// pages of pageSize.
+func fakeCoinservice(txs []shared.TxData) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromtime, _ := strconv.ParseInt(r.URL.Query().Get("fromtime"), 10, 64)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		result := []shared.TxData{}
+		for i := len(txs) - 1; i >= 0; i-- {
+			if txs[i].Locktime >= fromtime {
+				result = append(result, txs[i])
+			}
+		}
+		if offset > len(result) {
+			offset = len(result)
+		}
+		result = result[offset:]
+		if len(result) > pageSize {
+			result = result[:pageSize]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Result": result})
+	}))
+}
+
+// openTestDB points the collections of the trigger to an in-memory leveldb.
+func openTestDB(t *testing.T) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewLevelDB(db)
+	cursors = store.Collection(cursorCollection)
+	processed = store.Collection(processedCollection)
+	queue = store.Collection(queueCollection)
+	delivered = store.Collection(deliveredCollection)
+	deadLetters = store.Collection(deadLetterCollection)
+	config.Workers = 4
+	config.MaxAttempts = 2
+	config.RetryDelay = 0
+	config.MaxRetryDelay = 0
+}
+
+func count(t *testing.T, c storage.Collection) int {
+	n := 0
+	err := c.Scan("", "", func(id string, data []byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// testShieldDetail returns the details of a shield response tx minting amount PRV to a random
+// receiver, nothing when amount is 0.
+func testShieldDetail(t *testing.T, amount uint64) string {
+	common.MaxShardNumber = 8
+	var ks incognitokey.KeySet
+	if err := ks.InitFromPrivateKeyByte(common.RandBytes(32)); err != nil {
+		t.Fatal(err)
+	}
+	proof := &privacy_v2.PaymentProofV2{}
+	proof.Init()
+	if amount != 0 {
+		c, err := coin.NewCoinFromPaymentInfo(&privacy.PaymentInfo{PaymentAddress: ks.PaymentAddress, Amount: amount})
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof.SetOutputCoinsV2([]*coin.CoinV2{c})
+	}
+	tx := tx_ver2.Tx{}
+	tx.Version = 2
+	tx.Type = common.TxNormalType
+	tx.Proof = proof
+	detail, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(detail)
+}
+
+func TestFilter(t *testing.T) {
+	for _, tc := range []struct {
+		amount uint64
+		filter Filter
+		ok     bool
+		tier   int
+	}{
+		{1000, Filter{}, true, 0},
+		{0, Filter{}, false, 0},
+		{1000, Filter{Tokens: []string{common.PRVIDStr}, Types: []string{"eth", "bsc"}}, true, 0},
+		{1000, Filter{Tokens: []string{"ffff"}}, false, 0},
+		{1000, Filter{Types: []string{"portal"}}, false, 0},
+		{1000, Filter{MinAmounts: map[string]uint64{"": 1001}}, false, 0},
+		{1000, Filter{MinAmounts: map[string]uint64{"": 1001, common.PRVIDStr: 1000}}, true, 0},
+		{1000, Filter{Tiers: map[string][]uint64{"": {10, 100, 10000}}}, true, 2},
+		{1000, Filter{Tiers: map[string][]uint64{"": {10}, common.PRVIDStr: {5000}}}, true, 0},
+	} {
+		s, err := parseShield(shared.TxData{TxHash: "tx", Metatype: "81", TxDetail: testShieldDetail(t, tc.amount)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.TokenID != common.PRVIDStr || s.Type != "eth" || s.Amount != tc.amount {
+			t.Fatalf("parsed %+v", s)
+		}
+		if ok, reason := tc.filter.accept(s); ok != tc.ok {
+			t.Fatalf("%+v: accepted %v (%v), want %v", tc.filter, ok, reason, tc.ok)
+		}
+		if tier := tc.filter.tier(s); tier != tc.tier {
+			t.Fatalf("%+v: tier %v, want %v", tc.filter, tier, tc.tier)
+		}
+	}
+}
+
+func TestProcessShields(t *testing.T) {
+	openTestDB(t)
+	detail := testShieldDetail(t, 1000)
+
+	txs := []shared.TxData{}
+	for i := 0; i < pageSize+10; i++ {
+		txs = append(txs, shared.TxData{
+			TxHash:          fmt.Sprintf("tx%04d", i),
+			Locktime:        int64(1000 + i/2),
+			PubKeyReceivers: []string{fmt.Sprintf("receiver%04d", i), ""},
+			Metatype:        "81",
+			TxDetail:        detail,
+		})
+	}
+	coinservice := fakeCoinservice(txs)
+	defer coinservice.Close()
+	config.Coinservice = coinservice.URL
+
+	got := make(chan service.DropRequest, len(txs))
+	faucet := fakeFaucet(t, http.StatusOK, service.DropResponse{Result: 1}, got)
+	defer faucet.Close()
+	config.Airdropservice = faucet.URL
+
+	src := &source{SourceConfig: SourceConfig{Name: shieldSourceName}, TriggerSource: shieldSource{}}
+	var cursor Cursor
+	advance := func(e Event) error {
+		if !cursor.before(e.Locktime, e.TxHash) {
+			t.Fatalf("%v processed after %+v", e.TxHash, cursor)
+		}
+		cursor = Cursor{Locktime: e.Locktime, TxHash: e.TxHash}
+		return nil
+	}
+	if err := src.process(1000, 0, advance); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, queue); n != len(txs) {
+		t.Fatalf("%v drops queued, want %v", n, len(txs))
+	}
+	if last := txs[len(txs)-1]; cursor.TxHash != last.TxHash {
+		t.Fatalf("cursor at %+v, want %v", cursor, last.TxHash)
+	}
+
+	// a run over the same range queues nothing again
+	cursor = Cursor{}
+	if err := src.process(1000, 0, advance); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, queue); n != len(txs) {
+		t.Fatalf("%v drops queued after a second run, want %v", n, len(txs))
+	}
+
+	deliverDue()
+	if len(got) != len(txs) || count(t, queue) != 0 || count(t, delivered) != len(txs) {
+		t.Fatalf("%v drops requested, %v left in the queue", len(got), count(t, queue))
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	openTestDB(t)
+	got := make(chan service.DropRequest, 10)
+	faucet := fakeFaucet(t, http.StatusServiceUnavailable, service.DropResponse{}, got)
+	defer faucet.Close()
+	config.Airdropservice = faucet.URL
+
+	queued := trigger{Source: "pdex-trade", Campaign: "first-trade", TxHash: "tx1", Receiver: testOTAReceiver}
+	if err := enqueue(queued); err != nil {
+		t.Fatal(err)
+	}
+	deliverDue()
+	var tr trigger
+	if err := queue.Get(triggerID(queued), &tr); err != nil || tr.Attempts != 1 || tr.LastError == "" {
+		t.Fatalf("after a failure: %+v %v", tr, err)
+	}
+	deliverDue()
+	if count(t, queue) != 0 || count(t, deadLetters) != 1 {
+		t.Fatalf("not dead-lettered after %v attempts", config.MaxAttempts)
+	}
+
+	if n, err := requeue(nil); err != nil || n != 1 {
+		t.Fatalf("requeue: %v %v", n, err)
+	}
+	if count(t, queue) != 1 || count(t, deadLetters) != 0 {
+		t.Fatal("not requeued")
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	paymentAddress, addr := testPaymentAddress(t)
+	burning := func(metaType int, name string) parseRequestCase {
+		data, err := json.Marshal(struct {
+			BurnerAddress privacy.PaymentAddress
+			BurningAmount uint64
+			TokenID       string
+			Type          int
+		}{addr, 500, "ffff", metaType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parseRequestCase{metaType, string(data), detail{"ffff", name, 500}, paymentAddress}
+	}
+	tests := []parseRequestCase{
+		{metadataCommon.PDETradeRequestMeta, `{"TokenIDToSellStr":"ffff","SellAmount":100,"TraderAddressStr":"` + paymentAddress + `"}`,
+			detail{"ffff", "trade", 100}, paymentAddress},
+		{metadataCommon.PDECrossPoolTradeRequestMeta, `{"TokenIDToSellStr":"ffff","SellAmount":200,"TraderAddressStr":"` + paymentAddress + `"}`,
+			detail{"ffff", "cross-pool-trade", 200}, paymentAddress},
+		burning(metadataCommon.BurningRequestMeta, "burning"),
+		burning(metadataCommon.BurningRequestMetaV2, "burning"),
+		burning(metadataCommon.BurningPBSCRequestMeta, "burning-bsc"),
+		burning(metadataCommon.BurningPRVERC20RequestMeta, "burning-prv-erc20"),
+		burning(metadataCommon.BurningPRVBEP20RequestMeta, "burning-prv-bep20"),
+		{metadataCommon.PDEContributionMeta, `{"TokenIDStr":"ffff","ContributedAmount":300,"ContributorAddressStr":"` + paymentAddress + `"}`,
+			detail{"ffff", "contribution", 300}, paymentAddress},
+		{metadataCommon.PDEPRVRequiredContributionRequestMeta, `{"TokenIDStr":"ffff","ContributedAmount":400,"ContributorAddressStr":"` + paymentAddress + `"}`,
+			detail{"ffff", "prv-required-contribution", 400}, paymentAddress},
+	}
+	tested := make(map[int]bool)
+	for _, tc := range tests {
+		tested[tc.metaType] = true
+		metatype := strconv.Itoa(tc.metaType)
+		d, receiver, err := parseRequest(shared.TxData{TxHash: "tx", Metatype: metatype, Metadata: tc.metadata})
+		if err != nil {
+			t.Fatalf("%v: %v", metatype, err)
+		}
+		if d != tc.want || receiver != tc.receiver {
+			t.Fatalf("%v: parsed %+v %v, want %+v %v", metatype, d, receiver, tc.want, tc.receiver)
+		}
+		// a request naming no one cannot be paid
+		if _, _, err := parseRequest(shared.TxData{TxHash: "tx", Metatype: metatype, Metadata: `{}`}); err == nil {
+			t.Fatalf("%v: request without a receiver parsed", metatype)
+		}
+		if _, _, err := parseRequest(shared.TxData{TxHash: "tx", Metatype: metatype, Metadata: `[`}); err == nil {
+			t.Fatalf("%v: broken metadata parsed", metatype)
+		}
+	}
+	for kind, metaTypes := range txKinds {
+		for _, metaType := range metaTypes {
+			if !tested[metaType] {
+				t.Errorf("%v: metatype %v not tested", kind, metaType)
+			}
+		}
+	}
+
+	// the pDEX v3 requests pay OTA receivers, which cannot be told apart from user to user
+	for _, metaType := range []int{metadataCommon.Pdexv3TradeRequestMeta, metadataCommon.Pdexv3AddLiquidityRequestMeta, metadataCommon.Pdexv3UserMintNftRequestMeta} {
+		metatype := strconv.Itoa(metaType)
+		if _, _, err := parseRequest(shared.TxData{TxHash: "tx", Metatype: metatype, Metadata: `{}`}); err == nil {
+			t.Fatalf("%v parsed", metatype)
+		}
+	}
+}
+
+type parseRequestCase struct {
+	metaType int
+	metadata string
+	want     detail
+	receiver string
+}
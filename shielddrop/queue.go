@@ -0,0 +1,219 @@
+package shielddrop
+
+import (
+	"encoding/json"
+	"log"
+	"main/accesslist"
+	"main/storage"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The drop requests move from the queue to the delivered ones once the airdrop service answered
+// them, or to the dead letters after MaxAttempts failed attempts.
+const (
+	queueCollection      = "queue"
+	deliveredCollection  = "delivered"
+	deadLetterCollection = "deadletters"
+)
+
+var queue, delivered, deadLetters storage.Collection
+
+// wakeQueue tells the queue that requests were added.
+var wakeQueue = make(chan struct{}, 1)
+
+// trigger is a drop request for a receiver of an event, persisted so that its retries survive
+// restarts.
+type trigger struct {
+	// Source is the name of the source of the event, the shield one when empty.
+	Source   string
+	Campaign string
+	TxHash   string
+	Receiver string
+	// Tier is the size tier of the event, see Filter.Tiers.
+	Tier        int
+	Attempts    int
+	NextAttempt int64
+	// LastError is why the last attempt failed.
+	LastError string
+	// Outcome is the answer of the airdrop service, once delivered.
+	Outcome   string
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+func (t trigger) source() string {
+	if t.Source == "" {
+		return shieldSourceName
+	}
+	return t.Source
+}
+
+func triggerID(t trigger) string {
+	return sourceKey(t.source(), t.TxHash+"-"+t.Receiver)
+}
+
+// enqueue queues the drop request t, unless it already was.
+func enqueue(t trigger) error {
+	now := time.Now().Unix()
+	t.NextAttempt = now
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	err := queue.Insert(triggerID(t), t)
+	if err == storage.ErrExists {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case wakeQueue <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// startQueue sends the due drop requests with config.Workers workers, forever.
+func startQueue() {
+	for {
+		deliverDue()
+		select {
+		case <-wakeQueue:
+		case <-time.After(time.Duration(config.PollInterval) * time.Second):
+		}
+	}
+}
+
+type queuedTrigger struct {
+	id string
+	trigger
+}
+
+func deliverDue() {
+	now := time.Now().Unix()
+	due := []queuedTrigger{}
+	err := queue.Scan("", "", func(id string, data []byte) error {
+		var t trigger
+		if err := json.Unmarshal(data, &t); err != nil {
+			log.Printf("queue: decode %v: %v\n", id, err)
+			return nil
+		}
+		if t.NextAttempt <= now {
+			due = append(due, queuedTrigger{id: id, trigger: t})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("queue:", err)
+	}
+
+	jobs := make(chan queuedTrigger)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for qt := range jobs {
+				deliver(qt.id, qt.trigger)
+			}
+		}()
+	}
+	for _, qt := range due {
+		jobs <- qt
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// deliver sends the drop request t, and files it according to the result.
+func deliver(id string, t trigger) {
+	outcome, err := sendTrigger(t)
+	t.UpdatedAt = time.Now().Unix()
+	if err == nil {
+		t.Outcome = outcome
+		log.Printf("drop for %v of %v %v: %v\n", t.Receiver, t.source(), t.TxHash, outcome)
+		moveTrigger(id, t, delivered)
+		return
+	}
+	t.Attempts++
+	t.LastError = err.Error()
+	if t.Attempts >= config.MaxAttempts {
+		log.Printf("queue: giving up on %v after %v attempts: %v\n", id, t.Attempts, err)
+		moveTrigger(id, t, deadLetters)
+		return
+	}
+	t.NextAttempt = time.Now().Add(backoff(t.Attempts)).Unix()
+	if err := queue.Put(id, t); err != nil {
+		log.Printf("queue: save %v: %v\n", id, err)
+	}
+}
+
+func sendTrigger(t trigger) (string, error) {
+	// the deny list holds public keys, which an OTA receiver does not tell
+	pubkey, err := accesslist.CanonicalPubkey(t.Receiver)
+	if err != nil {
+		return requestAirdrop(t)
+	}
+	denied, err := isDenied(pubkey)
+	if err != nil {
+		log.Println(err)
+	}
+	if denied {
+		return "skipped, on the deny list", nil
+	}
+	return requestAirdrop(t)
+}
+
+// backoff is the delay before the retry following the attempts failed ones: RetryDelay doubled
+// with each attempt up to MaxRetryDelay, of which the second half is random so that the requests
+// failing together, say while the airdrop service is down, do not all come back at once.
+func backoff(attempts int) time.Duration {
+	max := time.Duration(config.MaxRetryDelay) * time.Second
+	delay := time.Duration(config.RetryDelay) * time.Second << uint(attempts-1)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func moveTrigger(id string, t trigger, to storage.Collection) {
+	if err := to.Put(id, t); err != nil {
+		log.Printf("queue: save %v: %v\n", id, err)
+		return
+	}
+	if err := queue.Delete(id); err != nil {
+		log.Printf("queue: remove %v: %v\n", id, err)
+	}
+}
+
+// requeue moves the dead letters ids back to the queue, all of them when ids is empty, and returns
+// how many it moved.
+func requeue(ids []string) (int, error) {
+	if len(ids) == 0 {
+		err := deadLetters.Scan("", "", func(id string, data []byte) error {
+			ids = append(ids, id)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	now := time.Now().Unix()
+	for i, id := range ids {
+		var t trigger
+		if err := deadLetters.Get(id, &t); err != nil {
+			return i, err
+		}
+		t.Attempts = 0
+		t.NextAttempt = now
+		t.UpdatedAt = now
+		if err := queue.Put(id, t); err != nil {
+			return i, err
+		}
+		if err := deadLetters.Delete(id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,176 @@
+package shielddrop
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// shieldSourceName is the name of the source of the shields when none is configured.
+const shieldSourceName = "shield"
+
+// TriggerSource lists the on-chain events of a kind whose receivers are worth a drop.
+type TriggerSource interface {
+	// Events returns the events with from <= Locktime < to, to being open when 0, in (Locktime,
+	// TxHash) order.
+	Events(from, to int64) ([]Event, error)
+}
+
+// notifier is a TriggerSource telling when it may have new events, polled then without waiting
+// for the poll interval.
+type notifier interface {
+	Notify() <-chan struct{}
+}
+
+// Event is a tx whose receivers are worth a drop.
+type Event struct {
+	TxHash   string
+	Locktime int64
+	// Receivers are the public keys, OTA receivers or payment addresses to drop to.
+	Receivers []string
+	// Tier is the size tier of the event, see Filter.Tiers.
+	Tier int
+	// Skipped is why the event is not worth a drop, if it is not.
+	Skipped string
+}
+
+// SourceConfig registers a TriggerSource.
+type SourceConfig struct {
+	// Name identifies the source in the cursors, the queue and the logs, Kind by default.
+	Name string
+	// Kind is "shield", "pdex-trade", "unshield" or "add-liquidity". The trades and contributions
+	// are those of the pDEX v1, paid at a payment address.
+	Kind string
+	// Mode is how the shields are read: "api" (default) through the coin service API, or "mongo"
+	// straight from its database, when running next to it. The other events are always read from
+	// the database.
+	Mode string
+	// Campaign is the campaign of the airdrop service the drops count against, its own by default.
+	// Its cooldown policy decides who gets one: a single drop ever makes the drops of the first
+	// trades, say. The OTA receivers being fresh keys, it only links the events of a user paid at
+	// their payment address.
+	Campaign string
+	// Filter picks the events worth a drop, by the token and amount they move.
+	Filter Filter
+	// PollInterval and Overlap override those of the config.
+	PollInterval int
+	Overlap      int
+}
+
+// sourceKinds makes the sources by kind.
+var sourceKinds = map[string]func(SourceConfig) (TriggerSource, error){
+	"shield":        newShieldSource,
+	"pdex-trade":    newTxSource,
+	"unshield":      newTxSource,
+	"add-liquidity": newTxSource,
+}
+
+// source is a registered TriggerSource, polled on its own.
+type source struct {
+	SourceConfig
+	TriggerSource
+}
+
+var sources []*source
+
+func initSources() error {
+	sources = nil
+	for _, cfg := range config.Sources {
+		newSource, ok := sourceKinds[cfg.Kind]
+		if !ok {
+			return fmt.Errorf("source %v: unknown kind %q", cfg.Name, cfg.Kind)
+		}
+		ts, err := newSource(cfg)
+		if err != nil {
+			return fmt.Errorf("source %v: %v", cfg.Name, err)
+		}
+		sources = append(sources, &source{SourceConfig: cfg, TriggerSource: ts})
+	}
+	return nil
+}
+
+// findSource returns the source called name, nil when there is none.
+func findSource(name string) *source {
+	for _, src := range sources {
+		if src.Name == name {
+			return src
+		}
+	}
+	return nil
+}
+
+// watch polls src and queues the drops of its events, forever. It resumes after cursor, the last
+// event it processed, a first run starting with the events to come.
+func (src *source) watch(cursor Cursor) {
+	if cursor.Locktime == 0 {
+		cursor.Locktime = time.Now().Unix()
+	}
+	var wake <-chan struct{}
+	if n, ok := src.TriggerSource.(notifier); ok {
+		wake = n.Notify()
+	}
+	for {
+		retry("poll "+src.Name, func() error {
+			return src.process(cursor.Locktime-int64(src.Overlap), 0, func(e Event) error {
+				if !cursor.before(e.Locktime, e.TxHash) {
+					return nil
+				}
+				cursor = Cursor{Locktime: e.Locktime, TxHash: e.TxHash}
+				return saveCursor(src.Name, cursor)
+			})
+		})
+		select {
+		case <-wake:
+		case <-time.After(time.Duration(src.PollInterval) * time.Second):
+		}
+	}
+}
+
+// process queues the drops of the events of src with from <= locktime < to, to being open when 0,
+// in (Locktime, TxHash) order. done is called after each one, processed now or before; the first
+// error stops the run, which can be made again as the processed events are skipped.
+func (src *source) process(from, to int64, done func(e Event) error) error {
+	events, err := src.Events(from, to)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if err := src.processEvent(e); err != nil {
+			return err
+		}
+		if err := done(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processEvent queues the drops of the receivers of e, unless it was processed before or is
+// skipped.
+func (src *source) processEvent(e Event) error {
+	seen, err := isProcessed(src.Name, e.TxHash)
+	if err != nil || seen {
+		return err
+	}
+	if e.Skipped != "" {
+		log.Printf("%v: skipping %v: %v\n", src.Name, e.TxHash, e.Skipped)
+		return markProcessed(src.Name, e)
+	}
+	for _, r := range e.Receivers {
+		// the v2 shields list the receiver of their metadata, if any
+		if r == "" {
+			continue
+		}
+		err := enqueue(trigger{
+			Source:   src.Name,
+			Campaign: src.Campaign,
+			TxHash:   e.TxHash,
+			Receiver: r,
+			Tier:     e.Tier,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return markProcessed(src.Name, e)
+}
@@ -0,0 +1,242 @@
+package shielddrop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/incognitochain/coin-service/shared"
+	"github.com/incognitochain/incognito-chain/incognitokey"
+	metadataCommon "github.com/incognitochain/incognito-chain/metadata/common"
+	"github.com/incognitochain/incognito-chain/privacy"
+	"github.com/incognitochain/incognito-chain/wallet"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// txKinds are the metadata types of the request txs of the kinds of sources read from the database
+// of the coin service, whose API only lists the txs of a user. The requests count whether the
+// chain accepts them or refunds them. The pDEX v3 requests are left out: they pay fresh OTA
+// receivers, which no cooldown can link to an earlier request of the same user.
+var txKinds = map[string][]int{
+	"pdex-trade": {
+		metadataCommon.PDETradeRequestMeta,
+		metadataCommon.PDECrossPoolTradeRequestMeta,
+	},
+	"unshield": {
+		metadataCommon.BurningRequestMeta,
+		metadataCommon.BurningRequestMetaV2,
+		metadataCommon.BurningPBSCRequestMeta,
+		metadataCommon.BurningPRVERC20RequestMeta,
+		metadataCommon.BurningPRVBEP20RequestMeta,
+	},
+	"add-liquidity": {
+		metadataCommon.PDEContributionMeta,
+		metadataCommon.PDEPRVRequiredContributionRequestMeta,
+	},
+}
+
+// shieldMetatypes are the metadata types of the shield response txs the coin service API lists.
+var shieldMetatypes = []int{
+	metadataCommon.IssuingResponseMeta,
+	metadataCommon.IssuingETHResponseMeta,
+	metadataCommon.IssuingBSCResponseMeta,
+	metadataCommon.IssuingPRVERC20ResponseMeta,
+	metadataCommon.IssuingPRVBEP20ResponseMeta,
+	metadataCommon.PortalV4ShieldingResponseMeta,
+}
+
+// txSource lists the txs of some metadata types from the database of the coin service, without
+// the pages of its API. It is told of the new ones by a change stream when the database runs as a
+// replica set, polled alone otherwise.
+type txSource struct {
+	metatypes []string
+	filter    Filter
+	// parse reads what a tx moves and its receivers.
+	parse func(tx shared.TxData) (detail, []string, error)
+	// withDetail tells whether parse needs the tx details, left in the database otherwise.
+	withDetail bool
+	listening  sync.Once
+	wake       chan struct{}
+}
+
+func newTxSource(cfg SourceConfig) (TriggerSource, error) {
+	return newDBSource(cfg, txKinds[cfg.Kind], false, func(tx shared.TxData) (detail, []string, error) {
+		d, receiver, err := parseRequest(tx)
+		return d, []string{receiver}, err
+	})
+}
+
+// newDBShieldSource is the shield source of the "mongo" mode.
+func newDBShieldSource(cfg SourceConfig) (TriggerSource, error) {
+	return newDBSource(cfg, shieldMetatypes, true, func(tx shared.TxData) (detail, []string, error) {
+		d, err := parseShield(tx)
+		return d, tx.PubKeyReceivers, err
+	})
+}
+
+func newDBSource(cfg SourceConfig, metatypes []int, withDetail bool, parse func(tx shared.TxData) (detail, []string, error)) (*txSource, error) {
+	if err := connectCoinserviceDB(); err != nil {
+		return nil, err
+	}
+	s := &txSource{
+		filter:     cfg.Filter,
+		parse:      parse,
+		withDetail: withDetail,
+		wake:       make(chan struct{}, 1),
+	}
+	for _, metaType := range metatypes {
+		s.metatypes = append(s.metatypes, strconv.Itoa(metaType))
+	}
+	return s, nil
+}
+
+var coinserviceDBConnected bool
+
+// connectCoinserviceDB connects to the database of the coin service, once.
+func connectCoinserviceDB() error {
+	if coinserviceDBConnected {
+		return nil
+	}
+	if config.CoinserviceMongoURI == "" {
+		return errors.New("no CoinserviceMongoURI")
+	}
+	if err := ConnectDB(config.CoinserviceMongoDB, config.CoinserviceMongoURI); err != nil {
+		return err
+	}
+	coinserviceDBConnected = true
+	return nil
+}
+
+func (s *txSource) Events(from, to int64) ([]Event, error) {
+	locktime := bson.M{"$gte": from}
+	if to != 0 {
+		locktime["$lt"] = to
+	}
+	filter := bson.M{"metatype": bson.M{"$in": s.metatypes}, "locktime": locktime}
+	opts := &options.FindOptions{
+		Sort: bson.D{{Key: "locktime", Value: 1}, {Key: "txhash", Value: 1}},
+	}
+	if !s.withDetail {
+		opts.Projection = bson.M{"txdetail": 0}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var list []shared.TxData
+	if err := mgm.Coll(&shared.TxData{}).SimpleFindWithCtx(ctx, &list, filter, opts); err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for _, tx := range list {
+		e := Event{TxHash: tx.TxHash, Locktime: tx.Locktime}
+		d, receivers, err := s.parse(tx)
+		if err != nil {
+			e.Skipped = err.Error()
+		} else if ok, reason := s.filter.accept(d); !ok {
+			e.Skipped = reason
+		} else {
+			e.Receivers = receivers
+			e.Tier = s.filter.tier(d)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Notify starts the change stream of the txs of s on the first call.
+func (s *txSource) Notify() <-chan struct{} {
+	s.listening.Do(func() {
+		go s.listen()
+	})
+	return s.wake
+}
+
+// listen wakes the poller of s whenever the coin service inserts one of its txs. When the stream
+// cannot be opened, on a standalone server say, or breaks, it is retried with a growing delay,
+// the polls going on meanwhile.
+func (s *txSource) listen() {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType":         "insert",
+		"fullDocument.metatype": bson.M{"$in": s.metatypes},
+	}}}}
+	delay := minBackoff
+	for {
+		ctx := context.Background()
+		stream, err := mgm.Coll(&shared.TxData{}).Watch(ctx, pipeline)
+		if err == nil {
+			for stream.Next(ctx) {
+				// the stream works, a later break is retried quickly again
+				delay = minBackoff
+				select {
+				case s.wake <- struct{}{}:
+				default:
+				}
+			}
+			err = stream.Err()
+			stream.Close(ctx)
+		}
+		log.Printf("change stream: %v, retrying in %v\n", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+}
+
+// parseRequest reads the token and amount a request tx moves from its metadata, and who made it:
+// the payment address of the requester.
+func parseRequest(tx shared.TxData) (detail, string, error) {
+	metaType, _ := strconv.Atoi(tx.Metatype)
+	d := detail{Type: typeName(tx)}
+	meta := []byte(tx.Metadata)
+	var receiver string
+	var err error
+	switch metaType {
+	case metadataCommon.PDETradeRequestMeta, metadataCommon.PDECrossPoolTradeRequestMeta:
+		var m struct {
+			TokenIDToSellStr string
+			SellAmount       uint64
+			TraderAddressStr string
+		}
+		err = json.Unmarshal(meta, &m)
+		d.TokenID, d.Amount, receiver = m.TokenIDToSellStr, m.SellAmount, m.TraderAddressStr
+	case metadataCommon.BurningRequestMeta, metadataCommon.BurningRequestMetaV2, metadataCommon.BurningPBSCRequestMeta,
+		metadataCommon.BurningPRVERC20RequestMeta, metadataCommon.BurningPRVBEP20RequestMeta:
+		var m struct {
+			BurnerAddress privacy.PaymentAddress
+			BurningAmount uint64
+			TokenID       string
+		}
+		err = json.Unmarshal(meta, &m)
+		d.TokenID, d.Amount = m.TokenID, m.BurningAmount
+		if err == nil && len(m.BurnerAddress.Pk) != 0 {
+			kw := wallet.KeyWallet{KeySet: incognitokey.KeySet{PaymentAddress: m.BurnerAddress}}
+			receiver = kw.Base58CheckSerialize(wallet.PaymentAddressType)
+		}
+	case metadataCommon.PDEContributionMeta, metadataCommon.PDEPRVRequiredContributionRequestMeta:
+		var m struct {
+			TokenIDStr            string
+			ContributedAmount     uint64
+			ContributorAddressStr string
+		}
+		err = json.Unmarshal(meta, &m)
+		d.TokenID, d.Amount, receiver = m.TokenIDStr, m.ContributedAmount, m.ContributorAddressStr
+	default:
+		return d, "", fmt.Errorf("unknown request type %v", tx.Metatype)
+	}
+	if err != nil {
+		return d, "", fmt.Errorf("decode metadata: %v", err)
+	}
+	if receiver == "" {
+		return d, "", errors.New("no receiver")
+	}
+	return d, receiver, nil
+}
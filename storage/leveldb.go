@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	lvdbErrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrLegacyKeys is returned when opening a leveldb still holding the user records of an older
+// version, which MigrateLegacyKeys moves to their collection.
+var ErrLegacyKeys = errors.New("storage: user records of an older version under bare ids")
+
+// versionKey marks a leveldb without legacy keys, sparing the search for them on the next opens.
+var versionKey = []byte("storage-version")
+
+// levelDBStore keeps every collection in one leveldb, under "<name>-<id>" keys. Older versions
+// kept the user records under their bare ids: base58 never produces '-', which tells them apart.
+type levelDBStore struct {
+	db *leveldb.DB
+	// lock makes Insert and Swap atomic with the other writes of the process.
+	lock sync.Mutex
+}
+
+// OpenLevelDB opens the leveldb at dbPath, refusing one with legacy keys.
+func OpenLevelDB(dbPath string) (Store, error) {
+	lvdb, err := openLevelDBFile(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLegacyKeys(lvdb); err != nil {
+		lvdb.Close()
+		return nil, errors.Wrap(err, dbPath)
+	}
+	return NewLevelDB(lvdb), nil
+}
+
+func openLevelDBFile(dbPath string) (*leveldb.DB, error) {
+	handles := 256
+	cache := 8
+	lvdb, err := leveldb.OpenFile(dbPath, &opt.Options{
+		OpenFilesCacheCapacity: handles,
+		BlockCacheCapacity:     cache / 2 * opt.MiB,
+		WriteBuffer:            cache / 4 * opt.MiB, // Two of these are used internally
+		Filter:                 filter.NewBloomFilter(10),
+	})
+	if _, corrupted := err.(*lvdbErrors.ErrCorrupted); corrupted {
+		lvdb, err = leveldb.RecoverFile(dbPath, nil)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "levelvdb.OpenFile %s", dbPath)
+	}
+	return lvdb, nil
+}
+
+// isLegacyKey tells whether key is the bare id of a user record of an older version.
+func isLegacyKey(key []byte) bool {
+	return bytes.IndexByte(key, '-') == -1
+}
+
+func checkLegacyKeys(db *leveldb.DB) error {
+	if ok, err := db.Has(versionKey, nil); err != nil || ok {
+		return err
+	}
+	count := 0
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		if isLegacyKey(iter.Key()) {
+			count++
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %v records to move with `db migrate-legacy`", ErrLegacyKeys, count)
+	}
+	return db.Put(versionKey, []byte("1"), nil)
+}
+
+// MigrateLegacyKeys moves the records an older version kept under bare ids in the leveldb at
+// dbPath to collection, in one batch, and returns their number. The db must not be open.
+func MigrateLegacyKeys(dbPath, collection string) (int, error) {
+	db, err := openLevelDBFile(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	batch := new(leveldb.Batch)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		if !isLegacyKey(iter.Key()) {
+			continue
+		}
+		key := append([]byte(collection+"-"), iter.Key()...)
+		if ok, err := db.Has(key, nil); err != nil || ok {
+			if err == nil {
+				err = fmt.Errorf("storage: %s is both a legacy and a %v record", iter.Key(), collection)
+			}
+			iter.Release()
+			return 0, err
+		}
+		batch.Put(key, iter.Value())
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	count := batch.Len() / 2
+	batch.Put(versionKey, []byte("1"))
+	return count, db.Write(batch, nil)
+}
+
+// NewLevelDB wraps an open leveldb.
+func NewLevelDB(db *leveldb.DB) Store {
+	return &levelDBStore{db: db}
+}
+
+func (s *levelDBStore) Collection(name string) Collection {
+	return &levelDBCollection{db: s.db, lock: &s.lock, prefix: []byte(name + "-")}
+}
+
+func (s *levelDBStore) Close() error {
+	return s.db.Close()
+}
+
+// Dump walks a snapshot of the db, so that writes made meanwhile do not show up halfway.
+func (s *levelDBStore) Dump(fn func(key, value []byte) error) error {
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+	iter := snap.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+type levelDBCollection struct {
+	db     *leveldb.DB
+	lock   *sync.Mutex
+	prefix []byte
+}
+
+func (c *levelDBCollection) key(id string) []byte {
+	return append(append([]byte{}, c.prefix...), id...)
+}
+
+func (c *levelDBCollection) Get(id string, v interface{}) error {
+	data, err := c.db.Get(c.key(id), nil)
+	if err == leveldb.ErrNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (c *levelDBCollection) Put(id string, v interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.put(id, v)
+}
+
+func (c *levelDBCollection) put(id string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.db.Put(c.key(id), data, nil)
+}
+
+func (c *levelDBCollection) Insert(id string, v interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ok, err := c.db.Has(c.key(id), nil); err != nil {
+		return err
+	} else if ok {
+		return ErrExists
+	}
+	return c.put(id, v)
+}
+
+func (c *levelDBCollection) Swap(id string, old, v interface{}) error {
+	oldData, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	data, err := c.db.Get(c.key(id), nil)
+	if err == leveldb.ErrNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, oldData) {
+		return ErrConflict
+	}
+	return c.put(id, v)
+}
+
+func (c *levelDBCollection) Delete(id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.db.Delete(c.key(id), nil)
+}
+
+func (c *levelDBCollection) iterator(from, to string) iterator.Iterator {
+	r := util.BytesPrefix(c.prefix)
+	if from != "" {
+		r.Start = c.key(from)
+	}
+	if to != "" {
+		r.Limit = c.key(to)
+	}
+	return c.db.NewIterator(r, nil)
+}
+
+func (c *levelDBCollection) Scan(from, to string, fn func(id string, data []byte) error) error {
+	iter := c.iterator(from, to)
+	defer iter.Release()
+	for iter.Next() {
+		// the slices returned by the iterator are only valid until the next call to Next
+		id := string(iter.Key()[len(c.prefix):])
+		data := append([]byte{}, iter.Value()...)
+		if err := fn(id, data); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+func (c *levelDBCollection) Last(v interface{}) (string, error) {
+	iter := c.iterator("", "")
+	defer iter.Release()
+	if iter.Last() {
+		return string(iter.Key()[len(c.prefix):]), json.Unmarshal(iter.Value(), v)
+	}
+	if err := iter.Error(); err != nil {
+		return "", err
+	}
+	return "", ErrNotFound
+}
+
+func (c *levelDBCollection) EnsureIndex(field string) error {
+	return nil
+}
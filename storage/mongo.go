@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const mongoTimeout = 10 * time.Second
+
+// mongoStore keeps each collection in its own MongoDB collection, as {_id, doc, raw} documents:
+// raw is the JSON the collection reads back, doc its BSON copy that the indexes are built on, left
+// out for the values that are not objects.
+type mongoStore struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+type mongoDocument struct {
+	ID  string `bson:"_id"`
+	Doc bson.M `bson:"doc,omitempty"`
+	Raw string `bson:"raw"`
+}
+
+func OpenMongo(uri, dbName string) (Store, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		return nil, err
+	}
+	return &mongoStore{client: client, db: client.Database(dbName)}, nil
+}
+
+func (s *mongoStore) Collection(name string) Collection {
+	return &mongoCollection{coll: s.db.Collection(name)}
+}
+
+func (s *mongoStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	return s.client.Disconnect(ctx)
+}
+
+type mongoCollection struct {
+	coll *mongo.Collection
+}
+
+func newMongoDocument(id string, v interface{}) (*mongoDocument, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := &mongoDocument{ID: id, Raw: string(data)}
+	// raw is what is read back: doc is only there for the indexes, which need an object
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if err := bson.UnmarshalExtJSON(data, false, &doc.Doc); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func (c *mongoCollection) Get(id string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	var doc mongoDocument
+	err := c.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(doc.Raw), v)
+}
+
+func (c *mongoCollection) Put(id string, v interface{}) error {
+	doc, err := newMongoDocument(id, v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err = c.coll.ReplaceOne(ctx, bson.M{"_id": id}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (c *mongoCollection) Insert(id string, v interface{}) error {
+	doc, err := newMongoDocument(id, v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err = c.coll.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrExists
+	}
+	return err
+}
+
+func (c *mongoCollection) Swap(id string, old, v interface{}) error {
+	oldData, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+	doc, err := newMongoDocument(id, v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	res, err := c.coll.ReplaceOne(ctx, bson.M{"_id": id, "raw": string(oldData)}, doc)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if err := c.Get(id, &json.RawMessage{}); err != nil {
+			return err
+		}
+		return ErrConflict
+	}
+	return nil
+}
+
+func (c *mongoCollection) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err := c.coll.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (c *mongoCollection) Scan(from, to string, fn func(id string, data []byte) error) error {
+	idRange := bson.M{}
+	if from != "" {
+		idRange["$gte"] = from
+	}
+	if to != "" {
+		idRange["$lt"] = to
+	}
+	filter := bson.M{}
+	if len(idRange) > 0 {
+		filter["_id"] = idRange
+	}
+	ctx := context.Background()
+	cursor, err := c.coll.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}).SetProjection(bson.M{"doc": 0}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc mongoDocument
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(doc.ID, []byte(doc.Raw)); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (c *mongoCollection) Last(v interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	var doc mongoDocument
+	err := c.coll.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return doc.ID, json.Unmarshal([]byte(doc.Raw), v)
+}
+
+func (c *mongoCollection) EnsureIndex(field string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err := c.coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"doc." + field: 1}})
+	return err
+}
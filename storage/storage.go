@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound = errors.New("storage: not found")
+	ErrExists   = errors.New("storage: already exists")
+	ErrConflict = errors.New("storage: changed meanwhile")
+)
+
+// Store is a set of named collections of JSON documents, keyed by id.
+type Store interface {
+	Collection(name string) Collection
+	Close() error
+}
+
+// Collection is a set of JSON documents keyed by id. Values are marshalled with encoding/json.
+type Collection interface {
+	Get(id string, v interface{}) error
+	Put(id string, v interface{}) error
+	// Insert stores v only if id is free, and returns ErrExists otherwise.
+	Insert(id string, v interface{}) error
+	// Swap replaces the document of id by v only if it still marshals as old does, and returns
+	// ErrConflict otherwise.
+	Swap(id string, old, v interface{}) error
+	Delete(id string) error
+	// Scan calls fn in id order for the documents with from <= id < to. An empty bound is open.
+	Scan(from, to string, fn func(id string, data []byte) error) error
+	// Last decodes the document with the greatest id into v and returns its id, or ErrNotFound.
+	Last(v interface{}) (string, error)
+	// EnsureIndex indexes the documents on a field, dotted for nested ones, where the backend
+	// supports it.
+	EnsureIndex(field string) error
+}
+
+// Dumper is implemented by the backends able to export a consistent copy of all their data, as
+// the raw keys and values they store.
+type Dumper interface {
+	Dump(fn func(key, value []byte) error) error
+}
+
+// Config picks the backend of a service.
+type Config struct {
+	// Backend is "leveldb" (the default) or "mongo".
+	Backend     string
+	LevelDBPath string
+	MongoURI    string
+	MongoDB     string
+}
+
+func Open(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "", "leveldb":
+		return OpenLevelDB(cfg.LevelDBPath)
+	case "mongo":
+		return OpenMongo(cfg.MongoURI, cfg.MongoDB)
+	}
+	return nil, fmt.Errorf("storage: unknown backend %v", cfg.Backend)
+}
+
+// Namespace returns a view of store whose collections are named "<ns>.<name>", for the state a
+// service keeps to itself in a store shared with other services. An empty ns returns store.
+func Namespace(store Store, ns string) Store {
+	if ns == "" {
+		return store
+	}
+	return &namespacedStore{Store: store, ns: ns}
+}
+
+type namespacedStore struct {
+	Store
+	ns string
+}
+
+func (s *namespacedStore) Collection(name string) Collection {
+	return s.Store.Collection(s.ns + "." + name)
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type testDoc struct {
+	Name  string
+	Value uint64
+	Tags  map[string][]string
+}
+
+// testStore is the contract every backend must fulfil.
+func testStore(t *testing.T, s Store) {
+	users := s.Collection("users")
+	other := s.Collection("other")
+
+	if err := users.Get("missing", &testDoc{}); err != ErrNotFound {
+		t.Fatalf("Get missing: got %v, want ErrNotFound", err)
+	}
+	if _, err := other.Last(&testDoc{}); err != ErrNotFound {
+		t.Fatalf("Last on empty collection: got %v, want ErrNotFound", err)
+	}
+
+	doc := testDoc{Name: "a", Value: 1 << 40, Tags: map[string][]string{"0000": {"x", "y"}}}
+	if err := users.Put("12abc", doc); err != nil {
+		t.Fatal(err)
+	}
+	var got testDoc
+	if err := users.Get("12abc", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != doc.Name || got.Value != doc.Value || len(got.Tags["0000"]) != 2 {
+		t.Fatalf("Get: got %+v, want %+v", got, doc)
+	}
+	doc.Value = 2
+	if err := users.Put("12abc", doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Get("12abc", &got); err != nil || got.Value != 2 {
+		t.Fatalf("Put does not overwrite: %+v %v", got, err)
+	}
+
+	if err := other.Insert("0001", testDoc{Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Insert("0001", testDoc{Name: "again"}); err != ErrExists {
+		t.Fatalf("Insert on taken id: got %v, want ErrExists", err)
+	}
+	for i := 2; i <= 5; i++ {
+		if err := other.Put(fmt.Sprintf("%04d", i), testDoc{Name: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// collections do not see each other's documents
+	count := 0
+	err := users.Scan("", "", func(id string, data []byte) error {
+		count++
+		if id != "12abc" {
+			t.Errorf("Scan users: unexpected id %v", id)
+		}
+		return nil
+	})
+	if err != nil || count != 1 {
+		t.Fatalf("Scan users: %v documents, err %v", count, err)
+	}
+
+	ids := []string{}
+	err = other.Scan("0002", "0005", func(id string, data []byte) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil || fmt.Sprint(ids) != "[0002 0003 0004]" {
+		t.Fatalf("Scan range: got %v, err %v", ids, err)
+	}
+
+	id, err := other.Last(&got)
+	if err != nil || id != "0005" || got.Name != "5" {
+		t.Fatalf("Last: got %v %+v, err %v", id, got, err)
+	}
+
+	if err := other.Swap("0004", testDoc{Name: "4"}, testDoc{Name: "swapped"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Swap("0004", testDoc{Name: "4"}, testDoc{Name: "again"}); err != ErrConflict {
+		t.Fatalf("Swap on changed document: got %v, want ErrConflict", err)
+	}
+	if err := other.Get("0004", &got); err != nil || got.Name != "swapped" {
+		t.Fatalf("Swap: got %+v, err %v", got, err)
+	}
+	if err := other.Swap("missing", testDoc{}, testDoc{}); err != ErrNotFound {
+		t.Fatalf("Swap missing: got %v, want ErrNotFound", err)
+	}
+
+	if err := other.Delete("0005"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Get("0005", &got); err != ErrNotFound {
+		t.Fatalf("Get deleted: got %v, want ErrNotFound", err)
+	}
+	if err := other.EnsureIndex("Name"); err != nil {
+		t.Fatal(err)
+	}
+
+	// any encoding/json value, not only objects
+	scalars := s.Collection("scalars")
+	values := map[string]interface{}{"1": uint64(1 << 40), "2": "text", "3": true, "4": []string{"a"}}
+	for id, v := range values {
+		if err := scalars.Put(id, v); err != nil {
+			t.Fatalf("Put %T: %v", v, err)
+		}
+	}
+	var n uint64
+	if err := scalars.Get("1", &n); err != nil || n != 1<<40 {
+		t.Fatalf("Get number: %v, err %v", n, err)
+	}
+	if err := scalars.Swap("1", uint64(1<<40), uint64(2)); err != nil {
+		t.Fatal(err)
+	}
+	var str string
+	if err := scalars.Get("2", &str); err != nil || str != "text" {
+		t.Fatalf("Get string: %q, err %v", str, err)
+	}
+	scanned := []string{}
+	err = scalars.Scan("", "", func(id string, data []byte) error {
+		scanned = append(scanned, id+"="+string(data))
+		return nil
+	})
+	if err != nil || fmt.Sprint(scanned) != `[1=2 2="text" 3=true 4=["a"]]` {
+		t.Fatalf("Scan scalars: %v, err %v", scanned, err)
+	}
+	var list []string
+	if id, err := scalars.Last(&list); err != nil || id != "4" || fmt.Sprint(list) != "[a]" {
+		t.Fatalf("Last: %v %v, err %v", id, list, err)
+	}
+}
+
+func TestMongoDocument(t *testing.T) {
+	doc, err := newMongoDocument("id", testDoc{Name: "a", Value: 1})
+	if err != nil || doc.Doc["Name"] != "a" {
+		t.Fatalf("object: %+v, err %v", doc, err)
+	}
+	for _, v := range []interface{}{uint64(1), "a", false, []int{1}, nil} {
+		doc, err := newMongoDocument("id", v)
+		if err != nil || doc.Doc != nil {
+			t.Fatalf("%#v: %+v, err %v", v, doc, err)
+		}
+	}
+}
+
+func TestLevelDB(t *testing.T) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewLevelDB(db)
+	defer s.Close()
+	testStore(t, s)
+}
+
+// TestLevelDBLegacyKeys checks that a leveldb holding the user records of an older version is
+// refused until they are moved to their collection.
+func TestLevelDBLegacyKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"12legacy", "12other", "tracker-tx"} {
+		if err := db.Put([]byte(key), []byte(`{"Name":"legacy"}`), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	if _, err := OpenLevelDB(path); !errors.Is(err, ErrLegacyKeys) {
+		t.Fatalf("open with legacy keys: %v", err)
+	}
+	if count, err := MigrateLegacyKeys(path, "users"); err != nil || count != 2 {
+		t.Fatalf("migrated %v records, err %v", count, err)
+	}
+	s, err := OpenLevelDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got testDoc
+	if err := s.Collection("users").Get("12legacy", &got); err != nil || got.Name != "legacy" {
+		t.Fatalf("migrated record: %+v, err %v", got, err)
+	}
+	if err := s.Collection("tracker").Get("tx", &got); err != nil {
+		t.Fatalf("prefixed record: %v", err)
+	}
+	s.Close()
+	if count, err := MigrateLegacyKeys(path, "nftusers"); err != nil || count != 0 {
+		t.Fatalf("second migration: %v records, err %v", count, err)
+	}
+}
+
+// TestNamespace checks that services sharing a store keep their private collections apart.
+func TestNamespace(t *testing.T) {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewLevelDB(db)
+	defer s.Close()
+	nft := Namespace(s, "nft")
+	testStore(t, nft)
+	if err := nft.Collection("tracker").Put("tx", testDoc{Name: "nft"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Collection("tracker").Get("tx", &testDoc{}); err != ErrNotFound {
+		t.Fatalf("namespaced record seen from the shared store: err %v", err)
+	}
+	if ok, _ := db.Has([]byte("nft.tracker-tx"), nil); !ok {
+		t.Fatal("namespaced record not found under nft.tracker-tx")
+	}
+	if Namespace(s, "") != s {
+		t.Fatal("an empty namespace should return the store itself")
+	}
+}
+
+// TestMongo runs against the database of STORAGE_TEST_MONGO_URI, and is skipped without one.
+func TestMongo(t *testing.T) {
+	uri := os.Getenv("STORAGE_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("STORAGE_TEST_MONGO_URI not set")
+	}
+	s, err := OpenMongo(uri, fmt.Sprintf("storagetest%v", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		s.(*mongoStore).db.Drop(context.Background())
+		s.Close()
+	}()
+	testStore(t, s)
+}
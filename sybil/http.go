@@ -0,0 +1,55 @@
+package sybil
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes adds the admin endpoints of the scorer to r:
+//
+//	GET  /sybil/records?since=24h        the scored requests with their reasons, the window by default
+//	GET  /sybil/reviews?status=pending   the held requests
+//	POST /sybil/reviews/approve?key=     carries out a held request through onApprove
+//	POST /sybil/reviews/reject?key=      refuses a held request and the later ones of its pubkey
+func RegisterRoutes(r gin.IRouter, s *Scorer, onApprove func(Review) error) {
+	r.GET("/sybil/records", func(c *gin.Context) {
+		since := time.Duration(s.cfg.Window) * time.Minute
+		if c.Query("since") != "" {
+			var err error
+			since, err = time.ParseDuration(c.Query("since"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+				return
+			}
+		}
+		now := time.Now()
+		records, err := s.Records(now.Add(-since), now.Add(time.Second))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"Result": records})
+	})
+	r.GET("/sybil/reviews", func(c *gin.Context) {
+		reviews, err := s.Reviews(c.Query("status"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"Error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"Result": reviews})
+	})
+	decide := func(approve bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			review, err := s.Decide(c.Query("key"), approve, onApprove)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"Result": review})
+		}
+	}
+	r.POST("/sybil/reviews/approve", decide(true))
+	r.POST("/sybil/reviews/reject", decide(false))
+}
@@ -0,0 +1,310 @@
+package sybil
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/storage"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// CollectionName is the collection of the scored requests, under "<unixnano>-<pubkey>" so that
+	// they iterate by time.
+	CollectionName = "sybil"
+	// ReviewCollectionName holds the requests held for review, by pubkey.
+	ReviewCollectionName = "sybilreview"
+)
+
+// Decisions taken on a request.
+const (
+	DecisionAllow  = "allow"
+	DecisionReview = "review"
+	DecisionRefuse = "refuse"
+)
+
+// Statuses of a held request.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// scriptedAgents are the user agents of HTTP libraries and tools rather than browsers.
+var scriptedAgents = []string{"curl/", "wget/", "python-requests", "go-http-client", "node-fetch", "axios/", "okhttp", "java/"}
+
+// Config sets the window requests are clustered over and the thresholds of the decisions.
+type Config struct {
+	Enabled bool
+	// Window is the sliding window in minutes, 60 by default.
+	Window int
+	// ReviewScore holds the requests for review, 50 by default.
+	ReviewScore int
+	// RefuseScore refuses the requests, 100 by default.
+	RefuseScore int
+	// CaptchaHostname is the site the captchas must be solved on, unchecked when empty.
+	CaptchaHostname string
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Window <= 0 {
+		cfg.Window = 60
+	}
+	if cfg.ReviewScore <= 0 {
+		cfg.ReviewScore = 50
+	}
+	if cfg.RefuseScore <= 0 {
+		cfg.RefuseScore = 100
+	}
+	return cfg
+}
+
+// Captcha is what the captcha provider told about a solved challenge.
+type Captcha struct {
+	Hostname string
+	// ChallengeTime is the unix time the challenge was solved.
+	ChallengeTime int64
+}
+
+// Fingerprint describes a drop request.
+type Fingerprint struct {
+	Time      int64
+	Pubkey    string
+	IP        string
+	Subnet    string
+	UserAgent string
+	Captcha   *Captcha `json:",omitempty"`
+	Shard     int
+}
+
+// NewFingerprint fingerprints a request from ip for pubkey.
+func NewFingerprint(r *http.Request, ip, pubkey string, shard int) Fingerprint {
+	return Fingerprint{
+		Time:      time.Now().Unix(),
+		Pubkey:    pubkey,
+		IP:        ip,
+		Subnet:    Subnet(ip),
+		UserAgent: r.UserAgent(),
+		Shard:     shard,
+	}
+}
+
+// Subnet returns the /24 of an IPv4 or the /48 of an IPv6, which a single operator usually has at
+// hand.
+func Subnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// Record is a scored request.
+type Record struct {
+	Fingerprint
+	Score    int
+	Reasons  []string
+	Decision string
+}
+
+// Review is a request held until an admin approves or rejects it. Request is what the service
+// needs to carry it out.
+type Review struct {
+	Pubkey    string
+	Record    Record
+	Request   json.RawMessage
+	Status    string
+	DecidedAt int64
+}
+
+// Scorer scores the requests against the others of the window. Everything is kept in the store so
+// that replicas sharing it see each other's requests.
+type Scorer struct {
+	cfg     Config
+	records storage.Collection
+	reviews storage.Collection
+}
+
+func NewScorer(store storage.Store, cfg Config) *Scorer {
+	return &Scorer{
+		cfg:     cfg.withDefaults(),
+		records: store.Collection(CollectionName),
+		reviews: store.Collection(ReviewCollectionName),
+	}
+}
+
+func (s *Scorer) Enabled() bool {
+	return s.cfg.Enabled
+}
+
+// recordID keeps a single record per pubkey and second.
+func recordID(fp Fingerprint) string {
+	return fmt.Sprintf("%v-%v", timeID(time.Unix(fp.Time, 0)), fp.Pubkey)
+}
+
+func timeID(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// Records returns the scored requests within [from, to).
+func (s *Scorer) Records(from, to time.Time) ([]Record, error) {
+	result := []Record{}
+	err := s.records.Scan(timeID(from), timeID(to), func(id string, data []byte) error {
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("sybil: decode record %v: %v", id, err)
+		}
+		result = append(result, rec)
+		return nil
+	})
+	return result, err
+}
+
+// score applies the heuristics to fp given the other requests of the window.
+func (s *Scorer) score(fp Fingerprint, window []Record) (int, []string) {
+	score := 0
+	reasons := []string{}
+	add := func(points int, reason string, args ...interface{}) {
+		score += points
+		reasons = append(reasons, fmt.Sprintf(reason, args...))
+	}
+
+	sameIP := make(map[string]bool)
+	sameSubnet := make(map[string]bool)
+	sameShard := make(map[string]bool)
+	burst := 0
+	for _, rec := range window {
+		if rec.Pubkey == fp.Pubkey {
+			continue
+		}
+		if rec.Subnet != fp.Subnet {
+			continue
+		}
+		if rec.IP == fp.IP {
+			sameIP[rec.Pubkey] = true
+		} else {
+			sameSubnet[rec.Pubkey] = true
+		}
+		if rec.Shard == fp.Shard {
+			sameShard[rec.Pubkey] = true
+		}
+		if fp.Time-rec.Time < 60 {
+			burst++
+		}
+	}
+	if n := len(sameIP); n > 0 {
+		add(30*n, "%v other addresses requested from %v", n, fp.IP)
+	}
+	if n := len(sameSubnet); n > 0 {
+		add(10*n, "%v other addresses requested from %v", n, fp.Subnet)
+	}
+	if n := len(sameShard); n >= 3 {
+		add(15, "%v addresses from %v target shard %v", n+1, fp.Subnet, fp.Shard)
+	}
+	if burst >= 3 {
+		add(20, "%v requests from %v within a minute", burst+1, fp.Subnet)
+	}
+
+	agent := strings.ToLower(fp.UserAgent)
+	if agent == "" {
+		add(40, "no user agent")
+	}
+	for _, scripted := range scriptedAgents {
+		if strings.Contains(agent, scripted) {
+			add(40, "scripted user agent %q", fp.UserAgent)
+			break
+		}
+	}
+
+	if fp.Captcha != nil && s.cfg.CaptchaHostname != "" && fp.Captcha.Hostname != s.cfg.CaptchaHostname {
+		add(50, "captcha solved on %q", fp.Captcha.Hostname)
+	}
+	return score, reasons
+}
+
+// Evaluate scores fp and records it. A request to review is held along with request, which is
+// handed back on approval. A pubkey rejected on review is refused from then on.
+func (s *Scorer) Evaluate(fp Fingerprint, request interface{}) (Record, error) {
+	rec := Record{Fingerprint: fp, Reasons: []string{}}
+	var previous Review
+	err := s.reviews.Get(fp.Pubkey, &previous)
+	if err != nil && err != storage.ErrNotFound {
+		return rec, err
+	}
+	if err == nil && previous.Status == ReviewRejected {
+		rec.Decision = DecisionRefuse
+		rec.Reasons = append(rec.Reasons, "rejected on review")
+		return rec, s.records.Put(recordID(fp), rec)
+	}
+
+	from := time.Unix(fp.Time, 0).Add(-time.Duration(s.cfg.Window) * time.Minute)
+	window, err := s.Records(from, time.Unix(fp.Time+1, 0))
+	if err != nil {
+		return rec, err
+	}
+	rec.Score, rec.Reasons = s.score(fp, window)
+	switch {
+	case rec.Score >= s.cfg.RefuseScore:
+		rec.Decision = DecisionRefuse
+	case rec.Score >= s.cfg.ReviewScore:
+		rec.Decision = DecisionReview
+	default:
+		rec.Decision = DecisionAllow
+	}
+	if err := s.records.Put(recordID(fp), rec); err != nil {
+		return rec, err
+	}
+	if rec.Decision == DecisionReview {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return rec, err
+		}
+		review := Review{Pubkey: fp.Pubkey, Record: rec, Request: data, Status: ReviewPending}
+		if err := s.reviews.Put(fp.Pubkey, review); err != nil {
+			return rec, err
+		}
+	}
+	return rec, nil
+}
+
+// Reviews returns the held requests with status, or all of them when it is empty.
+func (s *Scorer) Reviews(status string) ([]Review, error) {
+	result := []Review{}
+	err := s.reviews.Scan("", "", func(id string, data []byte) error {
+		var review Review
+		if err := json.Unmarshal(data, &review); err != nil {
+			return fmt.Errorf("sybil: decode review %v: %v", id, err)
+		}
+		if status == "" || review.Status == status {
+			result = append(result, review)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Decide settles the pending review of pubkey. On approval, onApprove carries out the request
+// first: the review stays pending if it fails.
+func (s *Scorer) Decide(pubkey string, approve bool, onApprove func(Review) error) (Review, error) {
+	var review Review
+	if err := s.reviews.Get(pubkey, &review); err != nil {
+		return review, err
+	}
+	if review.Status != ReviewPending {
+		return review, fmt.Errorf("sybil: the review of %v is already %v", pubkey, review.Status)
+	}
+	review.Status = ReviewRejected
+	if approve {
+		if err := onApprove(review); err != nil {
+			return review, err
+		}
+		review.Status = ReviewApproved
+	}
+	review.DecidedAt = time.Now().Unix()
+	return review, s.reviews.Put(pubkey, review)
+}
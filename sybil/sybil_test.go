@@ -0,0 +1,168 @@
+package sybil
+
+import (
+	"fmt"
+	"main/storage"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+const browser = "Mozilla/5.0 (X11; Linux x86_64) Firefox/94.0"
+
+func newTestScorer(t *testing.T, cfg Config) *Scorer {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	cfg.Enabled = true
+	return NewScorer(storage.NewLevelDB(db), cfg)
+}
+
+func fingerprint(at int64, pubkey, ip, agent string, shard int) Fingerprint {
+	return Fingerprint{Time: at, Pubkey: pubkey, IP: ip, Subnet: Subnet(ip), UserAgent: agent, Shard: shard}
+}
+
+func TestSubnet(t *testing.T) {
+	for ip, want := range map[string]string{
+		"203.0.113.7":       "203.0.113.0/24",
+		"2001:db8:1:2::7":   "2001:db8:1::/48",
+		"not an ip address": "not an ip address",
+	} {
+		if got := Subnet(ip); got != want {
+			t.Errorf("Subnet(%v) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	const now = 1637000000
+	tests := []struct {
+		name   string
+		window []Record
+		fp     Fingerprint
+		score  int
+	}{
+		{"browser alone", nil, fingerprint(now, "a", "203.0.113.7", browser, 0), 0},
+		{"no user agent", nil, fingerprint(now, "a", "203.0.113.7", "", 0), 40},
+		{"scripted user agent", nil, fingerprint(now, "a", "203.0.113.7", "Go-http-client/1.1", 0), 40},
+		{
+			"same ip",
+			[]Record{{Fingerprint: fingerprint(now-600, "b", "203.0.113.7", browser, 1)}},
+			fingerprint(now, "a", "203.0.113.7", browser, 0),
+			30,
+		},
+		{
+			"same subnet",
+			[]Record{{Fingerprint: fingerprint(now-600, "b", "203.0.113.8", browser, 1)}},
+			fingerprint(now, "a", "203.0.113.7", browser, 0),
+			10,
+		},
+		{
+			"same pubkey again",
+			[]Record{{Fingerprint: fingerprint(now-600, "a", "203.0.113.7", browser, 0)}},
+			fingerprint(now, "a", "203.0.113.7", browser, 0),
+			0,
+		},
+		{
+			"other subnet",
+			[]Record{{Fingerprint: fingerprint(now-10, "b", "198.51.100.7", browser, 0)}},
+			fingerprint(now, "a", "203.0.113.7", browser, 0),
+			0,
+		},
+		{
+			// 3 other addresses of the subnet on the same shard within a minute
+			"burst on a shard",
+			[]Record{
+				{Fingerprint: fingerprint(now-10, "b", "203.0.113.8", browser, 2)},
+				{Fingerprint: fingerprint(now-20, "c", "203.0.113.9", browser, 2)},
+				{Fingerprint: fingerprint(now-30, "d", "203.0.113.10", browser, 2)},
+			},
+			fingerprint(now, "a", "203.0.113.7", browser, 2),
+			3*10 + 15 + 20,
+		},
+	}
+	s := newTestScorer(t, Config{})
+	for _, tc := range tests {
+		score, reasons := s.score(tc.fp, tc.window)
+		if score != tc.score {
+			t.Errorf("%v: score %v, want %v: %v", tc.name, score, tc.score, reasons)
+		}
+	}
+
+	s = newTestScorer(t, Config{CaptchaHostname: "faucet.example"})
+	fp := fingerprint(now, "a", "203.0.113.7", browser, 0)
+	fp.Captcha = &Captcha{Hostname: "other.example"}
+	if score, _ := s.score(fp, nil); score != 50 {
+		t.Errorf("captcha solved elsewhere: score %v, want 50", score)
+	}
+	fp.Captcha.Hostname = "faucet.example"
+	if score, _ := s.score(fp, nil); score != 0 {
+		t.Errorf("captcha solved on the site: score %v, want 0", score)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	const now = 1637000000
+	s := newTestScorer(t, Config{})
+
+	// the scripted requests from a single IP get held, then refused as they pile up
+	for i, decision := range []string{DecisionAllow, DecisionReview, DecisionRefuse} {
+		fp := fingerprint(now+int64(i)*120, fmt.Sprint("key", i), "203.0.113.7", "curl/7.79", 0)
+		rec, err := s.Evaluate(fp, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Decision != decision {
+			t.Fatalf("request %v: %v with score %v, want %v", i, rec.Decision, rec.Score, decision)
+		}
+	}
+	held, err := s.Reviews(ReviewPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(held) != 1 || held[0].Pubkey != "key1" || string(held[0].Request) != "1" {
+		t.Fatalf("held: %+v", held)
+	}
+
+	// a key rejected on review is refused from then on, whatever its score
+	if _, err := s.Decide("key1", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := s.Evaluate(fingerprint(now+3600*24, "key1", "198.51.100.7", browser, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Decision != DecisionRefuse {
+		t.Fatalf("rejected key: %v", rec.Decision)
+	}
+	if _, err := s.Decide("key1", true, nil); err == nil {
+		t.Fatal("decided a review twice")
+	}
+}
+
+func TestDecideApproveFails(t *testing.T) {
+	const now = 1637000000
+	s := newTestScorer(t, Config{ReviewScore: 40})
+	if _, err := s.Evaluate(fingerprint(now, "a", "203.0.113.7", "", 0), "req"); err != nil {
+		t.Fatal(err)
+	}
+	// the review stays pending when the approved request cannot be carried out
+	if _, err := s.Decide("a", true, func(Review) error { return fmt.Errorf("no funds") }); err == nil {
+		t.Fatal("approval did not fail")
+	}
+	review, err := s.Decide("a", true, func(r Review) error {
+		if string(r.Request) != `"req"` {
+			t.Fatalf("request %s", r.Request)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.Status != ReviewApproved || review.DecidedAt == 0 {
+		t.Fatalf("review: %+v", review)
+	}
+}
@@ -0,0 +1,21 @@
+package txtracker
+
+import "expvar"
+
+var (
+	confirmedTxs = expvar.NewInt("txtracker_confirmed")
+	droppedTxs   = expvar.NewInt("txtracker_dropped")
+	timeoutTxs   = expvar.NewInt("txtracker_timeout")
+)
+
+// MetricsSubscriber counts final states in expvar, exposed at /debug/vars.
+func MetricsSubscriber(ev Event) {
+	switch ev.Status {
+	case StatusConfirmed:
+		confirmedTxs.Add(1)
+	case StatusDropped:
+		droppedTxs.Add(1)
+	case StatusTimeout:
+		timeoutTxs.Add(1)
+	}
+}
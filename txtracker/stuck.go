@@ -0,0 +1,197 @@
+package txtracker
+
+import (
+	"log"
+	"time"
+)
+
+type stuckTx struct {
+	tx     *PendingTx
+	status Status
+}
+
+func (t *Tracker) rebroadcast(tx *PendingTx) {
+	var err error
+	if tx.IsTokenTx {
+		err = t.node.SendRawTokenTx(tx.RawTx)
+	} else {
+		err = t.node.SendRawTx(tx.RawTx)
+	}
+	if err != nil {
+		log.Printf("txtracker: rebroadcast %v error: %v\n", tx.TxHash, err)
+	}
+	t.lock.Lock()
+	tx.Rebroadcasts++
+	tx.LastBroadcast = time.Now().Unix()
+	if err := t.save(tx); err != nil {
+		log.Println("txtracker:", err)
+	}
+	t.lock.Unlock()
+}
+
+// resolveStuck handles a tx that timed out or that the node forgot about. As long as its inputs
+// are unspent, it is replaced by a tx spending the very same inputs, so that at most one of them
+// can ever be confirmed.
+func (t *Tracker) resolveStuck(tx *PendingTx, status Status) []Event {
+	// Track may merge into tx meanwhile, so the fields are read from a copy
+	t.lock.Lock()
+	stuck := tx.clone()
+	replacer := t.replacers[tx.Kind]
+	t.lock.Unlock()
+
+	if len(stuck.Inputs) == 0 {
+		return t.giveUp(tx, status)
+	}
+	onChain, inMempool, err := t.inputsSpent(&stuck)
+	if err != nil {
+		// keep the tx pending and look again at the next round
+		log.Printf("txtracker: checking inputs of %v error: %v\n", stuck.TxHash, err)
+		return nil
+	}
+	if onChain {
+		// the tx, or one it replaced, may have landed since it was checked
+		for _, txHash := range append([]string{stuck.TxHash}, stuck.Replaces...) {
+			isInBlock, err := t.node.CheckTxInBlock(txHash)
+			if err == nil && isInBlock {
+				return t.settleLanded(tx, txHash)
+			}
+		}
+		log.Printf("txtracker: inputs of %v were spent by an unknown tx\n", stuck.TxHash)
+		events := t.giveUp(tx, StatusDropped)
+		events[0].Conflicted = true
+		return events
+	}
+	if inMempool {
+		// the tx or another one spending its inputs may still be confirmed, and a replacement
+		// would be refused as a double spend
+		log.Printf("txtracker: inputs of %v are spent in the mempool\n", stuck.TxHash)
+		return t.wait(tx, status)
+	}
+
+	if replacer == nil || len(stuck.Replaces) >= t.cfg.MaxReplacements {
+		return t.giveUp(tx, status)
+	}
+	newTx, err := replacer(stuck)
+	if err != nil {
+		log.Printf("txtracker: replacing %v error: %v\n", stuck.TxHash, err)
+		return t.giveUp(tx, status)
+	}
+	now := time.Now().Unix()
+	newTx.Kind = stuck.Kind
+	newTx.Owner = stuck.Owner
+	newTx.CreatedAt = now
+	newTx.Deadline = now + (stuck.Deadline - stuck.CreatedAt)
+	newTx.LastBroadcast = now
+	newTx.Replaces = append(stuck.Replaces, stuck.TxHash)
+	if newTx.Inputs == nil {
+		newTx.Inputs = stuck.Inputs
+	}
+	if newTx.ShardID == 0 {
+		newTx.ShardID = stuck.ShardID
+	}
+	if newTx.IsTokenTx {
+		err = t.node.SendRawTokenTx(newTx.RawTx)
+	} else {
+		err = t.node.SendRawTx(newTx.RawTx)
+	}
+	if err != nil {
+		log.Printf("txtracker: sending replacement %v of %v error: %v\n", newTx.TxHash, stuck.TxHash, err)
+		// a tx still sitting in the mempool must not be given up on, it can still be confirmed
+		if _, err := t.node.CheckTxInBlock(stuck.TxHash); err == nil {
+			return t.wait(tx, status)
+		}
+		return t.giveUp(tx, status)
+	}
+	log.Printf("txtracker: %v replaced by %v\n", stuck.TxHash, newTx.TxHash)
+
+	t.lock.Lock()
+	ev := t.settle(tx, StatusReplaced)
+	ev.ReplacedBy = newTx.TxHash
+	replacement := *newTx
+	replacement.RawTx = nil
+	ev.Replacement = &replacement
+	t.pending[newTx.TxHash] = newTx
+	if err := t.save(newTx); err != nil {
+		log.Println("txtracker:", err)
+	}
+	t.lock.Unlock()
+	return []Event{ev}
+}
+
+// wait leaves a stuck tx pending for another RebroadcastAfter, up to MaxReplacements times before
+// it is given up on.
+func (t *Tracker) wait(tx *PendingTx, status Status) []Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if tx.FailedReplacements >= t.cfg.MaxReplacements {
+		return []Event{t.settle(tx, status)}
+	}
+	tx.FailedReplacements++
+	tx.Misses = 0
+	tx.Deadline = time.Now().Unix() + int64(t.cfg.RebroadcastAfter.Seconds())
+	if err := t.save(tx); err != nil {
+		log.Println("txtracker:", err)
+	}
+	return nil
+}
+
+// settleLanded settles tx once landedHash, either tx itself or a tx it replaced, is in a block.
+func (t *Tracker) settleLanded(tx *PendingTx, landedHash string) []Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if landedHash == tx.TxHash {
+		return []Event{t.settle(tx, StatusConfirmed)}
+	}
+	replaced := t.settle(tx, StatusReplaced)
+	replaced.ReplacedBy = landedHash
+	landed := *tx
+	landed.TxHash = landedHash
+	landed.RawTx = nil
+	landed.Replaces = nil
+	return []Event{replaced, {Tx: landed, Status: StatusConfirmed}}
+}
+
+func (t *Tracker) giveUp(tx *PendingTx, status Status) []Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return []Event{t.settle(tx, status)}
+}
+
+// inputsSpent returns whether any input of tx is spent on the chain, or else by a tx in the
+// mempool.
+func (t *Tracker) inputsSpent(tx *PendingTx) (onChain, inMempool bool, err error) {
+	keyImages := []string{}
+	for tokenID, tokenKeyImages := range tx.Inputs {
+		spentList, err := t.node.CheckCoinsSpent(byte(tx.ShardID), tokenID, tokenKeyImages)
+		if err != nil {
+			return false, false, err
+		}
+		for _, spent := range spentList {
+			if spent {
+				return true, false, nil
+			}
+		}
+		keyImages = append(keyImages, tokenKeyImages...)
+	}
+	spentList, err := t.node.CheckCoinsInMempool(keyImages)
+	if err != nil {
+		return false, false, err
+	}
+	for _, spent := range spentList {
+		if spent {
+			return false, true, nil
+		}
+	}
+	return false, false, nil
+}
+
+// clone copies tx along with its inputs and replaced txs.
+func (tx *PendingTx) clone() PendingTx {
+	c := *tx
+	c.Inputs = make(map[string][]string, len(tx.Inputs))
+	for tokenID, keyImages := range tx.Inputs {
+		c.Inputs[tokenID] = append([]string{}, keyImages...)
+	}
+	c.Replaces = append([]string{}, tx.Replaces...)
+	return c
+}
@@ -0,0 +1,420 @@
+package txtracker
+
+import (
+	"encoding/json"
+	"log"
+	"main/storage"
+	"sync"
+	"time"
+)
+
+// CollectionName is the collection of the pending txs persisted by a Tracker, keyed by txHash.
+const CollectionName = "tracker"
+
+// Status is the final state of a tracked tx.
+type Status int
+
+const (
+	StatusConfirmed Status = iota + 1
+	StatusDropped
+	StatusTimeout
+	// StatusReplaced means the tx was superseded by Event.ReplacedBy, which spends the same inputs.
+	StatusReplaced
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusConfirmed:
+		return "confirmed"
+	case StatusDropped:
+		return "dropped"
+	case StatusTimeout:
+		return "timeout"
+	case StatusReplaced:
+		return "replaced"
+	}
+	return "unknown"
+}
+
+// PendingTx is a tx waiting to be included in a block.
+type PendingTx struct {
+	TxHash string
+	// Kind tells subscribers what the tx is for (e.g. "airdrop", "utxo").
+	Kind string
+	// Owner is an optional reference to the record the tx belongs to (e.g. a user key).
+	Owner     string
+	CreatedAt int64
+	Deadline  int64
+	// Misses counts consecutive polls in which the fullnode did not know the tx.
+	Misses int
+
+	// RawTx is the encoded tx, kept to rebroadcast it while it is stuck.
+	RawTx         []byte
+	IsTokenTx     bool
+	ShardID       int
+	Fee           uint64
+	LastBroadcast int64
+	Rebroadcasts  int
+	// FailedReplacements counts the times the tx was left waiting past its deadline, its
+	// replacement being refused or its inputs being spent in the mempool.
+	FailedReplacements int
+	// Inputs lists the key images spent by the tx, by tokenID.
+	Inputs map[string][]string
+	// Replaces lists the earlier txs spending the same inputs that this tx superseded.
+	Replaces []string
+}
+
+// Event is published to subscribers when a tracked tx reaches a final state.
+type Event struct {
+	Tx     PendingTx
+	Status Status
+	// ReplacedBy is the tx that took over when Status is StatusReplaced.
+	ReplacedBy string
+	// Replacement is the newly broadcast tx when ReplacedBy was created for this one, as opposed to
+	// an earlier tx that landed instead.
+	Replacement *PendingTx
+	// Conflicted is set when the inputs of the tx were spent by another tx, so that it can never
+	// be confirmed anymore.
+	Conflicted bool
+}
+
+// Node is the part of the fullnode client used by a Tracker. CheckTxInBlock returns an error if
+// the node does not know the tx, GetBestBlock tells whether the node answers at all. CheckCoinsSpent only looks at the chain, CheckCoinsInMempool at
+// the txs waiting in the mempool.
+type Node interface {
+	CheckTxInBlock(txHash string) (bool, error)
+	GetBestBlock() (map[int]uint64, error)
+	SendRawTx(encodedTx []byte) error
+	SendRawTokenTx(encodedTx []byte) error
+	CheckCoinsSpent(shardID byte, tokenID string, snList []string) ([]bool, error)
+	CheckCoinsInMempool(snList []string) ([]bool, error)
+}
+
+// Replacer builds (but does not send) a tx spending the same inputs as a stuck one. The returned
+// tx needs TxHash and RawTx; the Tracker fills in what it already knows about the stuck tx.
+type Replacer func(stuck PendingTx) (*PendingTx, error)
+
+// Config controls how a Tracker polls the fullnode.
+type Config struct {
+	// MinInterval is the poll interval used right after a tracked tx changes state.
+	MinInterval time.Duration
+	// MaxInterval caps the interval, which doubles after every round without changes.
+	MaxInterval time.Duration
+	// BatchSize is the number of txs checked concurrently.
+	BatchSize int
+	// MaxMisses is the number of consecutive unknown-tx polls after which a tx is dropped.
+	MaxMisses int
+	// RebroadcastAfter is how long a tx with a RawTx may wait before its bytes are sent again.
+	RebroadcastAfter time.Duration
+	MaxRebroadcasts  int
+	// MaxReplacements bounds how many times the same inputs are re-spent by a replacement.
+	MaxReplacements int
+}
+
+// DefaultConfig returns the Config used by the airdrop services.
+func DefaultConfig() Config {
+	return Config{
+		MinInterval:      5 * time.Second,
+		MaxInterval:      60 * time.Second,
+		BatchSize:        20,
+		MaxMisses:        10,
+		RebroadcastAfter: 5 * time.Minute,
+		MaxRebroadcasts:  3,
+		MaxReplacements:  3,
+	}
+}
+
+// Tracker watches pending txs in a single loop and publishes their final state.
+type Tracker struct {
+	cfg  Config
+	node Node
+	db   storage.Collection
+
+	lock        sync.Mutex
+	pending     map[string]*PendingTx
+	subscribers []func(Event)
+	replacers   map[string]Replacer
+	waiters     map[string][]chan Event
+	wake        chan struct{}
+}
+
+// NewTracker creates a Tracker and restores the pending txs persisted in store. A nil store keeps
+// the pending set in memory only.
+func NewTracker(node Node, store storage.Store, cfg Config) (*Tracker, error) {
+	t := &Tracker{
+		cfg:       cfg,
+		node:      node,
+		pending:   make(map[string]*PendingTx),
+		replacers: make(map[string]Replacer),
+		waiters:   make(map[string][]chan Event),
+		wake:      make(chan struct{}, 1),
+	}
+	if store == nil {
+		return t, nil
+	}
+	t.db = store.Collection(CollectionName)
+	err := t.db.Scan("", "", func(id string, data []byte) error {
+		tx := new(PendingTx)
+		if err := json.Unmarshal(data, tx); err != nil {
+			return err
+		}
+		t.pending[tx.TxHash] = tx
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("txtracker: restored %v pending txs\n", len(t.pending))
+	return t, nil
+}
+
+// Subscribe registers fn to be called for every final state. Subscribers are called
+// sequentially from the polling loop and must not block.
+func (t *Tracker) Subscribe(fn func(Event)) {
+	t.lock.Lock()
+	t.subscribers = append(t.subscribers, fn)
+	t.lock.Unlock()
+}
+
+// SetReplacer registers how stuck txs of the given kind are replaced. Stuck txs of a kind
+// without a Replacer are given up on.
+func (t *Tracker) SetReplacer(kind string, fn Replacer) {
+	t.lock.Lock()
+	t.replacers[kind] = fn
+	t.lock.Unlock()
+}
+
+// Track starts watching a tx until it is confirmed, dropped or its deadline passes.
+// Tracking a hash that is already pending merges the details that were not known yet.
+func (t *Tracker) Track(tx PendingTx) error {
+	now := time.Now().Unix()
+	if tx.CreatedAt == 0 {
+		tx.CreatedAt = now
+	}
+	if tx.RawTx != nil && tx.LastBroadcast == 0 {
+		tx.LastBroadcast = now
+	}
+	t.lock.Lock()
+	if old, ok := t.pending[tx.TxHash]; ok {
+		mergePendingTx(old, &tx)
+		tx = *old
+	} else {
+		t.pending[tx.TxHash] = &tx
+	}
+	err := t.save(&tx)
+	t.lock.Unlock()
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+func mergePendingTx(old, tx *PendingTx) {
+	if old.Owner == "" && tx.Owner != "" {
+		old.Kind = tx.Kind
+		old.Owner = tx.Owner
+	}
+	if tx.Deadline > old.Deadline {
+		old.Deadline = tx.Deadline
+	}
+	if old.RawTx == nil && tx.RawTx != nil {
+		old.RawTx = tx.RawTx
+		old.IsTokenTx = tx.IsTokenTx
+		old.LastBroadcast = tx.LastBroadcast
+	}
+	if old.ShardID == 0 {
+		old.ShardID = tx.ShardID
+	}
+	if old.Fee == 0 {
+		old.Fee = tx.Fee
+	}
+	for tokenID, keyImages := range tx.Inputs {
+		if old.Inputs == nil {
+			old.Inputs = make(map[string][]string)
+		}
+		old.Inputs[tokenID] = append(old.Inputs[tokenID], keyImages...)
+	}
+}
+
+// IsTracked returns whether txHash is pending in the tracker.
+func (t *Tracker) IsTracked(txHash string) bool {
+	t.lock.Lock()
+	_, ok := t.pending[txHash]
+	t.lock.Unlock()
+	return ok
+}
+
+// Await returns a channel that receives the final state of txHash. It should be called
+// before Track so that the event cannot be missed. A replaced tx is followed to its replacement.
+func (t *Tracker) Await(txHash string) <-chan Event {
+	ch := make(chan Event, 1)
+	t.lock.Lock()
+	t.waiters[txHash] = append(t.waiters[txHash], ch)
+	t.lock.Unlock()
+	return ch
+}
+
+// Start runs the polling loop. It never returns.
+func (t *Tracker) Start() {
+	interval := t.cfg.MinInterval
+	for {
+		t.lock.Lock()
+		numPending := len(t.pending)
+		t.lock.Unlock()
+		if numPending == 0 {
+			// new txs never confirm within a block time, the wake-up only ends the idle wait
+			<-t.wake
+			interval = t.cfg.MinInterval
+		}
+		time.Sleep(interval)
+		if t.poll() {
+			interval = t.cfg.MinInterval
+		} else {
+			interval *= 2
+			if interval > t.cfg.MaxInterval {
+				interval = t.cfg.MaxInterval
+			}
+		}
+	}
+}
+
+type checkResult struct {
+	tx        *PendingTx
+	isInBlock bool
+	err       error
+}
+
+// poll checks every pending tx once, in batches, and returns whether any tx reached a final state.
+func (t *Tracker) poll() bool {
+	t.lock.Lock()
+	txList := make([]*PendingTx, 0, len(t.pending))
+	for _, tx := range t.pending {
+		txList = append(txList, tx)
+	}
+	t.lock.Unlock()
+	if len(txList) == 0 {
+		return false
+	}
+
+	// an unknown tx and an unreachable fullnode give the same error, so the node is probed first
+	if _, err := t.node.GetBestBlock(); err != nil {
+		log.Println("txtracker: fullnode unavailable:", err)
+		return false
+	}
+
+	results := make([]checkResult, len(txList))
+	for start := 0; start < len(txList); start += t.cfg.BatchSize {
+		end := start + t.cfg.BatchSize
+		if end > len(txList) {
+			end = len(txList)
+		}
+		var wg sync.WaitGroup
+		for i := start; i < end; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				isInBlock, err := t.node.CheckTxInBlock(txList[i].TxHash)
+				results[i] = checkResult{tx: txList[i], isInBlock: isInBlock, err: err}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	now := time.Now().Unix()
+	events := []Event{}
+	stuckList := []stuckTx{}
+	rebroadcastList := []*PendingTx{}
+	t.lock.Lock()
+	for _, r := range results {
+		tx := r.tx
+		status := Status(0)
+		oldMisses := tx.Misses
+		switch {
+		case r.err == nil && r.isInBlock:
+			status = StatusConfirmed
+		case r.err != nil:
+			tx.Misses++
+			if tx.Misses >= t.cfg.MaxMisses {
+				status = StatusDropped
+			}
+		case r.err == nil:
+			tx.Misses = 0
+		}
+		if status == 0 && tx.Deadline != 0 && now > tx.Deadline {
+			status = StatusTimeout
+		}
+		switch status {
+		case 0:
+			if tx.RawTx != nil && tx.Rebroadcasts < t.cfg.MaxRebroadcasts &&
+				now-tx.LastBroadcast > int64(t.cfg.RebroadcastAfter.Seconds()) {
+				rebroadcastList = append(rebroadcastList, tx)
+			} else if tx.Misses != oldMisses {
+				if err := t.save(tx); err != nil {
+					log.Println("txtracker:", err)
+				}
+			}
+		case StatusConfirmed:
+			events = append(events, t.settle(tx, status))
+		default:
+			stuckList = append(stuckList, stuckTx{tx: tx, status: status})
+		}
+	}
+	t.lock.Unlock()
+
+	for _, tx := range rebroadcastList {
+		t.rebroadcast(tx)
+	}
+	for _, s := range stuckList {
+		events = append(events, t.resolveStuck(s.tx, s.status)...)
+	}
+	t.publish(events)
+	return len(events) > 0
+}
+
+// settle removes tx from the pending set. It must be called with t.lock held.
+func (t *Tracker) settle(tx *PendingTx, status Status) Event {
+	delete(t.pending, tx.TxHash)
+	if err := t.remove(tx.TxHash); err != nil {
+		log.Println("txtracker:", err)
+	}
+	return Event{Tx: *tx, Status: status}
+}
+
+func (t *Tracker) publish(events []Event) {
+	t.lock.Lock()
+	subscribers := t.subscribers
+	t.lock.Unlock()
+
+	for _, ev := range events {
+		for _, fn := range subscribers {
+			fn(ev)
+		}
+		t.lock.Lock()
+		waiters := t.waiters[ev.Tx.TxHash]
+		delete(t.waiters, ev.Tx.TxHash)
+		if ev.Status == StatusReplaced {
+			t.waiters[ev.ReplacedBy] = append(t.waiters[ev.ReplacedBy], waiters...)
+			waiters = nil
+		}
+		t.lock.Unlock()
+		for _, ch := range waiters {
+			ch <- ev
+		}
+	}
+}
+
+func (t *Tracker) save(tx *PendingTx) error {
+	if t.db == nil {
+		return nil
+	}
+	return t.db.Put(tx.TxHash, tx)
+}
+
+func (t *Tracker) remove(txHash string) error {
+	if t.db == nil {
+		return nil
+	}
+	return t.db.Delete(txHash)
+}
@@ -0,0 +1,278 @@
+package txtracker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNode knows the txs of inBlock, confirmed or not, and the key images spent on the chain or in
+// the mempool.
+type fakeNode struct {
+	lock    sync.Mutex
+	inBlock map[string]bool
+	spent   map[string]bool
+	mempool map[string]bool
+	sendErr error
+	sent    int
+	down    bool
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{inBlock: map[string]bool{}, spent: map[string]bool{}, mempool: map[string]bool{}}
+}
+
+func (n *fakeNode) CheckTxInBlock(txHash string) (bool, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	isInBlock, ok := n.inBlock[txHash]
+	if !ok {
+		return false, fmt.Errorf("tx %v not found", txHash)
+	}
+	return isInBlock, nil
+}
+
+func (n *fakeNode) GetBestBlock() (map[int]uint64, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.down {
+		return nil, errors.New("connection refused")
+	}
+	return map[int]uint64{0: 1}, nil
+}
+
+func (n *fakeNode) SendRawTx(encodedTx []byte) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.sendErr != nil {
+		return n.sendErr
+	}
+	n.sent++
+	n.inBlock[string(encodedTx)] = false
+	return nil
+}
+
+func (n *fakeNode) SendRawTokenTx(encodedTx []byte) error {
+	return n.SendRawTx(encodedTx)
+}
+
+func (n *fakeNode) CheckCoinsSpent(shardID byte, tokenID string, snList []string) ([]bool, error) {
+	return n.check(n.spent, snList), nil
+}
+
+func (n *fakeNode) CheckCoinsInMempool(snList []string) ([]bool, error) {
+	return n.check(n.mempool, snList), nil
+}
+
+func (n *fakeNode) check(spent map[string]bool, snList []string) []bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	res := make([]bool, len(snList))
+	for i, sn := range snList {
+		res[i] = spent[sn]
+	}
+	return res
+}
+
+func newTestTracker(t *testing.T, node Node) (*Tracker, *[]Event) {
+	cfg := DefaultConfig()
+	cfg.MaxMisses = 2
+	cfg.MaxReplacements = 2
+	tracker, err := NewTracker(node, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := &[]Event{}
+	tracker.Subscribe(func(ev Event) { *events = append(*events, ev) })
+	return tracker, events
+}
+
+// replaceWith returns a Replacer numbering its txs after the stuck one, the raw tx being the hash
+// so that fakeNode learns it when sent.
+func replaceWith(err error) Replacer {
+	return func(stuck PendingTx) (*PendingTx, error) {
+		if err != nil {
+			return nil, err
+		}
+		txHash := fmt.Sprintf("%v'", stuck.TxHash)
+		return &PendingTx{TxHash: txHash, RawTx: []byte(txHash), Fee: 2 * stuck.Fee}, nil
+	}
+}
+
+func TestPollTransitions(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name     string
+		known    bool
+		inBlock  bool
+		deadline int64
+		polls    int
+		want     Status
+	}{
+		{"confirmed", true, true, 0, 1, StatusConfirmed},
+		{"pending", true, false, now + 3600, 3, 0},
+		{"missed once", false, false, 0, 1, 0},
+		{"dropped", false, false, 0, 2, StatusDropped},
+		{"timeout", true, false, now - 1, 1, StatusTimeout},
+	}
+	for _, tc := range tests {
+		node := newFakeNode()
+		if tc.known {
+			node.inBlock["tx"] = tc.inBlock
+		}
+		tracker, events := newTestTracker(t, node)
+		tracker.Track(PendingTx{TxHash: "tx", Deadline: tc.deadline})
+		for i := 0; i < tc.polls; i++ {
+			tracker.poll()
+		}
+		if tc.want == 0 {
+			if len(*events) != 0 || !tracker.IsTracked("tx") {
+				t.Errorf("%v: events %+v, tracked %v", tc.name, *events, tracker.IsTracked("tx"))
+			}
+			continue
+		}
+		if len(*events) != 1 || (*events)[0].Status != tc.want || tracker.IsTracked("tx") {
+			t.Errorf("%v: events %+v, want %v", tc.name, *events, tc.want)
+		}
+	}
+}
+
+func TestPollNodeDown(t *testing.T) {
+	node := newFakeNode()
+	node.down = true
+	tracker, events := newTestTracker(t, node)
+	tracker.Track(PendingTx{TxHash: "tx", RawTx: []byte("tx"), LastBroadcast: 1})
+	for i := 0; i < 3; i++ {
+		tracker.poll()
+	}
+	if len(*events) != 0 || tracker.pending["tx"].Misses != 0 || node.sent != 0 {
+		t.Fatalf("events %+v, misses %v, sent %v", *events, tracker.pending["tx"].Misses, node.sent)
+	}
+}
+
+// TestPollUnknownTx checks that a single pending tx the node does not know is not taken for the
+// node being down.
+func TestPollUnknownTx(t *testing.T) {
+	node := newFakeNode()
+	tracker, events := newTestTracker(t, node)
+	tracker.Track(PendingTx{TxHash: "tx", RawTx: []byte("tx"), LastBroadcast: 1})
+	tracker.poll()
+	if len(*events) != 0 || node.sent != 1 || tracker.pending["tx"].Rebroadcasts != 1 {
+		t.Fatalf("events %+v, sent %v", *events, node.sent)
+	}
+	if tracker.pending["tx"].Misses != 1 {
+		t.Fatalf("misses %v", tracker.pending["tx"].Misses)
+	}
+}
+
+func TestResolveStuck(t *testing.T) {
+	tests := []struct {
+		name       string
+		spent      bool
+		mempool    bool
+		landed     string
+		replaceErr error
+		sendErr    error
+		want       []Status
+		conflicted bool
+		pending    string
+	}{
+		{"replaced", false, false, "", nil, nil, []Status{StatusReplaced}, false, "tx'"},
+		{"replacer failed", false, false, "", errors.New("no coins"), nil, []Status{StatusTimeout}, false, ""},
+		{"replacement refused", false, false, "", nil, errors.New("rejected"), nil, false, "tx"},
+		{"inputs in the mempool", false, true, "", nil, nil, nil, false, "tx"},
+		{"replaced tx landed", true, false, "old", nil, nil, []Status{StatusReplaced, StatusConfirmed}, false, ""},
+		{"conflict", true, false, "", nil, nil, []Status{StatusDropped}, true, ""},
+	}
+	for _, tc := range tests {
+		node := newFakeNode()
+		node.inBlock["tx"] = false
+		node.spent["ki"] = tc.spent
+		node.mempool["ki"] = tc.mempool
+		node.sendErr = tc.sendErr
+		if tc.landed != "" {
+			node.inBlock[tc.landed] = true
+		}
+		tracker, events := newTestTracker(t, node)
+		tracker.SetReplacer("airdrop", replaceWith(tc.replaceErr))
+		now := time.Now().Unix()
+		tracker.Track(PendingTx{
+			TxHash:    "tx",
+			Kind:      "airdrop",
+			CreatedAt: now - 60,
+			Deadline:  now - 1,
+			Inputs:    map[string][]string{"prv": {"ki"}},
+			Replaces:  []string{"old"},
+		})
+		tracker.poll()
+
+		got := []Status{}
+		for _, ev := range *events {
+			got = append(got, ev.Status)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%v: statuses %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		if len(*events) > 0 && (*events)[0].Conflicted != tc.conflicted {
+			t.Errorf("%v: conflicted %v", tc.name, (*events)[0].Conflicted)
+		}
+		if tc.pending == "" {
+			if len(tracker.pending) != 0 {
+				t.Errorf("%v: still pending %v", tc.name, tracker.pending)
+			}
+			continue
+		}
+		tx, ok := tracker.pending[tc.pending]
+		if !ok || len(tracker.pending) != 1 {
+			t.Errorf("%v: pending %v, want %v", tc.name, tracker.pending, tc.pending)
+			continue
+		}
+		if tc.pending == "tx" && (tx.FailedReplacements != 1 || tx.Deadline <= now) {
+			t.Errorf("%v: not left waiting: %+v", tc.name, tx)
+		}
+		if tc.pending == "tx'" && (fmt.Sprint(tx.Replaces) != "[old tx]" || tx.Deadline-tx.CreatedAt != 59 || tx.Kind != "airdrop") {
+			t.Errorf("%v: replacement %+v", tc.name, tx)
+		}
+	}
+}
+
+func TestReplacementLimit(t *testing.T) {
+	node := newFakeNode()
+	node.inBlock["tx"] = false
+	tracker, events := newTestTracker(t, node)
+	tracker.SetReplacer("airdrop", replaceWith(nil))
+	tracker.Track(PendingTx{TxHash: "tx", Kind: "airdrop", Fee: 100, Inputs: map[string][]string{"prv": {"ki"}}})
+
+	// each replacement times out in turn, the inputs never getting spent
+	txHash := "tx"
+	for i := 0; i <= 2; i++ {
+		tracker.pending[txHash].Deadline = time.Now().Unix() - 1
+		tracker.poll()
+		txHash += "'"
+	}
+	if node.sent != 2 || len(tracker.pending) != 0 {
+		t.Fatalf("sent %v, pending %v", node.sent, tracker.pending)
+	}
+	last := (*events)[len(*events)-1]
+	if len(*events) != 3 || last.Status != StatusTimeout || len(last.Tx.Replaces) != 2 {
+		t.Fatalf("events %+v", *events)
+	}
+
+	// a tx whose inputs stay in the mempool waits as many times before it is given up on
+	node.mempool["ki2"] = true
+	tracker.Track(PendingTx{TxHash: "tx2", Kind: "airdrop", Inputs: map[string][]string{"prv": {"ki2"}}})
+	node.inBlock["tx2"] = false
+	for i := 0; i <= 2; i++ {
+		tracker.pending["tx2"].Deadline = time.Now().Unix() - 1
+		tracker.poll()
+	}
+	if node.sent != 2 || tracker.IsTracked("tx2") {
+		t.Fatalf("sent %v, tx2 tracked %v", node.sent, tracker.IsTracked("tx2"))
+	}
+	if last := (*events)[len(*events)-1]; last.Tx.TxHash != "tx2" || last.Status != StatusTimeout || last.Tx.FailedReplacements != 2 {
+		t.Fatalf("last event %+v", last)
+	}
+}
@@ -0,0 +1,337 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"main/storage"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CollectionName is the collection of the deliveries waiting to be made.
+const CollectionName = "webhook"
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body, keyed by the secret of the API key.
+const SignatureHeader = "X-Signature"
+
+// Events notified for an airdrop request.
+const (
+	EventConfirmed = "confirmed"
+	EventFailed    = "failed"
+)
+
+// Key is an API key registered by an integrator, with the URL notified for its requests when they
+// do not give one.
+type Key struct {
+	APIKey      string
+	CallbackURL string
+	Secret      string
+}
+
+// Payload is the body posted when an airdrop request reaches a terminal state.
+type Payload struct {
+	Event          string
+	PaymentAddress string
+	TxHashes       []string
+	Time           int64
+}
+
+type Config struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Timeout     time.Duration
+	// AllowPrivate lets the callbacks reach loopback, private and link-local addresses, which are
+	// refused by default so that a callback url cannot probe the internal network.
+	AllowPrivate bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts: 10,
+		BaseDelay:   10 * time.Second,
+		MaxDelay:    time.Hour,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// delivery is a pending POST, persisted so that retries survive restarts.
+type delivery struct {
+	ID          string
+	URL         string
+	APIKey      string
+	Body        []byte
+	Attempts    int
+	NextAttempt int64
+}
+
+// Dispatcher posts the payloads and retries failed deliveries with exponential backoff.
+type Dispatcher struct {
+	deliveries    storage.Collection
+	keys          map[string]Key
+	defaultSecret string
+	cfg           Config
+	client        *http.Client
+	wake          chan struct{}
+}
+
+// NewDispatcher returns a dispatcher signing with the secret of each API key, or defaultSecret for
+// requests made without one.
+func NewDispatcher(store storage.Store, keys []Key, defaultSecret string, cfg Config) *Dispatcher {
+	d := &Dispatcher{
+		deliveries:    store.Collection(CollectionName),
+		keys:          make(map[string]Key),
+		defaultSecret: defaultSecret,
+		cfg:           cfg,
+		wake:          make(chan struct{}, 1),
+	}
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	d.client = &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: &http.Transport{DialContext: d.dialContext(dialer)},
+		// a redirect would lead the callback anywhere: it counts as a failed delivery
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, k := range keys {
+		d.keys[k.APIKey] = k
+	}
+	return d
+}
+
+// Callback returns the URL to notify for a request: callbackURL if given, otherwise the one
+// registered for apiKey. An empty result means no notification. Anyone may give a callbackURL, so
+// the internal addresses are refused here and again when the host is resolved.
+func (d *Dispatcher) Callback(apiKey, callbackURL string) (string, error) {
+	if apiKey != "" {
+		key, ok := d.keys[apiKey]
+		if !ok {
+			return "", fmt.Errorf("unknown api key")
+		}
+		if callbackURL == "" {
+			callbackURL = key.CallbackURL
+		}
+	}
+	if callbackURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid callback url")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !d.cfg.AllowPrivate && isInternal(ip) {
+		return "", fmt.Errorf("callback url on an internal address")
+	}
+	return callbackURL, nil
+}
+
+// internalNets are the private and shared address ranges, which net.IP has no method for in go1.16.
+var internalNets = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return ipNet
+}
+
+// isInternal tells whether ip is a loopback, private, link-local (cloud metadata among them) or
+// otherwise non-public address.
+func isInternal(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, ipNet := range internalNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// errInternalAddress is returned for a delivery to a host resolving to an internal address.
+var errInternalAddress = errors.New("callback host resolves to an internal address")
+
+// dialContext resolves the host itself and dials the address it checked, so that a DNS answer
+// changing in between cannot lead the delivery to an internal address.
+func (d *Dispatcher) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no address for %v", host)
+		}
+		if !d.cfg.AllowPrivate {
+			for _, ip := range ips {
+				if isInternal(ip.IP) {
+					return nil, errInternalAddress
+				}
+			}
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+	}
+}
+
+// Notify queues payload for callbackURL. It is a no-op without a URL.
+func (d *Dispatcher) Notify(apiKey, callbackURL string, payload Payload) error {
+	if callbackURL == "" {
+		return nil
+	}
+	if payload.Time == 0 {
+		payload.Time = time.Now().Unix()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	dl := delivery{
+		ID:          fmt.Sprintf("%020d", time.Now().UnixNano()),
+		URL:         callbackURL,
+		APIKey:      apiKey,
+		Body:        body,
+		NextAttempt: time.Now().Unix(),
+	}
+	if err := d.save(&dl); err != nil {
+		return err
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Sign returns the signature of body sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Start makes the due deliveries, forever.
+func (d *Dispatcher) Start() {
+	for {
+		d.deliverDue()
+		select {
+		case <-d.wake:
+		case <-time.After(d.cfg.BaseDelay):
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue() {
+	now := time.Now().Unix()
+	due := []delivery{}
+	err := d.deliveries.Scan("", "", func(id string, data []byte) error {
+		var dl delivery
+		if err := json.Unmarshal(data, &dl); err != nil {
+			log.Printf("webhook: decode %v: %v\n", id, err)
+			return nil
+		}
+		if dl.NextAttempt <= now {
+			due = append(due, dl)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("webhook:", err)
+	}
+
+	for i := range due {
+		dl := &due[i]
+		err := d.post(dl)
+		if err == nil {
+			d.remove(dl)
+			continue
+		}
+		dl.Attempts++
+		var rejected errRejected
+		if errors.As(err, &rejected) {
+			log.Printf("webhook: %v refused the delivery: %v\n", dl.URL, err)
+			d.remove(dl)
+			continue
+		}
+		if dl.Attempts >= d.cfg.MaxAttempts {
+			log.Printf("webhook: giving up on %v after %v attempts: %v\n", dl.URL, dl.Attempts, err)
+			d.remove(dl)
+			continue
+		}
+		delay := d.cfg.BaseDelay << uint(dl.Attempts-1)
+		if delay > d.cfg.MaxDelay || delay <= 0 {
+			delay = d.cfg.MaxDelay
+		}
+		dl.NextAttempt = time.Now().Add(delay).Unix()
+		if err := d.save(dl); err != nil {
+			log.Println("webhook:", err)
+		}
+	}
+}
+
+func (d *Dispatcher) post(dl *delivery) error {
+	secret := d.defaultSecret
+	if key, ok := d.keys[dl.APIKey]; ok {
+		secret = key.Secret
+	}
+	req, err := http.NewRequest(http.MethodPost, dl.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(secret, dl.Body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("status %v", resp.Status)
+		if isFinal(resp.StatusCode) {
+			return errRejected{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// errRejected is a refusal of the receiver that a retry would not change.
+type errRejected struct {
+	error
+}
+
+// isFinal tells the client errors apart from the timeouts and rate limits worth retrying.
+func isFinal(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+func (d *Dispatcher) save(dl *delivery) error {
+	return d.deliveries.Put(dl.ID, dl)
+}
+
+func (d *Dispatcher) remove(dl *delivery) {
+	if err := d.deliveries.Delete(dl.ID); err != nil {
+		log.Println("webhook:", err)
+	}
+}
@@ -0,0 +1,225 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"main/storage"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lvstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newTestDispatcher(t *testing.T, cfg Config) *Dispatcher {
+	db, err := leveldb.Open(lvstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	keys := []Key{{APIKey: "key", CallbackURL: "https://integrator.example/cb", Secret: "key secret"}}
+	return NewDispatcher(storage.NewLevelDB(db), keys, "default secret", cfg)
+}
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.MaxAttempts = 3
+	cfg.BaseDelay = time.Minute
+	cfg.Timeout = 200 * time.Millisecond
+	// the test servers listen on loopback
+	cfg.AllowPrivate = true
+	return cfg
+}
+
+func (d *Dispatcher) pending(t *testing.T) []delivery {
+	result := []delivery{}
+	err := d.deliveries.Scan("", "", func(id string, data []byte) error {
+		var dl delivery
+		if err := json.Unmarshal(data, &dl); err != nil {
+			return err
+		}
+		result = append(result, dl)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestCallback(t *testing.T) {
+	d := newTestDispatcher(t, DefaultConfig())
+	for _, tc := range []struct {
+		apiKey, callbackURL, want string
+		fails                     bool
+	}{
+		{"", "", "", false},
+		{"", "https://example.com/cb", "https://example.com/cb", false},
+		{"key", "", "https://integrator.example/cb", false},
+		{"key", "https://other.example/cb", "https://other.example/cb", false},
+		{"nope", "", "", true},
+		{"", "ftp://example.com/cb", "", true},
+		{"", "http://127.0.0.1:8080/admin", "", true},
+		{"", "http://169.254.169.254/latest/meta-data", "", true},
+		{"", "http://10.1.2.3/", "", true},
+		{"key", "http://[::1]/", "", true},
+	} {
+		got, err := d.Callback(tc.apiKey, tc.callbackURL)
+		if (err != nil) != tc.fails || got != tc.want {
+			t.Errorf("%q %q: got %q, err %v", tc.apiKey, tc.callbackURL, got, err)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var lock sync.Mutex
+	var got []*http.Request
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		got = append(got, r)
+		bodies = append(bodies, body)
+		lock.Unlock()
+	}))
+	defer srv.Close()
+	d := newTestDispatcher(t, testConfig())
+
+	if err := d.Notify("key", srv.URL, Payload{Event: EventConfirmed, PaymentAddress: "addr"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Notify("", srv.URL, Payload{Event: EventFailed}); err != nil {
+		t.Fatal(err)
+	}
+	d.deliverDue()
+	if len(got) != 2 || len(d.pending(t)) != 0 {
+		t.Fatalf("%v delivered, %v pending", len(got), len(d.pending(t)))
+	}
+	for i, secret := range []string{"key secret", "default secret"} {
+		if sig := got[i].Header.Get(SignatureHeader); sig != Sign(secret, bodies[i]) {
+			t.Errorf("delivery %v: signature %q", i, sig)
+		}
+	}
+	var payload Payload
+	if err := json.Unmarshal(bodies[0], &payload); err != nil || payload.Event != EventConfirmed || payload.Time == 0 {
+		t.Fatalf("payload %+v, err %v", payload, err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var lock sync.Mutex
+	status, delay, calls := 0, time.Duration(0), 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		calls++
+		status, delay := status, delay
+		lock.Unlock()
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name    string
+		status  int
+		delay   time.Duration
+		retried bool
+	}{
+		{"server error", http.StatusBadGateway, 0, true},
+		{"timeout", http.StatusOK, time.Second, true},
+		{"rate limited", http.StatusTooManyRequests, 0, true},
+		{"client error", http.StatusBadRequest, 0, false},
+		{"gone", http.StatusGone, 0, false},
+	} {
+		lock.Lock()
+		status, delay, calls = tc.status, tc.delay, 0
+		lock.Unlock()
+		d := newTestDispatcher(t, testConfig())
+		if err := d.Notify("", srv.URL, Payload{Event: EventConfirmed}); err != nil {
+			t.Fatal(err)
+		}
+		before := time.Now()
+		d.deliverDue()
+		pending := d.pending(t)
+		if !tc.retried {
+			lock.Lock()
+			n := calls
+			lock.Unlock()
+			if n != 1 || len(pending) != 0 {
+				t.Errorf("%v: %v calls, %v pending", tc.name, n, len(pending))
+			}
+			continue
+		}
+		if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].NextAttempt < before.Add(time.Minute).Unix() {
+			t.Errorf("%v: pending %+v", tc.name, pending)
+			continue
+		}
+
+		// the delay doubles with each attempt, until MaxAttempts
+		dl := pending[0]
+		dl.NextAttempt = 0
+		if err := d.save(&dl); err != nil {
+			t.Fatal(err)
+		}
+		before = time.Now()
+		d.deliverDue()
+		if pending = d.pending(t); len(pending) != 1 || pending[0].NextAttempt < before.Add(2*time.Minute).Unix() {
+			t.Errorf("%v: second attempt %+v", tc.name, pending)
+			continue
+		}
+		dl = pending[0]
+		dl.NextAttempt = 0
+		if err := d.save(&dl); err != nil {
+			t.Fatal(err)
+		}
+		d.deliverDue()
+		if pending = d.pending(t); len(pending) != 0 {
+			t.Errorf("%v: still pending after MaxAttempts: %+v", tc.name, pending)
+		}
+	}
+}
+
+func TestInternalAddressRefused(t *testing.T) {
+	var lock sync.Mutex
+	calls := 0
+	delivered := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return calls
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		calls++
+		lock.Unlock()
+	}))
+	defer srv.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, srv.URL, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	// a queued delivery to loopback, as a host resolving there would be
+	cfg := testConfig()
+	cfg.AllowPrivate = false
+	d := newTestDispatcher(t, cfg)
+	if err := d.Notify("", srv.URL, Payload{Event: EventConfirmed}); err != nil {
+		t.Fatal(err)
+	}
+	d.deliverDue()
+	if delivered() != 0 {
+		t.Fatal("delivered to a loopback address")
+	}
+
+	// redirects are not followed
+	d = newTestDispatcher(t, testConfig())
+	if err := d.Notify("", redirect.URL, Payload{Event: EventConfirmed}); err != nil {
+		t.Fatal(err)
+	}
+	d.deliverDue()
+	if n := delivered(); n != 0 || len(d.pending(t)) != 1 {
+		t.Fatalf("redirect followed: %v calls", n)
+	}
+}